@@ -0,0 +1,115 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// failureCategory is the kind of condition that fails a manifest check.
+type failureCategory string
+
+const (
+	invalidEntryFailure      failureCategory = "invalid-entry"
+	untrackedPackageFailure  failureCategory = "untracked-package"
+	inventoryMismatchFailure failureCategory = "inventory-mismatch"
+	missingDocsPageFailure   failureCategory = "missing-docs-page"
+	unparsableDocFailure     failureCategory = "unparsable-doc"
+	goWorkMismatchFailure    failureCategory = "go-work-mismatch"
+	betaTooLongFailure       failureCategory = "beta-too-long"
+)
+
+// checkFailureExitCode is the exit code of a manifest command whose checks
+// failed. It is distinct from the exit code 1 of other errors and the exit
+// code 2 of usage errors.
+const checkFailureExitCode = 3
+
+// checkFailure is a single failed check.
+type checkFailure struct {
+	Category failureCategory
+	Message  string
+}
+
+// checkResult collects the failures of the checks made by a manifest command.
+// A result with failures is an error.
+type checkResult struct {
+	Failures []checkFailure
+}
+
+// addf records a failure in category.
+func (r *checkResult) addf(category failureCategory, format string, v ...interface{}) {
+	r.Failures = append(r.Failures, checkFailure{category, fmt.Sprintf(format, v...)})
+}
+
+// Passed reports whether there are no failures.
+func (r *checkResult) Passed() bool {
+	return len(r.Failures) == 0
+}
+
+// Err returns r if there are failures, or nil if all checks passed.
+func (r *checkResult) Err() error {
+	if r.Passed() {
+		return nil
+	}
+	return r
+}
+
+func (r *checkResult) Error() string {
+	return fmt.Sprintf("%d checks failed", len(r.Failures))
+}
+
+// Summary returns a human-readable summary of the failures grouped by
+// category, in category order.
+func (r *checkResult) Summary() string {
+	if r.Passed() {
+		return "all checks passed\n"
+	}
+	byCategory := map[failureCategory][]string{}
+	var categories []string
+	for _, f := range r.Failures {
+		if _, ok := byCategory[f.Category]; !ok {
+			categories = append(categories, string(f.Category))
+		}
+		byCategory[f.Category] = append(byCategory[f.Category], f.Message)
+	}
+	sort.Strings(categories)
+	var b strings.Builder
+	fmt.Fprintf(&b, "%d checks failed:\n", len(r.Failures))
+	for _, c := range categories {
+		msgs := byCategory[failureCategory(c)]
+		fmt.Fprintf(&b, "  %s (%d):\n", c, len(msgs))
+		for _, msg := range msgs {
+			fmt.Fprintf(&b, "    %s\n", msg)
+		}
+	}
+	return b.String()
+}
+
+// exitCode returns the process exit code for the error returned by a
+// command.
+func exitCode(err error) int {
+	var r *checkResult
+	switch {
+	case err == nil:
+		return 0
+	case errors.As(err, &r):
+		return checkFailureExitCode
+	default:
+		return 1
+	}
+}
@@ -0,0 +1,101 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestCheckResult(t *testing.T) {
+	var r checkResult
+	r.addf(untrackedPackageFailure, "%s is not in the config or the manifest", "cloud.google.com/go/new/apiv1")
+	r.addf(invalidEntryFailure, "foo: missing docs URL")
+	r.addf(inventoryMismatchFailure, "bar is in the manifest but not the inventory")
+	r.addf(invalidEntryFailure, "baz: missing description")
+
+	if r.Passed() {
+		t.Fatal("Passed() = true, want false")
+	}
+	want := `4 checks failed:
+  invalid-entry (2):
+    foo: missing docs URL
+    baz: missing description
+  inventory-mismatch (1):
+    bar is in the manifest but not the inventory
+  untracked-package (1):
+    cloud.google.com/go/new/apiv1 is not in the config or the manifest
+`
+	if got := r.Summary(); got != want {
+		t.Errorf("Summary() = %q, want %q", got, want)
+	}
+}
+
+func TestExitCode(t *testing.T) {
+	var mixed checkResult
+	mixed.addf(invalidEntryFailure, "foo: missing docs URL")
+	mixed.addf(inventoryMismatchFailure, "bar is in the manifest but not the inventory")
+	var passed checkResult
+
+	tests := []struct {
+		name string
+		err  error
+		want int
+	}{
+		{name: "success", err: nil, want: 0},
+		{name: "passed checks", err: passed.Err(), want: 0},
+		{name: "failed checks", err: mixed.Err(), want: checkFailureExitCode},
+		{name: "wrapped failed checks", err: fmt.Errorf("validate: %w", mixed.Err()), want: checkFailureExitCode},
+		{name: "other error", err: errors.New("no manifest found"), want: 1},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := exitCode(tc.err); got != tc.want {
+				t.Errorf("exitCode() = %d, want %d", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestRunCommandCheckResult(t *testing.T) {
+	p := newTestManifestProcessor(t)
+	writeTestFiles(t, p.googleCloudDir, map[string]string{
+		"internal/.repo-metadata-full.json": `{
+  "cloud.google.com/go/foo/apiv1": {
+    "distribution_name": "cloud.google.com/go/foo/apiv1",
+    "release_level": "unknown"
+  }
+}`,
+	})
+
+	err := p.runCommand(context.Background(), []string{"validate"})
+	var r *checkResult
+	if !errors.As(err, &r) {
+		t.Fatalf("runCommand(validate) = %v, want a *checkResult", err)
+	}
+	if r.Passed() {
+		t.Fatal("Passed() = true, want failures for an incomplete entry")
+	}
+	for _, f := range r.Failures {
+		if f.Category != invalidEntryFailure {
+			t.Errorf("failure %q has category %s, want %s", f.Message, f.Category, invalidEntryFailure)
+		}
+	}
+	if got := exitCode(err); got != checkFailureExitCode {
+		t.Errorf("exitCode() = %d, want %d", got, checkFailureExitCode)
+	}
+}
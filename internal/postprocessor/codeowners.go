@@ -0,0 +1,121 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// codeownersRule is a line of a CODEOWNERS file.
+type codeownersRule struct {
+	Pattern string
+	Owners  []string
+}
+
+// parseCodeowners parses the rules of the CODEOWNERS file contents b, skipping
+// blank lines and comments.
+func parseCodeowners(b []byte) []codeownersRule {
+	var rules []codeownersRule
+	s := bufio.NewScanner(bytes.NewReader(b))
+	for s.Scan() {
+		line := s.Text()
+		if i := strings.Index(line, "#"); i >= 0 {
+			line = line[:i]
+		}
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		rules = append(rules, codeownersRule{Pattern: fields[0], Owners: fields[1:]})
+	}
+	return rules
+}
+
+// matches reports whether the rule applies to dir, a slash-separated path
+// relative to the repo root. It supports the subset of the gitignore pattern
+// syntax used for directories: a pattern matches a directory and everything
+// below it, is anchored to the root if it contains a slash other than a
+// trailing one, and otherwise matches a directory name at any depth.
+func (r codeownersRule) matches(dir string) bool {
+	pattern := strings.TrimSuffix(strings.TrimSuffix(r.Pattern, "/**"), "/")
+	anchored := strings.Contains(pattern, "/")
+	pattern = strings.TrimPrefix(pattern, "/")
+	if pattern == "" || pattern == "*" || pattern == "**" {
+		return true
+	}
+	elems := strings.Split(dir, "/")
+	for i := range elems {
+		candidate := elems[i]
+		if anchored {
+			candidate = strings.Join(elems[:i+1], "/")
+		}
+		if ok, _ := path.Match(pattern, candidate); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// codeowners returns the owners of dir. As on GitHub, the last matching rule
+// wins, and a matching rule without owners leaves dir unowned.
+func codeowners(rules []codeownersRule, dir string) []string {
+	var owners []string
+	for _, r := range rules {
+		if r.matches(dir) {
+			owners = r.Owners
+		}
+	}
+	return owners
+}
+
+// checkCodeowners warns about each entry whose directory has no owner in the
+// configured CODEOWNERS file. It does nothing if no CODEOWNERS file is
+// configured. Entries outside of cloud.google.com/go are not checked.
+func (p *postProcessor) checkCodeowners(entries map[string]ManifestEntry) error {
+	if p.config.CodeownersFile == "" {
+		return nil
+	}
+	codeownersPath := filepath.Join(p.googleCloudDir, p.config.CodeownersFile)
+	b, err := os.ReadFile(codeownersPath)
+	if err != nil {
+		return err
+	}
+	rules := parseCodeowners(b)
+	var unowned []string
+	for name := range entries {
+		importPath := name
+		if ip, ok := p.importPaths[name]; ok {
+			importPath = ip
+		}
+		dir, ok := strings.CutPrefix(importPath, "cloud.google.com/go/")
+		if !ok {
+			continue
+		}
+		if len(codeowners(rules, dir)) == 0 {
+			unowned = append(unowned, name)
+		}
+	}
+	sort.Strings(unowned)
+	for _, name := range unowned {
+		p.warnFilef(codeownersPath, "%s has no owner", name)
+	}
+	return nil
+}
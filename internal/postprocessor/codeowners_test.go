@@ -0,0 +1,80 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"log"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+)
+
+const testCodeowners = `# Default owners.
+*                   @googleapis/owners
+
+/foo/               @googleapis/foo-team
+bar                 @googleapis/bar-team
+/qux/**             @googleapis/qux-team
+/qux/apiv1beta/
+`
+
+func TestCodeowners(t *testing.T) {
+	rules := parseCodeowners([]byte(testCodeowners))
+	tests := map[string][]string{
+		"foo/apiv1":     {"@googleapis/foo-team"},
+		"bar/apiv1":     {"@googleapis/bar-team"},
+		"baz/bar":       {"@googleapis/bar-team"},
+		"qux/apiv1":     {"@googleapis/qux-team"},
+		"qux/apiv1beta": {},
+		"baz":           {"@googleapis/owners"},
+	}
+	for dir, want := range tests {
+		if got := codeowners(rules, dir); !cmp.Equal(got, want, cmpopts.EquateEmpty()) {
+			t.Errorf("codeowners(%q) = %v, want %v", dir, got, want)
+		}
+	}
+}
+
+func TestManifestCodeowners(t *testing.T) {
+	p := newTestManifestProcessor(t)
+	p.config.CodeownersFile = ".github/CODEOWNERS"
+	writeTestFiles(t, p.googleCloudDir, map[string]string{
+		".github/CODEOWNERS": `/foo/ @googleapis/foo-team
+/bar/ @googleapis/bar-team
+/baz/ @googleapis/baz-team
+`,
+	})
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	defer log.SetOutput(os.Stderr)
+	if _, err := p.Manifest(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, line := range strings.Split(buf.String(), "\n") {
+		if _, msg, ok := strings.Cut(line, "warning: "); ok && strings.HasSuffix(msg, "has no owner") {
+			got = append(got, msg)
+		}
+	}
+	want := []string{"cloud.google.com/go/qux/apiv1beta has no owner"}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("Manifest() warnings mismatch (-want +got):\n%s", diff)
+	}
+}
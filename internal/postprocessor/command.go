@@ -53,6 +53,21 @@ func runAll(dir, branchOverride string) (bool, error) {
 	return strings.HasPrefix(branchName, owlBotBranchPrefix), nil
 }
 
+// isGitWorktree reports whether dir is inside a git worktree.
+func isGitWorktree(dir string) bool {
+	c := execv.Command("git", "rev-parse", "--is-inside-work-tree")
+	c.Dir = dir
+	b, err := c.Output()
+	return err == nil && strings.TrimSpace(string(b)) == "true"
+}
+
+// gitAdd stages the files at paths in the git worktree at dir.
+func gitAdd(dir string, paths ...string) error {
+	c := execv.Command("git", append([]string{"add", "--"}, paths...)...)
+	c.Dir = dir
+	return c.Run()
+}
+
 // DeepClone clones a repository in the given directory.
 func DeepClone(repo, dir string) error {
 	log.Printf("cloning %s\n", repo)
@@ -15,10 +15,21 @@
 package main
 
 import (
+	"bytes"
+	"errors"
 	"fmt"
+	"go/token"
+	"io"
+	"net/url"
 	"os"
 	"path/filepath"
+	"regexp"
+	"runtime"
+	"sort"
+	"strconv"
 	"strings"
+	"text/template"
+	"time"
 
 	"gopkg.in/yaml.v3"
 )
@@ -36,37 +47,687 @@ type config struct {
 	// ManualClientInfo contains information on manual clients used to generate
 	// the manifest file.
 	ManualClientInfo []*ManifestEntry
+
+	manifestConfig
+}
+
+// defaultMaxServiceConfigSize is the default maximum size of a service config
+// file read while generating the manifest.
+const defaultMaxServiceConfigSize = 4 << 20
+
+// defaultServiceConfigOpenAttempts is the default number of attempts to open
+// a service config.
+const defaultServiceConfigOpenAttempts = 3
+
+// defaultMaxDescriptionLength is the default maximum length, in characters,
+// of a manifest entry description.
+const defaultMaxDescriptionLength = 200
+
+// defaultDocsLanguagePath is the default language segment of docs URLs.
+const defaultDocsLanguagePath = "go"
+
+// defaultManifestOutput is the default path of the manifest, relative to the
+// client root.
+const defaultManifestOutput = "internal/.repo-metadata-full.json"
+
+// Formats of the manifest that may be configured as Format.
+const (
+	jsonManifestFormat = "json"
+	yamlManifestFormat = "yaml"
+)
+
+// manifestConfig contains options that control how the manifest file is
+// generated. The zero value of each option selects the default behavior.
+type manifestConfig struct {
+	// ExpandServiceConfigTabs expands each tab in the indentation of a
+	// service config to two spaces before decoding it, with a warning. By
+	// default a tab-indented service config is an error naming the line.
+	ExpandServiceConfigTabs bool `yaml:"expand-service-config-tabs"`
+	// MaxServiceConfigSize is the maximum size, in bytes, of a service config
+	// file. Defaults to defaultMaxServiceConfigSize.
+	MaxServiceConfigSize int64 `yaml:"max-service-config-size"`
+	// AllowExternalServiceConfigSymlinks follows a service config that is a
+	// symlink to a file outside of the googleapis directory, such as a shared
+	// directory of service configs. By default such a service config is an
+	// error, like a service config path that escapes the directory.
+	AllowExternalServiceConfigSymlinks bool `yaml:"allow-external-service-config-symlinks"`
+	// ServiceConfigOpenAttempts is how many times opening a service config is
+	// attempted when it fails with a transient error. Defaults to
+	// defaultServiceConfigOpenAttempts.
+	ServiceConfigOpenAttempts int `yaml:"service-config-open-attempts"`
+	// FailOnManualShadowing makes a generated entry that collides with a
+	// manual entry an error. By default the entries are merged according to
+	// MergePolicy and a warning is logged.
+	FailOnManualShadowing bool `yaml:"fail-on-manual-shadowing"`
+	// WriteJSONL additionally writes the manifest as newline-delimited JSON,
+	// sorted by SortBy, to internal/.repo-metadata-full.jsonl.
+	WriteJSONL bool `yaml:"write-jsonl"`
+	// RequireExplicitStability makes it an error for a package to have no
+	// stability signal, rather than inferring that it is ga.
+	RequireExplicitStability bool `yaml:"require-explicit-stability"`
+	// UnknownReleaseLevel, if set, is the placeholder release level of a
+	// package without a stability signal when RequireExplicitStability is
+	// set, such as "unknown", so that the rest of the manifest is still
+	// generated. Such entries are reported in a warning. By default they are
+	// an error.
+	UnknownReleaseLevel string `yaml:"unknown-release-level"`
+	// DefaultLanguage is the language of generated entries and of manual
+	// entries that do not set one. Defaults to "Go".
+	DefaultLanguage string `yaml:"default-language"`
+	// FailOnDocsURLProblems makes a structurally malformed docs URL, or one
+	// that a manual and a generated entry of the same module share, an error
+	// rather than a warning.
+	FailOnDocsURLProblems bool `yaml:"fail-on-docs-url-problems"`
+	// LowercaseDocsURLPaths lowercases the package path of the docs URLs of
+	// generated entries, rather than reporting its uppercase segments as a
+	// docs URL problem.
+	LowercaseDocsURLPaths bool `yaml:"lowercase-docs-url-paths"`
+	// FailOnReleaseLevelConflicts makes a doc.go release level disclaimer
+	// that disagrees with the version in the import path an error rather
+	// than a warning.
+	FailOnReleaseLevelConflicts bool `yaml:"fail-on-release-level-conflicts"`
+	// AllowedLibraryTypes are the library types that may appear in the
+	// manifest. Defaults to all known library types.
+	AllowedLibraryTypes []libraryType `yaml:"allowed-library-types"`
+	// SkipManifestVerification disables re-reading the manifest after it is
+	// written to check that it round-trips to the computed entries.
+	SkipManifestVerification bool `yaml:"skip-manifest-verification"`
+	// DescriptionTemplate is a text/template used to build the description
+	// of generated entries. It is executed with a descriptionData. Defaults
+	// to the text of the first description source that has one.
+	DescriptionTemplate string `yaml:"description-template"`
+	// DescriptionSources is the ordered chain of sources of the description
+	// text of generated entries. The first source with a non-empty text is
+	// used. Defaults to defaultDescriptionSources.
+	DescriptionSources []descriptionSource `yaml:"description-sources"`
+	// OverridesFile is the path, relative to the repo root, of a JSON file of
+	// hand-tuned entry fields keyed by distribution name. Fields set in it
+	// override the computed entries.
+	OverridesFile string `yaml:"overrides-file"`
+	// LibraryMetadataFile is the name of a JSON file, such as
+	// repo-metadata.json, that a library may keep in its directory to
+	// replace its computed entry. By default no such files are read.
+	LibraryMetadataFile string `yaml:"library-metadata-file"`
+	// AgentMarkerFile is the name of a file, such as AGENTS.md, whose
+	// presence in the directory of a library makes its library type AGENT.
+	// The marker takes precedence over the computed type and that of a
+	// manual entry, but the overrides file and library metadata files are
+	// applied after it. By default no marker is checked.
+	AgentMarkerFile string `yaml:"agent-marker-file"`
+	// LaunchStageLevels maps service config launch stages to release levels.
+	// Entries are merged on top of the launch stage levels of Detection. A
+	// stage mapped to "" is no signal and left to the other detectors.
+	LaunchStageLevels map[string]string `yaml:"launch-stage-levels"`
+	// DefaultReleaseLevelForUnknownStage is the release level used for launch
+	// stages that are not mapped or are empty, including a service config
+	// without a launch stage and LAUNCH_STAGE_UNSPECIFIED. By default an
+	// unknown stage is an error and an empty one is left to the other
+	// detectors.
+	DefaultReleaseLevelForUnknownStage string `yaml:"default-release-level-for-unknown-stage"`
+	// ReleaseLevelDetectors is the ordered chain of release level detectors,
+	// by source name. The first detector that reports a level is used. By
+	// default the chain is all of builtinDetectorSources, omitting the
+	// launch-stage, snippet-metadata, build-tag, release-please and changelog
+	// detectors unless UseLaunchStage, UseSnippetMetadata, UseBuildTags,
+	// UseReleasePlease and UseChangelog are set.
+	ReleaseLevelDetectors []releaseLevelSource `yaml:"release-level-detectors"`
+	// Labels are the labels of the entries, keyed by distribution name or, for
+	// keys ending in "/...", by distribution name prefix.
+	Labels map[string][]string `yaml:"labels"`
+	// CodeownersFile is the path, relative to the repo root, of a CODEOWNERS
+	// file. If set, a warning is logged for each manifest entry whose
+	// directory has no owner in it.
+	CodeownersFile string `yaml:"codeowners-file"`
+	// HandwrittenMarker is the name of a file that marks a package directory
+	// as a handwritten client to discover. Its first line is the description
+	// of the client. By default handwritten clients are not discovered.
+	HandwrittenMarker string `yaml:"handwritten-marker"`
+	// GeneratorVersion is recorded in the generator_version field of every
+	// generated entry.
+	GeneratorVersion string `yaml:"generator-version"`
+	// GeneratorVersionFile is the path, relative to the repo root, of a file
+	// containing the generator version, used instead of GeneratorVersion.
+	GeneratorVersionFile string `yaml:"generator-version-file"`
+	// TemplatesManifest is the path, relative to the repo root, of a YAML
+	// templates manifest of the generator that declares the wording of the
+	// alpha and beta disclaimers in doc.go, see templatesManifest. By default
+	// the indicators of Detection are used.
+	TemplatesManifest string `yaml:"templates-manifest"`
+	// Detection overrides the release level detection defaults embedded from
+	// _detection_defaults.yaml. Unset options keep their default.
+	Detection detectionDefaults `yaml:"detection"`
+	// UseBuildTags detects the beta level of a package in which any Go file
+	// is gated behind the preview build tag of Detection.
+	UseBuildTags bool `yaml:"use-build-tags"`
+	// UseLaunchStage detects the release level of a package from the launch
+	// stage in the library settings of its primary service config, see
+	// LaunchStageLevels. It takes precedence over the doc.go disclaimer.
+	UseLaunchStage bool `yaml:"use-launch-stage"`
+	// UseChangelog infers the release level of a package that has no other
+	// stability signal from the highest release in its module's changelog.
+	UseChangelog bool `yaml:"use-changelog"`
+	// UseReleasePlease detects the release level of a package that has no
+	// doc.go disclaimer from the prerelease, prerelease-type or release-as
+	// options of the package of ReleasePleaseConfigFile that contains it. A
+	// missing config is no signal.
+	UseReleasePlease bool `yaml:"use-release-please"`
+	// ReleasePleaseConfigFile is the path, relative to the repo root, of the
+	// release-please config read by the release-please detector. Defaults to
+	// defaultReleasePleaseConfigFile.
+	ReleasePleaseConfigFile string `yaml:"release-please-config-file"`
+	// UseSnippetMetadata detects pre-release levels from the API versions in
+	// the snippet metadata file of a package, before falling back to doc.go.
+	UseSnippetMetadata bool `yaml:"use-snippet-metadata"`
+	// ModuleResolver is how the module containing a package is found, one of
+	// "go", "go-mod-file" or "fallback". Defaults to "go".
+	ModuleResolver string `yaml:"module-resolver"`
+	// GraduationDates are the dates, in RFC 3339 full-date format, that alpha
+	// and beta libraries are expected to become ga, keyed by distribution
+	// name.
+	GraduationDates map[string]string `yaml:"graduation-dates"`
+	// ReleaseLevelPins are release levels, keyed by distribution name, that
+	// replace the computed release level of the entry after everything else
+	// is applied, for example to keep a library beta during a coordinated
+	// launch even though its doc.go no longer says so.
+	ReleaseLevelPins map[string]string `yaml:"release-level-pins"`
+	// DetectManualReleaseLevels detects the release level of each manual
+	// entry that does not set one from the package of its distribution name,
+	// with the same detectors as generated entries.
+	DetectManualReleaseLevels bool `yaml:"detect-manual-release-levels"`
+	// CheckBuilds runs go build in the directory of each generated entry
+	// and fails if any of the libraries does not build. It is slow, so it is
+	// off by default.
+	CheckBuilds bool `yaml:"check-builds"`
+	// CheckImportPaths fails before any entry is computed if the directory
+	// that the import path of a generated library maps to has no .go files,
+	// so that a config typo is reported as such.
+	CheckImportPaths bool `yaml:"check-import-paths"`
+	// SparseCheckout skips the generated libraries whose directory is
+	// absent, such as in a sparse checkout, rather than failing. Their
+	// entries are left out of the manifest. Libraries whose directory exists
+	// but is broken still fail.
+	SparseCheckout bool `yaml:"sparse-checkout"`
+	// SkipDocsURL leaves the docs URLs of generated and handwritten entries
+	// empty and does not resolve the module of any package, which avoids
+	// running the go command. The docs URL overrides of generated libraries
+	// are ignored.
+	SkipDocsURL bool `yaml:"skip-docs-url"`
+	// WriteModulePackages additionally writes the packages of the generated
+	// entries, keyed by module, to internal/.repo-metadata-modules.json.
+	WriteModulePackages bool `yaml:"write-module-packages"`
+	// StageManifest runs git add on the manifest files after they are
+	// written, if the repo root is a git worktree. Committing is left to the
+	// caller.
+	StageManifest bool `yaml:"stage-manifest"`
+	// MergePolicy overrides defaultMergePolicy. It maps JSON field names of
+	// ManifestEntry to "manual" or "generated", the entry that the field is
+	// taken from when a generated entry and a manual entry collide.
+	MergePolicy map[string]string `yaml:"merge-policy"`
+	// ExcludeFromManifest are distribution names that are computed as usual
+	// but removed from the manifest right before it is written.
+	ExcludeFromManifest []string `yaml:"exclude-from-manifest"`
+	// TitleLanguage is the preferred language code, such as "ja" or "pt-BR",
+	// of the service config titles used for descriptions. A title from the
+	// localized_titles of a service config is used if there is one for the
+	// language code or its base language, otherwise the title is used.
+	TitleLanguage string `yaml:"title-language"`
+	// TitleSeparator separates the titles of the service configs of a library
+	// with more than one in its description. Defaults to ", ".
+	TitleSeparator string `yaml:"title-separator"`
+	// DeniedTitleSubstrings are placeholder texts, such as "Untitled" or
+	// "Example API", that indicate a service config copied from a template.
+	// A service config title that contains any of them, ignoring case, is
+	// reported in a warning.
+	DeniedTitleSubstrings []string `yaml:"denied-title-substrings"`
+	// FailOnDeniedTitles makes a service config title that contains one of
+	// the DeniedTitleSubstrings an error rather than a warning.
+	FailOnDeniedTitles bool `yaml:"fail-on-denied-titles"`
+	// MaxWorkers is the number of workers used to compute release levels. It
+	// is overridden by the maxWorkersEnv environment variable. Defaults to
+	// the number of CPUs.
+	MaxWorkers int `yaml:"max-workers"`
+	// CheckDocPackage makes it an error for the doc.go of a generated library
+	// to declare a package other than the one expected from its import path.
+	CheckDocPackage bool `yaml:"check-doc-package"`
+	// DocsLanguagePath is the language path segment of the docs URLs of
+	// generated entries, as in https://cloud.google.com/go/docs/reference/.
+	// Defaults to defaultDocsLanguagePath.
+	DocsLanguagePath string `yaml:"docs-language-path"`
+	// DocsBaseURL is the base of the docs URLs of generated entries, to which
+	// the module and package path are appended, such as a staging mirror of
+	// the reference docs. Defaults to the reference docs of DocsLanguagePath,
+	// as in https://cloud.google.com/go/docs/reference/.
+	DocsBaseURL string `yaml:"docs-base-url"`
+	// KeepModuleRootDocsURLSlash keeps the trailing slash of the docs URL of
+	// a package at the root of its module, as in .../storage/latest/. By
+	// default such URLs end in /latest.
+	KeepModuleRootDocsURLSlash bool `yaml:"keep-module-root-docs-url-slash"`
+	// DocsURLSuffixes are appended to the docs URL of generated entries with
+	// the release level they are keyed by. A suffix is either a query, such as
+	// "?preview=true", or a path, such as "/beta/".
+	DocsURLSuffixes map[string]string `yaml:"docs-url-suffixes"`
+	// WriteReleaseLevelSplits additionally writes, for each release level, a
+	// manifest with only the entries at that level to
+	// internal/.repo-metadata-<level>.json.
+	WriteReleaseLevelSplits bool `yaml:"write-release-level-splits"`
+	// WriteGAManifest additionally writes only the ga release level split,
+	// internal/.repo-metadata-ga.json, as an index of the stable libraries.
+	// It is implied by WriteReleaseLevelSplits.
+	WriteGAManifest bool `yaml:"write-ga-manifest"`
+	// ScopedManifests are additional manifests, each with only the entries
+	// whose labels match a label selector, written next to the manifest. The
+	// full manifest is still written.
+	ScopedManifests []scopedManifest `yaml:"scoped-manifests"`
+	// WriteChecksum additionally writes the SHA-256 checksum of the manifest
+	// to internal/.repo-metadata-full.json.sha256, in the format of
+	// sha256sum.
+	WriteChecksum bool `yaml:"write-checksum"`
+	// WriteLibrariesManifest additionally writes the manifest as a JSON object
+	// with a "libraries" array of the entries sorted by SortBy to
+	// internal/.repo-metadata-libraries.json, for consumers that do not
+	// accept an object keyed by distribution name.
+	WriteLibrariesManifest bool `yaml:"write-libraries-manifest"`
+	// WriteOpenAPIComponents additionally writes the description, release
+	// level and docs URL of each entry as an OpenAPI components section to
+	// internal/.repo-metadata-openapi.json, for docs integrations.
+	WriteOpenAPIComponents bool `yaml:"write-openapi-components"`
+	// GoSourceFile, if set, is the path, relative to the repo root, of a Go
+	// file to additionally write the manifest to as a map of entries named
+	// Entries, for consumers that compile the manifest in. The file declares
+	// its own ManifestEntry type.
+	GoSourceFile string `yaml:"go-source-file"`
+	// GoSourcePackage is the package name of GoSourceFile. Defaults to the
+	// name of its directory.
+	GoSourcePackage string `yaml:"go-source-package"`
+	// WriteDescriptionSources additionally writes the absolute paths of the
+	// service configs that the description of each generated entry is from,
+	// keyed by distribution name, to
+	// internal/.repo-metadata-description-sources.json. It is only meant for
+	// debugging.
+	WriteDescriptionSources bool `yaml:"write-description-sources"`
+	// ReleaseLevelHistoryFile, if set, is the path, relative to the repo
+	// root, of a JSONL file to which a dated record is appended for each
+	// entry whose release level changed since the previous manifest, to
+	// build a timeline of release level changes.
+	ReleaseLevelHistoryFile string `yaml:"release-level-history-file"`
+	// CompactJSON writes the manifest without indentation.
+	CompactJSON bool `yaml:"compact-json"`
+	// Output is the path of the manifest, relative to the client root. The
+	// other manifest outputs are written next to it. Defaults to
+	// defaultManifestOutput.
+	Output string `yaml:"output"`
+	// Format is the format of the manifest and of its release level splits
+	// and scoped manifests, "json" or "yaml". The YAML manifest has the same
+	// fields as the JSON one. Defaults to "json".
+	Format string `yaml:"format"`
+	// DryRun computes the manifest and every output and logs the files that
+	// would be written, without writing or staging any of them.
+	DryRun bool `yaml:"dry-run"`
+	// JSONFieldNaming is the naming of the entry fields in the manifest, its
+	// release level splits, the JSONL and libraries manifests and the
+	// streamed manifest, either "snake_case", as in distribution_name, or
+	// "camelCase", as in distributionName. Manifests in either naming are
+	// read. Defaults to "snake_case".
+	JSONFieldNaming string `yaml:"json-field-naming"`
+	// NullUnsetFields writes the unset fields of the entries in the
+	// manifest, its release level splits, the JSONL and libraries manifests
+	// and the streamed manifest as null rather than as an empty string or not
+	// at all, for consumers that tell an absent field from an empty one.
+	NullUnsetFields bool `yaml:"null-unset-fields"`
+	// MaxDescriptionLength is the maximum length, in characters, of an entry
+	// description. Defaults to defaultMaxDescriptionLength.
+	MaxDescriptionLength int `yaml:"max-description-length"`
+	// FailOnLongDescriptions makes a description longer than
+	// MaxDescriptionLength an error rather than a warning.
+	FailOnLongDescriptions bool `yaml:"fail-on-long-descriptions"`
+	// MaxManifestBytes is the size budget, in bytes, of the written manifest,
+	// such as the practical limit of the docs CDN for the metadata file. A
+	// larger manifest is reported in a warning with its actual size, to move
+	// to the split outputs in time. Zero means no budget.
+	MaxManifestBytes int `yaml:"max-manifest-bytes"`
+	// FailOnManifestSize makes a manifest larger than MaxManifestBytes an
+	// error rather than a warning.
+	FailOnManifestSize bool `yaml:"fail-on-manifest-size"`
+	// MaxEntriesPerDescription is the number of entries that may share a
+	// description. A warning is logged for each description shared by more
+	// entries, as it usually means that a service config title was not
+	// customized. By default descriptions are not checked.
+	MaxEntriesPerDescription int `yaml:"max-entries-per-description"`
+	// MinEntries is the number of entries the manifest must have at least.
+	// Fewer entries are an error rather than written, as they usually mean
+	// that the config did not load or that no entry was computed. By default
+	// any number of entries is written.
+	MinEntries int `yaml:"min-entries"`
+	// ManifestKey is the field the top-level keys of the manifest are taken
+	// from, either "distribution-name" or "import-path". The default is
+	// "distribution-name".
+	ManifestKey string `yaml:"manifest-key"`
+	// SortBy is the order of the entries in the JSONL and libraries
+	// manifests, one of "distribution-name", "release-level", least stable
+	// first as in the release level report, or "module", by the path of the
+	// module that owns them. Entries with the same release level or module
+	// are sorted by distribution name. Defaults to "distribution-name".
+	SortBy string `yaml:"sort-by"`
+}
+
+const (
+	// distributionNameSortKey sorts entries by distribution name.
+	distributionNameSortKey = "distribution-name"
+	// releaseLevelSortKey sorts entries by release level.
+	releaseLevelSortKey = "release-level"
+	// moduleSortKey sorts entries by the path of their module.
+	moduleSortKey = "module"
+)
+
+const (
+	// distributionNameManifestKey keys the manifest by distribution_name.
+	distributionNameManifestKey = "distribution-name"
+	// importPathManifestKey keys the manifest by the Go import path of each
+	// entry. Manual entries use their distribution name as import path.
+	importPathManifestKey = "import-path"
+)
+
+// graduationDateLayout is the RFC 3339 full-date layout of graduation dates.
+const graduationDateLayout = "2006-01-02"
+
+// maxWorkersEnv is the environment variable that overrides MaxWorkers.
+const maxWorkersEnv = "POSTPROCESSOR_MAX_WORKERS"
+
+// scopedManifest is a manifest file with only the entries matching a label
+// selector, see labelSelector.
+type scopedManifest struct {
+	// File is the name of the manifest file, in the directory of the
+	// manifest. It must differ from the names of the manifest and its other
+	// outputs.
+	File string `yaml:"file"`
+	// Selector is the label selector of the entries in the file.
+	Selector string `yaml:"selector"`
 }
 
 // libraryInfo contains information about a GAPIC client.
 type libraryInfo struct {
 	// ImportPath is the Go import path for the GAPIC library.
-	ImportPath string
-	// ServiceConfig is the relative directory to the service config from the
-	// services directory in googleapis.
-	ServiceConfig string
+	ImportPath string `yaml:"import-path"`
+	// ServiceConfig are the relative paths to the service configs from the
+	// services directory in googleapis. Libraries that aggregate several APIs
+	// have more than one, in which case the first is the primary service
+	// config. In YAML it may be a single string.
+	ServiceConfig serviceConfigList `yaml:"service-config"`
 	// RelPath is the relative path to the client from the repo root.
-	RelPath string
+	RelPath string `yaml:"rel-path"`
+	// DocsURLOverride, if set, is used as the docs URL of the library instead
+	// of the computed one. It must use https.
+	DocsURLOverride string `yaml:"docs-url-override,omitempty"`
+	// UnderlyingPackage is the import path of the configured library that
+	// this library wraps, such as the gapic of a handwritten wrapper. If no
+	// detector reports a release level for this library, it has the release
+	// level of the underlying library rather than being inferred ga.
+	UnderlyingPackage string `yaml:"underlying-package,omitempty"`
+	// DescriptionOverride is the description text of the library from the
+	// description-override description source.
+	DescriptionOverride string `yaml:"description-override,omitempty"`
 }
 
-func (p *postProcessor) loadConfig() error {
-	var postProcessorConfig struct {
-		Modules        []string `yaml:"modules"`
-		ServiceConfigs []*struct {
+// serviceConfigList is a list of service config paths that may be decoded from
+// either a YAML sequence or a single YAML string.
+type serviceConfigList []string
+
+// UnmarshalYAML implements yaml.Unmarshaler.
+func (l *serviceConfigList) UnmarshalYAML(value *yaml.Node) error {
+	if value.Kind == yaml.ScalarNode {
+		var s string
+		if err := value.Decode(&s); err != nil {
+			return err
+		}
+		*l = serviceConfigList{s}
+		return nil
+	}
+	var s []string
+	if err := value.Decode(&s); err != nil {
+		return err
+	}
+	*l = s
+	return nil
+}
+
+// configFile is the on-disk format of the post-processor config.
+type configFile struct {
+	Modules        []string `yaml:"modules"`
+	ServiceConfigs []*struct {
+		InputDirectory string `yaml:"input-directory"`
+		libraryInfo    `yaml:",inline"`
+	} `yaml:"service-configs"`
+	ManualClients []*ManifestEntry `yaml:"manual-clients"`
+	Manifest      manifestConfig   `yaml:"manifest"`
+}
+
+// config converts the config file to a config. ClientRelPaths is left empty.
+func (cf *configFile) config() *config {
+	c := &config{
+		Modules:                cf.Modules,
+		ClientRelPaths:         make([]string, 0),
+		GoogleapisToImportPath: make(map[string]*libraryInfo),
+		ManualClientInfo:       cf.ManualClients,
+		manifestConfig:         cf.Manifest,
+	}
+	for _, v := range cf.ServiceConfigs {
+		li := v.libraryInfo
+		c.GoogleapisToImportPath[v.InputDirectory] = &li
+	}
+	return c
+}
+
+// configFile converts the config back to its on-disk format, with the
+// defaults of the manifest options filled in.
+func (c *config) configFile() (*configFile, error) {
+	mc, err := c.effectiveManifestConfig()
+	if err != nil {
+		return nil, err
+	}
+	cf := &configFile{
+		Modules:       c.Modules,
+		ManualClients: c.ManualClientInfo,
+		Manifest:      mc,
+	}
+	var inputDirs []string
+	for inputDir := range c.GoogleapisToImportPath {
+		inputDirs = append(inputDirs, inputDir)
+	}
+	sort.Strings(inputDirs)
+	for _, inputDir := range inputDirs {
+		cf.ServiceConfigs = append(cf.ServiceConfigs, &struct {
 			InputDirectory string `yaml:"input-directory"`
-			ServiceConfig  string `yaml:"service-config"`
-			ImportPath     string `yaml:"import-path"`
-			RelPath        string `yaml:"rel-path"`
-		} `yaml:"service-configs"`
-		ManualClients []*ManifestEntry `yaml:"manual-clients"`
+			libraryInfo    `yaml:",inline"`
+		}{inputDir, *c.GoogleapisToImportPath[inputDir]})
+	}
+	return cf, nil
+}
+
+// effectiveManifestConfig returns the manifest options with every option that
+// has a default filled in.
+func (c *config) effectiveManifestConfig() (manifestConfig, error) {
+	mc := c.manifestConfig
+	mc.MaxServiceConfigSize = c.maxServiceConfigSize()
+	mc.MaxDescriptionLength = c.maxDescriptionLength()
+	mc.ServiceConfigOpenAttempts = c.serviceConfigOpenAttempts()
+	mc.DefaultLanguage = c.defaultLanguage()
+	mc.AllowedLibraryTypes = c.allowedLibraryTypes()
+	mc.ModuleResolver = c.moduleResolver()
+	mc.TitleSeparator = c.titleSeparator()
+	mc.ManifestKey = c.manifestKey()
+	mc.SortBy = c.sortBy()
+	mc.ReleasePleaseConfigFile = c.releasePleaseConfigFile()
+	mc.DocsLanguagePath = c.docsLanguagePath()
+	mc.DocsBaseURL = c.docsBaseURL()
+	mc.Output = c.output()
+	mc.Format = c.format()
+	mc.JSONFieldNaming = c.jsonFieldNaming()
+	mc.ReleaseLevelDetectors = c.releaseLevelDetectors()
+	mc.DescriptionSources = c.descriptionSources()
+	workers, err := c.maxWorkers()
+	if err != nil {
+		return manifestConfig{}, err
 	}
-	b, err := os.ReadFile(filepath.Join(p.googleCloudDir, "internal", "postprocessor", "config.yaml"))
+	mc.MaxWorkers = workers
+	mc.Detection = c.detection()
+	mc.LaunchStageLevels = mc.Detection.LaunchStageLevels
+	mc.MergePolicy = map[string]string{}
+	for _, field := range manifestEntryFields() {
+		if field != "" {
+			mc.MergePolicy[field] = c.mergeSource(field)
+		}
+	}
+	return mc, nil
+}
+
+// loadConfigFile loads the entire config from a single YAML file at path,
+// without consulting the OwlBot config. This is used by the manifest commands.
+// Unlike loadConfig, import paths must be set explicitly, while relative paths
+// still default to being derived from the import path.
+func loadConfigFile(path string, strict bool) (*config, error) {
+	b, err := os.ReadFile(path)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	if err := yaml.Unmarshal(b, &postProcessorConfig); err != nil {
+	return parseConfigFile(path, b, strict)
+}
+
+// loadLayeredConfigFile loads the config file at basePath with the config
+// file at overridePath merged on top of it, see mergeConfigValues. The merged
+// config is validated as a single config file.
+func loadLayeredConfigFile(basePath, overridePath string, strict bool) (*config, error) {
+	var layers []interface{}
+	for _, path := range []string{basePath, overridePath} {
+		b, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		if err := decodeConfig(b, &configFile{}, strict); err != nil {
+			return nil, fmt.Errorf("%s: %v", path, err)
+		}
+		var v interface{}
+		if err := yaml.Unmarshal(b, &v); err != nil {
+			return nil, fmt.Errorf("%s: %v", path, err)
+		}
+		layers = append(layers, v)
+	}
+	b, err := yaml.Marshal(mergeConfigValues(layers[0], layers[1], ""))
+	if err != nil {
+		return nil, err
+	}
+	return parseConfigFile(basePath+" with "+overridePath, b, strict)
+}
+
+// configListKeys are the keys that identify the elements of the config lists
+// that are merged element by element, keyed by the name of the list.
+var configListKeys = map[string]string{
+	"service-configs": "input-directory",
+	"manual-clients":  "distribution-name",
+}
+
+// mergeConfigValues merges the decoded YAML value override on top of base.
+// Mappings are merged key by key, recursively. The elements of the lists in
+// configListKeys replace the base elements with the same key and the others
+// are appended. Any other value, including any other list, replaces the base
+// value. name is the key base and override are found under.
+func mergeConfigValues(base, override interface{}, name string) interface{} {
+	switch o := override.(type) {
+	case map[string]interface{}:
+		b, ok := base.(map[string]interface{})
+		if !ok {
+			return override
+		}
+		merged := make(map[string]interface{}, len(b)+len(o))
+		for k, v := range b {
+			merged[k] = v
+		}
+		for k, v := range o {
+			merged[k] = mergeConfigValues(b[k], v, k)
+		}
+		return merged
+	case []interface{}:
+		key, keyed := configListKeys[name]
+		b, ok := base.([]interface{})
+		if !keyed || !ok {
+			return override
+		}
+		merged := append([]interface{}(nil), b...)
+		index := map[string]int{}
+		for i, elem := range merged {
+			if k, ok := configListKey(elem, key); ok {
+				index[k] = i
+			}
+		}
+		for _, elem := range o {
+			if k, ok := configListKey(elem, key); ok {
+				if i, found := index[k]; found {
+					merged[i] = elem
+					continue
+				}
+			}
+			merged = append(merged, elem)
+		}
+		return merged
+	}
+	return override
+}
+
+// configListKey returns the value of key in the config list element elem.
+func configListKey(elem interface{}, key string) (string, bool) {
+	m, ok := elem.(map[string]interface{})
+	if !ok {
+		return "", false
+	}
+	k, ok := m[key].(string)
+	return k, ok
+}
+
+// parseConfigFile parses the contents b of the config file at path.
+func parseConfigFile(path string, b []byte, strict bool) (*config, error) {
+	var cf configFile
+	if err := decodeConfig(b, &cf, strict); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	for i, v := range cf.ServiceConfigs {
+		if v.InputDirectory == "" {
+			return nil, fmt.Errorf("%s: service-configs[%d] is missing input-directory", path, i)
+		}
+		if v.ImportPath == "" {
+			return nil, fmt.Errorf("%s: service-configs[%d] (%s) is missing import-path", path, i, v.InputDirectory)
+		}
+		if v.RelPath == "" {
+			v.RelPath = strings.TrimPrefix(v.ImportPath, "cloud.google.com/go")
+		}
+	}
+	for i, m := range cf.ManualClients {
+		if m.DistributionName == "" {
+			return nil, fmt.Errorf("%s: manual-clients[%d] is missing distribution-name", path, i)
+		}
+	}
+	c := cf.config()
+	if len(c.GoogleapisToImportPath) != len(cf.ServiceConfigs) {
+		return nil, fmt.Errorf("%s: service-configs contains duplicate input directories", path)
+	}
+	for _, li := range c.GoogleapisToImportPath {
+		c.ClientRelPaths = append(c.ClientRelPaths, li.RelPath)
+	}
+	sort.Strings(c.ClientRelPaths)
+	if err := c.validate(); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return c, nil
+}
+
+func (p *postProcessor) loadConfig() error {
+	var postProcessorConfig configFile
+	path := filepath.Join(p.googleCloudDir, "internal", "postprocessor", "config.yaml")
+	b, err := os.ReadFile(path)
+	if err != nil {
 		return err
 	}
+	if err := decodeConfig(b, &postProcessorConfig, p.strictConfig); err != nil {
+		return fmt.Errorf("%s: %v", path, err)
+	}
 	var owlBotConfig struct {
 		DeepCopyRegex []struct {
 			Source string `yaml:"source"`
@@ -81,19 +742,7 @@ func (p *postProcessor) loadConfig() error {
 		return err
 	}
 
-	c := &config{
-		Modules:                postProcessorConfig.Modules,
-		ClientRelPaths:         make([]string, 0),
-		GoogleapisToImportPath: make(map[string]*libraryInfo),
-		ManualClientInfo:       postProcessorConfig.ManualClients,
-	}
-	for _, v := range postProcessorConfig.ServiceConfigs {
-		c.GoogleapisToImportPath[v.InputDirectory] = &libraryInfo{
-			ServiceConfig: v.ServiceConfig,
-			ImportPath:    v.ImportPath,
-			RelPath:       v.RelPath,
-		}
-	}
+	c := postProcessorConfig.config()
 	for _, v := range owlBotConfig.DeepCopyRegex {
 		i := strings.Index(v.Source, "/cloud.google.com/go")
 		li, ok := c.GoogleapisToImportPath[v.Source[1:i]]
@@ -108,10 +757,221 @@ func (p *postProcessor) loadConfig() error {
 		}
 		c.ClientRelPaths = append(c.ClientRelPaths, li.RelPath)
 	}
+	if err := c.validate(); err != nil {
+		return err
+	}
 	p.config = c
 	return nil
 }
 
+// decodeConfig decodes the YAML post-processor config in b into v. If strict
+// is set, fields that do not exist in v are an error. Upstream files such as
+// service configs are always decoded leniently.
+func decodeConfig(b []byte, v interface{}, strict bool) error {
+	dec := yaml.NewDecoder(bytes.NewReader(b))
+	dec.KnownFields(strict)
+	err := dec.Decode(v)
+	if err == io.EOF {
+		return nil
+	}
+	var typeErr *yaml.TypeError
+	if !errors.As(err, &typeErr) {
+		return err
+	}
+	// Drop the Go type names from unknown field errors, they mean nothing
+	// to someone editing the config.
+	msgs := make([]string, len(typeErr.Errors))
+	for i, msg := range typeErr.Errors {
+		if j := strings.Index(msg, " not found in type "); j >= 0 {
+			msg = strings.Replace(msg[:j], "field ", "unknown field ", 1)
+		}
+		msgs[i] = msg
+	}
+	return fmt.Errorf("invalid config: %s", strings.Join(msgs, "; "))
+}
+
+// validate checks that the loaded config is usable.
+func (c *config) validate() error {
+	if _, err := c.descriptionTemplate(); err != nil {
+		return fmt.Errorf("invalid description-template: %v", err)
+	}
+	for stage, level := range c.LaunchStageLevels {
+		if level != "" && !knownReleaseLevels[level] {
+			return fmt.Errorf("invalid launch-stage-levels: unknown release level %q for %s", level, stage)
+		}
+	}
+	seenSources := map[descriptionSource]bool{}
+	for _, source := range c.DescriptionSources {
+		known := false
+		for _, s := range defaultDescriptionSources {
+			known = known || s == source
+		}
+		if !known {
+			return fmt.Errorf("invalid description-sources: unknown source %q", source)
+		}
+		if seenSources[source] {
+			return fmt.Errorf("invalid description-sources: %q is listed more than once", source)
+		}
+		seenSources[source] = true
+	}
+	if err := c.Detection.validate(); err != nil {
+		return fmt.Errorf("invalid detection: %v", err)
+	}
+	if l := c.UnknownReleaseLevel; l != "" {
+		if err := validatePathSegment(l); err != nil {
+			return fmt.Errorf("invalid unknown-release-level: %v", err)
+		}
+	}
+	if l := c.DefaultReleaseLevelForUnknownStage; l != "" && !knownReleaseLevels[l] {
+		return fmt.Errorf("invalid default-release-level-for-unknown-stage: unknown release level %q", l)
+	}
+	if f := c.GoSourceFile; f != "" && filepath.Ext(f) != ".go" {
+		return fmt.Errorf("invalid go-source-file %q: must be a .go file", f)
+	}
+	if pkg := c.GoSourcePackage; pkg != "" && !token.IsIdentifier(pkg) {
+		return fmt.Errorf("invalid go-source-package %q: must be a Go identifier", pkg)
+	}
+	if c.SkipDocsURL && c.WriteModulePackages {
+		return errors.New("skip-docs-url and write-module-packages can not both be set, module packages need module resolution")
+	}
+	for name, level := range c.ReleaseLevelPins {
+		if !knownReleaseLevels[level] {
+			return fmt.Errorf("invalid release-level-pins: unknown release level %q for %s", level, name)
+		}
+	}
+	for name, date := range c.GraduationDates {
+		if _, err := time.Parse(graduationDateLayout, date); err != nil {
+			return fmt.Errorf("invalid graduation-dates: %s: %v", name, err)
+		}
+	}
+	fields := map[string]bool{}
+	for _, field := range manifestEntryFields() {
+		if field != "" {
+			fields[field] = true
+		}
+	}
+	for field, source := range c.MergePolicy {
+		if !fields[field] {
+			return fmt.Errorf("invalid merge-policy: unknown field %q", field)
+		}
+		if source != manualMergeSource && source != generatedMergeSource {
+			return fmt.Errorf("invalid merge-policy: %s: source must be %q or %q, got %q", field, manualMergeSource, generatedMergeSource, source)
+		}
+	}
+	if err := validatePathSegment(c.docsLanguagePath()); err != nil {
+		return fmt.Errorf("invalid docs-language-path: %v", err)
+	}
+	if u := c.DocsBaseURL; u != "" {
+		if parsed, err := url.Parse(u); err != nil || parsed.Scheme != "https" || parsed.Host == "" {
+			return fmt.Errorf("invalid docs-base-url %q: must be an https URL", u)
+		}
+	}
+	if o := c.Output; o != "" && (filepath.IsAbs(o) || !filepath.IsLocal(o)) {
+		return fmt.Errorf("invalid output %q: must be a path relative to the client root", o)
+	}
+	switch c.format() {
+	case jsonManifestFormat, yamlManifestFormat:
+	default:
+		return fmt.Errorf("invalid format %q: must be %q or %q", c.Format, jsonManifestFormat, yamlManifestFormat)
+	}
+	for level, suffix := range c.DocsURLSuffixes {
+		if !knownReleaseLevels[level] {
+			return fmt.Errorf("invalid docs-url-suffixes: unknown release level %q", level)
+		}
+		if err := validateDocsURLSuffix(suffix); err != nil {
+			return fmt.Errorf("invalid docs-url-suffixes: %s: %v", level, err)
+		}
+	}
+	if name := c.LibraryMetadataFile; name != "" && (name != filepath.Base(name) || name == "." || name == "..") {
+		return fmt.Errorf("invalid library-metadata-file %q: must be a file name", name)
+	}
+	if name := c.AgentMarkerFile; name != "" && (name != filepath.Base(name) || name == "." || name == "..") {
+		return fmt.Errorf("invalid agent-marker-file %q: must be a file name", name)
+	}
+	scopedFiles := map[string]bool{}
+	reserved := c.outputFiles()
+	for _, sm := range c.ScopedManifests {
+		if sm.File == "" || sm.File != filepath.Base(sm.File) || sm.File == "." || sm.File == ".." {
+			return fmt.Errorf("invalid scoped-manifests file %q: must be a file name", sm.File)
+		}
+		if reserved[sm.File] {
+			return fmt.Errorf("invalid scoped-manifests file %q: it is the name of another manifest output", sm.File)
+		}
+		if scopedFiles[sm.File] {
+			return fmt.Errorf("invalid scoped-manifests: file %q is listed more than once", sm.File)
+		}
+		scopedFiles[sm.File] = true
+		if _, err := parseLabelSelector(sm.Selector); err != nil {
+			return fmt.Errorf("invalid scoped-manifests: %v", err)
+		}
+	}
+	if c.MaxEntriesPerDescription < 0 {
+		return fmt.Errorf("invalid max-entries-per-description %d: must not be negative", c.MaxEntriesPerDescription)
+	}
+	for _, s := range c.DeniedTitleSubstrings {
+		if strings.TrimSpace(s) == "" {
+			return fmt.Errorf("invalid denied-title-substrings: substrings must not be empty")
+		}
+	}
+	if c.MinEntries < 0 {
+		return fmt.Errorf("invalid min-entries %d: must not be negative", c.MinEntries)
+	}
+	if c.ServiceConfigOpenAttempts < 0 {
+		return fmt.Errorf("invalid service-config-open-attempts %d: must be positive", c.ServiceConfigOpenAttempts)
+	}
+	if c.MaxManifestBytes < 0 {
+		return fmt.Errorf("invalid max-manifest-bytes %d: must not be negative", c.MaxManifestBytes)
+	}
+	if c.MaxDescriptionLength < 0 {
+		return fmt.Errorf("invalid max-description-length %d: must be positive", c.MaxDescriptionLength)
+	}
+	if c.MaxWorkers < 0 {
+		return fmt.Errorf("invalid max-workers %d: must be positive", c.MaxWorkers)
+	}
+	if _, err := c.maxWorkers(); err != nil {
+		return err
+	}
+	for key, labels := range c.Labels {
+		for _, l := range labels {
+			if strings.TrimSpace(l) == "" {
+				return fmt.Errorf("invalid labels: empty label for %s", key)
+			}
+		}
+	}
+	if strings.ContainsAny(c.HandwrittenMarker, `/\`) {
+		return fmt.Errorf("invalid handwritten-marker %q: must be a file name", c.HandwrittenMarker)
+	}
+	if c.GeneratorVersion != "" && c.GeneratorVersionFile != "" {
+		return errors.New("generator-version and generator-version-file are mutually exclusive")
+	}
+	for _, source := range c.ReleaseLevelDetectors {
+		if !isBuiltinDetectorSource(source) {
+			return fmt.Errorf("invalid release-level-detectors: unknown detector %q", source)
+		}
+	}
+	switch c.manifestKey() {
+	case distributionNameManifestKey, importPathManifestKey:
+	default:
+		return fmt.Errorf("invalid manifest-key %q", c.ManifestKey)
+	}
+	switch c.sortBy() {
+	case distributionNameSortKey, releaseLevelSortKey, moduleSortKey:
+	default:
+		return fmt.Errorf("invalid sort-by %q", c.SortBy)
+	}
+	switch c.jsonFieldNaming() {
+	case snakeCaseNaming, camelCaseNaming:
+	default:
+		return fmt.Errorf("invalid json-field-naming %q", c.JSONFieldNaming)
+	}
+	switch c.moduleResolver() {
+	case goModuleResolver, goModFileResolver, fallbackModuleResolver:
+	default:
+		return fmt.Errorf("invalid module-resolver %q", c.ModuleResolver)
+	}
+	return nil
+}
+
 func (c *config) GapicImportPaths() []string {
 	var s []string
 	for _, v := range c.GoogleapisToImportPath {
@@ -119,3 +979,317 @@ func (c *config) GapicImportPaths() []string {
 	}
 	return s
 }
+
+func (c *config) maxServiceConfigSize() int64 {
+	if c.MaxServiceConfigSize > 0 {
+		return c.MaxServiceConfigSize
+	}
+	return defaultMaxServiceConfigSize
+}
+
+func (c *config) serviceConfigOpenAttempts() int {
+	if c.ServiceConfigOpenAttempts > 0 {
+		return c.ServiceConfigOpenAttempts
+	}
+	return defaultServiceConfigOpenAttempts
+}
+
+func (c *config) maxDescriptionLength() int {
+	if c.MaxDescriptionLength > 0 {
+		return c.MaxDescriptionLength
+	}
+	return defaultMaxDescriptionLength
+}
+
+func (c *config) defaultLanguage() string {
+	if c.DefaultLanguage != "" {
+		return c.DefaultLanguage
+	}
+	return "Go"
+}
+
+func (c *config) moduleResolver() string {
+	if c.ModuleResolver != "" {
+		return c.ModuleResolver
+	}
+	return goModuleResolver
+}
+
+// mergeSource returns the entry that field is taken from when a generated
+// entry and a manual entry collide.
+func (c *config) mergeSource(field string) string {
+	if source, ok := c.MergePolicy[field]; ok {
+		return source
+	}
+	if source, ok := defaultMergePolicy[field]; ok {
+		return source
+	}
+	return generatedMergeSource
+}
+
+func (c *config) releasePleaseConfigFile() string {
+	if c.ReleasePleaseConfigFile != "" {
+		return c.ReleasePleaseConfigFile
+	}
+	return defaultReleasePleaseConfigFile
+}
+
+func (c *config) releaseLevelDetectors() []releaseLevelSource {
+	if len(c.ReleaseLevelDetectors) > 0 {
+		return c.ReleaseLevelDetectors
+	}
+	var sources []releaseLevelSource
+	for _, source := range builtinDetectorSources {
+		switch {
+		case source == launchStageSource && !c.UseLaunchStage,
+			source == snippetMetadataSource && !c.UseSnippetMetadata,
+			source == buildTagSource && !c.UseBuildTags,
+			source == releasePleaseSource && !c.UseReleasePlease,
+			source == changelogSource && !c.UseChangelog:
+			continue
+		}
+		sources = append(sources, source)
+	}
+	return sources
+}
+
+// hasDocsURLOverride reports whether the manual entry or conf with the given
+// distribution name overrides its docs URL.
+func (c *config) hasDocsURLOverride(name string) bool {
+	for _, m := range c.ManualClientInfo {
+		if m.DistributionName == name && m.DocsURLOverride != "" {
+			return true
+		}
+	}
+	for _, conf := range c.GoogleapisToImportPath {
+		if normalizeImportPath(conf.ImportPath) == name && conf.DocsURLOverride != "" {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *config) manifestKey() string {
+	if c.ManifestKey != "" {
+		return c.ManifestKey
+	}
+	return distributionNameManifestKey
+}
+
+func (c *config) sortBy() string {
+	if c.SortBy != "" {
+		return c.SortBy
+	}
+	return distributionNameSortKey
+}
+
+func (c *config) jsonFieldNaming() string {
+	if c.JSONFieldNaming != "" {
+		return c.JSONFieldNaming
+	}
+	return snakeCaseNaming
+}
+
+// manifestFormat returns the configured format of the manifest files.
+func (c *config) manifestFormat() manifestFormat {
+	return manifestFormat{Compact: c.CompactJSON, Naming: c.jsonFieldNaming(), NullUnset: c.NullUnsetFields, YAML: c.format() == yamlManifestFormat}
+}
+
+// outputFiles returns the names of the manifest and of every other output
+// that may be written next to it, whether or not it is enabled.
+func (c *config) outputFiles() map[string]bool {
+	manifest := filepath.Base(c.output())
+	files := map[string]bool{
+		manifest:               true,
+		manifest + ".sha256":   true,
+		jsonlManifestFile:      true,
+		librariesManifestFile:  true,
+		openAPIComponentsFile:  true,
+		descriptionSourcesFile: true,
+		modulePackagesFile:     true,
+	}
+	for level := range knownReleaseLevels {
+		files[splitManifestFile(level)] = true
+	}
+	return files
+}
+
+func (c *config) titleSeparator() string {
+	if c.TitleSeparator != "" {
+		return c.TitleSeparator
+	}
+	return ", "
+}
+
+// docsURLSuffixRe matches the characters that may appear unescaped in the
+// path and query of a URL.
+var docsURLSuffixRe = regexp.MustCompile(`^[A-Za-z0-9\-._~/?=&%+]*$`)
+
+// validateDocsURLSuffix checks that suffix is a URL-safe query or path.
+func validateDocsURLSuffix(suffix string) error {
+	if !strings.HasPrefix(suffix, "?") && !strings.HasPrefix(suffix, "/") {
+		return fmt.Errorf("suffix %q must start with ? or /", suffix)
+	}
+	if !docsURLSuffixRe.MatchString(suffix) {
+		return fmt.Errorf("suffix %q contains characters that are not URL-safe", suffix)
+	}
+	if _, err := url.Parse("https://example.com" + suffix); err != nil {
+		return fmt.Errorf("suffix %q: %v", suffix, err)
+	}
+	return nil
+}
+
+// pathSegmentRe matches the characters that may appear unescaped in a URL
+// path segment.
+var pathSegmentRe = regexp.MustCompile(`^[A-Za-z0-9\-._~]+$`)
+
+// validatePathSegment returns an error unless seg is a single non-empty URL
+// path segment.
+func validatePathSegment(seg string) error {
+	if seg == "." || seg == ".." || !pathSegmentRe.MatchString(seg) {
+		return fmt.Errorf("%q is not a URL path segment", seg)
+	}
+	return nil
+}
+
+func (c *config) docsLanguagePath() string {
+	if c.DocsLanguagePath != "" {
+		return c.DocsLanguagePath
+	}
+	return defaultDocsLanguagePath
+}
+
+// docsBaseURL returns the base of the docs URLs of generated entries, which
+// ends in a slash.
+func (c *config) docsBaseURL() string {
+	if c.DocsBaseURL != "" {
+		return strings.TrimSuffix(c.DocsBaseURL, "/") + "/"
+	}
+	return "https://cloud.google.com/" + c.docsLanguagePath() + "/docs/reference/"
+}
+
+func (c *config) output() string {
+	if c.Output != "" {
+		return c.Output
+	}
+	return defaultManifestOutput
+}
+
+func (c *config) format() string {
+	if c.Format != "" {
+		return c.Format
+	}
+	return jsonManifestFormat
+}
+
+// maxWorkers returns the number of workers used to compute release levels.
+func (c *config) maxWorkers() (int, error) {
+	if v := os.Getenv(maxWorkersEnv); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			return 0, fmt.Errorf("invalid %s %q: must be a positive integer", maxWorkersEnv, v)
+		}
+		return n, nil
+	}
+	if c.MaxWorkers > 0 {
+		return c.MaxWorkers, nil
+	}
+	return runtime.NumCPU(), nil
+}
+
+func (c *config) allowedLibraryTypes() []libraryType {
+	if len(c.AllowedLibraryTypes) > 0 {
+		return c.AllowedLibraryTypes
+	}
+	return knownLibraryTypes
+}
+
+// descriptionSource is a source of the description text of a generated
+// entry.
+type descriptionSource string
+
+const (
+	// titleDescriptionSource is the titles of the service configs.
+	titleDescriptionSource descriptionSource = "title"
+	// summaryDescriptionSource is the documentation summary of the primary
+	// service config.
+	summaryDescriptionSource descriptionSource = "summary"
+	// overrideDescriptionSource is the description override of the conf.
+	overrideDescriptionSource descriptionSource = "description-override"
+	// distributionNameDescriptionSource is the distribution name.
+	distributionNameDescriptionSource descriptionSource = "distribution-name"
+)
+
+// defaultDescriptionSources is the default chain of description sources.
+var defaultDescriptionSources = []descriptionSource{
+	titleDescriptionSource,
+	summaryDescriptionSource,
+	overrideDescriptionSource,
+	distributionNameDescriptionSource,
+}
+
+func (c *config) descriptionSources() []descriptionSource {
+	if len(c.DescriptionSources) > 0 {
+		return c.DescriptionSources
+	}
+	return defaultDescriptionSources
+}
+
+// descriptionData is the data DescriptionTemplate is executed with.
+type descriptionData struct {
+	// Title is the text of the first description source that has one.
+	Title string
+	// ImportPath is the import path of the library.
+	ImportPath string
+	// ReleaseLevel is the computed release level of the library.
+	ReleaseLevel string
+}
+
+func (c *config) descriptionTemplate() (*template.Template, error) {
+	if c.DescriptionTemplate == "" {
+		return nil, nil
+	}
+	return template.New("description").Option("missingkey=error").Parse(c.DescriptionTemplate)
+}
+
+// description builds the description of a generated entry.
+func (c *config) description(title, importPath, releaseLevel string) (string, error) {
+	t, err := c.descriptionTemplate()
+	if err != nil || t == nil {
+		return title, err
+	}
+	var sb strings.Builder
+	data := descriptionData{
+		Title:        title,
+		ImportPath:   importPath,
+		ReleaseLevel: releaseLevel,
+	}
+	if err := t.Execute(&sb, data); err != nil {
+		return "", err
+	}
+	return sb.String(), nil
+}
+
+// unspecifiedLaunchStage is the launch stage of a service config that does
+// not specify one.
+const unspecifiedLaunchStage = "LAUNCH_STAGE_UNSPECIFIED"
+
+// launchStageLevel maps a service config launch stage to a release level. It
+// reports false for an empty stage or one mapped to "", which are no signal
+// unless DefaultReleaseLevelForUnknownStage is set.
+func (c *config) launchStageLevel(stage string) (string, bool, error) {
+	empty := stage == "" || stage == unspecifiedLaunchStage
+	if empty && c.DefaultReleaseLevelForUnknownStage != "" {
+		return c.DefaultReleaseLevelForUnknownStage, true, nil
+	}
+	if level, ok := c.detection().LaunchStageLevels[stage]; ok {
+		return level, level != "", nil
+	}
+	if stage == "" {
+		return "", false, nil
+	}
+	if c.DefaultReleaseLevelForUnknownStage != "" {
+		return c.DefaultReleaseLevelForUnknownStage, true, nil
+	}
+	return "", false, fmt.Errorf("unknown launch stage %q", stage)
+}
@@ -0,0 +1,287 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+	"gopkg.in/yaml.v3"
+)
+
+func TestLoadConfigFile(t *testing.T) {
+	got, err := loadConfigFile("testdata/manifest/config.yaml", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := &config{
+		Modules:        []string{"foo", "bar"},
+		ClientRelPaths: []string{"/bar/apiv1", "/foo/apiv1"},
+		GoogleapisToImportPath: map[string]*libraryInfo{
+			"google/cloud/foo/v1": {
+				ImportPath:    "cloud.google.com/go/foo/apiv1",
+				ServiceConfig: serviceConfigList{"foo_v1.yaml"},
+				RelPath:       "/foo/apiv1",
+			},
+			"google/cloud/bar/v1": {
+				ImportPath:    "cloud.google.com/go/bar/apiv1",
+				ServiceConfig: serviceConfigList{"bar_v1.yaml"},
+				RelPath:       "/bar/apiv1",
+			},
+		},
+		ManualClientInfo: []*ManifestEntry{
+			{
+				DistributionName:  "cloud.google.com/go/baz",
+				Description:       "Baz",
+				Language:          "Go",
+				ClientLibraryType: "manual",
+				DocsURL:           "https://cloud.google.com/go/docs/reference/cloud.google.com/go/baz/latest",
+				ReleaseLevel:      "ga",
+				LibraryType:       gapicManualLibraryType,
+			},
+		},
+		manifestConfig: manifestConfig{
+			WriteJSONL:          true,
+			DescriptionTemplate: "{{.Title}} client",
+		},
+	}
+	if diff := cmp.Diff(want, got, cmp.AllowUnexported(config{})); diff != "" {
+		t.Errorf("loadConfigFile() mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestLoadConfigFileInvalid(t *testing.T) {
+	tests := map[string]string{
+		"missing import path": "service-configs:\n  - input-directory: google/cloud/foo/v1\n    service-config: foo_v1.yaml\n",
+		"missing input dir":   "service-configs:\n  - service-config: foo_v1.yaml\n    import-path: cloud.google.com/go/foo/apiv1\n",
+		"duplicate input dir": "service-configs:\n  - input-directory: a\n    import-path: cloud.google.com/go/a\n  - input-directory: a\n    import-path: cloud.google.com/go/b\n",
+		"missing dist name":   "manual-clients:\n  - description: Baz\n",
+		"bad template":        "manifest:\n  description-template: \"{{.Title\"\n",
+	}
+	for name, content := range tests {
+		t.Run(name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "config.yaml")
+			if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := loadConfigFile(path, false); err == nil {
+				t.Errorf("loadConfigFile() = nil error, want error")
+			}
+		})
+	}
+}
+
+func TestLoadConfigFileStrict(t *testing.T) {
+	content := "service-configs:\n  - input-directory: google/cloud/foo/v1\n    import-path: cloud.google.com/go/foo/apiv1\n    service-conifg: foo_v1.yaml\nmanifest:\n  write-json: true\n"
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := loadConfigFile(path, false); err != nil {
+		t.Fatalf("loadConfigFile() = %v, want nil error when not strict", err)
+	}
+	_, err := loadConfigFile(path, true)
+	if err == nil {
+		t.Fatal("loadConfigFile() = nil error, want error for unknown fields")
+	}
+	for _, field := range []string{"service-conifg", "write-json"} {
+		if !strings.Contains(err.Error(), field) {
+			t.Errorf("loadConfigFile() = %v, want error mentioning %q", err, field)
+		}
+	}
+}
+
+func TestServiceConfigListYAML(t *testing.T) {
+	tests := map[string]serviceConfigList{
+		"service-config: foo_v1.yaml\n":                         {"foo_v1.yaml"},
+		"service-config: [foo_v1.yaml, admin/foo_admin.yaml]\n": {"foo_v1.yaml", "admin/foo_admin.yaml"},
+		"import-path: cloud.google.com/go/foo/apiv1\n":          nil,
+	}
+	for content, want := range tests {
+		var li libraryInfo
+		if err := yaml.Unmarshal([]byte(content), &li); err != nil {
+			t.Fatalf("yaml.Unmarshal(%q) = %v", content, err)
+		}
+		if diff := cmp.Diff(want, li.ServiceConfig); diff != "" {
+			t.Errorf("yaml.Unmarshal(%q) mismatch (-want +got):\n%s", content, diff)
+		}
+	}
+}
+
+func TestMaxWorkers(t *testing.T) {
+	tests := []struct {
+		name    string
+		env     string
+		config  int
+		want    int
+		wantErr bool
+	}{
+		{name: "default", want: runtime.NumCPU()},
+		{name: "config", config: 3, want: 3},
+		{name: "env", env: "2", want: 2},
+		{name: "env overrides config", env: "2", config: 3, want: 2},
+		{name: "env zero", env: "0", wantErr: true},
+		{name: "env not a number", env: "four", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv(maxWorkersEnv, tt.env)
+			c := &config{manifestConfig: manifestConfig{MaxWorkers: tt.config}}
+			got, err := c.maxWorkers()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("maxWorkers() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("maxWorkers() = %d, want %d", got, tt.want)
+			}
+			if err := c.validate(); (err != nil) != tt.wantErr {
+				t.Errorf("validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestPrintConfig(t *testing.T) {
+	t.Setenv(maxWorkersEnv, "3")
+	c, err := loadConfigFile("testdata/manifest/config.yaml", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := &postProcessor{config: c}
+	var buf bytes.Buffer
+	if err := p.PrintConfig(&buf); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		"max-service-config-size: 4194304",
+		"default-language: Go",
+		"module-resolver: go",
+		"max-workers: 3",
+		"GA: ga",
+		"docs_url: generated",
+		"description: manual",
+	} {
+		if !strings.Contains(buf.String(), want) {
+			t.Errorf("PrintConfig() is missing %q, got:\n%s", want, buf.String())
+		}
+	}
+
+	// The printed config loads back to the same effective config.
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(path, buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	got, err := loadConfigFile(path, true)
+	if err != nil {
+		t.Fatalf("loadConfigFile() of printed config = %v", err)
+	}
+	want := *c
+	want.manifestConfig, err = c.effectiveManifestConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(&want, got, cmp.AllowUnexported(config{}), cmpopts.EquateEmpty()); diff != "" {
+		t.Errorf("printed config mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestLoadLayeredConfigFile(t *testing.T) {
+	dir := t.TempDir()
+	base := `modules:
+  - foo
+  - bar
+service-configs:
+  - input-directory: google/cloud/foo/v1
+    import-path: cloud.google.com/go/foo/apiv1
+    service-config: foo_v1.yaml
+  - input-directory: google/cloud/bar/v1
+    import-path: cloud.google.com/go/bar/apiv1
+    service-config: bar_v1.yaml
+manual-clients:
+  - distribution-name: cloud.google.com/go/baz
+    description: Baz
+    docs-url: https://cloud.google.com/go/docs/reference/cloud.google.com/go/baz/latest
+manifest:
+  write-jsonl: true
+  title-separator: " / "
+  launch-stage-levels:
+    EARLY_ACCESS: beta
+`
+	override := `modules:
+  - qux
+service-configs:
+  - input-directory: google/cloud/bar/v1
+    import-path: cloud.google.com/go/bar/apiv2
+    service-config: bar_v1.yaml
+  - input-directory: google/cloud/qux/v1
+    import-path: cloud.google.com/go/qux/apiv1
+    service-config: qux_v1.yaml
+manifest:
+  write-jsonl: false
+  launch-stage-levels:
+    PRELAUNCH: beta
+`
+	basePath := filepath.Join(dir, "base.yaml")
+	overridePath := filepath.Join(dir, "override.yaml")
+	writeTestFiles(t, dir, map[string]string{"base.yaml": base, "override.yaml": override})
+
+	got, err := loadLayeredConfigFile(basePath, overridePath, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"qux"}; !cmp.Equal(got.Modules, want) {
+		t.Errorf("Modules = %v, want %v", got.Modules, want)
+	}
+	importPaths := map[string]string{}
+	for inputDir, li := range got.GoogleapisToImportPath {
+		importPaths[inputDir] = li.ImportPath
+	}
+	wantImportPaths := map[string]string{
+		"google/cloud/foo/v1": "cloud.google.com/go/foo/apiv1",
+		"google/cloud/bar/v1": "cloud.google.com/go/bar/apiv2",
+		"google/cloud/qux/v1": "cloud.google.com/go/qux/apiv1",
+	}
+	if diff := cmp.Diff(wantImportPaths, importPaths); diff != "" {
+		t.Errorf("service configs mismatch (-want +got):\n%s", diff)
+	}
+	if len(got.ManualClientInfo) != 1 || got.ManualClientInfo[0].DistributionName != "cloud.google.com/go/baz" {
+		t.Errorf("ManualClientInfo = %v, want the base manual client", got.ManualClientInfo)
+	}
+	if got.WriteJSONL {
+		t.Errorf("WriteJSONL = true, want it overridden to false")
+	}
+	if got.TitleSeparator != " / " {
+		t.Errorf("TitleSeparator = %q, want the base value", got.TitleSeparator)
+	}
+	if want := map[string]string{"EARLY_ACCESS": "beta", "PRELAUNCH": "beta"}; !cmp.Equal(got.LaunchStageLevels, want) {
+		t.Errorf("LaunchStageLevels = %v, want %v", got.LaunchStageLevels, want)
+	}
+
+	// The merged result is validated.
+	writeTestFiles(t, dir, map[string]string{"override.yaml": "manifest:\n  default-release-level-for-unknown-stage: preview\n"})
+	if _, err := loadLayeredConfigFile(basePath, overridePath, true); err == nil {
+		t.Errorf("loadLayeredConfigFile() = nil error, want error for invalid merged config")
+	}
+	writeTestFiles(t, dir, map[string]string{"override.yaml": "manifest:\n  write-json: true\n"})
+	if _, err := loadLayeredConfigFile(basePath, overridePath, true); err == nil || !strings.Contains(err.Error(), "override.yaml") {
+		t.Errorf("loadLayeredConfigFile() = %v, want error naming the override file", err)
+	}
+}
@@ -0,0 +1,152 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"reflect"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// optionFlags are the manifest options, by their key in the manifest section
+// of the config, that can also be set with a flag of the same name or with
+// the environment variable optionEnv(key). A flag takes precedence over the
+// environment, which takes precedence over the config file.
+var optionFlags = []struct {
+	key, usage string
+	isBool     bool
+}{
+	{key: "check-builds", usage: "Fail if the package of a generated entry does not build.", isBool: true},
+	{key: "compact-json", usage: "Write the manifest without indentation.", isBool: true},
+	{key: "docs-base-url", usage: "Base of the docs URLs of generated entries."},
+	{key: "docs-language-path", usage: "Language path segment of the docs URLs of generated entries."},
+	{key: "dry-run", usage: "Log the manifest outputs that would be written without writing them.", isBool: true},
+	{key: "expand-service-config-tabs", usage: "Expand tabs in the indentation of service configs to spaces.", isBool: true},
+	{key: "format", usage: `Format of the manifest, "json" or "yaml".`},
+	{key: "json-field-naming", usage: `Naming of the manifest fields, "snake_case" or "camelCase".`},
+	{key: "manifest-key", usage: `Key of the manifest entries, "distribution-name" or "import-path".`},
+	{key: "output", usage: "Path of the manifest, relative to the client root."},
+	{key: "stage-manifest", usage: "Stage the written manifest outputs with git add.", isBool: true},
+}
+
+// optionEnv returns the name of the environment variable that sets the
+// manifest option with the given key, such as POSTPROCESSOR_COMPACT_JSON.
+func optionEnv(key string) string {
+	return "POSTPROCESSOR_" + strings.ToUpper(strings.ReplaceAll(key, "-", "_"))
+}
+
+// optionValue is the value of an option flag. It records whether the flag was
+// set so that an unset flag does not override the config.
+type optionValue struct {
+	value  string
+	isBool bool
+	set    bool
+}
+
+func (v *optionValue) String() string { return v.value }
+
+func (v *optionValue) Set(s string) error {
+	v.value, v.set = s, true
+	return nil
+}
+
+func (v *optionValue) IsBoolFlag() bool { return v.isBool }
+
+// registerOptionFlags defines the option flags on fs and returns their values
+// keyed by option key.
+func registerOptionFlags(fs *flag.FlagSet) map[string]*optionValue {
+	values := map[string]*optionValue{}
+	for _, o := range optionFlags {
+		v := &optionValue{isBool: o.isBool}
+		fs.Var(v, o.key, fmt.Sprintf("%s Overrides the %s manifest option and $%s.", o.usage, o.key, optionEnv(o.key)))
+		values[o.key] = v
+	}
+	return values
+}
+
+// optionSetting is the effective value of an option flag along with where it
+// was set: "flag", "env", "file" or "default".
+type optionSetting struct {
+	Key, Value, Source string
+}
+
+// applyOptionFlags sets the manifest options of c from the set flags in
+// values and from the environment variables returned by getenv, and
+// validates the result. It returns the effective value of every option flag.
+func applyOptionFlags(c *config, values map[string]*optionValue, getenv func(string) string) ([]optionSetting, error) {
+	fromFile, err := manifestOptionValues(c.manifestConfig)
+	if err != nil {
+		return nil, err
+	}
+	sources := map[string]string{}
+	for _, o := range optionFlags {
+		var value, source string
+		if v := values[o.key]; v != nil && v.set {
+			value, source = v.value, "flag"
+		} else if v := getenv(optionEnv(o.key)); v != "" {
+			value, source = v, "env"
+		} else {
+			if !reflect.ValueOf(fromFile[o.key]).IsZero() {
+				sources[o.key] = "file"
+			}
+			continue
+		}
+		node := &yaml.Node{Kind: yaml.MappingNode, Content: []*yaml.Node{
+			{Kind: yaml.ScalarNode, Value: o.key},
+			{Kind: yaml.ScalarNode, Value: value},
+		}}
+		if err := node.Decode(&c.manifestConfig); err != nil {
+			return nil, fmt.Errorf("invalid %s %s %q: %v", o.key, source, value, err)
+		}
+		sources[o.key] = source
+	}
+	if err := c.validate(); err != nil {
+		return nil, err
+	}
+	mc, err := c.effectiveManifestConfig()
+	if err != nil {
+		return nil, err
+	}
+	effective, err := manifestOptionValues(mc)
+	if err != nil {
+		return nil, err
+	}
+	settings := make([]optionSetting, 0, len(optionFlags))
+	for _, o := range optionFlags {
+		source := sources[o.key]
+		if source == "" {
+			source = "default"
+		}
+		settings = append(settings, optionSetting{o.key, fmt.Sprint(effective[o.key]), source})
+	}
+	return settings, nil
+}
+
+// manifestOptionValues returns the options of mc keyed by their key in the
+// config.
+func manifestOptionValues(mc manifestConfig) (map[string]interface{}, error) {
+	b, err := yaml.Marshal(mc)
+	if err != nil {
+		return nil, err
+	}
+	var m map[string]interface{}
+	if err := yaml.Unmarshal(b, &m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
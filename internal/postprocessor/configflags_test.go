@@ -0,0 +1,94 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"flag"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestApplyOptionFlags(t *testing.T) {
+	const file = "manifest:\n  json-field-naming: camelCase\n  manifest-key: import-path\n  docs-language-path: golang\n  docs-base-url: https://docs.example.com/reference/\n  output: out/file.json\n  format: json\n"
+	env := map[string]string{
+		"POSTPROCESSOR_JSON_FIELD_NAMING":  "snake_case",
+		"POSTPROCESSOR_MANIFEST_KEY":       "distribution-name",
+		"POSTPROCESSOR_COMPACT_JSON":       "true",
+		"POSTPROCESSOR_DOCS_LANGUAGE_PATH": "",
+		"POSTPROCESSOR_DOCS_BASE_URL":      "https://staging.example.com/reference/",
+		"POSTPROCESSOR_OUTPUT":             "out/env.json",
+		"POSTPROCESSOR_FORMAT":             "yaml",
+	}
+	c, err := parseConfigFile("config.yaml", []byte(file), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	values := registerOptionFlags(fs)
+	if err := fs.Parse([]string{"-json-field-naming=camelCase", "-stage-manifest", "-output=out/flag.yaml", "-dry-run"}); err != nil {
+		t.Fatal(err)
+	}
+	settings, err := applyOptionFlags(c, values, func(k string) string { return env[k] })
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []optionSetting{
+		{"check-builds", "false", "default"},
+		{"compact-json", "true", "env"},
+		{"docs-base-url", "https://staging.example.com/reference/", "env"},
+		{"docs-language-path", "golang", "file"},
+		{"dry-run", "true", "flag"},
+		{"expand-service-config-tabs", "false", "default"},
+		{"format", "yaml", "env"},
+		{"json-field-naming", "camelCase", "flag"},
+		{"manifest-key", "distribution-name", "env"},
+		{"output", "out/flag.yaml", "flag"},
+		{"stage-manifest", "true", "flag"},
+	}
+	if diff := cmp.Diff(want, settings); diff != "" {
+		t.Errorf("applyOptionFlags() settings mismatch (-want +got):\n%s", diff)
+	}
+	if !c.CompactJSON || !c.StageManifest || c.JSONFieldNaming != "camelCase" || c.ManifestKey != "distribution-name" || c.DocsLanguagePath != "golang" {
+		t.Errorf("applyOptionFlags() config = %+v, want the overridden options", c.manifestConfig)
+	}
+
+	// Options set nowhere keep their default.
+	c = &config{}
+	settings, err = applyOptionFlags(c, registerOptionFlags(flag.NewFlagSet("test", flag.ContinueOnError)), func(string) string { return "" })
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range settings {
+		if s.Source != "default" {
+			t.Errorf("applyOptionFlags() %s source = %q, want default", s.Key, s.Source)
+		}
+	}
+	if got := settings[7]; got.Value != snakeCaseNaming {
+		t.Errorf("applyOptionFlags() json-field-naming = %q, want the default %q", got.Value, snakeCaseNaming)
+	}
+
+	// An invalid value is an error, as in the config file.
+	for _, args := range [][]string{{"-json-field-naming=kebab"}, {"-compact-json=maybe"}, {"-format=xml"}, {"-output=/abs/manifest.json"}, {"-docs-base-url=http://insecure.example.com/"}} {
+		fs := flag.NewFlagSet("test", flag.ContinueOnError)
+		values := registerOptionFlags(fs)
+		if err := fs.Parse(args); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := applyOptionFlags(&config{}, values, func(string) string { return "" }); err == nil {
+			t.Errorf("applyOptionFlags(%v) = nil error, want error", args)
+		}
+	}
+}
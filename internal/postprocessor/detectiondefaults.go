@@ -0,0 +1,113 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	_ "embed"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+//go:embed _detection_defaults.yaml
+var detectionDefaultsYAML []byte
+
+// detectionDefaults are the tunables of the release level detection.
+type detectionDefaults struct {
+	// BetaIndicator is a phrase of the beta disclaimer in doc.go.
+	BetaIndicator string `yaml:"beta-indicator,omitempty"`
+	// AlphaIndicator is a phrase of the alpha disclaimer in doc.go, which is
+	// checked before BetaIndicator.
+	AlphaIndicator string `yaml:"alpha-indicator,omitempty"`
+	// StabilityFile is the name of an optional file in a package directory
+	// that explicitly declares the release level of the package.
+	StabilityFile string `yaml:"stability-file,omitempty"`
+	// PreviewBuildTag is the build tag that gates the preview APIs of a
+	// package.
+	PreviewBuildTag string `yaml:"preview-build-tag,omitempty"`
+	// LaunchStageLevels maps the launch stage in a service config to the
+	// release level of the library.
+	LaunchStageLevels map[string]string `yaml:"launch-stage-levels,omitempty"`
+}
+
+// defaultDetection are the detection defaults embedded from
+// _detection_defaults.yaml.
+var defaultDetection = mustLoadDetectionDefaults(detectionDefaultsYAML)
+
+// mustLoadDetectionDefaults is like loadDetectionDefaults but panics on an
+// error, since the embedded defaults are part of the binary.
+func mustLoadDetectionDefaults(b []byte) detectionDefaults {
+	d, err := loadDetectionDefaults(b)
+	if err != nil {
+		panic(fmt.Sprintf("invalid embedded detection defaults: %v", err))
+	}
+	return d
+}
+
+// loadDetectionDefaults decodes a complete set of detection defaults from b.
+// It is an error for any of them to be unset.
+func loadDetectionDefaults(b []byte) (detectionDefaults, error) {
+	var d detectionDefaults
+	if err := decodeConfig(b, &d, true); err != nil {
+		return detectionDefaults{}, err
+	}
+	if d.BetaIndicator == "" || d.AlphaIndicator == "" || d.StabilityFile == "" || d.PreviewBuildTag == "" || len(d.LaunchStageLevels) == 0 {
+		return detectionDefaults{}, errors.New("every detection default must be set")
+	}
+	if err := d.validate(); err != nil {
+		return detectionDefaults{}, err
+	}
+	return d, nil
+}
+
+// validate checks the set detection defaults.
+func (d detectionDefaults) validate() error {
+	if strings.ContainsAny(d.StabilityFile, `/\`) {
+		return fmt.Errorf("stability-file %q must be a file name", d.StabilityFile)
+	}
+	for stage, level := range d.LaunchStageLevels {
+		if level != "" && !knownReleaseLevels[level] {
+			return fmt.Errorf("launch-stage-levels: unknown release level %q for %s", level, stage)
+		}
+	}
+	return nil
+}
+
+// detection returns the embedded detection defaults with the detection
+// options of the config applied. Launch stage levels are merged on top of the
+// defaults, and LaunchStageLevels on top of those.
+func (c *config) detection() detectionDefaults {
+	d := defaultDetection
+	o := c.Detection
+	if o.BetaIndicator != "" {
+		d.BetaIndicator = o.BetaIndicator
+	}
+	if o.AlphaIndicator != "" {
+		d.AlphaIndicator = o.AlphaIndicator
+	}
+	if o.StabilityFile != "" {
+		d.StabilityFile = o.StabilityFile
+	}
+	if o.PreviewBuildTag != "" {
+		d.PreviewBuildTag = o.PreviewBuildTag
+	}
+	d.LaunchStageLevels = map[string]string{}
+	for _, levels := range []map[string]string{defaultDetection.LaunchStageLevels, o.LaunchStageLevels, c.LaunchStageLevels} {
+		for stage, level := range levels {
+			d.LaunchStageLevels[stage] = level
+		}
+	}
+	return d
+}
@@ -0,0 +1,123 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestDetectionDefaults(t *testing.T) {
+	d, err := loadDetectionDefaults(detectionDefaultsYAML)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := detectionDefaults{
+		BetaIndicator:   "It is not stable",
+		AlphaIndicator:  "This package is in alpha",
+		StabilityFile:   ".stability",
+		PreviewBuildTag: "preview",
+		LaunchStageLevels: map[string]string{
+			"LAUNCH_STAGE_UNSPECIFIED": "",
+			"UNIMPLEMENTED":            "alpha",
+			"PRELAUNCH":                "alpha",
+			"EARLY_ACCESS":             "alpha",
+			"ALPHA":                    "alpha",
+			"BETA":                     "beta",
+			"GA":                       "ga",
+			"DEPRECATED":               "",
+		},
+	}
+	if diff := cmp.Diff(want, d); diff != "" {
+		t.Errorf("loadDetectionDefaults() mismatch (-want +got):\n%s", diff)
+	}
+	if diff := cmp.Diff(want, (&config{}).detection()); diff != "" {
+		t.Errorf("detection() without overrides mismatch (-want +got):\n%s", diff)
+	}
+
+	for _, b := range []string{
+		"beta-indicator: It is not stable\n",
+		"beta-indicator: a\nalpha-indicator: b\nstability-file: c\npreview-build-tag: d\nlaunch-stage-levels:\n  GA: stable\n",
+		"unknown: field\n",
+	} {
+		if _, err := loadDetectionDefaults([]byte(b)); err == nil {
+			t.Errorf("loadDetectionDefaults(%q) = nil error, want error", b)
+		}
+	}
+}
+
+func TestDetectionOverrides(t *testing.T) {
+	dir := t.TempDir()
+	writeTestFiles(t, dir, map[string]string{"config.yaml": `manifest:
+  detection:
+    beta-indicator: Its surface may change
+    stability-file: STABILITY
+    launch-stage-levels:
+      EARLY_ACCESS: beta
+  launch-stage-levels:
+    PRELAUNCH: beta
+`})
+	c, err := loadConfigFile(filepath.Join(dir, "config.yaml"), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := c.detection()
+	want := defaultDetection
+	want.BetaIndicator = "Its surface may change"
+	want.StabilityFile = "STABILITY"
+	want.LaunchStageLevels = map[string]string{
+		"LAUNCH_STAGE_UNSPECIFIED": "",
+		"UNIMPLEMENTED":            "alpha",
+		"PRELAUNCH":                "beta",
+		"EARLY_ACCESS":             "beta",
+		"ALPHA":                    "alpha",
+		"BETA":                     "beta",
+		"GA":                       "ga",
+		"DEPRECATED":               "",
+	}
+	if diff := cmp.Diff(want, d); diff != "" {
+		t.Errorf("detection() mismatch (-want +got):\n%s", diff)
+	}
+	if defaultDetection.LaunchStageLevels["EARLY_ACCESS"] != "alpha" {
+		t.Errorf("detection() modified the embedded defaults")
+	}
+
+	writeTestFiles(t, dir, map[string]string{
+		"foo/apiv1/doc.go":    testDocGA,
+		"foo/apiv1/STABILITY": "beta\n",
+		"bar/apiv1/doc.go":    testDocGA + "// Its surface may change.\n",
+	})
+	p := &postProcessor{googleCloudDir: dir, config: c}
+	for _, info := range []*libraryInfo{
+		{ImportPath: "cloud.google.com/go/foo/apiv1", RelPath: "/foo/apiv1"},
+		{ImportPath: "cloud.google.com/go/bar/apiv1", RelPath: "/bar/apiv1"},
+	} {
+		got, err := p.releaseLevel(context.Background(), info)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.Level != "beta" {
+			t.Errorf("releaseLevel(%s) = %q, want beta", info.ImportPath, got.Level)
+		}
+	}
+
+	writeTestFiles(t, dir, map[string]string{"invalid.yaml": "manifest:\n  detection:\n    stability-file: a/b\n"})
+	if _, err := loadConfigFile(filepath.Join(dir, "invalid.yaml"), true); err == nil {
+		t.Errorf("loadConfigFile() = nil error, want error for a stability file path")
+	}
+}
@@ -0,0 +1,117 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"reflect"
+)
+
+// entryExplanation describes how the manifest entry of a distribution was
+// computed, for debugging its metadata.
+type entryExplanation struct {
+	Distribution string `json:"distribution"`
+	ImportPath   string `json:"import_path"`
+	// Module and PkgPath are the module that owns the package and the path
+	// of the package relative to it. They are empty for a manual entry
+	// whose module can not be resolved.
+	Module       string             `json:"module,omitempty"`
+	PkgPath      string             `json:"pkg_path,omitempty"`
+	DocsURL      string             `json:"docs_url"`
+	ReleaseLevel string             `json:"release_level"`
+	Detector     releaseLevelSource `json:"detector"`
+	// ServiceConfig is the path, relative to googleapis, of the primary
+	// service config of a generated entry and Title is its decoded title.
+	ServiceConfig string `json:"service_config,omitempty"`
+	Title         string `json:"title,omitempty"`
+	// Overrides are the fields set for the distribution in the overrides
+	// file, keyed by their JSON name.
+	Overrides map[string]interface{} `json:"overrides,omitempty"`
+	// Pin is the release level pinned for the distribution, if any.
+	Pin string `json:"pin,omitempty"`
+}
+
+// Explain computes the current manifest entries, without writing the
+// manifest, and explains the entry of the named distribution.
+func (p *postProcessor) Explain(ctx context.Context, distribution string) (entryExplanation, error) {
+	entries, err := p.computeManifestEntries(ctx)
+	if err != nil {
+		return entryExplanation{}, err
+	}
+	e, ok := entries[distribution]
+	if !ok {
+		return entryExplanation{}, fmt.Errorf("no manifest entry for %s", distribution)
+	}
+	x := entryExplanation{
+		Distribution: distribution,
+		ImportPath:   distribution,
+		DocsURL:      e.DocsURL,
+		ReleaseLevel: e.ReleaseLevel,
+		Detector:     p.releaseLevelSources[distribution],
+		Pin:          p.config.ReleaseLevelPins[distribution],
+	}
+	if ip, ok := p.importPaths[distribution]; ok {
+		x.ImportPath = ip
+	}
+
+	inputDir, generated := p.generatedInputDirs[distribution]
+	relPath, ok := p.relPaths[distribution]
+	if !ok {
+		relPath, err = p.libraryDir(x.ImportPath)
+	}
+	var pkg modulePackage
+	if err == nil {
+		pkg, err = p.packageLocation(x.ImportPath, relPath)
+	}
+	if err != nil && generated {
+		return entryExplanation{}, fmt.Errorf("unable to resolve module of %s: %v", distribution, err)
+	} else if err == nil {
+		x.Module, x.PkgPath = pkg.Module, pkg.PkgPath
+	}
+
+	if conf := p.config.GoogleapisToImportPath[inputDir]; generated && conf != nil && len(conf.ServiceConfig) > 0 {
+		path, err := p.resolveServiceConfig(inputDir, conf.ServiceConfig[0])
+		if err != nil {
+			return entryExplanation{}, err
+		}
+		sc, err := p.readServiceConfig(path)
+		if err != nil {
+			return entryExplanation{}, err
+		}
+		if rel, err := filepath.Rel(p.googleapisDir, path); err == nil {
+			path = rel
+		}
+		x.ServiceConfig, x.Title = filepath.ToSlash(path), sc.Title
+	}
+
+	overrides, err := p.readManifestOverrides()
+	if err != nil {
+		return entryExplanation{}, err
+	}
+	if override, ok := overrides[distribution]; ok {
+		v := reflect.ValueOf(override)
+		for i, field := range manifestEntryFields() {
+			if f := v.Field(i); field != "" && !f.IsZero() {
+				if x.Overrides == nil {
+					x.Overrides = map[string]interface{}{}
+				}
+				x.Overrides[field] = f.Interface()
+			}
+		}
+	}
+	return x, nil
+}
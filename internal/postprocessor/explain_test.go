@@ -0,0 +1,74 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestExplain(t *testing.T) {
+	p := newTestManifestProcessor(t)
+	p.config.OverridesFile = "internal/manifest-overrides.json"
+	p.config.ReleaseLevelPins = map[string]string{"cloud.google.com/go/foo/apiv1": "beta"}
+	writeTestFiles(t, p.googleCloudDir, map[string]string{
+		p.config.OverridesFile: `{"cloud.google.com/go/foo/apiv1": {"description": "Hand-tuned Foo", "labels": ["ai"]}}`,
+	})
+	got, err := p.Explain(context.Background(), "cloud.google.com/go/foo/apiv1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := entryExplanation{
+		Distribution:  "cloud.google.com/go/foo/apiv1",
+		ImportPath:    "cloud.google.com/go/foo/apiv1",
+		Module:        "cloud.google.com/go/foo",
+		PkgPath:       "apiv1",
+		DocsURL:       "https://cloud.google.com/go/docs/reference/cloud.google.com/go/foo/latest/apiv1",
+		ReleaseLevel:  "beta",
+		Detector:      pinSource,
+		ServiceConfig: "google/cloud/foo/v1/foo_v1.yaml",
+		Title:         "Foo API",
+		Overrides: map[string]interface{}{
+			"description": "Hand-tuned Foo",
+			"labels":      []string{"ai"},
+		},
+		Pin: "beta",
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("Explain() mismatch (-want +got):\n%s", diff)
+	}
+
+	got, err = p.Explain(context.Background(), "cloud.google.com/go/baz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want = entryExplanation{
+		Distribution: "cloud.google.com/go/baz",
+		ImportPath:   "cloud.google.com/go/baz",
+		Module:       "cloud.google.com/go/baz",
+		DocsURL:      "https://cloud.google.com/go/docs/reference/cloud.google.com/go/baz/latest",
+		ReleaseLevel: "ga",
+		Detector:     manualSource,
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("Explain() of a manual entry mismatch (-want +got):\n%s", diff)
+	}
+
+	if _, err := p.Explain(context.Background(), "cloud.google.com/go/unknown"); err == nil {
+		t.Errorf("Explain() = nil error for an unknown distribution, want error")
+	}
+}
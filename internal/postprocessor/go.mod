@@ -6,6 +6,7 @@ require (
 	github.com/go-git/go-git/v5 v5.7.0
 	github.com/google/go-cmp v0.5.9
 	github.com/google/go-github/v52 v52.0.0
+	golang.org/x/mod v0.8.0
 	gopkg.in/yaml.v3 v3.0.1
 )
 
@@ -0,0 +1,102 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"golang.org/x/mod/modfile"
+)
+
+// goWorkDiff describes the differences between the modules of the manifest
+// entries and the modules used by a go.work file. Each field holds sorted
+// module paths.
+type goWorkDiff struct {
+	// MissingFromGoWork are modules of manifest entries that go.work does not
+	// use.
+	MissingFromGoWork []string
+	// MissingFromManifest are modules used by go.work that no manifest entry
+	// belongs to.
+	MissingFromManifest []string
+}
+
+// GoWorkDiff computes the current manifest entries, without writing the
+// manifest, and compares the modules that own them with the modules in the
+// use directives of the go.work file at workPath. Manual entries whose module
+// can not be resolved are ignored.
+func (p *postProcessor) GoWorkDiff(ctx context.Context, workPath string) (*goWorkDiff, error) {
+	used, err := readGoWorkModules(workPath)
+	if err != nil {
+		return nil, err
+	}
+	entries, err := p.computeManifestEntries(ctx)
+	if err != nil {
+		return nil, err
+	}
+	groups, err := p.ManifestByModule(entries)
+	if err != nil {
+		return nil, err
+	}
+	delete(groups, manualModuleBucket)
+	d := &goWorkDiff{}
+	for mod := range groups {
+		if !used[mod] {
+			d.MissingFromGoWork = append(d.MissingFromGoWork, mod)
+		}
+	}
+	for mod := range used {
+		if _, ok := groups[mod]; !ok {
+			d.MissingFromManifest = append(d.MissingFromManifest, mod)
+		}
+	}
+	sort.Strings(d.MissingFromGoWork)
+	sort.Strings(d.MissingFromManifest)
+	return d, nil
+}
+
+// readGoWorkModules returns the paths of the modules in the use directives of
+// the go.work file at path, read from the go.mod in each used directory.
+func readGoWorkModules(path string) (map[string]bool, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	wf, err := modfile.ParseWork(path, b, nil)
+	if err != nil {
+		return nil, err
+	}
+	mods := map[string]bool{}
+	for _, use := range wf.Use {
+		dir := use.Path
+		if !filepath.IsAbs(dir) {
+			dir = filepath.Join(filepath.Dir(path), filepath.FromSlash(dir))
+		}
+		modPath := filepath.Join(dir, "go.mod")
+		b, err := os.ReadFile(modPath)
+		if err != nil {
+			return nil, fmt.Errorf("%s: use %s: %v", path, use.Path, err)
+		}
+		mod := modfile.ModulePath(b)
+		if mod == "" {
+			return nil, fmt.Errorf("%s: use %s: no module path in %s", path, use.Path, modPath)
+		}
+		mods[mod] = true
+	}
+	return mods, nil
+}
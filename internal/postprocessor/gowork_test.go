@@ -0,0 +1,46 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestGoWorkDiff(t *testing.T) {
+	p := newTestManifestProcessor(t)
+	writeTestFiles(t, p.googleCloudDir, map[string]string{
+		"go.work": "go 1.20\n\nuse (\n\t.\n\t./foo\n\t./bar\n)\n",
+	})
+	d, err := p.GoWorkDiff(context.Background(), filepath.Join(p.googleCloudDir, "go.work"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := &goWorkDiff{
+		MissingFromGoWork:   []string{"cloud.google.com/go/baz", "cloud.google.com/go/qux"},
+		MissingFromManifest: []string{"cloud.google.com/go"},
+	}
+	if diff := cmp.Diff(want, d); diff != "" {
+		t.Errorf("GoWorkDiff() mismatch (-want +got):\n%s", diff)
+	}
+
+	writeTestFiles(t, p.googleCloudDir, map[string]string{"go.work": "go 1.20\n\nuse ./missing\n"})
+	if _, err := p.GoWorkDiff(context.Background(), filepath.Join(p.googleCloudDir, "go.work")); err == nil {
+		t.Errorf("GoWorkDiff() = nil error, want error for a used directory without a go.mod")
+	}
+}
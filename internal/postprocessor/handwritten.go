@@ -0,0 +1,132 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// addHandwrittenEntries adds an entry for each handwritten client discovered
+// in the repo that is not already in entries or configured. A handwritten
+// client is a package directory with a doc.go and a file named by the
+// configured HandwrittenMarker, whose first line is the description of the
+// client. The release level is detected as for generated clients, without a
+// service config.
+func (p *postProcessor) addHandwrittenEntries(ctx context.Context, entries map[string]ManifestEntry) error {
+	if p.config.HandwrittenMarker == "" {
+		return nil
+	}
+	tracked := map[string]bool{}
+	for name := range entries {
+		tracked[name] = true
+	}
+	for _, conf := range p.config.GoogleapisToImportPath {
+		tracked[normalizeImportPath(conf.ImportPath)] = true
+	}
+	clients, err := p.discoverHandwrittenClients(tracked)
+	if err != nil {
+		return err
+	}
+	for importPath, relPath := range clients {
+		entry, err := p.handwrittenEntry(ctx, importPath, relPath)
+		if err != nil {
+			return err
+		}
+		p.logf("discovered handwritten client %s", importPath)
+		entries[importPath] = entry
+		if p.importPaths != nil {
+			p.importPaths[importPath] = importPath
+		}
+	}
+	return nil
+}
+
+// discoverHandwrittenClients walks the repo for handwritten client markers
+// and returns the relative paths of the untracked clients, keyed by import
+// path. Directories are skipped as in UntrackedPackages.
+func (p *postProcessor) discoverHandwrittenClients(tracked map[string]bool) (map[string]string, error) {
+	clients := map[string]string{}
+	err := filepath.WalkDir(p.googleCloudDir, func(dir string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.IsDir() || dir == p.googleCloudDir {
+			return nil
+		}
+		if name := d.Name(); name == "internal" || name == "testdata" || strings.HasPrefix(name, ".") || strings.HasPrefix(name, "_") {
+			return filepath.SkipDir
+		}
+		if _, err := os.Stat(filepath.Join(dir, p.config.HandwrittenMarker)); err != nil {
+			return nil
+		}
+		if _, err := os.Stat(filepath.Join(dir, "doc.go")); err != nil {
+			return nil
+		}
+		rel, err := filepath.Rel(p.googleCloudDir, dir)
+		if err != nil {
+			return err
+		}
+		if importPath := path.Join("cloud.google.com/go", filepath.ToSlash(rel)); !tracked[importPath] {
+			clients[importPath] = "/" + filepath.ToSlash(rel)
+		}
+		return nil
+	})
+	return clients, err
+}
+
+// handwrittenEntry computes the manifest entry of the handwritten client with
+// the given import path at relPath.
+func (p *postProcessor) handwrittenEntry(ctx context.Context, importPath, relPath string) (ManifestEntry, error) {
+	markerPath := filepath.Join(p.googleCloudDir, relPath, p.config.HandwrittenMarker)
+	b, err := os.ReadFile(markerPath)
+	if err != nil {
+		return ManifestEntry{}, err
+	}
+	description, _, _ := strings.Cut(string(b), "\n")
+	description = strings.TrimSpace(description)
+	if description == "" {
+		return ManifestEntry{}, fmt.Errorf("handwritten client marker %s has no description", markerPath)
+	}
+	level, err := p.releaseLevel(ctx, &libraryInfo{ImportPath: importPath, RelPath: relPath})
+	if err != nil {
+		return ManifestEntry{}, fmt.Errorf("unable to calculate release level for %s: %v", importPath, err)
+	}
+	var docURL string
+	if !p.config.SkipDocsURL {
+		pkg, err := p.packageLocation(importPath, relPath)
+		if err != nil {
+			return ManifestEntry{}, err
+		}
+		docURL = pkg.docURL(p.config.docsBaseURL(), p.config.KeepModuleRootDocsURLSlash)
+	}
+	if p.releaseLevelSources != nil {
+		p.releaseLevelSources[importPath] = level.Source
+	}
+	return ManifestEntry{
+		DistributionName:  importPath,
+		Description:       description,
+		Language:          p.config.defaultLanguage(),
+		ClientLibraryType: "manual",
+		DocsURL:           docURL,
+		ReleaseLevel:      level.Level,
+		LibraryType:       gapicManualLibraryType,
+	}, nil
+}
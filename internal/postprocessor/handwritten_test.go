@@ -0,0 +1,82 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"io"
+	"log"
+	"os"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestManifestHandwrittenClients(t *testing.T) {
+	p := newTestManifestProcessor(t)
+	writeTestFiles(t, p.googleCloudDir, map[string]string{
+		"hand/go.mod":            "module cloud.google.com/go/hand\n\ngo 1.20\n",
+		"hand/doc.go":            testDocBeta,
+		"hand/.handwritten":      "Hand API\n",
+		"hand/admin/doc.go":      "package admin\n",
+		"unmarked/go.mod":        "module cloud.google.com/go/unmarked\n\ngo 1.20\n",
+		"unmarked/doc.go":        "package unmarked\n",
+		"baz/.handwritten":       "Baz\n",
+		"foo/apiv1/.handwritten": "Foo\n",
+	})
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	entries, err := p.Manifest(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := entries["cloud.google.com/go/hand"]; ok {
+		t.Fatalf("Manifest() discovered a handwritten client without HandwrittenMarker")
+	}
+
+	p.config.HandwrittenMarker = ".handwritten"
+	entries, err = p.Manifest(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := ManifestEntry{
+		DistributionName:  "cloud.google.com/go/hand",
+		Description:       "Hand API",
+		Language:          "Go",
+		ClientLibraryType: "manual",
+		DocsURL:           "https://cloud.google.com/go/docs/reference/cloud.google.com/go/hand/latest",
+		ReleaseLevel:      "beta",
+		LibraryType:       gapicManualLibraryType,
+	}
+	if diff := cmp.Diff(want, entries["cloud.google.com/go/hand"]); diff != "" {
+		t.Errorf("handwritten entry mismatch (-want +got):\n%s", diff)
+	}
+	if source, _ := p.ReleaseLevelSource("cloud.google.com/go/hand"); source != docMarkerSource {
+		t.Errorf("ReleaseLevelSource() = %q, want %q", source, docMarkerSource)
+	}
+	for _, name := range []string{"cloud.google.com/go/hand/admin", "cloud.google.com/go/unmarked"} {
+		if _, ok := entries[name]; ok {
+			t.Errorf("Manifest() has entry %s without a marker", name)
+		}
+	}
+	// Configured clients are not replaced by discovered ones.
+	if got := entries["cloud.google.com/go/baz"].Description; got != "Baz" {
+		t.Errorf("manual entry description = %q, want %q", got, "Baz")
+	}
+	if got := entries["cloud.google.com/go/foo/apiv1"].ClientLibraryType; got != "generated" {
+		t.Errorf("generated entry client library type = %q, want %q", got, "generated")
+	}
+}
@@ -0,0 +1,119 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// inventoryDiff describes the differences between an external inventory of
+// shipped libraries and the manifest. Each field holds sorted distribution
+// names.
+type inventoryDiff struct {
+	// MissingFromManifest are distributions that are only in the inventory.
+	MissingFromManifest []string
+	// MissingFromInventory are distributions that are only in the manifest.
+	MissingFromInventory []string
+}
+
+// diffInventory compares the distribution names in an inventory with the
+// manifest entries.
+func diffInventory(inventory []string, entries map[string]ManifestEntry) *inventoryDiff {
+	d := &inventoryDiff{}
+	inInventory := make(map[string]bool, len(inventory))
+	for _, name := range inventory {
+		inInventory[name] = true
+		if _, ok := entries[name]; !ok {
+			d.MissingFromManifest = append(d.MissingFromManifest, name)
+		}
+	}
+	for name := range entries {
+		if !inInventory[name] {
+			d.MissingFromInventory = append(d.MissingFromInventory, name)
+		}
+	}
+	sort.Strings(d.MissingFromManifest)
+	sort.Strings(d.MissingFromInventory)
+	return d
+}
+
+// readInventoryFile reads the distribution names listed in the inventory at
+// path. A .json inventory is either a list of names or a list of objects with
+// a distribution_name field. Any other inventory is read as CSV with the names
+// in the first column, optionally under a distribution_name header.
+func readInventoryFile(path string) ([]string, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	if strings.EqualFold(filepath.Ext(path), ".json") {
+		names, err = parseJSONInventory(b)
+	} else {
+		names, err = parseCSVInventory(b)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid inventory %s: %v", path, err)
+	}
+	return names, nil
+}
+
+func parseJSONInventory(b []byte) ([]string, error) {
+	var names []string
+	if err := json.Unmarshal(b, &names); err == nil {
+		return names, nil
+	}
+	var objs []struct {
+		DistributionName string `json:"distribution_name"`
+	}
+	if err := json.Unmarshal(b, &objs); err != nil {
+		return nil, err
+	}
+	names = make([]string, 0, len(objs))
+	for i, o := range objs {
+		if o.DistributionName == "" {
+			return nil, fmt.Errorf("item %d is missing distribution_name", i)
+		}
+		names = append(names, o.DistributionName)
+	}
+	return names, nil
+}
+
+func parseCSVInventory(b []byte) ([]string, error) {
+	r := csv.NewReader(bytes.NewReader(b))
+	r.FieldsPerRecord = -1
+	records, err := r.ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for i, rec := range records {
+		name := strings.TrimSpace(rec[0])
+		if i == 0 && name == "distribution_name" {
+			continue
+		}
+		if name != "" {
+			names = append(names, name)
+		}
+	}
+	return names, nil
+}
@@ -0,0 +1,98 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestDiffInventory(t *testing.T) {
+	entries := map[string]ManifestEntry{
+		"cloud.google.com/go/foo/apiv1": {},
+		"cloud.google.com/go/bar/apiv1": {},
+		"cloud.google.com/go/baz":       {},
+	}
+	tests := []struct {
+		name      string
+		inventory []string
+		want      *inventoryDiff
+	}{
+		{
+			name:      "match",
+			inventory: []string{"cloud.google.com/go/baz", "cloud.google.com/go/foo/apiv1", "cloud.google.com/go/bar/apiv1"},
+			want:      &inventoryDiff{},
+		},
+		{
+			name:      "added to manifest",
+			inventory: []string{"cloud.google.com/go/foo/apiv1", "cloud.google.com/go/baz"},
+			want:      &inventoryDiff{MissingFromInventory: []string{"cloud.google.com/go/bar/apiv1"}},
+		},
+		{
+			name: "removed from manifest",
+			inventory: []string{
+				"cloud.google.com/go/foo/apiv1",
+				"cloud.google.com/go/bar/apiv1",
+				"cloud.google.com/go/baz",
+				"cloud.google.com/go/old/apiv1",
+			},
+			want: &inventoryDiff{MissingFromManifest: []string{"cloud.google.com/go/old/apiv1"}},
+		},
+		{
+			name:      "renamed",
+			inventory: []string{"cloud.google.com/go/foo/apiv1", "cloud.google.com/go/baz", "cloud.google.com/go/bar/apiv1beta"},
+			want: &inventoryDiff{
+				MissingFromManifest:  []string{"cloud.google.com/go/bar/apiv1beta"},
+				MissingFromInventory: []string{"cloud.google.com/go/bar/apiv1"},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := diffInventory(tt.inventory, entries)
+			if diff := cmp.Diff(tt.want, got); diff != "" {
+				t.Errorf("diffInventory() mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestReadInventoryFile(t *testing.T) {
+	want := []string{"cloud.google.com/go/foo/apiv1", "cloud.google.com/go/baz"}
+	tests := map[string]string{
+		"inventory.json":         `["cloud.google.com/go/foo/apiv1", "cloud.google.com/go/baz"]`,
+		"inventory-objects.json": `[{"distribution_name": "cloud.google.com/go/foo/apiv1"}, {"distribution_name": "cloud.google.com/go/baz", "owner": "storage"}]`,
+		"inventory.csv":          "distribution_name,owner\ncloud.google.com/go/foo/apiv1,foo\ncloud.google.com/go/baz,baz\n",
+		"headerless.csv":         "cloud.google.com/go/foo/apiv1\ncloud.google.com/go/baz\n",
+	}
+	for name, content := range tests {
+		t.Run(name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), name)
+			if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+				t.Fatal(err)
+			}
+			got, err := readInventoryFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if diff := cmp.Diff(want, got); diff != "" {
+				t.Errorf("readInventoryFile() mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
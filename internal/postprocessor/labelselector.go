@@ -0,0 +1,73 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// labelSelector selects manifest entries by their labels. It is written as
+// either a single label, such as "ai", which selects the entries with that
+// label, or a set, such as "in (ai, ml)", which selects the entries with any
+// label in the set.
+type labelSelector struct {
+	labels map[string]bool
+}
+
+// parseLabelSelector parses a labelSelector.
+func parseLabelSelector(s string) (labelSelector, error) {
+	s = strings.TrimSpace(s)
+	var labels []string
+	if rest, ok := strings.CutPrefix(s, "in"); ok && strings.HasPrefix(strings.TrimSpace(rest), "(") {
+		set, ok := strings.CutSuffix(strings.TrimSpace(rest)[1:], ")")
+		if !ok {
+			return labelSelector{}, fmt.Errorf("invalid label selector %q: unterminated set", s)
+		}
+		labels = strings.Split(set, ",")
+	} else {
+		labels = []string{s}
+	}
+	sel := labelSelector{labels: map[string]bool{}}
+	for _, l := range labels {
+		l = strings.TrimSpace(l)
+		if l == "" || strings.ContainsAny(l, " \t(),") {
+			return labelSelector{}, fmt.Errorf("invalid label selector %q: invalid label %q", s, l)
+		}
+		sel.labels[l] = true
+	}
+	return sel, nil
+}
+
+// matches reports whether the entry has a selected label.
+func (sel labelSelector) matches(e ManifestEntry) bool {
+	for _, l := range e.Labels {
+		if sel.labels[l] {
+			return true
+		}
+	}
+	return false
+}
+
+// selectManifestEntries returns the entries that sel matches.
+func selectManifestEntries(entries map[string]ManifestEntry, sel labelSelector) map[string]ManifestEntry {
+	selected := map[string]ManifestEntry{}
+	for key, e := range entries {
+		if sel.matches(e) {
+			selected[key] = e
+		}
+	}
+	return selected
+}
@@ -0,0 +1,48 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestParseLabelSelector(t *testing.T) {
+	tests := []struct {
+		selector string
+		want     map[string]bool
+		wantErr  bool
+	}{
+		{selector: "ai", want: map[string]bool{"ai": true}},
+		{selector: " in (ai, ml) ", want: map[string]bool{"ai": true, "ml": true}},
+		{selector: "in(ai)", want: map[string]bool{"ai": true}},
+		{selector: "infra", want: map[string]bool{"infra": true}},
+		{selector: "", wantErr: true},
+		{selector: "in (ai, ml", wantErr: true},
+		{selector: "in (ai,)", wantErr: true},
+		{selector: "ai ml", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := parseLabelSelector(tt.selector)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("parseLabelSelector(%q) error = %v, wantErr %v", tt.selector, err, tt.wantErr)
+			continue
+		}
+		if diff := cmp.Diff(tt.want, got.labels); diff != "" {
+			t.Errorf("parseLabelSelector(%q) mismatch (-want +got):\n%s", tt.selector, diff)
+		}
+	}
+}
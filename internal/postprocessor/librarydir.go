@@ -0,0 +1,220 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// errLibraryDirNotFound is returned by libraryDir if no directory is
+// configured for a package and none exists in the module layout.
+var errLibraryDirNotFound = errors.New("library directory not found")
+
+// majorVersionElemRe matches the major version element of a module path, such
+// as v2.
+var majorVersionElemRe = regexp.MustCompile(`^v[2-9]\d*$`)
+
+// libraryDir returns the directory, relative to the repo root and starting
+// with a slash, of the package with the given import path. The directory is
+// taken from the rel-path of the generated libraries with the import path in
+// the config. Otherwise it is derived from the module layout: the import path
+// below cloud.google.com/go if that directory exists, or else the import path
+// without the major version element of a module that does not keep its major
+// versions in subdirectories. It is an error if the config or the layout give
+// more than one directory.
+func (p *postProcessor) libraryDir(importPath string) (string, error) {
+	importPath = normalizeImportPath(importPath)
+	configured := map[string]bool{}
+	for _, conf := range p.config.GoogleapisToImportPath {
+		if conf.RelPath != "" && normalizeImportPath(conf.ImportPath) == importPath {
+			configured[conf.RelPath] = true
+		}
+	}
+	if dir, err := singleLibraryDir(importPath, configured, "the config"); dir != "" || err != nil {
+		return dir, err
+	}
+
+	rel, ok := strings.CutPrefix(importPath, "cloud.google.com/go/")
+	if !ok {
+		return "", fmt.Errorf("unable to find the directory of %s: it is not in cloud.google.com/go", importPath)
+	}
+	elems := strings.Split(rel, "/")
+	candidates := []string{"/" + rel}
+	for i, elem := range elems {
+		if i > 0 && majorVersionElemRe.MatchString(elem) {
+			without := append(append([]string(nil), elems[:i]...), elems[i+1:]...)
+			candidates = append(candidates, "/"+strings.Join(without, "/"))
+		}
+	}
+	derived := map[string]bool{}
+	for i, dir := range candidates {
+		if fi, err := os.Stat(filepath.Join(p.googleCloudDir, dir)); err == nil && fi.IsDir() {
+			if i == 0 {
+				// The exact directory wins over the ones without a major
+				// version element, such as cloudbuild/apiv1/v2 over
+				// cloudbuild/apiv1.
+				return dir, nil
+			}
+			derived[dir] = true
+		}
+	}
+	if dir, err := singleLibraryDir(importPath, derived, "the module layout"); dir != "" || err != nil {
+		return dir, err
+	}
+	return "", fmt.Errorf("unable to find the directory of %s: it is not configured and %s does not exist: %w", importPath, candidates[0], errLibraryDirNotFound)
+}
+
+// withRelPaths returns confs with the rel-path of each conf that has service
+// configs but no rel-path derived from its import path by libraryDir. Confs
+// with service configs but without an import path, such as those of clients
+// that OwlBot does not copy yet, are skipped, since they have no entry. The
+// confs with a derived rel-path are copies, confs itself is not modified. It
+// is an error if the derived directory does not exist.
+func (p *postProcessor) withRelPaths(confs map[string]*libraryInfo) (map[string]*libraryInfo, error) {
+	var derived map[string]*libraryInfo
+	for inputDir, conf := range confs {
+		if conf.RelPath != "" || len(conf.ServiceConfig) == 0 {
+			continue
+		}
+		if derived == nil {
+			derived = make(map[string]*libraryInfo, len(confs))
+			for k, v := range confs {
+				derived[k] = v
+			}
+		}
+		if conf.ImportPath == "" {
+			delete(derived, inputDir)
+			continue
+		}
+		dir, err := p.libraryDir(conf.ImportPath)
+		if err != nil {
+			return nil, fmt.Errorf("%s has no rel-path: %v", inputDir, err)
+		}
+		withDir := *conf
+		withDir.RelPath = dir
+		derived[inputDir] = &withDir
+	}
+	if derived == nil {
+		return confs, nil
+	}
+	return derived, nil
+}
+
+// withoutSparseMissing returns confs without the confs whose library
+// directory does not exist, noting each one that is skipped, if
+// SparseCheckout is set. Otherwise it returns confs. A library whose
+// directory exists is kept even if it is broken, so that it still fails.
+func (p *postProcessor) withoutSparseMissing(confs map[string]*libraryInfo) (map[string]*libraryInfo, error) {
+	if !p.config.SparseCheckout {
+		return confs, nil
+	}
+	var inputDirs []string
+	for inputDir := range confs {
+		inputDirs = append(inputDirs, inputDir)
+	}
+	sort.Strings(inputDirs)
+	present := make(map[string]*libraryInfo, len(confs))
+	for _, inputDir := range inputDirs {
+		conf := confs[inputDir]
+		present[inputDir] = conf
+		if conf.ImportPath == "" || len(conf.ServiceConfig) == 0 {
+			continue
+		}
+		dir := conf.RelPath
+		if dir == "" {
+			var err error
+			dir, err = p.libraryDir(conf.ImportPath)
+			if errors.Is(err, errLibraryDirNotFound) {
+				p.logf("skipping %s: it has no directory in this checkout", conf.ImportPath)
+				delete(present, inputDir)
+				continue
+			} else if err != nil {
+				return nil, fmt.Errorf("%s has no rel-path: %v", inputDir, err)
+			}
+		}
+		if _, err := os.Stat(filepath.Join(p.googleCloudDir, dir)); errors.Is(err, fs.ErrNotExist) {
+			p.logf("skipping %s: its directory %s is not in this checkout", conf.ImportPath, dir)
+			delete(present, inputDir)
+		} else if err != nil {
+			return nil, err
+		}
+	}
+	return present, nil
+}
+
+// checkImportPaths returns an error naming each import path of confs with
+// service configs whose rel-path is not a directory with .go files. It does
+// nothing unless CheckImportPaths is set. The rel-paths must have been
+// resolved by withRelPaths.
+func (p *postProcessor) checkImportPaths(confs map[string]*libraryInfo) error {
+	if !p.config.CheckImportPaths {
+		return nil
+	}
+	var inputDirs []string
+	for inputDir, conf := range confs {
+		if len(conf.ServiceConfig) > 0 {
+			inputDirs = append(inputDirs, inputDir)
+		}
+	}
+	sort.Strings(inputDirs)
+	var errs []error
+	for _, inputDir := range inputDirs {
+		conf := confs[inputDir]
+		dir := filepath.Join(p.googleCloudDir, conf.RelPath)
+		files, err := os.ReadDir(dir)
+		if errors.Is(err, fs.ErrNotExist) {
+			errs = append(errs, fmt.Errorf("import path %s of %s does not resolve to a package: %s does not exist", conf.ImportPath, inputDir, conf.RelPath))
+			continue
+		} else if err != nil {
+			return err
+		}
+		hasGo := false
+		for _, f := range files {
+			if !f.IsDir() && filepath.Ext(f.Name()) == ".go" {
+				hasGo = true
+				break
+			}
+		}
+		if !hasGo {
+			errs = append(errs, fmt.Errorf("import path %s of %s does not resolve to a package: %s has no .go files", conf.ImportPath, inputDir, conf.RelPath))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// singleLibraryDir returns the only one of dirs, or "" if there are none. It
+// is an error if there are several, naming where they came from.
+func singleLibraryDir(importPath string, dirs map[string]bool, from string) (string, error) {
+	var sorted []string
+	for dir := range dirs {
+		sorted = append(sorted, dir)
+	}
+	sort.Strings(sorted)
+	switch len(sorted) {
+	case 0:
+		return "", nil
+	case 1:
+		return sorted[0], nil
+	default:
+		return "", fmt.Errorf("ambiguous directory for %s: %s gives %s", importPath, from, strings.Join(sorted, ", "))
+	}
+}
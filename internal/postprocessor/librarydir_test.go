@@ -0,0 +1,170 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLibraryDir(t *testing.T) {
+	tests := []struct {
+		name       string
+		importPath string
+		files      map[string]string
+		confs      map[string]*libraryInfo
+		want       string
+		wantErr    string
+	}{
+		{
+			name:       "config",
+			importPath: "cloud.google.com/go/foo/apiv1",
+			confs: map[string]*libraryInfo{
+				"google/cloud/foo/v1": {ImportPath: "cloud.google.com/go/foo/apiv1", RelPath: "/foo/internal/apiv1"},
+			},
+			want: "/foo/internal/apiv1",
+		},
+		{
+			name:       "derived",
+			importPath: "cloud.google.com/go/bar/apiv1",
+			files:      map[string]string{"bar/apiv1/doc.go": "package bar\n"},
+			want:       "/bar/apiv1",
+		},
+		{
+			name:       "derived major version",
+			importPath: "cloud.google.com/go/bar/v2/apiv1",
+			files:      map[string]string{"bar/apiv1/doc.go": "package bar\n"},
+			want:       "/bar/apiv1",
+		},
+		{
+			name:       "ambiguous config",
+			importPath: "cloud.google.com/go/foo/apiv1",
+			confs: map[string]*libraryInfo{
+				"google/cloud/foo/v1":       {ImportPath: "cloud.google.com/go/foo/apiv1", RelPath: "/foo/apiv1"},
+				"google/cloud/foo/v1/admin": {ImportPath: "cloud.google.com/go/foo/apiv1/", RelPath: "/foo/admin/apiv1"},
+			},
+			wantErr: "ambiguous directory for cloud.google.com/go/foo/apiv1: the config gives /foo/admin/apiv1, /foo/apiv1",
+		},
+		{
+			name:       "exact layout preferred",
+			importPath: "cloud.google.com/go/cloudbuild/apiv1/v2",
+			files: map[string]string{
+				"cloudbuild/apiv1/doc.go":    "package cloudbuild\n",
+				"cloudbuild/apiv1/v2/doc.go": "package cloudbuild\n",
+			},
+			want: "/cloudbuild/apiv1/v2",
+		},
+		{
+			name:       "ambiguous layout",
+			importPath: "cloud.google.com/go/bar/v2/apiv1/v3",
+			files: map[string]string{
+				"bar/apiv1/v3/doc.go": "package bar\n",
+				"bar/v2/apiv1/doc.go": "package bar\n",
+			},
+			wantErr: "the module layout gives /bar/apiv1/v3, /bar/v2/apiv1",
+		},
+		{
+			name:       "missing",
+			importPath: "cloud.google.com/go/missing/apiv1",
+			wantErr:    "/missing/apiv1 does not exist",
+		},
+		{
+			name:       "outside the repo",
+			importPath: "google.golang.org/api/foo/v1",
+			wantErr:    "not in cloud.google.com/go",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			writeTestFiles(t, dir, tt.files)
+			p := &postProcessor{
+				googleCloudDir: dir,
+				config:         &config{GoogleapisToImportPath: tt.confs},
+			}
+			got, err := p.libraryDir(tt.importPath)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("libraryDir() = %q, %v, want error containing %q", got, err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("libraryDir() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestManifestDerivedRelPath(t *testing.T) {
+	p := newTestManifestProcessor(t)
+	// bar/apiv1 has the beta disclaimer, which is only found if the
+	// directory is derived correctly.
+	p.config.GoogleapisToImportPath["google/cloud/bar/v1"].RelPath = ""
+	entries, err := p.ManifestForInput(context.Background(), "google/cloud/bar/v1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := entries["cloud.google.com/go/bar/apiv1"]
+	if got.ReleaseLevel != "beta" {
+		t.Errorf("ReleaseLevel = %q, want %q", got.ReleaseLevel, "beta")
+	}
+	if want := "https://cloud.google.com/go/docs/reference/cloud.google.com/go/bar/latest/apiv1"; got.DocsURL != want {
+		t.Errorf("DocsURL = %q, want %q", got.DocsURL, want)
+	}
+	if rel := p.config.GoogleapisToImportPath["google/cloud/bar/v1"].RelPath; rel != "" {
+		t.Errorf("the config was modified to rel-path %q, want it left empty", rel)
+	}
+
+	p.config.GoogleapisToImportPath["google/cloud/missing/v1"] = &libraryInfo{
+		ImportPath:    "cloud.google.com/go/missing/apiv1",
+		ServiceConfig: serviceConfigList{"missing_v1.yaml"},
+	}
+	if _, err := p.ManifestForInput(context.Background(), "google/cloud/missing/v1"); err == nil || !strings.Contains(err.Error(), "google/cloud/missing/v1 has no rel-path") {
+		t.Errorf("ManifestForInput() = %v, want an error for the missing directory", err)
+	}
+}
+
+func TestWithRelPathsCommittedConfig(t *testing.T) {
+	root, err := filepath.Abs(filepath.Join("..", ".."))
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := &postProcessor{googleCloudDir: root}
+	if err := p.loadConfig(); err != nil {
+		t.Fatal(err)
+	}
+	confs, err := p.withRelPaths(p.config.GoogleapisToImportPath)
+	if err != nil {
+		t.Fatalf("withRelPaths() = %v, want the directory of every configured library", err)
+	}
+	for inputDir, conf := range confs {
+		if len(conf.ServiceConfig) > 0 && conf.RelPath == "" {
+			t.Errorf("%s has no rel-path", inputDir)
+		}
+	}
+	// Not copied by OwlBot, so it has no import path and no entry.
+	if _, ok := confs["google/firestore/admin/v1"]; ok {
+		t.Errorf("withRelPaths() kept google/firestore/admin/v1, which has no import path")
+	}
+	if got, want := confs["google/devtools/cloudbuild/v1"].RelPath, "/cloudbuild/apiv1/v2"; got != want {
+		t.Errorf("google/devtools/cloudbuild/v1 rel-path = %q, want %q", got, want)
+	}
+}
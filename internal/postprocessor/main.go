@@ -24,12 +24,14 @@ import (
 	"go/parser"
 	"go/token"
 	"html/template"
+	"io"
 	"io/fs"
 	"log"
 	"os"
 	"path/filepath"
 	"regexp"
 	"strings"
+	"sync"
 	"time"
 
 	"cloud.google.com/go/internal/postprocessor/execv/gocmd"
@@ -58,15 +60,35 @@ var (
 )
 
 func main() {
+	os.Exit(run())
+}
+
+// run runs the post-processor and returns the exit status, so that its
+// deferred cleanup happens before main exits.
+func run() int {
 	clientRoot := flag.String("client-root", "/workspace/google-cloud-go", "Path to clients.")
 	googleapisDir := flag.String("googleapis-dir", "", "Path to googleapis/googleapis repo.")
 	directories := flag.String("dirs", "", "Comma-separated list of module names to run (not paths).")
 	branchOverride := flag.String("branch", "", "The branch that should be processed by this code")
 	githubUsername := flag.String("gh-user", "googleapis", "GitHub username where repo lives.")
 	prFilepath := flag.String("pr-file", "/workspace/new_pull_request_text.txt", "Path at which to write text file if changing PR title or body.")
+	configPath := flag.String("config", "", "Path to a single YAML config file for manifest commands. Defaults to the post-processor and OwlBot configs in client-root.")
+	configOverridePath := flag.String("config-override", "", "Path to a YAML config file merged on top of the -config file.")
+	githubActions := flag.Bool("github-actions", os.Getenv("GITHUB_ACTIONS") == "true", "Write warnings as GitHub Actions annotations. Defaults to true when running in GitHub Actions.")
+	strictConfig := flag.Bool("strict-config", false, "Fail on unknown fields in the post-processor config.")
+	quiet := flag.Bool("quiet", false, "Only print errors, and command output such as reports.")
+	verbose := flag.Bool("verbose", false, "Log debug details, such as the effective value and source of each manifest option flag and the description source of each entry.")
+	metrics := flag.Bool("metrics", false, "Log the time spent in each phase of the manifest computation.")
+	options := registerOptionFlags(flag.CommandLine)
 
 	flag.Parse()
 	ctx := context.Background()
+	// errLog prints errors even in quiet mode, which also silences the log
+	// lines of the go and git helpers.
+	errLog := log.New(os.Stderr, "", log.LstdFlags)
+	if *quiet {
+		log.SetOutput(io.Discard)
+	}
 
 	log.Println("client-root set to", *clientRoot)
 	log.Println("googleapis-dir set to", *googleapisDir)
@@ -86,7 +108,8 @@ func main() {
 		log.Println("creating temp dir")
 		tmpDir, err := os.MkdirTemp("", "update-postprocessor")
 		if err != nil {
-			log.Fatal(err)
+			errLog.Print(err)
+			return 1
 		}
 		defer os.RemoveAll(tmpDir)
 
@@ -94,27 +117,50 @@ func main() {
 		*googleapisDir = filepath.Join(tmpDir, "googleapis")
 
 		if err := DeepClone("https://github.com/googleapis/googleapis", *googleapisDir); err != nil {
-			log.Fatal(err)
+			errLog.Print(err)
+			return 1
 		}
 	}
 
+	apisDir, err := resolveDir("googleapis-dir", *googleapisDir)
+	if err != nil {
+		errLog.Print(err)
+		return 1
+	}
+	cloudDir, err := resolveDir("client-root", *clientRoot)
+	if err != nil {
+		errLog.Print(err)
+		return 1
+	}
+
 	p := &postProcessor{
-		googleapisDir:  *googleapisDir,
-		googleCloudDir: *clientRoot,
+		googleapisDir:  apisDir,
+		googleCloudDir: cloudDir,
 		modules:        dirSlice,
 		branchOverride: *branchOverride,
 		githubUsername: *githubUsername,
 		prFilepath:     *prFilepath,
+		strictConfig:   *strictConfig,
+		quiet:          *quiet,
+		verbose:        *verbose,
 	}
-
-	if err := p.loadConfig(); err != nil {
-		log.Fatal(err)
+	if *metrics {
+		p.metrics = &manifestMetrics{}
+	}
+	if *githubActions {
+		p.annotations = os.Stdout
 	}
 
-	if err := p.run(ctx); err != nil {
-		log.Fatal(err)
+	if err := p.execute(ctx, *configPath, *configOverridePath, options, os.Getenv, flag.Args()); err != nil {
+		var r *checkResult
+		if errors.As(err, &r) {
+			fmt.Fprint(os.Stderr, r.Summary())
+		} else {
+			errLog.Print(err)
+		}
+		return exitCode(err)
 	}
-	log.Println("Completed successfully.")
+	return 0
 }
 
 type postProcessor struct {
@@ -131,7 +177,166 @@ type postProcessor struct {
 	githubUsername string
 	prFilepath     string
 
+	// strictConfig makes unknown fields in the post-processor config an
+	// error.
+	strictConfig bool
+
+	// quiet suppresses informational log lines and logged warnings, leaving
+	// only errors.
+	quiet bool
+
+	// verbose turns on debug log lines, unless quiet mode is on.
+	verbose bool
+
+	// annotations, if set, is where warnings are written as GitHub Actions
+	// annotations instead of being logged.
+	annotations io.Writer
+
 	config *config
+
+	// releaseLevelSources records how the release level of each entry was
+	// determined by the most recent manifest computation.
+	releaseLevelSources map[string]releaseLevelSource
+
+	// modulePackages are the packages of the generated entries computed by
+	// the most recent manifest computation, keyed by module.
+	modulePackages map[string][]modulePackage
+
+	// importPaths are the Go import paths of the entries computed by the most
+	// recent manifest computation, keyed by distribution name.
+	importPaths map[string]string
+
+	// generatedInputDirs are the input directories of the generated entries
+	// computed by the most recent manifest computation, keyed by
+	// distribution name.
+	generatedInputDirs map[string]string
+
+	// relPaths are the directories of the generated entries computed by the
+	// most recent manifest computation, relative to the client root and
+	// keyed by distribution name.
+	relPaths map[string]string
+
+	// descriptionConfigs are the absolute paths of the service configs that
+	// the descriptions of the generated entries computed by the most recent
+	// manifest computation are from, keyed by distribution name.
+	descriptionConfigs map[string][]string
+
+	// manifestCounts are the entry counts of the most recent manifest
+	// written.
+	manifestCounts manifestCounts
+
+	// openFile, if set, replaces os.Open for opening service configs.
+	openFile func(path string) (io.ReadCloser, error)
+
+	// serviceConfigs memoizes the service configs decoded by the current
+	// manifest computation.
+	serviceConfigs serviceConfigCache
+
+	// templates memoizes the templates manifest read by the current manifest
+	// computation. It is guarded by templatesMu.
+	templates   *templatesManifest
+	templatesMu sync.Mutex
+
+	// releasePlease memoizes the release-please config read by the current
+	// manifest computation. It is guarded by releasePleaseMu.
+	releasePlease   *releasePleaseConfig
+	releasePleaseMu sync.Mutex
+
+	// renameFile, if set, replaces os.Rename for moving the manifest outputs
+	// into place.
+	renameFile func(oldpath, newpath string) error
+
+	// splitManifest, if set, replaces splitManifestByReleaseLevel for
+	// splitting the manifest into its release level splits.
+	splitManifest func(entries map[string]ManifestEntry) map[string]map[string]ManifestEntry
+
+	// goCurrentMod, if set, replaces gocmd.CurrentMod for resolving the
+	// module of a directory with the go command.
+	goCurrentMod func(dir string) (string, error)
+
+	// goBuild, if set, replaces gocmd.Build for checking that the package
+	// of a generated entry builds.
+	goBuild func(dir string) error
+
+	// now, if set, replaces time.Now for dating the release level history.
+	now func() time.Time
+
+	// detectors, if set, replace the configured chain of release level
+	// detectors.
+	detectors []releaseLevelDetector
+
+	// metrics, if set, collects the time spent in the phases of each
+	// manifest computation, which is logged when it finishes.
+	metrics *manifestMetrics
+}
+
+// execute configures p and then runs the manifest command in args, or the
+// full post-processor if args is empty. The merged config is validated before
+// anything runs, so that an invalid config fails before any file is written.
+func (p *postProcessor) execute(ctx context.Context, configPath, configOverridePath string, options map[string]*optionValue, getenv func(string) string, args []string) error {
+	if err := p.configure(configPath, configOverridePath, options, getenv); err != nil {
+		return err
+	}
+	if len(args) > 0 {
+		return p.runCommand(ctx, args)
+	}
+	if err := p.run(ctx); err != nil {
+		return err
+	}
+	log.Println("Completed successfully.")
+	return nil
+}
+
+// configure loads the config of p from configPath, merged with
+// configOverridePath if set, or by default from the post-processor and
+// OwlBot configs in the client root. The option flags and environment
+// variables are then applied on top and the merged config is validated.
+func (p *postProcessor) configure(configPath, configOverridePath string, options map[string]*optionValue, getenv func(string) string) error {
+	if configOverridePath != "" && configPath == "" {
+		return errors.New("-config-override requires -config")
+	}
+	if configPath != "" {
+		var c *config
+		var err error
+		if configOverridePath != "" {
+			c, err = loadLayeredConfigFile(configPath, configOverridePath, p.strictConfig)
+		} else {
+			c, err = loadConfigFile(configPath, p.strictConfig)
+		}
+		if err != nil {
+			return err
+		}
+		p.config = c
+	} else if err := p.loadConfig(); err != nil {
+		return err
+	}
+	settings, err := applyOptionFlags(p.config, options, getenv)
+	if err != nil {
+		return err
+	}
+	for _, s := range settings {
+		p.debugf("manifest option %s = %s (from %s)", s.Key, s.Value, s.Source)
+	}
+	return nil
+}
+
+// resolveDir returns the absolute path of the directory dir given by the
+// named flag, or an error if it is not an existing directory. Paths are
+// resolved up front so that later joins do not depend on the working
+// directory.
+func resolveDir(name, dir string) (string, error) {
+	abs, err := filepath.Abs(dir)
+	if err != nil {
+		return "", fmt.Errorf("invalid %s %q: %v", name, dir, err)
+	}
+	fi, err := os.Stat(abs)
+	if err != nil {
+		return "", fmt.Errorf("invalid %s %q: %v", name, dir, err)
+	}
+	if !fi.IsDir() {
+		return "", fmt.Errorf("invalid %s %q: %s is not a directory", name, dir, abs)
+	}
+	return abs, nil
 }
 
 func (p *postProcessor) run(ctx context.Context) error {
@@ -142,7 +347,7 @@ func (p *postProcessor) run(ctx context.Context) error {
 		return nil
 	}
 
-	manifest, err := p.Manifest()
+	manifest, err := p.Manifest(ctx)
 	if err != nil {
 		return err
 	}
@@ -16,6 +16,7 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"flag"
 	"log"
 	"os"
@@ -190,3 +191,58 @@ func TestUpdateManifestFile(t *testing.T) {
 		t.Errorf("updateConfigFile() mismatch (-want +got):\n%s", diff)
 	}
 }
+
+func TestResolveDir(t *testing.T) {
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := resolveDir("client-root", "testdata")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := filepath.Join(wd, "testdata"); got != want {
+		t.Errorf("resolveDir() = %q, want %q", got, want)
+	}
+
+	for _, dir := range []string{"testdata/missing", "main.go"} {
+		if _, err := resolveDir("client-root", dir); err == nil || !strings.Contains(err.Error(), "invalid client-root") {
+			t.Errorf("resolveDir(%q) = %v, want an invalid client-root error", dir, err)
+		}
+	}
+}
+
+func TestExecuteValidatesMergedConfigFirst(t *testing.T) {
+	p := newTestManifestProcessor(t)
+	configPath := filepath.Join(t.TempDir(), "config.yaml")
+	var buf bytes.Buffer
+	if err := p.PrintConfig(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(configPath, buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	options := registerOptionFlags(flag.NewFlagSet("test", flag.ContinueOnError))
+	manifestPath := filepath.Join(p.googleCloudDir, "internal", ".repo-metadata-full.json")
+
+	env := map[string]string{"POSTPROCESSOR_MANIFEST_KEY": "bogus"}
+	getenv := func(k string) string { return env[k] }
+	err := p.execute(context.Background(), configPath, "", options, getenv, []string{"manifest"})
+	if err == nil || !strings.Contains(err.Error(), "manifest-key") {
+		t.Fatalf("execute() = %v, want an invalid manifest-key error", err)
+	}
+	if _, err := os.Stat(manifestPath); !os.IsNotExist(err) {
+		t.Errorf("manifest written for an invalid merged config: %v", err)
+	}
+
+	env["POSTPROCESSOR_MANIFEST_KEY"] = "import-path"
+	if err := p.execute(context.Background(), configPath, "", options, getenv, []string{"manifest"}); err != nil {
+		t.Fatal(err)
+	}
+	if got := p.config.ManifestKey; got != "import-path" {
+		t.Errorf("manifest-key = %q, want the env override %q", got, "import-path")
+	}
+	if _, err := os.Stat(manifestPath); err != nil {
+		t.Errorf("manifest not written for a valid merged config: %v", err)
+	}
+}
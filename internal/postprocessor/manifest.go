@@ -15,20 +15,26 @@
 package main
 
 import (
-	"bufio"
+	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"log"
+	"go/token"
+	"io"
+	"io/fs"
 	"os"
+	"path"
 	"path/filepath"
+	"reflect"
+	"sort"
 	"strings"
+	"sync"
+	"time"
 
-	"cloud.google.com/go/internal/postprocessor/execv/gocmd"
 	"gopkg.in/yaml.v3"
 )
 
-const betaIndicator = "It is not stable"
-
 // ManifestEntry is used for JSON marshaling in manifest.
 type ManifestEntry struct {
 	DistributionName  string      `json:"distribution_name" yaml:"distribution-name"`
@@ -38,6 +44,28 @@ type ManifestEntry struct {
 	DocsURL           string      `json:"docs_url" yaml:"docs-url"`
 	ReleaseLevel      string      `json:"release_level" yaml:"release-level"`
 	LibraryType       libraryType `json:"library_type" yaml:"library-type"`
+	// GraduationDate is the date, in RFC 3339 full-date format, that an alpha
+	// or beta library is expected to become ga.
+	GraduationDate string `json:"graduation_date,omitempty" yaml:"graduation-date,omitempty"`
+	// GeneratorVersion is the version of the generator that produced a
+	// generated entry, if configured.
+	GeneratorVersion string `json:"generator_version,omitempty" yaml:"generator-version,omitempty"`
+	// Labels are free-form tags used to group entries in the docs, sorted
+	// and without duplicates.
+	Labels []string `json:"labels,omitempty" yaml:"labels,omitempty"`
+	// DocsURLOverride, if set on a manual entry, is used as its docs URL
+	// without checking it for structural problems. It is not written to the
+	// manifest.
+	DocsURLOverride string `json:"-" yaml:"docs-url-override,omitempty"`
+}
+
+// knownLanguages are the languages a manifest entry may be written in.
+var knownLanguages = map[string]bool{
+	"Go":       true,
+	"Java":     true,
+	"Node.js":  true,
+	"Python":   true,
+	"Protobuf": true,
 }
 
 type libraryType string
@@ -50,94 +78,1406 @@ const (
 	otherLibraryType       libraryType = "OTHER"
 )
 
+// knownLibraryTypes are all of the library types a manifest entry may have.
+var knownLibraryTypes = []libraryType{
+	gapicAutoLibraryType,
+	gapicManualLibraryType,
+	coreLibraryType,
+	agentLibraryType,
+	otherLibraryType,
+}
+
+// The names of the manifest outputs written next to the manifest.
+const (
+	jsonlManifestFile      = ".repo-metadata-full.jsonl"
+	librariesManifestFile  = ".repo-metadata-libraries.json"
+	openAPIComponentsFile  = ".repo-metadata-openapi.json"
+	descriptionSourcesFile = ".repo-metadata-description-sources.json"
+	modulePackagesFile     = ".repo-metadata-modules.json"
+)
+
+// splitManifestFile returns the name of the release level split of the
+// manifest for level.
+func splitManifestFile(level string) string {
+	return ".repo-metadata-" + level + ".json"
+}
+
+// manifestPath returns the path of the manifest file.
+func (p *postProcessor) manifestPath() string {
+	return filepath.Join(p.googleCloudDir, p.config.output())
+}
+
 // Manifest writes a manifest file with info about all of the confs.
-func (p *postProcessor) Manifest() (map[string]ManifestEntry, error) {
-	log.Println("updating gapic manifest")
-	entries := map[string]ManifestEntry{} // Key is the package name.
-	f, err := os.Create(filepath.Join(p.googleCloudDir, "internal", ".repo-metadata-full.json"))
+func (p *postProcessor) Manifest(ctx context.Context) (map[string]ManifestEntry, error) {
+	p.logln("updating gapic manifest")
+	if p.metrics != nil {
+		p.metrics.reset()
+		start := time.Now()
+		defer func() {
+			p.metrics.mu.Lock()
+			p.metrics.Total = time.Since(start)
+			p.metrics.mu.Unlock()
+			p.logf("manifest metrics: %s", p.metrics)
+		}()
+	}
+	entries, err := p.computeManifestEntries(ctx)
 	if err != nil {
 		return nil, err
 	}
-	defer f.Close()
-	for _, manual := range p.config.ManualClientInfo {
-		entries[manual.DistributionName] = *manual
+	// Overrides may set library types, so check them again.
+	if err := p.validateLibraryTypes(entries); err != nil {
+		return nil, err
 	}
-	for inputDir, conf := range p.config.GoogleapisToImportPath {
-		if conf.ServiceConfig == "" {
-			continue
+	if err := p.checkDescriptionLengths(entries); err != nil {
+		return nil, err
+	}
+	p.checkSharedDescriptions(entries)
+	p.checkPathSuffixLevels(entries)
+	p.checkUnknownReleaseLevels(entries)
+	if err := p.checkDocMarkerConflicts(entries); err != nil {
+		return nil, err
+	}
+	if err := p.checkGeneratedDocsURLs(entries); err != nil {
+		return nil, err
+	}
+	if err := p.checkSharedModuleDocsURLs(entries); err != nil {
+		return nil, err
+	}
+	if err := p.checkBuilds(entries); err != nil {
+		return nil, err
+	}
+	for _, name := range p.config.ExcludeFromManifest {
+		if _, ok := entries[name]; ok {
+			p.logf("excluding %s from the manifest", name)
+			delete(entries, name)
+		}
+	}
+	if n := len(entries); n < p.config.MinEntries {
+		return nil, fmt.Errorf("computed %d manifest entries, want at least %d (min-entries)", n, p.config.MinEntries)
+	}
+	keyed, err := p.keyManifestEntries(entries)
+	if err != nil {
+		return nil, err
+	}
+	manifestPath := p.manifestPath()
+	oldEntries, err := readManifestFile(manifestPath)
+	if err != nil {
+		return nil, err
+	}
+	d := diffManifests(oldEntries, keyed)
+	if summary := d.summary(); summary != "" {
+		p.logln(summary)
+	}
+	for _, name := range d.Removed {
+		p.warnFilef(manifestPath, "entry %s is no longer produced and will be removed from the manifest", name)
+	}
+	if summary := d.fieldSummary(); summary != "" {
+		p.logf("changed fields of %d entries: %s", len(d.Changed), summary)
+	}
+	for _, key := range p.suspiciousLevelFlips(oldEntries, keyed) {
+		source, _ := p.ReleaseLevelSource(keyed[key].DistributionName)
+		p.warnFilef(manifestPath, "suspicious auto-flip of %s from %s to %s: its level comes from %s and no stability file, import path or config change explains it", key, oldEntries[key].ReleaseLevel, keyed[key].ReleaseLevel, source)
+	}
+	// Every output is staged first and then replaced together, so that a
+	// failure leaves the previous set of outputs intact.
+	out := &outputSet{rename: p.renameFile}
+	var manifest bytes.Buffer
+	if err := checkManifestOverwrite(manifestPath); err != nil {
+		return nil, err
+	}
+	if err := writeManifest(&manifest, keyed, p.config.manifestFormat()); err != nil {
+		return nil, err
+	}
+	if !p.config.SkipManifestVerification {
+		if err := verifyManifest(manifestPath, manifest.Bytes(), keyed); err != nil {
+			return nil, err
+		}
+	}
+	if err := p.checkManifestSize(manifestPath, manifest.Len()); err != nil {
+		return nil, err
+	}
+	if err := out.add(manifestPath, manifest.Bytes()); err != nil {
+		return nil, err
+	}
+	if p.config.WriteChecksum {
+		if err := out.add(manifestPath+".sha256", checksumLine(manifestPath, manifest.Bytes())); err != nil {
+			return nil, err
 		}
-		yamlPath := filepath.Join(p.googleapisDir, inputDir, conf.ServiceConfig)
-		yamlFile, err := os.Open(yamlPath)
+	}
+	outputDir := filepath.Dir(manifestPath)
+	if p.config.WriteJSONL || p.config.WriteLibrariesManifest {
+		sorted, err := p.sortedOutputEntries(entries)
 		if err != nil {
 			return nil, err
 		}
-		yamlConfig := struct {
-			Title string `yaml:"title"` // We only need the title field.
-		}{}
-		if err := yaml.NewDecoder(yamlFile).Decode(&yamlConfig); err != nil {
-			return nil, fmt.Errorf("decode: %v", err)
+		if p.config.WriteJSONL {
+			jsonlPath := filepath.Join(outputDir, jsonlManifestFile)
+			if err := out.addFunc(jsonlPath, func(w io.Writer) error { return writeManifestJSONL(w, sorted, p.config.manifestFormat()) }); err != nil {
+				return nil, err
+			}
+		}
+		if p.config.WriteLibrariesManifest {
+			librariesPath := filepath.Join(outputDir, librariesManifestFile)
+			if err := out.addFunc(librariesPath, func(w io.Writer) error { return writeLibrariesManifest(w, sorted, p.config.manifestFormat()) }); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if p.config.WriteOpenAPIComponents {
+		openAPIPath := filepath.Join(outputDir, openAPIComponentsFile)
+		if err := out.addFunc(openAPIPath, func(w io.Writer) error { return writeOpenAPIComponents(w, entries, p.config.CompactJSON) }); err != nil {
+			return nil, err
+		}
+	}
+	if p.config.GoSourceFile != "" {
+		goPath := filepath.Join(p.googleCloudDir, p.config.GoSourceFile)
+		if err := checkGoSourceOverwrite(goPath); err != nil {
+			return nil, err
+		}
+		pkg := p.config.GoSourcePackage
+		if pkg == "" {
+			pkg = filepath.Base(filepath.Dir(goPath))
+			if !token.IsIdentifier(pkg) {
+				return nil, fmt.Errorf("go-source-package must be set, the directory name %q of %s is not a Go identifier", pkg, p.config.GoSourceFile)
+			}
+		}
+		if err := out.addFunc(goPath, func(w io.Writer) error { return writeGoSource(w, keyed, pkg) }); err != nil {
+			return nil, err
+		}
+	}
+	if p.config.WriteReleaseLevelSplits || p.config.WriteGAManifest {
+		split := splitManifestByReleaseLevel
+		if p.splitManifest != nil {
+			split = p.splitManifest
+		}
+		splits := split(keyed)
+		if err := checkSplitOverlap(splits); err != nil {
+			return nil, err
+		}
+		var levels []string
+		for level := range splits {
+			if p.config.WriteReleaseLevelSplits || level == "ga" {
+				levels = append(levels, level)
+			}
 		}
-		docURL, err := docURL(p.googleCloudDir, conf.ImportPath, conf.RelPath)
+		sort.Strings(levels)
+		for _, level := range levels {
+			splitPath := filepath.Join(outputDir, splitManifestFile(level))
+			if err := checkManifestOverwrite(splitPath); err != nil {
+				return nil, err
+			}
+			split := splits[level]
+			if err := out.addFunc(splitPath, func(w io.Writer) error { return writeManifest(w, split, p.config.manifestFormat()) }); err != nil {
+				return nil, err
+			}
+		}
+	}
+	for _, sm := range p.config.ScopedManifests {
+		sel, err := parseLabelSelector(sm.Selector)
 		if err != nil {
-			return nil, fmt.Errorf("unable to build docs URL: %v", err)
+			return nil, err
+		}
+		scopedPath := filepath.Join(outputDir, sm.File)
+		if err := checkManifestOverwrite(scopedPath); err != nil {
+			return nil, err
+		}
+		scoped := selectManifestEntries(keyed, sel)
+		if err := out.addFunc(scopedPath, func(w io.Writer) error { return writeManifest(w, scoped, p.config.manifestFormat()) }); err != nil {
+			return nil, err
+		}
+	}
+	if p.config.WriteDescriptionSources {
+		sourcesPath := filepath.Join(outputDir, descriptionSourcesFile)
+		if err := out.addFunc(sourcesPath, func(w io.Writer) error { return writeDescriptionConfigs(w, p.descriptionConfigs, entries) }); err != nil {
+			return nil, err
+		}
+	}
+	if p.config.WriteModulePackages {
+		packagesPath := filepath.Join(outputDir, modulePackagesFile)
+		if err := out.addFunc(packagesPath, func(w io.Writer) error { return writeModulePackages(w, p.modulePackages) }); err != nil {
+			return nil, err
+		}
+	}
+	if p.config.ReleaseLevelHistoryFile != "" {
+		now := time.Now
+		if p.now != nil {
+			now = p.now
+		}
+		if changes := releaseLevelChanges(d, oldEntries, keyed, now()); len(changes) > 0 {
+			historyPath := filepath.Join(p.googleCloudDir, p.config.ReleaseLevelHistoryFile)
+			history, err := appendReleaseLevelHistory(historyPath, changes)
+			if err != nil {
+				return nil, err
+			}
+			if err := out.add(historyPath, history); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if p.config.DryRun {
+		for _, path := range out.paths {
+			p.logf("dry run: would write %s (%d bytes)", path, len(out.contents[path]))
+		}
+		return entries, nil
+	}
+	if err := out.commit(); err != nil {
+		return nil, err
+	}
+	written := out.paths
+	if p.config.StageManifest {
+		if err := p.stageFiles(written); err != nil {
+			return nil, err
 		}
-		releaseLevel, err := releaseLevel(p.googleCloudDir, conf.ImportPath, conf.RelPath)
+	}
+	if err := p.checkCodeowners(entries); err != nil {
+		return nil, err
+	}
+	p.manifestCounts = p.countManifestEntries(entries)
+	p.logf("wrote %d entries (%d generated, %d manual)", len(entries), p.manifestCounts.Generated, p.manifestCounts.Manual)
+	if p.manifestCounts.Generated == 0 && len(p.config.GoogleapisToImportPath) > 0 {
+		p.warnf("no generated entries were produced from %d service configs", len(p.config.GoogleapisToImportPath))
+	}
+	return entries, nil
+}
+
+// manifestCounts are the numbers of generated and manual entries in a
+// manifest. Manual entries merged with a generated entry count as generated.
+type manifestCounts struct {
+	Generated int
+	Manual    int
+}
+
+// countManifestEntries counts the generated and manual entries among the
+// entries of the most recent manifest computation.
+func (p *postProcessor) countManifestEntries(entries map[string]ManifestEntry) manifestCounts {
+	var c manifestCounts
+	for name := range entries {
+		if _, ok := p.generatedInputDirs[name]; ok {
+			c.Generated++
+		} else {
+			c.Manual++
+		}
+	}
+	return c
+}
+
+// ManifestCounts returns the numbers of generated and manual entries written
+// by the most recent call to Manifest.
+func (p *postProcessor) ManifestCounts() manifestCounts {
+	return p.manifestCounts
+}
+
+// stageFiles stages the files at paths in the git worktree of the repo root.
+// It does nothing if the repo root is not a git worktree.
+func (p *postProcessor) stageFiles(paths []string) error {
+	if !isGitWorktree(p.googleCloudDir) {
+		p.logf("%s is not a git worktree, not staging the manifest", p.googleCloudDir)
+		return nil
+	}
+	rel := make([]string, len(paths))
+	for i, path := range paths {
+		r, err := filepath.Rel(p.googleCloudDir, path)
 		if err != nil {
-			return nil, fmt.Errorf("unable to calculate release level for %v: %v", inputDir, err)
+			return err
+		}
+		rel[i] = r
+	}
+	p.logf("staging %s", strings.Join(rel, ", "))
+	return gitAdd(p.googleCloudDir, rel...)
+}
+
+// computeManifestEntries computes the entries of the configured and
+// handwritten libraries, with the configured labels, agent markers, overrides
+// and library metadata files applied, keyed by distribution name.
+func (p *postProcessor) computeManifestEntries(ctx context.Context) (map[string]ManifestEntry, error) {
+	p.resetCaches()
+	entries, err := p.manifestEntries(ctx, p.config.ManualClientInfo, p.config.GoogleapisToImportPath)
+	if err != nil {
+		return nil, err
+	}
+	if err := p.addHandwrittenEntries(ctx, entries); err != nil {
+		return nil, err
+	}
+	p.config.applyLabels(entries)
+	if err := p.applyAgentMarkers(entries); err != nil {
+		return nil, err
+	}
+	if err := p.applyManifestOverrides(entries); err != nil {
+		return nil, err
+	}
+	if err := p.applyLibraryMetadataFiles(entries); err != nil {
+		return nil, err
+	}
+	if err := p.applyReleaseLevelPins(entries); err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+// ManifestForInput computes the manifest entries for a single googleapis
+// input directory, along with its manual counterpart if there is one. Unlike
+// Manifest, it does not write the manifest file.
+func (p *postProcessor) ManifestForInput(ctx context.Context, inputDir string) (map[string]ManifestEntry, error) {
+	p.resetCaches()
+	conf, ok := p.config.GoogleapisToImportPath[inputDir]
+	if !ok {
+		return nil, fmt.Errorf("no service config found for input directory %q", inputDir)
+	}
+	var manual []*ManifestEntry
+	for _, m := range p.config.ManualClientInfo {
+		if m.DistributionName == conf.ImportPath {
+			manual = append(manual, m)
+		}
+	}
+	return p.manifestEntries(ctx, manual, map[string]*libraryInfo{inputDir: conf})
+}
+
+// ManifestForImportPaths computes the manifest entries for the confs with the
+// provided import paths. It returns an error if any import path does not have
+// a conf. Like ManifestForInput, it does not write the manifest file.
+func (p *postProcessor) ManifestForImportPaths(ctx context.Context, paths []string) (map[string]ManifestEntry, error) {
+	p.resetCaches()
+	confs := make(map[string]*libraryInfo, len(paths))
+	for _, path := range paths {
+		inputDir, conf, ok := p.confForImportPath(path)
+		if !ok {
+			return nil, fmt.Errorf("no service config found for import path %q", path)
 		}
+		confs[inputDir] = conf
+	}
+	return p.manifestEntries(ctx, nil, confs)
+}
+
+// PreviewEntry computes the manifest entry of a hypothetical generated library
+// with the given import path at relPath, relative to the repo root, and
+// service config at serviceConfigPath, relative to the googleapis directory,
+// as if it were configured. The configured labels and entry checks are
+// applied. It does not write the manifest file.
+func (p *postProcessor) PreviewEntry(ctx context.Context, relPath, importPath, serviceConfigPath string) (ManifestEntry, error) {
+	p.resetCaches()
+	serviceConfigPath = slashPath(serviceConfigPath)
+	inputDir, serviceConfig := path.Split(serviceConfigPath)
+	inputDir = strings.TrimSuffix(inputDir, "/")
+	if inputDir == "" || serviceConfig == "" {
+		return ManifestEntry{}, fmt.Errorf("service config %q must be a file in an input directory", serviceConfigPath)
+	}
+	conf := &libraryInfo{
+		ImportPath:    importPath,
+		ServiceConfig: serviceConfigList{serviceConfig},
+		RelPath:       "/" + strings.TrimPrefix(slashPath(relPath), "/"),
+	}
+	entries, err := p.manifestEntries(ctx, nil, map[string]*libraryInfo{inputDir: conf})
+	if err != nil {
+		return ManifestEntry{}, err
+	}
+	p.config.applyLabels(entries)
+	if err := p.checkDescriptionLengths(entries); err != nil {
+		return ManifestEntry{}, err
+	}
+	if err := p.checkGeneratedDocsURLs(entries); err != nil {
+		return ManifestEntry{}, err
+	}
+	entry, ok := entries[normalizeImportPath(importPath)]
+	if !ok {
+		return ManifestEntry{}, fmt.Errorf("no entry computed for %s", importPath)
+	}
+	return entry, nil
+}
+
+// normalizeImportPath cleans importPath of backslashes, duplicate and trailing
+// slashes and dot elements so that differently formatted paths produce the
+// same key.
+func normalizeImportPath(importPath string) string {
+	if importPath == "" {
+		return ""
+	}
+	return path.Clean(slashPath(importPath))
+}
+
+// slashPath replaces the backslashes of a path produced by filepath on
+// Windows with forward slashes. Unlike filepath.ToSlash it does so on every
+// OS, since import paths and URLs in the manifest always use forward slashes.
+func slashPath(p string) string {
+	return strings.ReplaceAll(p, `\`, "/")
+}
 
-		entry := ManifestEntry{
-			DistributionName:  conf.ImportPath,
-			Description:       yamlConfig.Title,
-			Language:          "Go",
-			ClientLibraryType: "generated",
-			DocsURL:           docURL,
-			ReleaseLevel:      releaseLevel,
-			LibraryType:       gapicAutoLibraryType,
+// confForImportPath returns the input directory and conf of the generated
+// library with the given import path.
+func (p *postProcessor) confForImportPath(importPath string) (string, *libraryInfo, bool) {
+	for inputDir, conf := range p.config.GoogleapisToImportPath {
+		if conf.ImportPath == importPath {
+			return inputDir, conf, true
 		}
-		entries[conf.ImportPath] = entry
+	}
+	return "", nil, false
+}
+
+// manifestEntries computes the manifest entries for the provided manual
+// clients and confs. Generated and manual entries with the same distribution
+// name are merged according to the merge policy.
+func (p *postProcessor) manifestEntries(ctx context.Context, manual []*ManifestEntry, confs map[string]*libraryInfo) (map[string]ManifestEntry, error) {
+	if err := validateManualDocsURLs(manual); err != nil {
+		return nil, err
+	}
+	entries := map[string]ManifestEntry{} // Key is the package name.
+	sources := map[string]releaseLevelSource{}
+	packages := map[string][]modulePackage{}
+	importPaths := map[string]string{}
+	generated := map[string]string{} // Key is the package name, value the input directory.
+	folded := map[string]string{}    // Key is the lower case package name.
+	relPaths := map[string]string{}
+	descriptionConfigs := map[string][]string{}
+	for _, m := range manual {
+		entry := *m
+		if entry.Language == "" {
+			entry.Language = p.config.defaultLanguage()
+		}
+		if !knownLanguages[entry.Language] {
+			return nil, fmt.Errorf("manual entry %s has unsupported language %q", entry.DistributionName, entry.Language)
+		}
+		if entry.DocsURLOverride != "" {
+			p.logf("using docs URL override %s for %s", entry.DocsURLOverride, entry.DistributionName)
+			entry.DocsURL, entry.DocsURLOverride = entry.DocsURLOverride, ""
+		}
+		source := manualSource
+		if level, ok, err := p.manualReleaseLevel(ctx, m); err != nil {
+			return nil, err
+		} else if ok {
+			entry.ReleaseLevel, source = level.Level, level.Source
+		}
+		entries[m.DistributionName] = entry
+		sources[m.DistributionName] = source
+		importPaths[m.DistributionName] = m.DistributionName
+	}
+	confs, err := p.withoutSparseMissing(confs)
+	if err != nil {
+		return nil, err
+	}
+	confs, err = p.withRelPaths(confs)
+	if err != nil {
+		return nil, err
+	}
+	if err := p.checkImportPaths(confs); err != nil {
+		return nil, err
+	}
+	yamlPaths, err := p.serviceConfigPaths(confs)
+	if err != nil {
+		return nil, err
+	}
+	workers, err := p.config.maxWorkers()
+	if err != nil {
+		return nil, err
+	}
+	levels, err := p.releaseLevels(ctx, confs, yamlPaths, workers)
+	if err != nil {
+		return nil, err
+	}
+	generatorVersion, err := p.generatorVersion()
+	if err != nil {
+		return nil, err
+	}
+	// Check for import paths that only collide once normalized before
+	// computing any entry, whose errors would otherwise hide the collision.
+	// Input directories with the same import path, such as a client and its
+	// subdirectory with the same service config, generate a single entry,
+	// which is computed from the first of them.
+	var all []string
+	for inputDir, conf := range confs {
+		if len(conf.ServiceConfig) > 0 {
+			all = append(all, inputDir)
+		}
+	}
+	sort.Strings(all)
+	inputDirs := make([]string, 0, len(all))
+	for _, inputDir := range all {
+		raw := confs[inputDir].ImportPath
+		name := normalizeImportPath(raw)
+		if other, ok := generated[name]; ok {
+			if otherRaw := confs[other].ImportPath; otherRaw != raw {
+				return nil, fmt.Errorf("import paths %q of %s and %q of %s both normalize to %s", otherRaw, other, raw, inputDir, name)
+			}
+			continue
+		}
+		if other, ok := folded[strings.ToLower(name)]; ok {
+			return nil, fmt.Errorf("import paths %s and %s differ only in case", other, name)
+		}
+		generated[name] = inputDir
+		folded[strings.ToLower(name)] = name
+		inputDirs = append(inputDirs, inputDir)
+	}
+	for _, inputDir := range inputDirs {
+		info := *confs[inputDir]
+		info.ImportPath = normalizeImportPath(info.ImportPath)
+		entry, pkg, descConfigs, err := p.manifestEntry(inputDir, &info, yamlPaths[inputDir], levels[inputDir].Level)
+		if err != nil {
+			return nil, err
+		}
+		entry.GeneratorVersion = generatorVersion
+		name := entry.DistributionName
+		if !p.config.SkipDocsURL {
+			packages[pkg.Module] = append(packages[pkg.Module], pkg)
+		}
+		source := levels[inputDir].Source
+		if m, ok := entries[name]; ok {
+			if p.config.FailOnManualShadowing {
+				return nil, fmt.Errorf("generated entry for %s collides with a manual entry", name)
+			}
+			p.warnf("generated entry for %s shadows a manual entry, merging the entries", name)
+			entry = p.config.mergeEntries(entry, m)
+			if m.ReleaseLevel != "" && p.config.mergeSource("release_level") == manualMergeSource {
+				source = manualSource
+			}
+		}
+		entries[name] = entry
+		sources[name] = source
+		importPaths[name] = info.ImportPath
+		relPaths[name] = info.RelPath
+		if len(descConfigs) > 0 {
+			descriptionConfigs[name] = descConfigs
+		}
+	}
+	for name, date := range p.config.GraduationDates {
+		entry, ok := entries[name]
+		if !ok {
+			continue
+		}
+		if entry.ReleaseLevel == "ga" {
+			return nil, fmt.Errorf("graduation date set for %s, which is already ga", name)
+		}
+		entry.GraduationDate = date
+		entries[name] = entry
 	}
 	// Remove base module entry
 	delete(entries, "")
-	enc := json.NewEncoder(f)
-	enc.SetIndent("", "  ")
-	return entries, enc.Encode(entries)
+	delete(sources, "")
+	delete(importPaths, "")
+	delete(generated, "")
+	delete(relPaths, "")
+	delete(descriptionConfigs, "")
+	p.releaseLevelSources = sources
+	p.importPaths = importPaths
+	p.generatedInputDirs = generated
+	p.relPaths = relPaths
+	p.descriptionConfigs = descriptionConfigs
+	for _, pkgs := range packages {
+		sort.Slice(pkgs, func(i, j int) bool { return pkgs[i].ImportPath < pkgs[j].ImportPath })
+	}
+	p.modulePackages = packages
+	if err := p.validateLibraryTypes(entries); err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+// applyLabels adds the configured labels to each of the entries. A label
+// rule applies to the entry with the distribution name of its key or, if
+// the key ends in "/...", to every entry whose distribution name has the
+// key's prefix. The labels of each entry are sorted and deduplicated.
+func (c *config) applyLabels(entries map[string]ManifestEntry) {
+	for name, e := range entries {
+		labels := append([]string(nil), e.Labels...)
+		for key, rule := range c.Labels {
+			if prefix, ok := strings.CutSuffix(key, "/..."); ok {
+				if name != prefix && !strings.HasPrefix(name, prefix+"/") {
+					continue
+				}
+			} else if key != name {
+				continue
+			}
+			labels = append(labels, rule...)
+		}
+		if len(labels) == 0 {
+			continue
+		}
+		sort.Strings(labels)
+		e.Labels = labels[:0]
+		for i, l := range labels {
+			if i == 0 || l != labels[i-1] {
+				e.Labels = append(e.Labels, l)
+			}
+		}
+		entries[name] = e
+	}
 }
 
-func docURL(cloudDir, importPath, relPath string) (string, error) {
-	dir := filepath.Join(cloudDir, relPath)
-	mod, err := gocmd.CurrentMod(dir)
+// applyManifestOverrides merges the entries in the configured overrides file,
+// if any, on top of the computed entries. Only fields that are set in an
+// override replace the computed values.
+func (p *postProcessor) applyManifestOverrides(entries map[string]ManifestEntry) error {
+	overrides, err := p.readManifestOverrides()
 	if err != nil {
-		return "", err
+		return err
 	}
-	pkgPath := strings.TrimPrefix(strings.TrimPrefix(importPath, mod), "/")
-	return "https://cloud.google.com/go/docs/reference/" + mod + "/latest/" + pkgPath, nil
+	for name, override := range overrides {
+		entry, ok := entries[name]
+		if !ok {
+			return fmt.Errorf("invalid overrides file %s: no entry for %q", p.overridesPath(), name)
+		}
+		mergeManifestEntry(&entry, override)
+		entries[name] = entry
+		if override.ReleaseLevel != "" && p.releaseLevelSources != nil {
+			p.releaseLevelSources[name] = overrideSource
+		}
+	}
+	return nil
+}
+
+// applyReleaseLevelPins replaces the release level of each entry with a
+// configured pin. It returns an error if a pin names no entry.
+func (p *postProcessor) applyReleaseLevelPins(entries map[string]ManifestEntry) error {
+	var names []string
+	for name := range p.config.ReleaseLevelPins {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if _, ok := entries[name]; !ok {
+			return fmt.Errorf("invalid release-level-pins: no entry for %q", name)
+		}
+		p.pinReleaseLevel(entries, name)
+	}
+	return nil
+}
+
+// pinReleaseLevel replaces the release level of the entry named name, which
+// must exist, with its configured pin, if any.
+func (p *postProcessor) pinReleaseLevel(entries map[string]ManifestEntry, name string) {
+	level, ok := p.config.ReleaseLevelPins[name]
+	if !ok {
+		return
+	}
+	entry := entries[name]
+	p.logf("pinning the release level of %s to %s, detected %s", name, level, entry.ReleaseLevel)
+	entry.ReleaseLevel = level
+	entries[name] = entry
+	if p.releaseLevelSources != nil {
+		p.releaseLevelSources[name] = pinSource
+	}
+}
+
+// applyAgentMarkers sets the library type of each entry with the configured
+// agent marker file in its directory to AGENT. Entries without a directory
+// are left as computed, and it is an error if an entry has more than one.
+func (p *postProcessor) applyAgentMarkers(entries map[string]ManifestEntry) error {
+	if p.config.AgentMarkerFile == "" {
+		return nil
+	}
+	for name, entry := range entries {
+		dir, err := p.libraryDir(name)
+		if errors.Is(err, errLibraryDirNotFound) {
+			continue
+		} else if err != nil {
+			return err
+		}
+		path := filepath.Join(p.googleCloudDir, dir, p.config.AgentMarkerFile)
+		if _, err := os.Stat(path); errors.Is(err, fs.ErrNotExist) {
+			continue
+		} else if err != nil {
+			return err
+		}
+		if entry.LibraryType != agentLibraryType {
+			p.logf("%s has agent marker %s, setting its library type to %s", name, path, agentLibraryType)
+			entry.LibraryType = agentLibraryType
+			entries[name] = entry
+		}
+	}
+	return nil
+}
+
+// applyLibraryMetadataFiles replaces the entry of each library that has a
+// configured library metadata file in its directory with the entry in that
+// file. The file holds a single entry, whose distribution name defaults to
+// the distribution it replaces. Each replacement entry must be valid. Entries
+// without a directory are left as computed, and it is an error if an entry has
+// more than one.
+func (p *postProcessor) applyLibraryMetadataFiles(entries map[string]ManifestEntry) error {
+	if p.config.LibraryMetadataFile == "" {
+		return nil
+	}
+	for name := range entries {
+		dir, err := p.libraryDir(name)
+		if errors.Is(err, errLibraryDirNotFound) {
+			continue
+		} else if err != nil {
+			return err
+		}
+		path := filepath.Join(p.googleCloudDir, dir, p.config.LibraryMetadataFile)
+		entry, err := readLibraryMetadataFile(path)
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		} else if err != nil {
+			return err
+		}
+		if entry.DistributionName == "" {
+			entry.DistributionName = name
+		}
+		var errs []error
+		for _, err := range p.ValidateEntries(map[string]ManifestEntry{name: entry}) {
+			errs = append(errs, err)
+		}
+		if err := errors.Join(errs...); err != nil {
+			return fmt.Errorf("invalid library metadata file %s: %v", path, err)
+		}
+		p.logf("using the entry in %s for %s", path, name)
+		entries[name] = entry
+		if p.releaseLevelSources != nil {
+			p.releaseLevelSources[name] = overrideSource
+		}
+	}
+	return nil
+}
+
+// readLibraryMetadataFile reads the single manifest entry in the library
+// metadata file at path.
+func readLibraryMetadataFile(path string) (ManifestEntry, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return ManifestEntry{}, err
+	}
+	defer f.Close()
+	var entry ManifestEntry
+	dec := json.NewDecoder(f)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&entry); err != nil {
+		return ManifestEntry{}, fmt.Errorf("invalid library metadata file %s: %v", path, err)
+	}
+	return entry, nil
+}
+
+// overridesPath returns the path of the configured overrides file.
+func (p *postProcessor) overridesPath() string {
+	return filepath.Join(p.googleCloudDir, p.config.OverridesFile)
+}
+
+// readManifestOverrides reads the configured overrides file. It returns nil
+// overrides if there is no overrides file.
+func (p *postProcessor) readManifestOverrides() (map[string]ManifestEntry, error) {
+	if p.config.OverridesFile == "" {
+		return nil, nil
+	}
+	path := p.overridesPath()
+	f, err := os.Open(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var overrides map[string]ManifestEntry
+	dec := json.NewDecoder(f)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&overrides); err != nil {
+		return nil, fmt.Errorf("invalid overrides file %s: %v", path, err)
+	}
+	for name, override := range overrides {
+		if override.DistributionName != "" && override.DistributionName != name {
+			return nil, fmt.Errorf("invalid overrides file %s: override for %q sets distribution_name %q", path, name, override.DistributionName)
+		}
+	}
+	return overrides, nil
+}
+
+// mergeManifestEntry sets each field of dst to the corresponding field of src
+// if the field is set in src.
+func mergeManifestEntry(dst *ManifestEntry, src ManifestEntry) {
+	dv := reflect.ValueOf(dst).Elem()
+	sv := reflect.ValueOf(src)
+	for i := 0; i < sv.NumField(); i++ {
+		if !sv.Field(i).IsZero() {
+			dv.Field(i).Set(sv.Field(i))
+		}
+	}
+}
+
+// Sources that a field of a merged entry may be taken from.
+const (
+	manualMergeSource    = "manual"
+	generatedMergeSource = "generated"
+)
+
+// defaultMergePolicy is the source that each field of an entry is taken from
+// when a generated entry and a manual entry have the same distribution name.
+// The manual entry is curated by hand, so it wins for the human-facing fields,
+// while the generated entry wins for everything that is computed from the
+// library itself, such as the docs URL and release level. The policy may be
+// overridden per field with MergePolicy.
+var defaultMergePolicy = map[string]string{
+	"description":         manualMergeSource,
+	"client_library_type": manualMergeSource,
+	"library_type":        manualMergeSource,
+}
+
+// manifestEntryFields returns the JSON names of the fields of ManifestEntry,
+// in declaration order. Fields that are not written to the manifest have an
+// empty name.
+func manifestEntryFields() []string {
+	t := reflect.TypeOf(ManifestEntry{})
+	fields := make([]string, t.NumField())
+	for i := range fields {
+		if name, _, _ := strings.Cut(t.Field(i).Tag.Get("json"), ","); name != "-" {
+			fields[i] = name
+		}
+	}
+	return fields
+}
+
+// mergeEntries merges a generated entry and a manual entry with the same
+// distribution name field by field, according to the merge policy. If the
+// preferred entry does not set a field, it is taken from the other entry.
+func (c *config) mergeEntries(generated, manual ManifestEntry) ManifestEntry {
+	merged := generated
+	mv := reflect.ValueOf(&merged).Elem()
+	manv := reflect.ValueOf(manual)
+	for i, field := range manifestEntryFields() {
+		if field == "" {
+			continue
+		}
+		preferred, other := mv.Field(i), manv.Field(i)
+		if c.mergeSource(field) == manualMergeSource {
+			preferred, other = other, preferred
+		}
+		if preferred.IsZero() {
+			preferred = other
+		}
+		mv.Field(i).Set(preferred)
+	}
+	return merged
+}
+
+// checkManifestSize reports a manifest of size bytes at path that is over
+// the MaxManifestBytes budget, if one is configured, in a warning or, with
+// FailOnManifestSize, as an error.
+func (p *postProcessor) checkManifestSize(path string, size int) error {
+	budget := p.config.MaxManifestBytes
+	if budget == 0 || size <= budget {
+		return nil
+	}
+	msg := fmt.Sprintf("manifest %s is %d bytes, over the budget of %d bytes (max-manifest-bytes)", path, size, budget)
+	if p.config.FailOnManifestSize {
+		return errors.New(msg)
+	}
+	p.warnFilef(path, "%s", msg)
+	return nil
+}
+
+// deniedTitleSubstring returns the first of the configured
+// DeniedTitleSubstrings that title contains, ignoring case, or "" if it
+// contains none of them.
+func (p *postProcessor) deniedTitleSubstring(title string) string {
+	lower := strings.ToLower(title)
+	for _, s := range p.config.DeniedTitleSubstrings {
+		if strings.Contains(lower, strings.ToLower(s)) {
+			return s
+		}
+	}
+	return ""
 }
 
-func releaseLevel(cloudDir, importPath, relPath string) (string, error) {
-	i := strings.LastIndex(importPath, "/")
-	lastElm := importPath[i+1:]
-	if strings.Contains(lastElm, "alpha") {
-		return "alpha", nil
-	} else if strings.Contains(lastElm, "beta") {
-		return "beta", nil
+// manifestEntry computes the manifest entry for a single conf with the given,
+// already resolved, service config paths and release level, along with the
+// location of its package and the service configs its description is from,
+// if any. The description is the text of the first description source that
+// has one, see descriptionSources.
+func (p *postProcessor) manifestEntry(inputDir string, conf *libraryInfo, yamlPaths []string, releaseLevel string) (ManifestEntry, modulePackage, []string, error) {
+	titles := make([]string, len(yamlPaths))
+	var hasTitle bool
+	var summary string
+	for i, yamlPath := range yamlPaths {
+		sc, err := p.readServiceConfig(yamlPath)
+		if err != nil {
+			return ManifestEntry{}, modulePackage{}, nil, err
+		}
+		titles[i] = sc.title(p.config.TitleLanguage)
+		if denied := p.deniedTitleSubstring(titles[i]); denied != "" {
+			msg := fmt.Sprintf("service config %s of %s has the placeholder title %q (denied %q)", yamlPath, conf.ImportPath, titles[i], denied)
+			if p.config.FailOnDeniedTitles {
+				return ManifestEntry{}, modulePackage{}, nil, errors.New(msg)
+			}
+			p.warnFilef(yamlPath, "%s", msg)
+		}
+		hasTitle = hasTitle || titles[i] != ""
+		if i == 0 {
+			summary = strings.Join(strings.Fields(sc.Documentation.Summary), " ")
+		}
+	}
+	pkg, docURL, err := p.generatedDocsURL(conf, releaseLevel)
+	if err != nil {
+		return ManifestEntry{}, modulePackage{}, nil, err
+	}
+	texts := map[descriptionSource]string{
+		summaryDescriptionSource:          summary,
+		overrideDescriptionSource:         conf.DescriptionOverride,
+		distributionNameDescriptionSource: conf.ImportPath,
+	}
+	if hasTitle {
+		texts[titleDescriptionSource] = strings.Join(titles, p.config.titleSeparator())
+	}
+	var text string
+	var descConfigs []string
+	for _, source := range p.config.descriptionSources() {
+		if text = texts[source]; text != "" {
+			p.debugf("description of %s is from its %s", conf.ImportPath, source)
+			switch source {
+			case titleDescriptionSource:
+				for i, title := range titles {
+					if title != "" {
+						descConfigs = append(descConfigs, yamlPaths[i])
+					}
+				}
+			case summaryDescriptionSource:
+				descConfigs = yamlPaths[:1]
+			}
+			break
+		}
+	}
+	description, err := p.config.description(text, conf.ImportPath, releaseLevel)
+	if err != nil {
+		return ManifestEntry{}, modulePackage{}, nil, fmt.Errorf("unable to build description for %v: %v", inputDir, err)
+	}
+
+	return ManifestEntry{
+		DistributionName:  conf.ImportPath,
+		Description:       description,
+		Language:          p.config.defaultLanguage(),
+		ClientLibraryType: "generated",
+		DocsURL:           docURL,
+		ReleaseLevel:      releaseLevel,
+		LibraryType:       gapicAutoLibraryType,
+	}, pkg, descConfigs, nil
+}
+
+// generatedDocsURL returns the location of the package of conf and its docs
+// URL at the given release level. If docs URLs are skipped, the package has no
+// module and the docs URL is empty.
+func (p *postProcessor) generatedDocsURL(conf *libraryInfo, releaseLevel string) (modulePackage, string, error) {
+	if p.config.SkipDocsURL {
+		return modulePackage{ImportPath: conf.ImportPath}, "", nil
+	}
+	pkg, err := p.packageLocation(conf.ImportPath, conf.RelPath)
+	if err != nil {
+		return modulePackage{}, "", fmt.Errorf("unable to build docs URL: %v", err)
+	}
+	urlPkg := pkg
+	if lower := strings.ToLower(pkg.PkgPath); p.config.LowercaseDocsURLPaths && lower != pkg.PkgPath {
+		p.logf("lowercasing the package path %s in the docs URL of %s", pkg.PkgPath, conf.ImportPath)
+		urlPkg.PkgPath = lower
+	}
+	docURL := urlPkg.docURL(p.config.docsBaseURL(), p.config.KeepModuleRootDocsURLSlash) + p.config.DocsURLSuffixes[releaseLevel]
+	if conf.DocsURLOverride != "" {
+		p.logf("using docs URL override %s for %s", conf.DocsURLOverride, conf.ImportPath)
+		docURL = conf.DocsURLOverride
 	}
+	if err := requireHTTPS(docURL); err != nil {
+		return modulePackage{}, "", err
+	}
+	problems := docsURLProblems(docURL, conf.ImportPath, p.config.KeepModuleRootDocsURLSlash)
+	problems = append(problems, uppercasePathProblems(urlPkg.PkgPath)...)
+	if conf.DocsURLOverride == "" && len(problems) > 0 {
+		if p.config.FailOnDocsURLProblems {
+			return modulePackage{}, "", fmt.Errorf("malformed docs URL %s for %s: %s", docURL, conf.ImportPath, strings.Join(problems, "; "))
+		}
+		p.warnFilef(filepath.Join(p.googleCloudDir, conf.RelPath, "doc.go"), "malformed docs URL %s for %s: %s", docURL, conf.ImportPath, strings.Join(problems, "; "))
+	}
+	return pkg, docURL, nil
+}
 
-	// Determine by scanning doc.go for our beta disclaimer
-	docFile := filepath.Join(cloudDir, relPath, "doc.go")
-	f, err := os.Open(docFile)
+// generatorVersion returns the configured version of the generator, read from
+// the generator version file if one is configured. It returns "" if neither
+// is configured.
+func (p *postProcessor) generatorVersion() (string, error) {
+	if p.config.GeneratorVersionFile == "" {
+		return p.config.GeneratorVersion, nil
+	}
+	path := filepath.Join(p.googleCloudDir, p.config.GeneratorVersionFile)
+	b, err := os.ReadFile(path)
 	if err != nil {
 		return "", err
 	}
+	version := strings.TrimSpace(string(b))
+	if version == "" {
+		return "", fmt.Errorf("generator version file %s is empty", path)
+	}
+	return version, nil
+}
+
+// serviceConfigPaths resolves the service config paths of each of the confs
+// that has any, keyed by input directory.
+func (p *postProcessor) serviceConfigPaths(confs map[string]*libraryInfo) (map[string][]string, error) {
+	if err := p.checkGoogleapisDir(confs); err != nil {
+		return nil, err
+	}
+	paths := make(map[string][]string, len(confs))
+	for inputDir, conf := range confs {
+		for _, serviceConfig := range conf.ServiceConfig {
+			path, err := p.resolveServiceConfig(inputDir, serviceConfig)
+			if err != nil {
+				return nil, err
+			}
+			paths[inputDir] = append(paths[inputDir], path)
+		}
+	}
+	return paths, nil
+}
+
+// checkGoogleapisDir returns an error if any of the confs has a service
+// config but the googleapis directory is not a googleapis checkout, so that a
+// wrong checkout fails with one clear error rather than one per library.
+func (p *postProcessor) checkGoogleapisDir(confs map[string]*libraryInfo) error {
+	needed := false
+	for _, conf := range confs {
+		if len(conf.ServiceConfig) > 0 {
+			needed = true
+			break
+		}
+	}
+	if !needed {
+		return nil
+	}
+	fi, err := os.Stat(filepath.Join(p.googleapisDir, "google"))
+	if err != nil || !fi.IsDir() {
+		return fmt.Errorf("googleapis checkout not found: %s has no google directory, set -googleapis-dir to a local clone of https://github.com/googleapis/googleapis", p.googleapisDir)
+	}
+	return nil
+}
+
+// maxServiceConfigSearchDepth is how many directories below an input directory
+// are searched for a service config that is not at its configured location.
+const maxServiceConfigSearchDepth = 3
+
+// resolveServiceConfig returns the path of the service config for inputDir.
+// serviceConfig may contain subdirectories. If the file is not found at its
+// configured location, the input directory is searched for a file with the
+// same name. It is an error for the service config or the input directory to
+// be outside of the googleapis directory, including through a symlink unless
+// AllowExternalServiceConfigSymlinks is set.
+func (p *postProcessor) resolveServiceConfig(inputDir, serviceConfig string) (string, error) {
+	yamlPath := filepath.Join(p.googleapisDir, inputDir, serviceConfig)
+	root := filepath.Join(p.googleapisDir, inputDir)
+	for _, path := range []string{yamlPath, root} {
+		if err := checkWithinDir(p.googleapisDir, path); err != nil {
+			return "", fmt.Errorf("service config %q of %s: %v", serviceConfig, inputDir, err)
+		}
+	}
+	_, err := os.Stat(yamlPath)
+	if err == nil {
+		return yamlPath, p.checkServiceConfigTarget(yamlPath)
+	} else if !errors.Is(err, fs.ErrNotExist) {
+		return yamlPath, err
+	}
+	name := filepath.Base(serviceConfig)
+	var found string
+	walkErr := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			rel, err := filepath.Rel(root, path)
+			if err != nil {
+				return err
+			}
+			if rel != "." && len(strings.Split(rel, string(filepath.Separator))) > maxServiceConfigSearchDepth {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if d.Name() == name {
+			found = path
+			return fs.SkipAll
+		}
+		return nil
+	})
+	if walkErr != nil && !errors.Is(walkErr, fs.ErrNotExist) {
+		return "", walkErr
+	}
+	if found == "" {
+		return "", err
+	}
+	p.warnf("service config %s not found, using %s", yamlPath, found)
+	return found, p.checkServiceConfigTarget(found)
+}
+
+// checkServiceConfigTarget returns an error if the service config at path, a
+// path inside of the googleapis directory, is a symlink to a file outside of
+// it, unless AllowExternalServiceConfigSymlinks is set. Symlinks within the
+// googleapis directory are always followed.
+func (p *postProcessor) checkServiceConfigTarget(path string) error {
+	if p.config.AllowExternalServiceConfigSymlinks {
+		return nil
+	}
+	target, err := filepath.EvalSymlinks(path)
+	if err != nil {
+		return err
+	}
+	// The googleapis directory may itself be reached through a symlink.
+	dir, err := filepath.EvalSymlinks(p.googleapisDir)
+	if err != nil {
+		return err
+	}
+	if checkWithinDir(dir, target) != nil {
+		return fmt.Errorf("service config %s links to %s, which is outside of %s; set allow-external-service-config-symlinks to follow it", path, target, dir)
+	}
+	return nil
+}
+
+// checkWithinDir returns an error if path, which is joined to dir, is not dir
+// or inside of it.
+func checkWithinDir(dir, path string) error {
+	rel, err := filepath.Rel(dir, path)
+	if err != nil {
+		return err
+	}
+	if rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return fmt.Errorf("%s is outside of %s", path, dir)
+	}
+	return nil
+}
+
+// serviceConfig contains the fields of a service config used to generate the
+// manifest.
+type serviceConfig struct {
+	Title string `yaml:"title"`
+	// Documentation.Summary is a short description of the API.
+	Documentation struct {
+		Summary string `yaml:"summary"`
+	} `yaml:"documentation"`
+	// LocalizedTitles are translations of the title keyed by language code.
+	LocalizedTitles map[string]string `yaml:"localized_titles"`
+	// Stable and Maturity mark an API as stable in newer service configs.
+	Stable     bool   `yaml:"stable"`
+	Maturity   string `yaml:"maturity"`
+	Publishing struct {
+		LibrarySettings []struct {
+			LaunchStage string `yaml:"launch_stage"`
+		} `yaml:"library_settings"`
+	} `yaml:"publishing"`
+}
+
+// title returns the title of the service config in the given language, or
+// in its base language if there is no title for the language's region, and
+// otherwise the default title.
+func (sc *serviceConfig) title(lang string) string {
+	if lang == "" {
+		return sc.Title
+	}
+	if t, ok := sc.LocalizedTitles[lang]; ok && t != "" {
+		return t
+	}
+	if base, _, ok := strings.Cut(lang, "-"); ok {
+		if t, ok := sc.LocalizedTitles[base]; ok && t != "" {
+			return t
+		}
+	}
+	return sc.Title
+}
+
+// isStable reports whether the service config sets stable: true or
+// maturity: STABLE.
+func (sc *serviceConfig) isStable() bool {
+	return sc.Stable || strings.EqualFold(sc.Maturity, "STABLE")
+}
+
+// launchStage returns the first launch stage set in the library settings of
+// the service config, if any.
+func (sc *serviceConfig) launchStage() string {
+	for _, ls := range sc.Publishing.LibrarySettings {
+		if ls.LaunchStage != "" {
+			return ls.LaunchStage
+		}
+	}
+	return ""
+}
+
+// serviceConfigRetryDelay is the delay before the first retry of opening a
+// service config. It doubles with each retry.
+var serviceConfigRetryDelay = 100 * time.Millisecond
+
+// openServiceConfig opens the service config at path, retrying transient
+// errors up to the configured number of attempts. A missing file or one that
+// can not be read due to permissions is not retried.
+func (p *postProcessor) openServiceConfig(path string) (io.ReadCloser, error) {
+	open := p.openFile
+	if open == nil {
+		open = func(path string) (io.ReadCloser, error) { return os.Open(path) }
+	}
+	delay := serviceConfigRetryDelay
+	attempts := p.config.serviceConfigOpenAttempts()
+	for attempt := 1; ; attempt++ {
+		f, err := open(path)
+		if err == nil || attempt == attempts || errors.Is(err, fs.ErrNotExist) || errors.Is(err, fs.ErrPermission) {
+			return f, err
+		}
+		p.logf("opening service config %s failed, retrying: %v", path, err)
+		time.Sleep(delay)
+		delay *= 2
+	}
+}
+
+// readServiceConfig returns the decoded service config at path, which is only
+// decoded the first time it is read in a manifest computation.
+func (p *postProcessor) readServiceConfig(path string) (*serviceConfig, error) {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return nil, err
+	}
+	return p.serviceConfigs.get(abs, func(path string) (*serviceConfig, error) {
+		defer p.metrics.since(serviceConfigDecodePhase, time.Now())
+		return p.decodeServiceConfig(path)
+	})
+}
+
+// serviceConfigCache memoizes decoded service configs by absolute path. It is
+// safe for concurrent use, and a config read by several goroutines at once is
+// only decoded once. Failed decodes are not memoized.
+type serviceConfigCache struct {
+	mu      sync.Mutex
+	configs map[string]*serviceConfigCacheEntry
+}
+
+type serviceConfigCacheEntry struct {
+	done chan struct{}
+	sc   *serviceConfig
+	err  error
+}
+
+// get returns the service config at path, decoding it with decode if it is
+// not memoized.
+func (c *serviceConfigCache) get(path string, decode func(string) (*serviceConfig, error)) (*serviceConfig, error) {
+	c.mu.Lock()
+	if e, ok := c.configs[path]; ok {
+		c.mu.Unlock()
+		<-e.done
+		return e.sc, e.err
+	}
+	if c.configs == nil {
+		c.configs = map[string]*serviceConfigCacheEntry{}
+	}
+	e := &serviceConfigCacheEntry{done: make(chan struct{})}
+	c.configs[path] = e
+	c.mu.Unlock()
+
+	e.sc, e.err = decode(path)
+	if e.err != nil {
+		c.mu.Lock()
+		delete(c.configs, path)
+		c.mu.Unlock()
+	}
+	close(e.done)
+	return e.sc, e.err
+}
+
+// reset forgets the memoized service configs.
+func (c *serviceConfigCache) reset() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.configs = nil
+}
+
+// resetCaches forgets the service configs, the templates manifest and the
+// release-please config memoized by the previous manifest computation, so
+// that the next one sees any changes to them.
+func (p *postProcessor) resetCaches() {
+	p.serviceConfigs.reset()
+	p.templatesMu.Lock()
+	p.templates = nil
+	p.templatesMu.Unlock()
+	p.releasePleaseMu.Lock()
+	p.releasePlease = nil
+	p.releasePleaseMu.Unlock()
+}
+
+// decodeServiceConfig decodes the service config at path. Configs larger
+// than the configured maximum size are rejected.
+func (p *postProcessor) decodeServiceConfig(path string) (*serviceConfig, error) {
+	f, err := p.openServiceConfig(path)
+	if err != nil {
+		return nil, err
+	}
 	defer f.Close()
+	max := p.config.maxServiceConfigSize()
+	b, err := io.ReadAll(io.LimitReader(f, max+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(b)) > max {
+		return nil, fmt.Errorf("service config %s exceeds the maximum size of %d bytes", path, max)
+	}
+	line, tabbed := tabIndentedLine(b)
+	if tabbed && p.config.ExpandServiceConfigTabs {
+		p.warnFilef(path, "service config %s is indented with tabs, expanding them to spaces", path)
+		b = expandLeadingTabs(b)
+	}
+	sc := &serviceConfig{}
+	if err := yaml.NewDecoder(bytes.NewReader(b)).Decode(sc); err != nil {
+		if tabbed && !p.config.ExpandServiceConfigTabs {
+			return nil, fmt.Errorf("decode %s: line %d is indented with a tab, YAML only allows spaces", path, line)
+		}
+		return nil, fmt.Errorf("decode: %v", err)
+	}
+	return sc, nil
+}
 
-	scanner := bufio.NewScanner(f)
-	var lineCnt int
-	for scanner.Scan() && lineCnt < 50 {
-		line := scanner.Text()
-		if strings.Contains(line, betaIndicator) {
-			return "beta", nil
+// tabIndentedLine returns the number of the first line of b whose
+// indentation contains a tab. It reports false if there is none.
+func tabIndentedLine(b []byte) (int, bool) {
+	for i, line := range bytes.Split(b, []byte("\n")) {
+		indent := line[:len(line)-len(bytes.TrimLeft(line, " \t"))]
+		if bytes.IndexByte(indent, '\t') >= 0 {
+			return i + 1, true
 		}
 	}
-	return "ga", nil
+	return 0, false
+}
+
+// expandLeadingTabs replaces each tab in the indentation of the lines of b
+// with two spaces.
+func expandLeadingTabs(b []byte) []byte {
+	lines := bytes.Split(b, []byte("\n"))
+	for i, line := range lines {
+		rest := bytes.TrimLeft(line, " \t")
+		indent := line[:len(line)-len(rest)]
+		lines[i] = append(bytes.ReplaceAll(indent, []byte("\t"), []byte("  ")), rest...)
+	}
+	return bytes.Join(lines, []byte("\n"))
+}
+
+// modulePackage is a package along with the module that contains it.
+type modulePackage struct {
+	Module     string `json:"-"`
+	ImportPath string `json:"import_path"`
+	// PkgPath is the path of the package relative to its module.
+	PkgPath string `json:"pkg_path"`
+}
+
+// packageLocation finds the module that contains the package with the given
+// import path at relPath. This is the nearest enclosing module, so a package
+// in a nested submodule with its own go.mod belongs to the submodule rather
+// than to the module above it. It is an error if the import path is not in
+// that module.
+func (p *postProcessor) packageLocation(importPath, relPath string) (modulePackage, error) {
+	dir := filepath.Join(p.googleCloudDir, relPath)
+	mod, err := p.currentMod(dir)
+	if err != nil {
+		return modulePackage{}, err
+	}
+	mod, importPath = slashPath(mod), slashPath(importPath)
+	pkgPath, ok := strings.CutPrefix(importPath, mod)
+	if !ok || (pkgPath != "" && !strings.HasPrefix(pkgPath, "/")) {
+		return modulePackage{}, fmt.Errorf("import path %s is not in module %s, the nearest module enclosing %s", importPath, mod, dir)
+	}
+	return modulePackage{
+		Module:     mod,
+		ImportPath: importPath,
+		PkgPath:    strings.TrimPrefix(pkgPath, "/"),
+	}, nil
+}
+
+// docURL returns the docs URL of the package below baseURL, which ends in a
+// slash. The URL of the root package of a module ends in
+// /latest, or in /latest/ if keepRootSlash is set.
+func (mp modulePackage) docURL(baseURL string, keepRootSlash bool) string {
+	u := baseURL + slashPath(mp.Module) + "/latest"
+	if mp.PkgPath != "" || keepRootSlash {
+		u += "/" + slashPath(mp.PkgPath)
+	}
+	return u
 }
@@ -0,0 +1,84 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+// manifestIntegrationEnv is the environment variable that enables
+// TestCommittedManifestIsCurrent.
+const manifestIntegrationEnv = "POSTPROCESSOR_MANIFEST_INTEGRATION"
+
+// TestCommittedManifestIsCurrent computes the manifest of the repo that
+// contains this package with its real config and checks that it matches the
+// committed internal/.repo-metadata-full.json. It needs the full repo and a
+// googleapis checkout, so it only runs if manifestIntegrationEnv is set. The
+// committed manifest is restored afterwards.
+func TestCommittedManifestIsCurrent(t *testing.T) {
+	if os.Getenv(manifestIntegrationEnv) == "" {
+		t.Skipf("set %s=1 to check the committed manifest", manifestIntegrationEnv)
+	}
+	cloudDir, err := filepath.Abs(filepath.Join("..", ".."))
+	if err != nil {
+		t.Fatal(err)
+	}
+	apisDir, err := filepath.Abs(googleapisDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := &postProcessor{googleapisDir: apisDir, googleCloudDir: cloudDir}
+	if err := p.loadConfig(); err != nil {
+		t.Fatal(err)
+	}
+	// Only the manifest itself is compared, and nothing is staged.
+	p.config.StageManifest = false
+	p.config.WriteJSONL = false
+	p.config.WriteReleaseLevelSplits = false
+	p.config.WriteModulePackages = false
+	p.config.WriteLibrariesManifest = false
+
+	manifestPath := p.manifestPath()
+	committedBytes, err := os.ReadFile(manifestPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		if err := os.WriteFile(manifestPath, committedBytes, 0644); err != nil {
+			t.Errorf("restoring %s: %v", manifestPath, err)
+		}
+	})
+	committed, err := readManifestFile(manifestPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	entries, err := p.Manifest(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := p.keyManifestEntries(entries)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(committed, got); diff != "" {
+		t.Errorf("committed manifest is not current, regenerate it with the post-processor (-committed +computed):\n%s", diff)
+	}
+}
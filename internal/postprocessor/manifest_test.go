@@ -0,0 +1,1922 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+var benchLibraries = flag.Int("bench-libraries", 50, "Number of synthetic libraries used by BenchmarkManifest")
+
+const testDocGA = `// Package foo is an auto-generated package for the
+// Foo API.
+package foo
+`
+
+const testDocAlpha = `// Package bar is an auto-generated package for the
+// Bar API.
+//
+//	NOTE: This package is in alpha. It is not stable, and is likely to change.
+//
+// # General documentation
+package bar
+`
+
+const testDocBeta = `// Package bar is an auto-generated package for the
+// Bar API.
+//
+//	NOTE: This package is in beta. It is not stable, and may be subject to changes.
+//
+// # General documentation
+package bar
+`
+
+// writeTestFiles writes files, keyed by slash separated path relative to dir,
+// creating any parent directories as needed.
+func writeTestFiles(t testing.TB, dir string, files map[string]string) {
+	t.Helper()
+	for name, content := range files {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// newTestManifestProcessor returns a postProcessor pointing at a small
+// fixture with a few generated clients and one manual client.
+func newTestManifestProcessor(t *testing.T) *postProcessor {
+	t.Helper()
+	cloudDir := t.TempDir()
+	apisDir := t.TempDir()
+	writeTestFiles(t, cloudDir, map[string]string{
+		"go.mod":               "module cloud.google.com/go\n\ngo 1.20\n",
+		"internal/README.md":   "",
+		"foo/go.mod":           "module cloud.google.com/go/foo\n\ngo 1.20\n",
+		"foo/apiv1/doc.go":     testDocGA,
+		"bar/go.mod":           "module cloud.google.com/go/bar\n\ngo 1.20\n",
+		"bar/apiv1/doc.go":     testDocBeta,
+		"baz/go.mod":           "module cloud.google.com/go/baz\n\ngo 1.20\n",
+		"baz/doc.go":           "package baz\n",
+		"qux/go.mod":           "module cloud.google.com/go/qux\n\ngo 1.20\n",
+		"qux/apiv1beta/doc.go": "package qux\n",
+	})
+	writeTestFiles(t, apisDir, map[string]string{
+		"google/cloud/foo/v1/foo_v1.yaml":         "type: google.api.Service\ntitle: Foo API\n",
+		"google/cloud/bar/v1/bar_v1.yaml":         "type: google.api.Service\ntitle: Bar API\n",
+		"google/cloud/qux/v1beta/qux_v1beta.yaml": "type: google.api.Service\ntitle: Qux API\n",
+	})
+	return &postProcessor{
+		googleapisDir:  apisDir,
+		googleCloudDir: cloudDir,
+		config: &config{
+			GoogleapisToImportPath: map[string]*libraryInfo{
+				"google/cloud/foo/v1": {
+					ImportPath:    "cloud.google.com/go/foo/apiv1",
+					ServiceConfig: serviceConfigList{"foo_v1.yaml"},
+					RelPath:       "/foo/apiv1",
+				},
+				"google/cloud/bar/v1": {
+					ImportPath:    "cloud.google.com/go/bar/apiv1",
+					ServiceConfig: serviceConfigList{"bar_v1.yaml"},
+					RelPath:       "/bar/apiv1",
+				},
+				"google/cloud/qux/v1beta": {
+					ImportPath:    "cloud.google.com/go/qux/apiv1beta",
+					ServiceConfig: serviceConfigList{"qux_v1beta.yaml"},
+					RelPath:       "/qux/apiv1beta",
+				},
+			},
+			ManualClientInfo: []*ManifestEntry{
+				{
+					DistributionName:  "cloud.google.com/go/baz",
+					Description:       "Baz",
+					Language:          "Go",
+					ClientLibraryType: "manual",
+					DocsURL:           "https://cloud.google.com/go/docs/reference/cloud.google.com/go/baz/latest",
+					ReleaseLevel:      "ga",
+					LibraryType:       gapicManualLibraryType,
+				},
+			},
+		},
+	}
+}
+
+func TestManifestLocalizedTitles(t *testing.T) {
+	const localized = `type: google.api.Service
+title: Foo API
+localized_titles:
+  ja: Foo API (ja)
+  pt: Foo API (pt)
+  pt-BR: Foo API (pt-BR)
+`
+	tests := []struct {
+		name     string
+		config   string
+		language string
+		want     string
+	}{
+		{name: "default", config: localized, want: "Foo API"},
+		{name: "exact", config: localized, language: "ja", want: "Foo API (ja)"},
+		{name: "region", config: localized, language: "pt-BR", want: "Foo API (pt-BR)"},
+		{name: "base language", config: localized, language: "pt-PT", want: "Foo API (pt)"},
+		{name: "no translation", config: localized, language: "fr", want: "Foo API"},
+		{name: "not localized", config: "type: google.api.Service\ntitle: Foo API\n", language: "ja", want: "Foo API"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := newTestManifestProcessor(t)
+			writeTestFiles(t, p.googleapisDir, map[string]string{"google/cloud/foo/v1/foo_v1.yaml": tt.config})
+			p.config.TitleLanguage = tt.language
+			entries, err := p.Manifest(context.Background())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := entries["cloud.google.com/go/foo/apiv1"].Description; got != tt.want {
+				t.Errorf("Description = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestManifestSkipDocsURL(t *testing.T) {
+	p := newTestManifestProcessor(t)
+	p.config.SkipDocsURL = true
+	p.config.HandwrittenMarker = ".handwritten"
+	writeTestFiles(t, p.googleCloudDir, map[string]string{
+		"hand/go.mod":       "module cloud.google.com/go/hand\n\ngo 1.20\n",
+		"hand/doc.go":       testDocGA,
+		"hand/.handwritten": "Hand\n",
+	})
+	p.goCurrentMod = func(dir string) (string, error) {
+		t.Errorf("go command called for %s", dir)
+		return "", errors.New("unexpected go command call")
+	}
+	entries, err := p.Manifest(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"cloud.google.com/go/foo/apiv1", "cloud.google.com/go/bar/apiv1", "cloud.google.com/go/hand"} {
+		e := entries[name]
+		if e.DocsURL != "" {
+			t.Errorf("%s: DocsURL = %q, want empty", name, e.DocsURL)
+		}
+		if e.Description == "" || e.ReleaseLevel == "" {
+			t.Errorf("%s: got description %q and release level %q, want both computed", name, e.Description, e.ReleaseLevel)
+		}
+	}
+	if got := entries["cloud.google.com/go/bar/apiv1"].ReleaseLevel; got != "beta" {
+		t.Errorf("bar ReleaseLevel = %q, want beta", got)
+	}
+	if errs := p.ValidateEntries(entries); len(errs) > 0 {
+		t.Errorf("ValidateEntries() = %v, want no problems", errs)
+	}
+}
+
+func TestManifestMissingGoogleapisDir(t *testing.T) {
+	tests := []struct {
+		name string
+		dir  func(t *testing.T) string
+	}{
+		{name: "absent", dir: func(t *testing.T) string { return filepath.Join(t.TempDir(), "googleapis") }},
+		{name: "not a checkout", dir: func(t *testing.T) string { return t.TempDir() }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := newTestManifestProcessor(t)
+			p.googleapisDir = tt.dir(t)
+			_, err := p.Manifest(context.Background())
+			if err == nil || !strings.Contains(err.Error(), "googleapis checkout not found") {
+				t.Fatalf("Manifest() = %v, want a missing googleapis checkout error", err)
+			}
+			if strings.Contains(err.Error(), "foo_v1.yaml") {
+				t.Errorf("Manifest() = %v, want no per-file error", err)
+			}
+		})
+	}
+}
+
+func TestManifestForInput(t *testing.T) {
+	p := newTestManifestProcessor(t)
+	got, err := p.ManifestForInput(context.Background(), "google/cloud/bar/v1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]ManifestEntry{
+		"cloud.google.com/go/bar/apiv1": {
+			DistributionName:  "cloud.google.com/go/bar/apiv1",
+			Description:       "Bar API",
+			Language:          "Go",
+			ClientLibraryType: "generated",
+			DocsURL:           "https://cloud.google.com/go/docs/reference/cloud.google.com/go/bar/latest/apiv1",
+			ReleaseLevel:      "beta",
+			LibraryType:       gapicAutoLibraryType,
+		},
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("ManifestForInput() mismatch (-want +got):\n%s", diff)
+	}
+	if _, err := os.Stat(filepath.Join(p.googleCloudDir, "internal", ".repo-metadata-full.json")); !os.IsNotExist(err) {
+		t.Errorf("ManifestForInput() wrote manifest file, want no file written")
+	}
+
+	if _, err := p.ManifestForInput(context.Background(), "google/cloud/unknown/v1"); err == nil {
+		t.Errorf("ManifestForInput() = nil error for unknown input directory, want error")
+	}
+}
+
+func TestPreviewEntry(t *testing.T) {
+	p := newTestManifestProcessor(t)
+	p.config.Labels = map[string][]string{"cloud.google.com/go/newapi/...": {"new"}}
+	writeTestFiles(t, p.googleCloudDir, map[string]string{
+		"newapi/go.mod":           "module cloud.google.com/go/newapi\n\ngo 1.20\n",
+		"newapi/apiv1beta/doc.go": testDocGA,
+	})
+	writeTestFiles(t, p.googleapisDir, map[string]string{
+		"google/cloud/newapi/v1beta/newapi_v1beta.yaml": "type: google.api.Service\ntitle: New API\n",
+	})
+	got, err := p.PreviewEntry(context.Background(), "newapi/apiv1beta", "cloud.google.com/go/newapi/apiv1beta", "google/cloud/newapi/v1beta/newapi_v1beta.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := ManifestEntry{
+		DistributionName:  "cloud.google.com/go/newapi/apiv1beta",
+		Description:       "New API",
+		Language:          "Go",
+		ClientLibraryType: "generated",
+		DocsURL:           "https://cloud.google.com/go/docs/reference/cloud.google.com/go/newapi/latest/apiv1beta",
+		ReleaseLevel:      "beta",
+		LibraryType:       gapicAutoLibraryType,
+		Labels:            []string{"new"},
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("PreviewEntry() mismatch (-want +got):\n%s", diff)
+	}
+	if _, ok := p.config.GoogleapisToImportPath["google/cloud/newapi/v1beta"]; ok {
+		t.Errorf("PreviewEntry() added the library to the config")
+	}
+
+	if _, err := p.PreviewEntry(context.Background(), "newapi/apiv1beta", "cloud.google.com/go/newapi/apiv1beta", "google/cloud/newapi/v1beta/missing.yaml"); err == nil {
+		t.Errorf("PreviewEntry() = nil error for a missing service config, want error")
+	}
+	if _, err := p.PreviewEntry(context.Background(), "newapi/apiv1beta", "cloud.google.com/go/newapi/apiv1beta", "newapi_v1beta.yaml"); err == nil {
+		t.Errorf("PreviewEntry() = nil error for a service config outside an input directory, want error")
+	}
+}
+
+func TestServiceConfigTooLarge(t *testing.T) {
+	p := newTestManifestProcessor(t)
+	yamlPath := filepath.Join(p.googleapisDir, "google/cloud/foo/v1/foo_v1.yaml")
+	b, err := os.ReadFile(yamlPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p.config.MaxServiceConfigSize = int64(len(b))
+	if _, err := p.ManifestForInput(context.Background(), "google/cloud/foo/v1"); err != nil {
+		t.Fatalf("ManifestForInput() = %v, want config at the limit to be accepted", err)
+	}
+	p.config.MaxServiceConfigSize = int64(len(b)) - 1
+	_, err = p.ManifestForInput(context.Background(), "google/cloud/foo/v1")
+	if err == nil || !strings.Contains(err.Error(), "exceeds the maximum size") {
+		t.Errorf("ManifestForInput() = %v, want maximum size error", err)
+	}
+}
+
+func TestServiceConfigTabIndented(t *testing.T) {
+	p := newTestManifestProcessor(t)
+	yamlPath := filepath.Join(p.googleapisDir, "google/cloud/foo/v1/foo_v1.yaml")
+	writeTestFiles(t, p.googleapisDir, map[string]string{
+		"google/cloud/foo/v1/foo_v1.yaml": "type: google.api.Service\ntitle: Foo API\ndocumentation:\n\tsummary: Manages foos.\n",
+	})
+	_, err := p.ManifestForInput(context.Background(), "google/cloud/foo/v1")
+	if err == nil || !strings.Contains(err.Error(), yamlPath+": line 4 is indented with a tab") {
+		t.Errorf("ManifestForInput() = %v, want an error naming line 4 of %s", err, yamlPath)
+	}
+
+	p.config.ExpandServiceConfigTabs = true
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	defer log.SetOutput(os.Stderr)
+	got, err := p.ManifestForInput(context.Background(), "google/cloud/foo/v1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if desc := got["cloud.google.com/go/foo/apiv1"].Description; desc != "Foo API" {
+		t.Errorf("Description = %q, want %q", desc, "Foo API")
+	}
+	if want := "is indented with tabs, expanding them to spaces"; !strings.Contains(buf.String(), want) {
+		t.Errorf("logged %q, want it to contain %q", buf.String(), want)
+	}
+}
+
+// newBenchManifestProcessor returns a postProcessor pointing at a synthetic
+// fixture of n generated libraries, each in its own module.
+func newBenchManifestProcessor(b *testing.B, n int) *postProcessor {
+	b.Helper()
+	cloudDir := b.TempDir()
+	apisDir := b.TempDir()
+	cloudFiles := map[string]string{
+		"go.mod":             "module cloud.google.com/go\n\ngo 1.20\n",
+		"internal/README.md": "",
+	}
+	apisFiles := map[string]string{}
+	confs := map[string]*libraryInfo{}
+	for i := 0; i < n; i++ {
+		name := fmt.Sprintf("lib%d", i)
+		doc := testDocGA
+		if i%2 == 1 {
+			doc = testDocBeta
+		}
+		cloudFiles[name+"/go.mod"] = fmt.Sprintf("module cloud.google.com/go/%s\n\ngo 1.20\n", name)
+		cloudFiles[name+"/apiv1/doc.go"] = doc
+		inputDir := fmt.Sprintf("google/cloud/%s/v1", name)
+		apisFiles[inputDir+"/"+name+"_v1.yaml"] = fmt.Sprintf("type: google.api.Service\ntitle: %s API\n", name)
+		confs[inputDir] = &libraryInfo{
+			ImportPath:    fmt.Sprintf("cloud.google.com/go/%s/apiv1", name),
+			ServiceConfig: serviceConfigList{name + "_v1.yaml"},
+			RelPath:       "/" + name + "/apiv1",
+		}
+	}
+	writeTestFiles(b, cloudDir, cloudFiles)
+	writeTestFiles(b, apisDir, apisFiles)
+	return &postProcessor{
+		googleapisDir:  apisDir,
+		googleCloudDir: cloudDir,
+		config: &config{
+			GoogleapisToImportPath: confs,
+		},
+	}
+}
+
+func BenchmarkManifest(b *testing.B) {
+	p := newBenchManifestProcessor(b, *benchLibraries)
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := p.Manifest(context.Background()); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func TestManifestManualShadowing(t *testing.T) {
+	p := newTestManifestProcessor(t)
+	p.config.ManualClientInfo = append(p.config.ManualClientInfo, &ManifestEntry{
+		DistributionName:  "cloud.google.com/go/foo/apiv1",
+		Description:       "Manual Foo",
+		Language:          "Go",
+		ClientLibraryType: "manual",
+		DocsURL:           "https://cloud.google.com/go/docs/reference/cloud.google.com/go/foo/apiv1/latest",
+		ReleaseLevel:      "beta",
+		LibraryType:       gapicManualLibraryType,
+	})
+
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	defer log.SetOutput(os.Stderr)
+	got, err := p.ManifestForInput(context.Background(), "google/cloud/foo/v1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := ManifestEntry{
+		DistributionName:  "cloud.google.com/go/foo/apiv1",
+		Description:       "Manual Foo",
+		Language:          "Go",
+		ClientLibraryType: "manual",
+		DocsURL:           "https://cloud.google.com/go/docs/reference/cloud.google.com/go/foo/latest/apiv1",
+		ReleaseLevel:      "ga",
+		LibraryType:       gapicManualLibraryType,
+	}
+	if diff := cmp.Diff(want, got["cloud.google.com/go/foo/apiv1"]); diff != "" {
+		t.Errorf("merged entry mismatch (-want +got):\n%s", diff)
+	}
+	if !strings.Contains(buf.String(), "shadows a manual entry") {
+		t.Errorf("ManifestForInput() did not log a shadowing warning, got:\n%s", buf.String())
+	}
+
+	p.config.MergePolicy = map[string]string{
+		"description":   generatedMergeSource,
+		"release_level": manualMergeSource,
+	}
+	got, err = p.ManifestForInput(context.Background(), "google/cloud/foo/v1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e := got["cloud.google.com/go/foo/apiv1"]; e.Description != "Foo API" || e.ReleaseLevel != "beta" {
+		t.Errorf("merged entry = %+v, want generated description and manual release level", e)
+	}
+	if source, _ := p.ReleaseLevelSource("cloud.google.com/go/foo/apiv1"); source != manualSource {
+		t.Errorf("ReleaseLevelSource() = %q, want %q", source, manualSource)
+	}
+	p.config.MergePolicy = map[string]string{"descripton": manualMergeSource}
+	if err := p.config.validate(); err == nil {
+		t.Errorf("validate() = nil error for unknown merge policy field, want error")
+	}
+	p.config.MergePolicy = nil
+
+	p.config.FailOnManualShadowing = true
+	if _, err := p.ManifestForInput(context.Background(), "google/cloud/foo/v1"); err == nil {
+		t.Errorf("ManifestForInput() = nil error with FailOnManualShadowing, want error")
+	}
+}
+
+func TestManifestForImportPaths(t *testing.T) {
+	p := newTestManifestProcessor(t)
+	got, err := p.ManifestForImportPaths(context.Background(), []string{"cloud.google.com/go/foo/apiv1", "cloud.google.com/go/qux/apiv1beta"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var gotLevels []string
+	for _, e := range sortedManifestEntries(got) {
+		gotLevels = append(gotLevels, e.DistributionName+"="+e.ReleaseLevel)
+	}
+	wantLevels := []string{"cloud.google.com/go/foo/apiv1=ga", "cloud.google.com/go/qux/apiv1beta=beta"}
+	if diff := cmp.Diff(wantLevels, gotLevels); diff != "" {
+		t.Errorf("ManifestForImportPaths() mismatch (-want +got):\n%s", diff)
+	}
+
+	_, err = p.ManifestForImportPaths(context.Background(), []string{"cloud.google.com/go/foo/apiv1", "cloud.google.com/go/unknown/apiv1"})
+	if err == nil || !strings.Contains(err.Error(), "cloud.google.com/go/unknown/apiv1") {
+		t.Errorf("ManifestForImportPaths() = %v, want error naming the unknown import path", err)
+	}
+}
+
+func TestManifestIdempotent(t *testing.T) {
+	p := newTestManifestProcessor(t)
+	manifestPath := filepath.Join(p.googleCloudDir, "internal", ".repo-metadata-full.json")
+
+	run := func() (map[string]ManifestEntry, []byte, []string) {
+		t.Helper()
+		entries, err := p.Manifest(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, err := os.ReadFile(manifestPath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		des, err := os.ReadDir(filepath.Dir(manifestPath))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var names []string
+		for _, de := range des {
+			names = append(names, de.Name())
+		}
+		return entries, b, names
+	}
+	entries1, b1, _ := run()
+	entries2, b2, names := run()
+
+	if diff := cmp.Diff(entries1, entries2); diff != "" {
+		t.Errorf("Manifest() entries differ between runs (-first +second):\n%s", diff)
+	}
+	if !bytes.Equal(b1, b2) {
+		t.Errorf("Manifest() output differs between runs:\n%s\n---\n%s", b1, b2)
+	}
+	if diff := cmp.Diff([]string{".repo-metadata-full.json", "README.md"}, names); diff != "" {
+		t.Errorf("unexpected files left in internal directory (-want +got):\n%s", diff)
+	}
+}
+
+func TestManifestManualLanguage(t *testing.T) {
+	p := newTestManifestProcessor(t)
+	p.config.ManualClientInfo = append(p.config.ManualClientInfo, &ManifestEntry{
+		DistributionName:  "google/cloud/common",
+		Description:       "Common protos",
+		Language:          "Protobuf",
+		ClientLibraryType: "manual",
+		DocsURL:           "https://cloud.google.com/go/docs/reference/google/cloud/common/latest",
+		ReleaseLevel:      "ga",
+		LibraryType:       otherLibraryType,
+	})
+	entries, err := p.Manifest(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := entries["google/cloud/common"].Language; got != "Protobuf" {
+		t.Errorf("manual entry Language = %q, want %q", got, "Protobuf")
+	}
+	if got := entries["cloud.google.com/go/foo/apiv1"].Language; got != "Go" {
+		t.Errorf("generated entry Language = %q, want %q", got, "Go")
+	}
+
+	p.config.ManualClientInfo[1].Language = "Klingon"
+	if _, err := p.Manifest(context.Background()); err == nil {
+		t.Errorf("Manifest() = nil error for unsupported language, want error")
+	}
+}
+
+func TestResolveServiceConfig(t *testing.T) {
+	p := newTestManifestProcessor(t)
+	writeTestFiles(t, p.googleapisDir, map[string]string{
+		"google/cloud/nested/sub/nested_v1.yaml":      "title: Sub\n",
+		"google/cloud/deep/a/b/c/d/deep_v1.yaml":      "title: Deep\n",
+		"google/cloud/foo/v1/extra/foo_extra_v1.yaml": "title: Extra\n",
+	})
+	// The repo directory is next to the googleapis directory, so this is an
+	// existing file outside of it.
+	outside := filepath.Join("../../../../..", filepath.Base(p.googleCloudDir), "go.mod")
+	tests := []struct {
+		name          string
+		inputDir      string
+		serviceConfig string
+		want          string
+		wantErr       bool
+	}{
+		{
+			name:          "direct",
+			inputDir:      "google/cloud/foo/v1",
+			serviceConfig: "foo_v1.yaml",
+			want:          "google/cloud/foo/v1/foo_v1.yaml",
+		},
+		{
+			name:          "relative with subdirectory",
+			inputDir:      "google/cloud/foo/v1",
+			serviceConfig: "extra/foo_extra_v1.yaml",
+			want:          "google/cloud/foo/v1/extra/foo_extra_v1.yaml",
+		},
+		{
+			name:          "found by search",
+			inputDir:      "google/cloud/nested",
+			serviceConfig: "nested_v1.yaml",
+			want:          "google/cloud/nested/sub/nested_v1.yaml",
+		},
+		{
+			name:          "too deep to search",
+			inputDir:      "google/cloud/deep",
+			serviceConfig: "deep_v1.yaml",
+			wantErr:       true,
+		},
+		{
+			name:          "missing",
+			inputDir:      "google/cloud/foo/v1",
+			serviceConfig: "missing_v1.yaml",
+			wantErr:       true,
+		},
+		{
+			name:          "parent within googleapis",
+			inputDir:      "google/cloud/foo/v1/extra",
+			serviceConfig: "../foo_v1.yaml",
+			want:          "google/cloud/foo/v1/foo_v1.yaml",
+		},
+		{
+			name:          "outside googleapis",
+			inputDir:      "google/cloud/foo/v1",
+			serviceConfig: outside,
+			wantErr:       true,
+		},
+		{
+			name:          "input directory outside googleapis",
+			inputDir:      "../google/cloud/foo/v1",
+			serviceConfig: "foo_v1.yaml",
+			wantErr:       true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := p.resolveServiceConfig(tt.inputDir, tt.serviceConfig)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("resolveServiceConfig() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if want := filepath.Join(p.googleapisDir, filepath.FromSlash(tt.want)); got != want {
+				t.Errorf("resolveServiceConfig() = %q, want %q", got, want)
+			}
+		})
+	}
+}
+
+func TestResolveServiceConfigSymlink(t *testing.T) {
+	p := newTestManifestProcessor(t)
+	shared := t.TempDir()
+	writeTestFiles(t, shared, map[string]string{"shared_v1.yaml": "type: google.api.Service\ntitle: Shared API\n"})
+	writeTestFiles(t, p.googleapisDir, map[string]string{"google/cloud/shared/v1/README.md": "shared\n"})
+	inside := filepath.Join(p.googleapisDir, "google", "cloud", "foo", "v1", "foo_v1.yaml")
+	for link, target := range map[string]string{
+		"shared_v1.yaml": filepath.Join(shared, "shared_v1.yaml"),
+		"foo_v1.yaml":    inside,
+	} {
+		if err := os.Symlink(target, filepath.Join(p.googleapisDir, "google", "cloud", "shared", "v1", link)); err != nil {
+			t.Skipf("unable to create symlink: %v", err)
+		}
+	}
+
+	// A link within googleapis is followed like any other path.
+	got, err := p.resolveServiceConfig("google/cloud/shared/v1", "foo_v1.yaml")
+	if err != nil {
+		t.Fatalf("resolveServiceConfig() = %v, want a link within googleapis to be followed", err)
+	}
+	if want := filepath.Join(p.googleapisDir, "google", "cloud", "shared", "v1", "foo_v1.yaml"); got != want {
+		t.Errorf("resolveServiceConfig() = %q, want %q", got, want)
+	}
+
+	if _, err := p.resolveServiceConfig("google/cloud/shared/v1", "shared_v1.yaml"); err == nil || !strings.Contains(err.Error(), "allow-external-service-config-symlinks") {
+		t.Errorf("resolveServiceConfig() = %v, want error for a link outside of googleapis", err)
+	}
+
+	p.config.AllowExternalServiceConfigSymlinks = true
+	path, err := p.resolveServiceConfig("google/cloud/shared/v1", "shared_v1.yaml")
+	if err != nil {
+		t.Fatalf("resolveServiceConfig() = %v, want the external link to be allowed", err)
+	}
+	sc, err := p.readServiceConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sc.Title != "Shared API" {
+		t.Errorf("Title = %q, want the title of the link target", sc.Title)
+	}
+}
+
+func TestManifestDescriptionTemplate(t *testing.T) {
+	p := newTestManifestProcessor(t)
+	p.config.DescriptionTemplate = "Go client for {{.Title}} ({{.ReleaseLevel}})"
+	got, err := p.ManifestForInput(context.Background(), "google/cloud/bar/v1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "Go client for Bar API (beta)"
+	if desc := got["cloud.google.com/go/bar/apiv1"].Description; desc != want {
+		t.Errorf("Description = %q, want %q", desc, want)
+	}
+
+	p.config.DescriptionTemplate = "{{.Title"
+	if err := p.config.validate(); err == nil {
+		t.Errorf("validate() = nil, want error for unparsable template")
+	}
+}
+
+func TestManifestDescriptionSources(t *testing.T) {
+	tests := []struct {
+		name          string
+		serviceConfig string
+		override      string
+		sources       []descriptionSource
+		want          string
+		wantSource    descriptionSource
+	}{
+		{
+			name:          "title",
+			serviceConfig: "type: google.api.Service\ntitle: Foo API\ndocumentation:\n  summary: Manages foos.\n",
+			override:      "Foo override",
+			want:          "Foo API",
+			wantSource:    titleDescriptionSource,
+		},
+		{
+			name:          "summary",
+			serviceConfig: "type: google.api.Service\ndocumentation:\n  summary: |-\n    Manages\n    foos.\n",
+			override:      "Foo override",
+			want:          "Manages foos.",
+			wantSource:    summaryDescriptionSource,
+		},
+		{
+			name:          "description override",
+			serviceConfig: "type: google.api.Service\n",
+			override:      "Foo override",
+			want:          "Foo override",
+			wantSource:    overrideDescriptionSource,
+		},
+		{
+			name:          "distribution name",
+			serviceConfig: "type: google.api.Service\n",
+			want:          "cloud.google.com/go/foo/apiv1",
+			wantSource:    distributionNameDescriptionSource,
+		},
+		{
+			name:          "configured order",
+			serviceConfig: "type: google.api.Service\ntitle: Foo API\ndocumentation:\n  summary: Manages foos.\n",
+			override:      "Foo override",
+			sources:       []descriptionSource{overrideDescriptionSource, titleDescriptionSource},
+			want:          "Foo override",
+			wantSource:    overrideDescriptionSource,
+		},
+		{
+			name:          "no source has a text",
+			serviceConfig: "type: google.api.Service\n",
+			sources:       []descriptionSource{titleDescriptionSource, summaryDescriptionSource},
+			want:          "",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := newTestManifestProcessor(t)
+			p.verbose = true
+			p.config.DescriptionSources = tt.sources
+			p.config.GoogleapisToImportPath["google/cloud/foo/v1"].DescriptionOverride = tt.override
+			writeTestFiles(t, p.googleapisDir, map[string]string{"google/cloud/foo/v1/foo_v1.yaml": tt.serviceConfig})
+			var buf bytes.Buffer
+			log.SetOutput(&buf)
+			defer log.SetOutput(os.Stderr)
+			got, err := p.ManifestForInput(context.Background(), "google/cloud/foo/v1")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if desc := got["cloud.google.com/go/foo/apiv1"].Description; desc != tt.want {
+				t.Errorf("Description = %q, want %q", desc, tt.want)
+			}
+			if tt.wantSource != "" {
+				if want := "description of cloud.google.com/go/foo/apiv1 is from its " + string(tt.wantSource); !strings.Contains(buf.String(), want) {
+					t.Errorf("logged %q, want it to contain %q", buf.String(), want)
+				}
+			}
+		})
+	}
+
+	c := &config{manifestConfig: manifestConfig{DescriptionSources: []descriptionSource{titleDescriptionSource, "readme"}}}
+	if err := c.validate(); err == nil {
+		t.Errorf("validate() = nil, want error for an unknown description source")
+	}
+}
+
+func TestManifestOverrides(t *testing.T) {
+	p := newTestManifestProcessor(t)
+	p.config.OverridesFile = "internal/manifest-overrides.json"
+	writeTestFiles(t, p.googleCloudDir, map[string]string{
+		p.config.OverridesFile: `{"cloud.google.com/go/foo/apiv1": {"description": "Hand-tuned Foo"}}`,
+	})
+	entries, err := p.Manifest(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := ManifestEntry{
+		DistributionName:  "cloud.google.com/go/foo/apiv1",
+		Description:       "Hand-tuned Foo",
+		Language:          "Go",
+		ClientLibraryType: "generated",
+		DocsURL:           "https://cloud.google.com/go/docs/reference/cloud.google.com/go/foo/latest/apiv1",
+		ReleaseLevel:      "ga",
+		LibraryType:       gapicAutoLibraryType,
+	}
+	if diff := cmp.Diff(want, entries["cloud.google.com/go/foo/apiv1"]); diff != "" {
+		t.Errorf("overridden entry mismatch (-want +got):\n%s", diff)
+	}
+	if got := entries["cloud.google.com/go/bar/apiv1"].Description; got != "Bar API" {
+		t.Errorf("Description = %q, want other entries unchanged", got)
+	}
+
+	for _, bad := range []string{
+		`{"cloud.google.com/go/foo/apiv1": {"descripton": "typo"}}`,
+		`{"cloud.google.com/go/unknown": {"description": "Unknown"}}`,
+		`{"cloud.google.com/go/foo/apiv1": {"distribution_name": "cloud.google.com/go/bar/apiv1"}}`,
+		`["cloud.google.com/go/foo/apiv1"]`,
+	} {
+		writeTestFiles(t, p.googleCloudDir, map[string]string{p.config.OverridesFile: bad})
+		if _, err := p.Manifest(context.Background()); err == nil {
+			t.Errorf("Manifest() = nil error for overrides %s, want error", bad)
+		}
+	}
+}
+
+func TestManifestLibraryMetadataFile(t *testing.T) {
+	p := newTestManifestProcessor(t)
+	p.config.LibraryMetadataFile = "repo-metadata.json"
+	writeTestFiles(t, p.googleCloudDir, map[string]string{
+		"foo/apiv1/repo-metadata.json": `{
+  "description": "Foo, curated by its team",
+  "language": "Go",
+  "client_library_type": "generated",
+  "docs_url": "https://cloud.google.com/go/docs/reference/cloud.google.com/go/foo/latest/apiv1",
+  "release_level": "beta",
+  "library_type": "GAPIC_MANUAL"
+}`,
+	})
+	entries, err := p.Manifest(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := ManifestEntry{
+		DistributionName:  "cloud.google.com/go/foo/apiv1",
+		Description:       "Foo, curated by its team",
+		Language:          "Go",
+		ClientLibraryType: "generated",
+		DocsURL:           "https://cloud.google.com/go/docs/reference/cloud.google.com/go/foo/latest/apiv1",
+		ReleaseLevel:      "beta",
+		LibraryType:       gapicManualLibraryType,
+	}
+	if diff := cmp.Diff(want, entries["cloud.google.com/go/foo/apiv1"]); diff != "" {
+		t.Errorf("replaced entry mismatch (-want +got):\n%s", diff)
+	}
+	if got := entries["cloud.google.com/go/bar/apiv1"].Description; got != "Bar API" {
+		t.Errorf("Description = %q, want other entries unchanged", got)
+	}
+
+	for _, bad := range []string{
+		`{"descripton": "typo"}`,
+		`{"description": "Foo", "language": "Go", "docs_url": "https://cloud.google.com/go/docs/reference/cloud.google.com/go/foo/latest/apiv1", "release_level": "stable", "library_type": "GAPIC_AUTO"}`,
+		`{"distribution_name": "cloud.google.com/go/bar/apiv1", "description": "Foo", "language": "Go", "docs_url": "https://cloud.google.com/go/docs/reference/cloud.google.com/go/foo/latest/apiv1", "release_level": "ga", "library_type": "GAPIC_AUTO"}`,
+	} {
+		writeTestFiles(t, p.googleCloudDir, map[string]string{"foo/apiv1/repo-metadata.json": bad})
+		if _, err := p.Manifest(context.Background()); err == nil {
+			t.Errorf("Manifest() = nil error for library metadata file %s, want error", bad)
+		}
+	}
+
+	// Only libraries without a directory are skipped.
+	if err := p.applyLibraryMetadataFiles(map[string]ManifestEntry{"cloud.google.com/go/missing": {}}); err != nil {
+		t.Errorf("applyLibraryMetadataFiles() = %v for a library without a directory, want nil error", err)
+	}
+	if err := p.applyLibraryMetadataFiles(map[string]ManifestEntry{"example.com/foo": {}}); err == nil {
+		t.Errorf("applyLibraryMetadataFiles() = nil error for a library outside cloud.google.com/go, want error")
+	}
+}
+
+func TestManifestAgentMarkerFile(t *testing.T) {
+	p := newTestManifestProcessor(t)
+	p.config.AgentMarkerFile = "AGENTS.md"
+	writeTestFiles(t, p.googleCloudDir, map[string]string{
+		"foo/apiv1/AGENTS.md": "# Foo agent\n",
+		"baz/AGENTS.md":       "# Baz agent\n",
+	})
+	entries, err := p.Manifest(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, want := range map[string]libraryType{
+		"cloud.google.com/go/foo/apiv1": agentLibraryType,
+		"cloud.google.com/go/baz":       agentLibraryType,
+		"cloud.google.com/go/bar/apiv1": gapicAutoLibraryType,
+	} {
+		if got := entries[name].LibraryType; got != want {
+			t.Errorf("%s: LibraryType = %q, want %q", name, got, want)
+		}
+	}
+
+	// The overrides file is applied after the marker.
+	p.config.OverridesFile = "internal/manifest-overrides.json"
+	writeTestFiles(t, p.googleCloudDir, map[string]string{
+		p.config.OverridesFile: `{"cloud.google.com/go/foo/apiv1": {"library_type": "GAPIC_MANUAL"}}`,
+	})
+	entries, err = p.Manifest(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := entries["cloud.google.com/go/foo/apiv1"].LibraryType; got != gapicManualLibraryType {
+		t.Errorf("LibraryType = %q, want the overridden %q", got, gapicManualLibraryType)
+	}
+
+	// Only libraries without a directory are skipped.
+	if err := p.applyAgentMarkers(map[string]ManifestEntry{"cloud.google.com/go/missing": {}}); err != nil {
+		t.Errorf("applyAgentMarkers() = %v for a library without a directory, want nil error", err)
+	}
+	if err := p.applyAgentMarkers(map[string]ManifestEntry{"example.com/foo": {}}); err == nil {
+		t.Errorf("applyAgentMarkers() = nil error for a library outside cloud.google.com/go, want error")
+	}
+
+	p.config.AgentMarkerFile = "agent/AGENTS.md"
+	if err := p.config.validate(); err == nil {
+		t.Errorf("validate() = nil error for an agent marker path, want error")
+	}
+}
+
+func TestManifestSideBySideMajorVersions(t *testing.T) {
+	p := newTestManifestProcessor(t)
+	p.config.GoogleapisToImportPath["google/cloud/foo/v2beta"] = &libraryInfo{
+		ImportPath:    "cloud.google.com/go/foo/apiv2beta",
+		ServiceConfig: serviceConfigList{"foo_v2beta.yaml"},
+		RelPath:       "/foo/apiv2beta",
+	}
+	writeTestFiles(t, p.googleCloudDir, map[string]string{"foo/apiv2beta/doc.go": "package foo\n"})
+	writeTestFiles(t, p.googleapisDir, map[string]string{
+		"google/cloud/foo/v2beta/foo_v2beta.yaml": "type: google.api.Service\ntitle: Foo API\n",
+	})
+	entries, err := p.Manifest(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]ManifestEntry{
+		"cloud.google.com/go/foo/apiv1": {
+			DistributionName:  "cloud.google.com/go/foo/apiv1",
+			Description:       "Foo API",
+			Language:          "Go",
+			ClientLibraryType: "generated",
+			DocsURL:           "https://cloud.google.com/go/docs/reference/cloud.google.com/go/foo/latest/apiv1",
+			ReleaseLevel:      "ga",
+			LibraryType:       gapicAutoLibraryType,
+		},
+		"cloud.google.com/go/foo/apiv2beta": {
+			DistributionName:  "cloud.google.com/go/foo/apiv2beta",
+			Description:       "Foo API",
+			Language:          "Go",
+			ClientLibraryType: "generated",
+			DocsURL:           "https://cloud.google.com/go/docs/reference/cloud.google.com/go/foo/latest/apiv2beta",
+			ReleaseLevel:      "beta",
+			LibraryType:       gapicAutoLibraryType,
+		},
+	}
+	for name, w := range want {
+		if diff := cmp.Diff(w, entries[name]); diff != "" {
+			t.Errorf("entry %s mismatch (-want +got):\n%s", name, diff)
+		}
+	}
+	if got := p.releaseLevelSources["cloud.google.com/go/foo/apiv2beta"]; got != pathSuffixSource {
+		t.Errorf("release level source of apiv2beta = %q, want %q", got, pathSuffixSource)
+	}
+	if got := p.modulePackages["cloud.google.com/go/foo"]; len(got) != 2 {
+		t.Errorf("module cloud.google.com/go/foo has packages %v, want both versions", got)
+	}
+}
+
+func TestManifestMinEntries(t *testing.T) {
+	p := newTestManifestProcessor(t)
+	p.config.MinEntries = 4
+	if _, err := p.Manifest(context.Background()); err != nil {
+		t.Fatalf("Manifest() = %v, want the 4 entries to meet the threshold", err)
+	}
+
+	p.config.MinEntries = 5
+	_, err := p.Manifest(context.Background())
+	if want := "computed 4 manifest entries, want at least 5"; err == nil || !strings.Contains(err.Error(), want) {
+		t.Errorf("Manifest() = %v, want error containing %q", err, want)
+	}
+
+	p.config.MinEntries = -1
+	if err := p.config.validate(); err == nil {
+		t.Errorf("validate() = nil error for a negative min-entries, want error")
+	}
+}
+
+func TestManifestDeniedTitle(t *testing.T) {
+	p := newTestManifestProcessor(t)
+	p.config.DeniedTitleSubstrings = []string{"todo", "Example API"}
+	writeTestFiles(t, p.googleapisDir, map[string]string{
+		"google/cloud/foo/v1/foo_v1.yaml": "type: google.api.Service\ntitle: TODO API\n",
+	})
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	defer log.SetOutput(os.Stderr)
+	if _, err := p.Manifest(context.Background()); err != nil {
+		t.Fatalf("Manifest() = %v, want a warning for the placeholder title", err)
+	}
+	want := `cloud.google.com/go/foo/apiv1 has the placeholder title "TODO API" (denied "todo")`
+	if got := buf.String(); !strings.Contains(got, want) {
+		t.Errorf("log = %q, want warning containing %q", got, want)
+	}
+	if n := strings.Count(buf.String(), "placeholder title"); n != 1 {
+		t.Errorf("log has %d placeholder title warnings, want 1", n)
+	}
+
+	p.config.FailOnDeniedTitles = true
+	_, err := p.Manifest(context.Background())
+	if err == nil || !strings.Contains(err.Error(), want) {
+		t.Errorf("Manifest() = %v, want error containing %q", err, want)
+	}
+
+	p.config.DeniedTitleSubstrings = []string{" "}
+	if err := p.config.validate(); err == nil {
+		t.Errorf("validate() = nil error for an empty denied title substring, want error")
+	}
+}
+
+func TestManifestSizeBudget(t *testing.T) {
+	p := newTestManifestProcessor(t)
+	if _, err := p.Manifest(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	manifestPath := filepath.Join(p.googleCloudDir, "internal", ".repo-metadata-full.json")
+	fi, err := os.Stat(manifestPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	size := int(fi.Size())
+
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	defer log.SetOutput(os.Stderr)
+	p.config.MaxManifestBytes = size
+	if _, err := p.Manifest(context.Background()); err != nil {
+		t.Fatalf("Manifest() = %v, want a manifest of exactly the budget to pass", err)
+	}
+	if strings.Contains(buf.String(), "max-manifest-bytes") {
+		t.Errorf("log = %q, want no warning for a manifest within the budget", buf.String())
+	}
+
+	p.config.MaxManifestBytes = size - 1
+	if _, err := p.Manifest(context.Background()); err != nil {
+		t.Fatalf("Manifest() = %v, want a warning for a manifest over the budget", err)
+	}
+	want := fmt.Sprintf("is %d bytes, over the budget of %d bytes", size, size-1)
+	if !strings.Contains(buf.String(), want) {
+		t.Errorf("log = %q, want warning containing %q", buf.String(), want)
+	}
+
+	p.config.FailOnManifestSize = true
+	_, err = p.Manifest(context.Background())
+	if err == nil || !strings.Contains(err.Error(), want) {
+		t.Errorf("Manifest() = %v, want error containing %q", err, want)
+	}
+
+	p.config.MaxManifestBytes = -1
+	if err := p.config.validate(); err == nil {
+		t.Errorf("validate() = nil error for a negative max-manifest-bytes, want error")
+	}
+}
+
+func TestManifestDryRun(t *testing.T) {
+	p := newTestManifestProcessor(t)
+	p.config.DryRun = true
+	p.config.WriteJSONL = true
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	defer log.SetOutput(os.Stderr)
+	entries, err := p.Manifest(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 4 {
+		t.Errorf("Manifest() = %d entries, want 4", len(entries))
+	}
+	for _, name := range []string{".repo-metadata-full.json", ".repo-metadata-full.jsonl"} {
+		path := filepath.Join(p.googleCloudDir, "internal", name)
+		if _, err := os.Stat(path); !os.IsNotExist(err) {
+			t.Errorf("%s was written in a dry run: %v", name, err)
+		}
+		if want := "dry run: would write " + path; !strings.Contains(buf.String(), want) {
+			t.Errorf("log = %q, want %q", buf.String(), want)
+		}
+	}
+}
+
+func TestManifestOutputFormat(t *testing.T) {
+	p := newTestManifestProcessor(t)
+	p.config.Output = "out/manifest.yaml"
+	p.config.Format = yamlManifestFormat
+	entries, err := p.Manifest(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := os.ReadFile(filepath.Join(p.googleCloudDir, "out", "manifest.yaml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "cloud.google.com/go/bar/apiv1:\n  distribution_name: cloud.google.com/go/bar/apiv1\n"; !strings.HasPrefix(string(b), want) {
+		t.Errorf("manifest = %q, want YAML starting with %q", b, want)
+	}
+	got, err := decodeManifest(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(entries, got); diff != "" {
+		t.Errorf("decoded YAML manifest mismatch (-want +got):\n%s", diff)
+	}
+	// The YAML manifest is recognized as one and may be overwritten.
+	if _, err := p.Manifest(context.Background()); err != nil {
+		t.Errorf("Manifest() over the YAML manifest = %v, want nil error", err)
+	}
+}
+
+func TestManifestDocsBaseURL(t *testing.T) {
+	p := newTestManifestProcessor(t)
+	p.config.DocsBaseURL = "https://staging.example.com/go/docs/reference"
+	entries, err := p.Manifest(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "https://staging.example.com/go/docs/reference/cloud.google.com/go/foo/latest/apiv1"
+	if got := entries["cloud.google.com/go/foo/apiv1"].DocsURL; got != want {
+		t.Errorf("DocsURL = %q, want %q", got, want)
+	}
+}
+
+func TestManifestCheckImportPaths(t *testing.T) {
+	p := newTestManifestProcessor(t)
+	p.config.CheckImportPaths = true
+	if _, err := p.Manifest(context.Background()); err != nil {
+		t.Fatalf("Manifest() = %v, want every configured import path to resolve", err)
+	}
+
+	p.config.GoogleapisToImportPath["google/cloud/foo/v2"] = &libraryInfo{
+		ImportPath:    "cloud.google.com/go/foo/apiv2",
+		ServiceConfig: serviceConfigList{"foo_v2.yaml"},
+		RelPath:       "/foo/apiv2",
+	}
+	p.config.GoogleapisToImportPath["google/cloud/qux/v2"] = &libraryInfo{
+		ImportPath:    "cloud.google.com/go/qux/apiv2",
+		ServiceConfig: serviceConfigList{"qux_v2.yaml"},
+		RelPath:       "/qux/apiv2",
+	}
+	writeTestFiles(t, p.googleCloudDir, map[string]string{"qux/apiv2/README.md": "# qux\n"})
+	_, err := p.Manifest(context.Background())
+	if err == nil {
+		t.Fatal("Manifest() = nil error for import paths without a package, want error")
+	}
+	for _, want := range []string{
+		"import path cloud.google.com/go/foo/apiv2 of google/cloud/foo/v2 does not resolve to a package: /foo/apiv2 does not exist",
+		"import path cloud.google.com/go/qux/apiv2 of google/cloud/qux/v2 does not resolve to a package: /qux/apiv2 has no .go files",
+	} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("Manifest() = %v, want it to contain %q", err, want)
+		}
+	}
+}
+
+func TestManifestSparseCheckout(t *testing.T) {
+	p := newTestManifestProcessor(t)
+	// qux is excluded from the checkout.
+	if err := os.RemoveAll(filepath.Join(p.googleCloudDir, "qux")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := p.Manifest(context.Background()); err == nil {
+		t.Fatalf("Manifest() = nil error for a missing library directory, want error")
+	}
+
+	p.config.SparseCheckout = true
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	defer log.SetOutput(os.Stderr)
+	entries, err := p.Manifest(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "skipping cloud.google.com/go/qux/apiv1beta: its directory /qux/apiv1beta is not in this checkout"; !strings.Contains(buf.String(), want) {
+		t.Errorf("Manifest() logged:\n%s\nwant %q", buf.String(), want)
+	}
+	if _, ok := entries["cloud.google.com/go/qux/apiv1beta"]; ok {
+		t.Errorf("Manifest() has an entry for the skipped cloud.google.com/go/qux/apiv1beta")
+	}
+	if _, ok := entries["cloud.google.com/go/foo/apiv1"]; !ok {
+		t.Errorf("Manifest() has no entry for cloud.google.com/go/foo/apiv1")
+	}
+
+	// A present library without its doc.go is broken rather than excluded.
+	if err := os.Remove(filepath.Join(p.googleCloudDir, "bar", "apiv1", "doc.go")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := p.Manifest(context.Background()); err == nil {
+		t.Errorf("Manifest() = nil error for a library directory without doc.go, want error")
+	}
+}
+
+func TestManifestUnknownReleaseLevel(t *testing.T) {
+	p := newTestManifestProcessor(t)
+	// foo/apiv1 has no stability signal.
+	p.config.RequireExplicitStability = true
+	if _, err := p.Manifest(context.Background()); err == nil {
+		t.Fatalf("Manifest() = nil error without a stability signal, want error")
+	}
+
+	p.config.UnknownReleaseLevel = "unknown"
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	defer log.SetOutput(os.Stderr)
+	entries, err := p.Manifest(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, want := range map[string]string{
+		"cloud.google.com/go/foo/apiv1":     "unknown",
+		"cloud.google.com/go/bar/apiv1":     "beta",
+		"cloud.google.com/go/qux/apiv1beta": "beta",
+	} {
+		if got := entries[name].ReleaseLevel; got != want {
+			t.Errorf("%s: ReleaseLevel = %q, want %q", name, got, want)
+		}
+	}
+	if want := `1 entries have no stability signal and the placeholder release level "unknown": cloud.google.com/go/foo/apiv1`; !strings.Contains(buf.String(), want) {
+		t.Errorf("Manifest() logged:\n%s\nwant %q", buf.String(), want)
+	}
+	if errs := p.ValidateEntries(entries); len(errs) > 0 {
+		t.Errorf("ValidateEntries() = %v, want the placeholder to be valid", errs)
+	}
+
+	p.config.UnknownReleaseLevel = "not known"
+	if err := p.config.validate(); err == nil {
+		t.Errorf("validate() = nil error for a placeholder with a space, want error")
+	}
+}
+
+func TestManifestGraduationDates(t *testing.T) {
+	p := newTestManifestProcessor(t)
+	p.config.GraduationDates = map[string]string{
+		"cloud.google.com/go/bar/apiv1": "2024-03-01",
+	}
+	entries, err := p.Manifest(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, e := range entries {
+		want := p.config.GraduationDates[name]
+		if e.GraduationDate != want {
+			t.Errorf("%s: GraduationDate = %q, want %q", name, e.GraduationDate, want)
+		}
+	}
+	b, err := os.ReadFile(p.manifestPath())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := strings.Count(string(b), `"graduation_date"`); n != 1 {
+		t.Errorf("manifest has %d graduation dates, want 1", n)
+	}
+
+	p.config.GraduationDates["cloud.google.com/go/foo/apiv1"] = "2024-03-01"
+	if _, err := p.Manifest(context.Background()); err == nil {
+		t.Errorf("Manifest() = nil error for graduation date of ga entry, want error")
+	}
+	p.config.GraduationDates = map[string]string{"cloud.google.com/go/bar/apiv1": "March 2024"}
+	if err := p.config.validate(); err == nil {
+		t.Errorf("validate() = nil error for malformed graduation date, want error")
+	}
+}
+
+func TestManifestReleaseLevelPins(t *testing.T) {
+	p := newTestManifestProcessor(t)
+	// The doc.go of foo/apiv1 has no disclaimer, so it is detected as ga.
+	p.config.ReleaseLevelPins = map[string]string{"cloud.google.com/go/foo/apiv1": "beta"}
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	defer log.SetOutput(os.Stderr)
+	entries, err := p.Manifest(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := entries["cloud.google.com/go/foo/apiv1"].ReleaseLevel; got != "beta" {
+		t.Errorf("ReleaseLevel = %q, want the pinned beta", got)
+	}
+	if got := p.releaseLevelSources["cloud.google.com/go/foo/apiv1"]; got != pinSource {
+		t.Errorf("release level source = %q, want %q", got, pinSource)
+	}
+	if want := "pinning the release level of cloud.google.com/go/foo/apiv1 to beta, detected ga"; !strings.Contains(buf.String(), want) {
+		t.Errorf("Manifest() logged %q, want %q", buf.String(), want)
+	}
+	if got := entries["cloud.google.com/go/bar/apiv1"].ReleaseLevel; got != "beta" {
+		t.Errorf("ReleaseLevel = %q, want other entries unchanged", got)
+	}
+
+	p.config.ReleaseLevelPins = map[string]string{"cloud.google.com/go/unknown": "beta"}
+	if _, err := p.Manifest(context.Background()); err == nil {
+		t.Errorf("Manifest() = nil error for a pin without an entry, want error")
+	}
+	p.config.ReleaseLevelPins = map[string]string{"cloud.google.com/go/foo/apiv1": "stable"}
+	if err := p.config.validate(); err == nil {
+		t.Errorf("validate() = nil error for a pin to an unknown release level, want error")
+	}
+}
+
+func TestManifestReleaseLevelHistory(t *testing.T) {
+	p := newTestManifestProcessor(t)
+	p.config.ReleaseLevelHistoryFile = "internal/release-level-history.jsonl"
+	p.now = func() time.Time { return time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC) }
+	historyPath := filepath.Join(p.googleCloudDir, p.config.ReleaseLevelHistoryFile)
+	if _, err := p.Manifest(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(historyPath); !errors.Is(err, fs.ErrNotExist) {
+		t.Fatalf("Stat() = %v, want no history for a new manifest", err)
+	}
+
+	p.config.ReleaseLevelPins = map[string]string{"cloud.google.com/go/foo/apiv1": "beta"}
+	if _, err := p.Manifest(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	// The level is unchanged in the third run, so nothing is appended.
+	if _, err := p.Manifest(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	got, err := os.ReadFile(historyPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `{"date":"2024-03-01","distribution":"cloud.google.com/go/foo/apiv1","old":"ga","new":"beta"}` + "\n"
+	if diff := cmp.Diff(want, string(got)); diff != "" {
+		t.Errorf("history mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestManifestDetectManualReleaseLevels(t *testing.T) {
+	p := newTestManifestProcessor(t)
+	p.config.DetectManualReleaseLevels = true
+	p.config.ManualClientInfo[0].ReleaseLevel = ""
+	writeTestFiles(t, p.googleCloudDir, map[string]string{"baz/doc.go": testDocBeta})
+	entries, err := p.Manifest(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := entries["cloud.google.com/go/baz"].ReleaseLevel; got != "beta" {
+		t.Errorf("ReleaseLevel = %q, want beta from baz/doc.go", got)
+	}
+	if got := p.releaseLevelSources["cloud.google.com/go/baz"]; got != docMarkerSource {
+		t.Errorf("release level source = %q, want %q", got, docMarkerSource)
+	}
+
+	// A configured release level is kept.
+	p.config.ManualClientInfo[0].ReleaseLevel = "ga"
+	entries, err = p.Manifest(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := entries["cloud.google.com/go/baz"].ReleaseLevel; got != "ga" {
+		t.Errorf("ReleaseLevel = %q, want the configured ga", got)
+	}
+}
+
+func TestManifestStage(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not found")
+	}
+	p := newTestManifestProcessor(t)
+	p.config.StageManifest = true
+	p.config.WriteJSONL = true
+
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	defer log.SetOutput(os.Stderr)
+	if _, err := p.Manifest(context.Background()); err != nil {
+		t.Fatalf("Manifest() = %v, want staging to be skipped outside of a git worktree", err)
+	}
+	if !strings.Contains(buf.String(), "is not a git worktree") {
+		t.Errorf("Manifest() did not log that staging was skipped, got:\n%s", buf.String())
+	}
+
+	git := func(args ...string) string {
+		t.Helper()
+		c := exec.Command("git", args...)
+		c.Dir = p.googleCloudDir
+		b, err := c.Output()
+		if err != nil {
+			t.Fatalf("git %s: %v", strings.Join(args, " "), err)
+		}
+		return string(b)
+	}
+	git("init", "-q")
+	if _, err := p.Manifest(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	got := strings.Fields(git("diff", "--cached", "--name-only"))
+	want := []string{"internal/.repo-metadata-full.json", "internal/.repo-metadata-full.jsonl"}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("staged files mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestManifestDuplicateGeneratedEntries(t *testing.T) {
+	// Like containeranalysis/v1beta1 and its grafeas subdirectory, two input
+	// directories with the same import path and service config generate one
+	// entry.
+	p := newTestManifestProcessor(t)
+	p.config.GoogleapisToImportPath["google/cloud/foo/v1/grafeas"] = &libraryInfo{
+		ImportPath:    "cloud.google.com/go/foo/apiv1",
+		ServiceConfig: serviceConfigList{"../foo_v1.yaml"},
+		RelPath:       "/foo/apiv1",
+	}
+	entries, err := p.Manifest(context.Background())
+	if err != nil {
+		t.Fatalf("Manifest() = %v, want the entries of the same import path merged", err)
+	}
+	if got, want := len(entries), 4; got != want {
+		t.Errorf("Manifest() has %d entries, want %d", got, want)
+	}
+	if got, want := entries["cloud.google.com/go/foo/apiv1"].Description, "Foo API"; got != want {
+		t.Errorf("description = %q, want %q", got, want)
+	}
+	if got := p.generatedInputDirs["cloud.google.com/go/foo/apiv1"]; got != "google/cloud/foo/v1" {
+		t.Errorf("input directory = %q, want the first input directory google/cloud/foo/v1", got)
+	}
+}
+
+func TestManifestCounts(t *testing.T) {
+	p := newTestManifestProcessor(t)
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	defer log.SetOutput(os.Stderr)
+	if _, err := p.Manifest(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if want := (manifestCounts{Generated: 3, Manual: 1}); p.ManifestCounts() != want {
+		t.Errorf("ManifestCounts() = %+v, want %+v", p.ManifestCounts(), want)
+	}
+	if !strings.Contains(buf.String(), "wrote 4 entries (3 generated, 1 manual)") {
+		t.Errorf("Manifest() logged %q, want a summary of the entry counts", buf.String())
+	}
+
+	// A manual entry merged with a generated entry counts as generated, and
+	// excluded entries are not counted.
+	p.config.ManualClientInfo = append(p.config.ManualClientInfo, &ManifestEntry{
+		DistributionName: "cloud.google.com/go/foo/apiv1",
+		DocsURL:          "https://cloud.google.com/go/docs/reference/cloud.google.com/go/foo/latest/apiv1",
+		LibraryType:      gapicManualLibraryType,
+	})
+	p.config.ExcludeFromManifest = []string{"cloud.google.com/go/bar/apiv1"}
+	if _, err := p.Manifest(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if want := (manifestCounts{Generated: 2, Manual: 1}); p.ManifestCounts() != want {
+		t.Errorf("ManifestCounts() = %+v, want %+v", p.ManifestCounts(), want)
+	}
+
+	p.config.GoogleapisToImportPath = map[string]*libraryInfo{
+		"google/cloud/foo/v1": {ImportPath: "cloud.google.com/go/foo/apiv1", RelPath: "/foo/apiv1"},
+	}
+	buf.Reset()
+	if _, err := p.Manifest(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(buf.String(), "no generated entries were produced from 1 service configs") {
+		t.Errorf("Manifest() logged %q, want a warning about no generated entries", buf.String())
+	}
+}
+
+func TestNormalizeImportPath(t *testing.T) {
+	tests := map[string]string{
+		"cloud.google.com/go/foo/apiv1":     "cloud.google.com/go/foo/apiv1",
+		"cloud.google.com/go/foo/apiv1/":    "cloud.google.com/go/foo/apiv1",
+		"cloud.google.com/go//foo/apiv1":    "cloud.google.com/go/foo/apiv1",
+		"cloud.google.com/go/foo/./apiv1//": "cloud.google.com/go/foo/apiv1",
+		`cloud.google.com\go\foo\apiv1`:     "cloud.google.com/go/foo/apiv1",
+		"":                                  "",
+	}
+	for in, want := range tests {
+		if got := normalizeImportPath(in); got != want {
+			t.Errorf("normalizeImportPath(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+func TestModulePackageDocURLSlashes(t *testing.T) {
+	mp := modulePackage{
+		Module:     `cloud.google.com\go\foo`,
+		ImportPath: `cloud.google.com\go\foo\apiv1\foopb`,
+		PkgPath:    `apiv1\foopb`,
+	}
+	want := "https://cloud.google.com/go/docs/reference/cloud.google.com/go/foo/latest/apiv1/foopb"
+	if got := mp.docURL("https://cloud.google.com/go/docs/reference/", false); got != want {
+		t.Errorf("docURL() = %q, want %q", got, want)
+	}
+}
+
+func TestManifestNormalizedImportPathCollision(t *testing.T) {
+	tests := []struct {
+		name       string
+		importPath string
+		wantErr    string
+	}{
+		{name: "trailing slash", importPath: "cloud.google.com/go/foo/apiv1/", wantErr: `"cloud.google.com/go/foo/apiv1/" of google/cloud/foo/v1alpha both normalize to cloud.google.com/go/foo/apiv1`},
+		{name: "duplicate slash", importPath: "cloud.google.com/go//foo/apiv1", wantErr: `"cloud.google.com/go//foo/apiv1" of google/cloud/foo/v1alpha both normalize to cloud.google.com/go/foo/apiv1`},
+		{name: "case", importPath: "cloud.google.com/go/Foo/apiv1", wantErr: "differ only in case"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := newTestManifestProcessor(t)
+			p.config.GoogleapisToImportPath["google/cloud/foo/v1alpha"] = &libraryInfo{
+				ImportPath:    tt.importPath,
+				ServiceConfig: serviceConfigList{"../v1/foo_v1.yaml"},
+				RelPath:       "/foo/apiv1",
+			}
+			if _, err := p.Manifest(context.Background()); err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("Manifest() = %v, want an error containing %q", err, tt.wantErr)
+			}
+		})
+	}
+
+	p := newTestManifestProcessor(t)
+	p.config.GoogleapisToImportPath["google/cloud/foo/v1"].ImportPath = "cloud.google.com/go/foo/apiv1/"
+	entries, err := p.Manifest(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := entries["cloud.google.com/go/foo/apiv1"]; !ok {
+		t.Errorf("Manifest() is missing the normalized entry cloud.google.com/go/foo/apiv1")
+	}
+}
+
+func TestManifestGeneratorVersion(t *testing.T) {
+	tests := []struct {
+		name    string
+		version string
+		file    string
+		want    string
+	}{
+		{name: "not configured"},
+		{name: "config", version: "v0.40.0", want: "v0.40.0"},
+		{name: "file", file: "v0.41.0\n", want: "v0.41.0"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := newTestManifestProcessor(t)
+			p.config.GeneratorVersion = tt.version
+			if tt.file != "" {
+				writeTestFiles(t, p.googleCloudDir, map[string]string{"internal/gapic-generator-version": tt.file})
+				p.config.GeneratorVersionFile = "internal/gapic-generator-version"
+			}
+			entries, err := p.Manifest(context.Background())
+			if err != nil {
+				t.Fatal(err)
+			}
+			for name, e := range entries {
+				want := tt.want
+				if e.ClientLibraryType == "manual" {
+					want = ""
+				}
+				if e.GeneratorVersion != want {
+					t.Errorf("%s has generator version %q, want %q", name, e.GeneratorVersion, want)
+				}
+			}
+		})
+	}
+}
+
+func TestOpenServiceConfigRetry(t *testing.T) {
+	defer func(d time.Duration) { serviceConfigRetryDelay = d }(serviceConfigRetryDelay)
+	serviceConfigRetryDelay = 0
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	tests := []struct {
+		name      string
+		failures  int
+		err       error
+		attempts  int
+		wantCalls int
+		wantErr   bool
+	}{
+		{name: "transient once", failures: 1, err: errors.New("stale file handle"), wantCalls: 2},
+		{name: "transient exhausted", failures: 5, err: errors.New("stale file handle"), attempts: 2, wantCalls: 2, wantErr: true},
+		{name: "not exist", failures: 5, err: fs.ErrNotExist, wantCalls: 1, wantErr: true},
+		{name: "permission", failures: 5, err: fs.ErrPermission, wantCalls: 1, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := newTestManifestProcessor(t)
+			p.config.ServiceConfigOpenAttempts = tt.attempts
+			var calls int
+			p.openFile = func(path string) (io.ReadCloser, error) {
+				calls++
+				if calls <= tt.failures {
+					return nil, &fs.PathError{Op: "open", Path: path, Err: tt.err}
+				}
+				return os.Open(path)
+			}
+			path := filepath.Join(p.googleapisDir, "google/cloud/foo/v1/foo_v1.yaml")
+			sc, err := p.readServiceConfig(path)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("readServiceConfig() = %v, wantErr %v", err, tt.wantErr)
+			}
+			if calls != tt.wantCalls {
+				t.Errorf("readServiceConfig() opened the service config %d times, want %d", calls, tt.wantCalls)
+			}
+			if !tt.wantErr && sc.Title != "Foo API" {
+				t.Errorf("readServiceConfig() title = %q, want %q", sc.Title, "Foo API")
+			}
+		})
+	}
+}
+
+func TestManifestSharedServiceConfig(t *testing.T) {
+	p := newTestManifestProcessor(t)
+	writeTestFiles(t, p.googleCloudDir, map[string]string{"foo/admin/apiv1/doc.go": testDocGA})
+	p.config.GoogleapisToImportPath["google/cloud/foo/admin/v1"] = &libraryInfo{
+		ImportPath:    "cloud.google.com/go/foo/admin/apiv1",
+		ServiceConfig: serviceConfigList{"../../v1/foo_v1.yaml"},
+		RelPath:       "/foo/admin/apiv1",
+	}
+	shared := filepath.Join(p.googleapisDir, "google/cloud/foo/v1/foo_v1.yaml")
+	var mu sync.Mutex
+	opens := map[string]int{}
+	p.openFile = func(path string) (io.ReadCloser, error) {
+		abs, err := filepath.Abs(path)
+		if err != nil {
+			return nil, err
+		}
+		mu.Lock()
+		opens[abs]++
+		mu.Unlock()
+		return os.Open(path)
+	}
+	entries, err := p.Manifest(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"cloud.google.com/go/foo/apiv1", "cloud.google.com/go/foo/admin/apiv1"} {
+		if got := entries[name].Description; got != "Foo API" {
+			t.Errorf("%s: Description = %q, want %q", name, got, "Foo API")
+		}
+	}
+	if got := opens[shared]; got != 1 {
+		t.Errorf("shared service config decoded %d times, want 1", got)
+	}
+}
+
+func TestManifestLabels(t *testing.T) {
+	p := newTestManifestProcessor(t)
+	p.config.ManualClientInfo[0].Labels = []string{"storage"}
+	p.config.Labels = map[string][]string{
+		"cloud.google.com/go/foo/...":   {"data-analytics", "ai"},
+		"cloud.google.com/go/foo/apiv1": {"ai", "featured"},
+		"cloud.google.com/go/baz":       {"featured"},
+		"cloud.google.com/go/ba/...":    {"unused"},
+	}
+	entries, err := p.Manifest(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string][]string{
+		"cloud.google.com/go/foo/apiv1":     {"ai", "data-analytics", "featured"},
+		"cloud.google.com/go/bar/apiv1":     nil,
+		"cloud.google.com/go/baz":           {"featured", "storage"},
+		"cloud.google.com/go/qux/apiv1beta": nil,
+	}
+	for name, labels := range want {
+		if diff := cmp.Diff(labels, entries[name].Labels); diff != "" {
+			t.Errorf("%s labels mismatch (-want +got):\n%s", name, diff)
+		}
+	}
+	if errs := p.ValidateEntries(entries); len(errs) > 0 {
+		t.Errorf("ValidateEntries() = %v, want no problems", errs)
+	}
+
+	p.config.Labels = map[string][]string{"cloud.google.com/go/foo/...": {" "}}
+	if err := p.config.validate(); err == nil {
+		t.Errorf("validate() = nil, want error for an empty label")
+	}
+}
+
+func TestManifestExclude(t *testing.T) {
+	p := newTestManifestProcessor(t)
+	p.config.ExcludeFromManifest = []string{"cloud.google.com/go/baz", "cloud.google.com/go/unknown"}
+
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	defer log.SetOutput(os.Stderr)
+	entries, err := p.Manifest(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := entries["cloud.google.com/go/baz"]; ok {
+		t.Errorf("Manifest() includes excluded entry cloud.google.com/go/baz")
+	}
+	if _, ok := entries["cloud.google.com/go/foo/apiv1"]; !ok {
+		t.Errorf("Manifest() is missing entry cloud.google.com/go/foo/apiv1")
+	}
+	written, err := readManifestFile(p.manifestPath())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(entries, written); diff != "" {
+		t.Errorf("written manifest mismatch (-want +got):\n%s", diff)
+	}
+	if !strings.Contains(buf.String(), "excluding cloud.google.com/go/baz") {
+		t.Errorf("Manifest() did not log the excluded entry, got:\n%s", buf.String())
+	}
+}
+
+func TestManifestMultipleServiceConfigs(t *testing.T) {
+	p := newTestManifestProcessor(t)
+	p.config.GoogleapisToImportPath["google/cloud/foo/v1"].ServiceConfig = serviceConfigList{"foo_v1.yaml", "admin/foo_admin_v1.yaml"}
+	writeTestFiles(t, p.googleapisDir, map[string]string{
+		"google/cloud/foo/v1/admin/foo_admin_v1.yaml": "type: google.api.Service\ntitle: Foo Admin API\n",
+	})
+	got, err := p.ManifestForInput(context.Background(), "google/cloud/foo/v1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if desc := got["cloud.google.com/go/foo/apiv1"].Description; desc != "Foo API, Foo Admin API" {
+		t.Errorf("Description = %q, want %q", desc, "Foo API, Foo Admin API")
+	}
+
+	p.config.TitleSeparator = " and "
+	got, err = p.ManifestForInput(context.Background(), "google/cloud/foo/v1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if desc := got["cloud.google.com/go/foo/apiv1"].Description; desc != "Foo API and Foo Admin API" {
+		t.Errorf("Description = %q, want %q", desc, "Foo API and Foo Admin API")
+	}
+}
+
+func TestManifestQuiet(t *testing.T) {
+	p := newTestManifestProcessor(t)
+	p.quiet = true
+	// The go command helpers log unconditionally, only the -quiet flag
+	// silences them.
+	p.config.ModuleResolver = goModFileResolver
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	defer log.SetOutput(os.Stderr)
+	if _, err := p.Manifest(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if buf.Len() > 0 {
+		t.Errorf("Manifest() logged in quiet mode:\n%s", buf.String())
+	}
+
+	p.quiet = false
+	if _, err := p.Manifest(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(buf.String(), "updating gapic manifest") {
+		t.Errorf("Manifest() log = %q, want progress lines when not quiet", buf.String())
+	}
+}
+
+func TestManifestDocsLanguagePath(t *testing.T) {
+	p := newTestManifestProcessor(t)
+	p.config.DocsLanguagePath = "python"
+	if err := p.config.validate(); err != nil {
+		t.Fatal(err)
+	}
+	entries, err := p.Manifest(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := entries["cloud.google.com/go/foo/apiv1"].DocsURL, "https://cloud.google.com/python/docs/reference/cloud.google.com/go/foo/latest/apiv1"; got != want {
+		t.Errorf("DocsURL = %q, want %q", got, want)
+	}
+
+	for _, path := range []string{"go/docs", "..", "a b", "/go"} {
+		p.config.DocsLanguagePath = path
+		if err := p.config.validate(); err == nil {
+			t.Errorf("validate() = nil error for docs language path %q, want error", path)
+		}
+	}
+}
+
+func TestManifestModuleRootDocsURL(t *testing.T) {
+	tests := []struct {
+		name          string
+		keepRootSlash bool
+		want          string
+	}{
+		{
+			name: "trimmed",
+			want: "https://cloud.google.com/go/docs/reference/cloud.google.com/go/root/latest",
+		},
+		{
+			name:          "kept",
+			keepRootSlash: true,
+			want:          "https://cloud.google.com/go/docs/reference/cloud.google.com/go/root/latest/",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := newTestManifestProcessor(t)
+			p.config.KeepModuleRootDocsURLSlash = tt.keepRootSlash
+			writeTestFiles(t, p.googleCloudDir, map[string]string{
+				"root/go.mod": "module cloud.google.com/go/root\n\ngo 1.20\n",
+				"root/doc.go": testDocGA,
+			})
+			writeTestFiles(t, p.googleapisDir, map[string]string{
+				"google/cloud/root/v1/root_v1.yaml": "type: google.api.Service\ntitle: Root API\n",
+			})
+			p.config.GoogleapisToImportPath["google/cloud/root/v1"] = &libraryInfo{
+				ImportPath:    "cloud.google.com/go/root",
+				ServiceConfig: serviceConfigList{"root_v1.yaml"},
+				RelPath:       "/root",
+			}
+			entries, err := p.Manifest(context.Background())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := entries["cloud.google.com/go/root"].DocsURL; got != tt.want {
+				t.Errorf("DocsURL = %q, want %q", got, tt.want)
+			}
+			if errs := p.ValidateEntries(entries); len(errs) > 0 {
+				t.Errorf("ValidateEntries() = %v, want no errors", errs)
+			}
+		})
+	}
+}
+
+func TestManifestDocsURLSuffixes(t *testing.T) {
+	p := newTestManifestProcessor(t)
+	p.config.DocsURLSuffixes = map[string]string{"beta": "?preview=true"}
+	entries, err := p.Manifest(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{
+		"cloud.google.com/go/foo/apiv1":     "https://cloud.google.com/go/docs/reference/cloud.google.com/go/foo/latest/apiv1",
+		"cloud.google.com/go/bar/apiv1":     "https://cloud.google.com/go/docs/reference/cloud.google.com/go/bar/latest/apiv1?preview=true",
+		"cloud.google.com/go/qux/apiv1beta": "https://cloud.google.com/go/docs/reference/cloud.google.com/go/qux/latest/apiv1beta?preview=true",
+	}
+	for name, url := range want {
+		if got := entries[name].DocsURL; got != url {
+			t.Errorf("%s: DocsURL = %q, want %q", name, got, url)
+		}
+	}
+
+	p.config.DocsURLSuffixes = map[string]string{"beta": "/beta/"}
+	entries, err = p.Manifest(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := entries["cloud.google.com/go/bar/apiv1"].DocsURL, "https://cloud.google.com/go/docs/reference/cloud.google.com/go/bar/latest/apiv1/beta/"; got != want {
+		t.Errorf("DocsURL = %q, want %q", got, want)
+	}
+
+	for _, suffix := range []string{"preview", "?preview=<true>", "/a b"} {
+		p.config.DocsURLSuffixes = map[string]string{"beta": suffix}
+		if err := p.config.validate(); err == nil {
+			t.Errorf("validate() = nil error for suffix %q, want error", suffix)
+		}
+	}
+}
+
+func TestManifestMixedCaseDocsURL(t *testing.T) {
+	p := newTestManifestProcessor(t)
+	p.config.GoogleapisToImportPath["google/cloud/foo/v2"] = &libraryInfo{
+		ImportPath:    "cloud.google.com/go/foo/apiV2",
+		ServiceConfig: serviceConfigList{"foo_v2.yaml"},
+		RelPath:       "/foo/apiV2",
+	}
+	writeTestFiles(t, p.googleCloudDir, map[string]string{"foo/apiV2/doc.go": testDocGA})
+	writeTestFiles(t, p.googleapisDir, map[string]string{"google/cloud/foo/v2/foo_v2.yaml": "type: google.api.Service\ntitle: Foo API\n"})
+
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	defer log.SetOutput(os.Stderr)
+	entries, err := p.Manifest(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := `package path segment "apiV2" is not lowercase`; !strings.Contains(buf.String(), want) {
+		t.Errorf("Manifest() logged:\n%s\nwant %q", buf.String(), want)
+	}
+	if got, want := entries["cloud.google.com/go/foo/apiV2"].DocsURL, "https://cloud.google.com/go/docs/reference/cloud.google.com/go/foo/latest/apiV2"; got != want {
+		t.Errorf("DocsURL = %q, want %q", got, want)
+	}
+
+	p.config.FailOnDocsURLProblems = true
+	if _, err := p.Manifest(context.Background()); err == nil {
+		t.Errorf("Manifest() = nil error for a mixed-case docs URL, want error")
+	}
+
+	p.config.LowercaseDocsURLPaths = true
+	entries, err = p.Manifest(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := entries["cloud.google.com/go/foo/apiV2"].DocsURL, "https://cloud.google.com/go/docs/reference/cloud.google.com/go/foo/latest/apiv2"; got != want {
+		t.Errorf("DocsURL = %q, want %q", got, want)
+	}
+}
+
+func TestManifestDocsURLOverride(t *testing.T) {
+	p := newTestManifestProcessor(t)
+	p.config.GoogleapisToImportPath["google/cloud/foo/v1"].DocsURLOverride = "https://cloud.google.com/foo/docs/go"
+	p.config.ManualClientInfo[0].DocsURLOverride = "https://example.com/baz"
+
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	defer log.SetOutput(os.Stderr)
+	entries, err := p.Manifest(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{
+		"cloud.google.com/go/foo/apiv1": "https://cloud.google.com/foo/docs/go",
+		"cloud.google.com/go/baz":       "https://example.com/baz",
+		"cloud.google.com/go/bar/apiv1": "https://cloud.google.com/go/docs/reference/cloud.google.com/go/bar/latest/apiv1",
+	}
+	for name, url := range want {
+		if got := entries[name].DocsURL; got != url {
+			t.Errorf("%s docs URL = %q, want %q", name, got, url)
+		}
+	}
+	for _, name := range []string{"cloud.google.com/go/foo/apiv1", "cloud.google.com/go/baz"} {
+		if msg := fmt.Sprintf("using docs URL override %s for %s", want[name], name); !strings.Contains(buf.String(), msg) {
+			t.Errorf("Manifest() logged %q, want %q", buf.String(), msg)
+		}
+	}
+	if errs := p.ValidateEntries(entries); len(errs) > 0 {
+		t.Errorf("ValidateEntries() = %v, want no problems for overridden docs URLs", errs)
+	}
+
+	p.config.GoogleapisToImportPath["google/cloud/foo/v1"].DocsURLOverride = "http://cloud.google.com/foo/docs/go"
+	if _, err := p.Manifest(context.Background()); err == nil || !strings.Contains(err.Error(), "does not use https") {
+		t.Errorf("Manifest() = %v, want error for an http override", err)
+	}
+}
@@ -0,0 +1,514 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// runCommand runs a manifest maintenance command instead of the full
+// post-processor. args are the positional command line arguments, starting
+// with the command name.
+func (p *postProcessor) runCommand(ctx context.Context, args []string) error {
+	switch args[0] {
+	case "promote-ga":
+		fs := flag.NewFlagSet(args[0], flag.ExitOnError)
+		editDoc := fs.Bool("edit-doc", false, "Also remove the beta disclaimer from the doc.go of each library.")
+		fs.Parse(args[1:])
+		if fs.NArg() == 0 {
+			return fmt.Errorf("%s: no distributions provided", args[0])
+		}
+		_, err := p.PromoteToGA(ctx, fs.Args(), *editDoc)
+		return err
+	case "refresh-release-levels":
+		_, err := p.RefreshReleaseLevels(ctx)
+		return err
+	case "reconcile":
+		untracked, err := p.UntrackedPackages()
+		if err != nil {
+			return err
+		}
+		var r checkResult
+		for _, importPath := range untracked {
+			r.addf(untrackedPackageFailure, "%s is not in the config or the manifest", importPath)
+		}
+		return r.Err()
+	case "inventory":
+		if len(args) != 2 {
+			return fmt.Errorf("%s: want a single inventory file", args[0])
+		}
+		inventory, err := readInventoryFile(args[1])
+		if err != nil {
+			return err
+		}
+		entries, err := readManifestFile(p.manifestPath())
+		if err != nil {
+			return err
+		}
+		d := diffInventory(inventory, entries)
+		var r checkResult
+		for _, name := range d.MissingFromManifest {
+			r.addf(inventoryMismatchFailure, "%s is in the inventory but not the manifest", name)
+		}
+		for _, name := range d.MissingFromInventory {
+			r.addf(inventoryMismatchFailure, "%s is in the manifest but not the inventory", name)
+		}
+		return r.Err()
+	case "go-work":
+		if len(args) > 2 {
+			return fmt.Errorf("%s: want at most one go.work file", args[0])
+		}
+		workPath := filepath.Join(p.googleCloudDir, "go.work")
+		if len(args) == 2 {
+			workPath = args[1]
+		}
+		d, err := p.GoWorkDiff(ctx, workPath)
+		if err != nil {
+			return err
+		}
+		var r checkResult
+		for _, mod := range d.MissingFromGoWork {
+			r.addf(goWorkMismatchFailure, "%s is in the manifest but not used in %s", mod, workPath)
+		}
+		for _, mod := range d.MissingFromManifest {
+			r.addf(goWorkMismatchFailure, "%s is used in %s but not in the manifest", mod, workPath)
+		}
+		return r.Err()
+	case "sitemap":
+		if len(args) != 2 {
+			return fmt.Errorf("%s: want a single sitemap file", args[0])
+		}
+		sitemap, err := readSitemapFile(args[1])
+		if err != nil {
+			return err
+		}
+		entries, err := readManifestFile(p.manifestPath())
+		if err != nil {
+			return err
+		}
+		// Computing the entries finds the generated ones, including those
+		// whose library type was changed by a marker or an override.
+		if _, err := p.computeManifestEntries(ctx); err != nil {
+			return err
+		}
+		var r checkResult
+		for _, name := range p.missingFromSitemap(sitemap, entries) {
+			r.addf(missingDocsPageFailure, "%s: %s is not in the sitemap", name, entries[name].DocsURL)
+		}
+		return r.Err()
+	case "release-policy":
+		if len(args) != 2 {
+			return fmt.Errorf("%s: want a single policy file", args[0])
+		}
+		policy, err := readReleaseLevelPolicy(args[1])
+		if err != nil {
+			return err
+		}
+		entries, err := readManifestFile(p.manifestPath())
+		if err != nil {
+			return err
+		}
+		var r checkResult
+		for _, v := range betaPolicyViolations(entries, policy, time.Now()) {
+			r.addf(betaTooLongFailure, "%s: beta since %s, %d days over the %d-day limit", v.Distribution, v.FirstBetaDate, v.DaysOver, policy.MaxBetaDays)
+		}
+		return r.Err()
+	case "release-level-report":
+		entries, err := readManifestFile(p.manifestPath())
+		if err != nil {
+			return err
+		}
+		return writeReleaseLevelReport(os.Stdout, entries)
+	case "preview-entry":
+		if len(args) != 4 {
+			return fmt.Errorf("%s: want a rel-path, an import path and a service config", args[0])
+		}
+		entry, err := p.PreviewEntry(ctx, args[1], args[2], args[3])
+		if err != nil {
+			return err
+		}
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(entry)
+	case "explain":
+		if len(args) != 2 {
+			return fmt.Errorf("%s: want a single distribution", args[0])
+		}
+		x, err := p.Explain(ctx, args[1])
+		if err != nil {
+			return err
+		}
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(x)
+	case "detector-order":
+		if len(args) < 2 {
+			return fmt.Errorf("%s: no detectors provided", args[0])
+		}
+		var order []releaseLevelSource
+		for _, source := range args[1:] {
+			order = append(order, releaseLevelSource(source))
+		}
+		changes, err := p.DetectorOrderChanges(ctx, order)
+		if err != nil {
+			return err
+		}
+		return writeDetectorOrderChangesMarkdown(os.Stdout, changes)
+	case "release-level-reasons":
+		reasons, err := p.ReleaseLevelReasons(ctx)
+		if err != nil {
+			return err
+		}
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(reasons)
+	case "diff-markdown":
+		if len(args) != 2 {
+			return fmt.Errorf("%s: want a single old manifest file", args[0])
+		}
+		old, err := readManifestFile(args[1])
+		if err != nil {
+			return err
+		}
+		entries, err := readManifestFile(p.manifestPath())
+		if err != nil {
+			return err
+		}
+		return writeManifestDiffMarkdown(os.Stdout, old, entries)
+	case "changed-modules":
+		if len(args) != 2 {
+			return fmt.Errorf("%s: want a single old manifest file", args[0])
+		}
+		old, err := readManifestFile(args[1])
+		if err != nil {
+			return err
+		}
+		entries, err := readManifestFile(p.manifestPath())
+		if err != nil {
+			return err
+		}
+		mods, err := p.ChangedModules(diffManifests(old, entries))
+		if err != nil {
+			return err
+		}
+		for _, mod := range mods {
+			fmt.Println(mod)
+		}
+		return nil
+	case "promotions":
+		if len(args) != 2 {
+			return fmt.Errorf("%s: want a single manifest snapshot", args[0])
+		}
+		ps, err := p.PromotionsSince(ctx, args[1])
+		if err != nil {
+			return err
+		}
+		return writePromotionsMarkdown(os.Stdout, ps)
+	case "schema-diff":
+		if len(args) != 2 {
+			return fmt.Errorf("%s: want a single old manifest file", args[0])
+		}
+		old, err := readManifestSchema(args[1])
+		if err != nil {
+			return err
+		}
+		return writeSchemaDiff(os.Stdout, diffSchema(old))
+	case "stream-manifest":
+		if p.config.DryRun {
+			if err := p.StreamManifest(ctx, io.Discard); err != nil {
+				return err
+			}
+			p.logf("dry run: would write %s", p.manifestPath())
+			return nil
+		}
+		path := p.manifestPath()
+		if err := checkManifestOverwrite(path); err != nil {
+			return err
+		}
+		f, err := os.Create(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		if err := p.StreamManifest(ctx, f); err != nil {
+			return err
+		}
+		return f.Close()
+	case "module-counts":
+		if p.config.SkipDocsURL {
+			return fmt.Errorf("%s: modules are not resolved when skip-docs-url is set", args[0])
+		}
+		if _, err := p.computeManifestEntries(ctx); err != nil {
+			return err
+		}
+		counts, total := modulePackageCounts(p.modulePackages)
+		return writeModuleCounts(os.Stdout, counts, total)
+	case "manifest":
+		_, err := p.Manifest(ctx)
+		return err
+	case "print-config":
+		return p.PrintConfig(os.Stdout)
+	case "check-docs":
+		errs, err := p.DocParseErrors()
+		if err != nil {
+			return err
+		}
+		var r checkResult
+		for _, err := range errs {
+			r.addf(unparsableDocFailure, "%v", err)
+		}
+		return r.Err()
+	case "validate":
+		entries, err := readManifestFile(p.manifestPath())
+		if err != nil {
+			return err
+		}
+		var r checkResult
+		for _, err := range p.ValidateEntries(entries) {
+			r.addf(invalidEntryFailure, "%v", err)
+		}
+		return r.Err()
+	default:
+		return fmt.Errorf("unknown command %q", args[0])
+	}
+}
+
+// PromoteToGA sets the release level of each of the distributions in the
+// manifest to ga and rewrites the manifest. If editDoc is set, the beta
+// disclaimer is removed from the doc.go of each distribution and its entry is
+// recomputed instead, which requires the distribution to be a generated
+// library.
+func (p *postProcessor) PromoteToGA(ctx context.Context, distributions []string, editDoc bool) (map[string]ManifestEntry, error) {
+	manifestPath := p.manifestPath()
+	entries, err := readManifestFile(manifestPath)
+	if err != nil {
+		return nil, err
+	}
+	if entries == nil {
+		return nil, fmt.Errorf("no manifest found at %s", manifestPath)
+	}
+	for _, dist := range distributions {
+		entry, ok := entries[dist]
+		if !ok {
+			return nil, fmt.Errorf("no manifest entry for %q", dist)
+		}
+		if !editDoc {
+			entry.ReleaseLevel = "ga"
+			entries[dist] = entry
+			continue
+		}
+		if _, _, ok := p.confForImportPath(dist); !ok {
+			return nil, fmt.Errorf("%q is not a generated library, its doc.go cannot be edited", dist)
+		}
+		relPath, err := p.libraryDir(dist)
+		if err != nil {
+			return nil, err
+		}
+		indicator, err := p.betaIndicator()
+		if err != nil {
+			return nil, err
+		}
+		if err := removeBetaDisclaimer(filepath.Join(p.googleCloudDir, relPath, "doc.go"), indicator); err != nil {
+			return nil, err
+		}
+		recomputed, err := p.ManifestForImportPaths(ctx, []string{dist})
+		if err != nil {
+			return nil, err
+		}
+		entry = recomputed[dist]
+		if entry.ReleaseLevel != "ga" {
+			return nil, fmt.Errorf("%q is still %s after removing the beta disclaimer", dist, entry.ReleaseLevel)
+		}
+		entries[dist] = entry
+	}
+	if err := writeManifestFile(manifestPath, entries, p.config.manifestFormat()); err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+// RefreshReleaseLevels recomputes the release level of each generated entry
+// in the manifest with the release level detectors and rewrites the manifest,
+// leaving every other field and the manual entries untouched. A configured
+// release level pin is applied as in the manifest, and otherwise a release
+// level set in the overrides file is kept.
+func (p *postProcessor) RefreshReleaseLevels(ctx context.Context) (map[string]ManifestEntry, error) {
+	p.resetCaches()
+	manifestPath := p.manifestPath()
+	entries, err := readManifestFile(manifestPath)
+	if err != nil {
+		return nil, err
+	}
+	if entries == nil {
+		return nil, fmt.Errorf("no manifest found at %s", manifestPath)
+	}
+	overrides, err := p.readManifestOverrides()
+	if err != nil {
+		return nil, err
+	}
+	keys := map[string]string{} // Key is the distribution name, value the manifest key.
+	for key, e := range entries {
+		keys[e.DistributionName] = key
+	}
+	confs := map[string]*libraryInfo{}
+	for inputDir, conf := range p.config.GoogleapisToImportPath {
+		if _, ok := keys[normalizeImportPath(conf.ImportPath)]; ok {
+			confs[inputDir] = conf
+		}
+	}
+	confs, err = p.withRelPaths(confs)
+	if err != nil {
+		return nil, err
+	}
+	yamlPaths, err := p.serviceConfigPaths(confs)
+	if err != nil {
+		return nil, err
+	}
+	workers, err := p.config.maxWorkers()
+	if err != nil {
+		return nil, err
+	}
+	levels, err := p.releaseLevels(ctx, confs, yamlPaths, workers)
+	if err != nil {
+		return nil, err
+	}
+	var changed int
+	for inputDir, level := range levels {
+		name := normalizeImportPath(confs[inputDir].ImportPath)
+		if pin, ok := p.config.ReleaseLevelPins[name]; ok {
+			level = releaseLevelResult{pin, pinSource}
+		} else if override, ok := overrides[name]; ok && override.ReleaseLevel != "" {
+			continue
+		}
+		key := keys[name]
+		entry := entries[key]
+		if entry.ReleaseLevel != level.Level {
+			p.logf("release level of %s changed from %s to %s (%s)", name, entry.ReleaseLevel, level.Level, level.Source)
+			entry.ReleaseLevel = level.Level
+			entries[key] = entry
+			changed++
+		}
+	}
+	p.logf("refreshed the release levels of %d entries, %d changed", len(levels), changed)
+	if err := writeManifestFile(manifestPath, entries, p.config.manifestFormat()); err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+// PrintConfig writes the loaded config to w as YAML in the format read by
+// loadConfigFile, with the defaults of the manifest options filled in.
+func (p *postProcessor) PrintConfig(w io.Writer) error {
+	cf, err := p.config.configFile()
+	if err != nil {
+		return err
+	}
+	enc := yaml.NewEncoder(w)
+	enc.SetIndent(2)
+	if err := enc.Encode(cf); err != nil {
+		return err
+	}
+	return enc.Close()
+}
+
+// UntrackedPackages walks the repo for directories containing a doc.go and
+// returns the sorted import paths of those that are neither configured nor in
+// the manifest. The root package, internal packages, testdata and hidden
+// directories are skipped.
+func (p *postProcessor) UntrackedPackages() ([]string, error) {
+	entries, err := readManifestFile(p.manifestPath())
+	if err != nil {
+		return nil, err
+	}
+	tracked := map[string]bool{}
+	for name := range entries {
+		tracked[name] = true
+	}
+	for _, conf := range p.config.GoogleapisToImportPath {
+		tracked[conf.ImportPath] = true
+	}
+	for _, m := range p.config.ManualClientInfo {
+		tracked[m.DistributionName] = true
+	}
+
+	var untracked []string
+	err = filepath.WalkDir(p.googleCloudDir, func(dir string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.IsDir() || dir == p.googleCloudDir {
+			return nil
+		}
+		if name := d.Name(); name == "internal" || name == "testdata" || strings.HasPrefix(name, ".") || strings.HasPrefix(name, "_") {
+			return filepath.SkipDir
+		}
+		if _, err := os.Stat(filepath.Join(dir, "doc.go")); err != nil {
+			return nil
+		}
+		rel, err := filepath.Rel(p.googleCloudDir, dir)
+		if err != nil {
+			return err
+		}
+		if importPath := path.Join("cloud.google.com/go", filepath.ToSlash(rel)); !tracked[importPath] {
+			untracked = append(untracked, importPath)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(untracked)
+	return untracked, nil
+}
+
+// removeBetaDisclaimer removes the line containing the beta disclaimer phrase
+// indicator from the doc.go at path, along with the empty comment line that
+// separates it from the following paragraph.
+func removeBetaDisclaimer(path, indicator string) error {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	lines := strings.Split(string(b), "\n")
+	out := make([]string, 0, len(lines))
+	var removed bool
+	for i := 0; i < len(lines); i++ {
+		if !removed && strings.Contains(lines[i], indicator) {
+			removed = true
+			if len(out) > 0 && out[len(out)-1] == "//" && i+1 < len(lines) && lines[i+1] == "//" {
+				i++
+			}
+			continue
+		}
+		out = append(out, lines[i])
+	}
+	if !removed {
+		return nil
+	}
+	return os.WriteFile(path, []byte(strings.Join(out, "\n")), 0644)
+}
@@ -0,0 +1,163 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+)
+
+func TestPromoteToGA(t *testing.T) {
+	p := newTestManifestProcessor(t)
+	if _, err := p.Manifest(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	entries, err := p.PromoteToGA(context.Background(), []string{"cloud.google.com/go/bar/apiv1"}, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := entries["cloud.google.com/go/bar/apiv1"].ReleaseLevel; got != "ga" {
+		t.Errorf("ReleaseLevel = %q, want %q", got, "ga")
+	}
+	b, err := os.ReadFile(filepath.Join(p.googleCloudDir, "bar", "apiv1", "doc.go"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantDoc := `// Package bar is an auto-generated package for the
+// Bar API.
+//
+// # General documentation
+package bar
+`
+	if diff := cmp.Diff(wantDoc, string(b)); diff != "" {
+		t.Errorf("doc.go mismatch (-want +got):\n%s", diff)
+	}
+	written, err := readManifestFile(p.manifestPath())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(entries, written); diff != "" {
+		t.Errorf("written manifest mismatch (-want +got):\n%s", diff)
+	}
+
+	// Without editing doc.go, only the manifest changes.
+	entries, err = p.PromoteToGA(context.Background(), []string{"cloud.google.com/go/qux/apiv1beta"}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := entries["cloud.google.com/go/qux/apiv1beta"].ReleaseLevel; got != "ga" {
+		t.Errorf("ReleaseLevel = %q, want %q", got, "ga")
+	}
+
+	// A beta path suffix cannot be fixed by editing doc.go.
+	if _, err := p.PromoteToGA(context.Background(), []string{"cloud.google.com/go/qux/apiv1beta"}, true); err == nil {
+		t.Errorf("PromoteToGA() = nil error for beta import path, want error")
+	}
+	if _, err := p.PromoteToGA(context.Background(), []string{"cloud.google.com/go/unknown"}, false); err == nil {
+		t.Errorf("PromoteToGA() = nil error for unknown distribution, want error")
+	}
+}
+
+func TestRefreshReleaseLevels(t *testing.T) {
+	p := newTestManifestProcessor(t)
+	if _, err := p.Manifest(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	committed, err := readManifestFile(p.manifestPath())
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Hand edits to the committed manifest are kept, and a stale release
+	// level is refreshed.
+	foo := committed["cloud.google.com/go/foo/apiv1"]
+	foo.Description = "Edited by hand"
+	committed["cloud.google.com/go/foo/apiv1"] = foo
+	bar := committed["cloud.google.com/go/bar/apiv1"]
+	bar.ReleaseLevel = "ga"
+	committed["cloud.google.com/go/bar/apiv1"] = bar
+	if err := writeManifestFile(p.manifestPath(), committed, manifestFormat{}); err != nil {
+		t.Fatal(err)
+	}
+	writeTestFiles(t, p.googleCloudDir, map[string]string{"foo/apiv1/" + defaultDetection.StabilityFile: "alpha"})
+
+	entries, err := p.RefreshReleaseLevels(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantLevels := map[string]string{
+		"cloud.google.com/go/foo/apiv1":     "alpha",
+		"cloud.google.com/go/bar/apiv1":     "beta",
+		"cloud.google.com/go/qux/apiv1beta": "beta",
+		"cloud.google.com/go/baz":           "ga",
+	}
+	for name, want := range wantLevels {
+		if got := entries[name].ReleaseLevel; got != want {
+			t.Errorf("%s: ReleaseLevel = %q, want %q", name, got, want)
+		}
+	}
+	written, err := readManifestFile(p.manifestPath())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(entries, written); diff != "" {
+		t.Errorf("written manifest mismatch (-want +got):\n%s", diff)
+	}
+	ignoreLevels := cmpopts.IgnoreFields(ManifestEntry{}, "ReleaseLevel")
+	if diff := cmp.Diff(committed, written, ignoreLevels); diff != "" {
+		t.Errorf("fields other than release_level changed (-committed +written):\n%s", diff)
+	}
+
+	// A pin wins over the detected release level, like in the manifest.
+	p.config.ReleaseLevelPins = map[string]string{"cloud.google.com/go/foo/apiv1": "beta"}
+	entries, err = p.RefreshReleaseLevels(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := entries["cloud.google.com/go/foo/apiv1"].ReleaseLevel; got != "beta" {
+		t.Errorf("pinned ReleaseLevel = %q, want %q", got, "beta")
+	}
+}
+
+func TestUntrackedPackages(t *testing.T) {
+	p := newTestManifestProcessor(t)
+	if _, err := p.Manifest(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	writeTestFiles(t, p.googleCloudDir, map[string]string{
+		"doc.go":                     "package cloud\n",
+		"untracked/apiv1/doc.go":     "package untracked\n",
+		"foo/apiv1/foopb/doc.go":     "package foopb\n",
+		"foo/internal/helper/doc.go": "package helper\n",
+		"foo/testdata/doc.go":        "package testdata\n",
+		"foo/apiv1/gen.go":           "package foo\n",
+	})
+	got, err := p.UntrackedPackages()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		"cloud.google.com/go/foo/apiv1/foopb",
+		"cloud.google.com/go/untracked/apiv1",
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("UntrackedPackages() mismatch (-want +got):\n%s", diff)
+	}
+}
@@ -0,0 +1,372 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+)
+
+// manifestDiff describes how a manifest changed between two generations. Each
+// field holds sorted distribution names.
+type manifestDiff struct {
+	// Added are entries that only exist in the new manifest.
+	Added []string
+	// Removed are entries that only exist in the old manifest.
+	Removed []string
+	// Changed are entries that exist in both manifests with different values.
+	Changed []string
+	// ChangedFields maps the JSON name of each field that differs in a
+	// changed entry to the entries it differs in.
+	ChangedFields map[string][]string
+}
+
+// diffManifests compares the old and new manifest entries.
+func diffManifests(old, new map[string]ManifestEntry) *manifestDiff {
+	d := &manifestDiff{}
+	fields := manifestEntryFields()
+	for k, ne := range new {
+		oe, ok := old[k]
+		if !ok {
+			d.Added = append(d.Added, k)
+			continue
+		}
+		if reflect.DeepEqual(oe, ne) {
+			continue
+		}
+		d.Changed = append(d.Changed, k)
+		ov, nv := reflect.ValueOf(oe), reflect.ValueOf(ne)
+		for i, field := range fields {
+			if field != "" && !reflect.DeepEqual(ov.Field(i).Interface(), nv.Field(i).Interface()) {
+				if d.ChangedFields == nil {
+					d.ChangedFields = map[string][]string{}
+				}
+				d.ChangedFields[field] = append(d.ChangedFields[field], k)
+			}
+		}
+	}
+	for k := range old {
+		if _, ok := new[k]; !ok {
+			d.Removed = append(d.Removed, k)
+		}
+	}
+	sort.Strings(d.Added)
+	sort.Strings(d.Removed)
+	sort.Strings(d.Changed)
+	for _, names := range d.ChangedFields {
+		sort.Strings(names)
+	}
+	return d
+}
+
+// DiffManifests compares the manifest files at oldPath and newPath, such as
+// the manifests of two branches, independently of any generation run. Both
+// files must exist and may use either JSON field naming.
+func DiffManifests(oldPath, newPath string) (*manifestDiff, error) {
+	var manifests [2]map[string]ManifestEntry
+	for i, path := range []string{oldPath, newPath} {
+		b, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		if manifests[i], err = decodeManifest(b); err != nil {
+			return nil, fmt.Errorf("unable to decode manifest %s: %v", path, err)
+		}
+	}
+	return diffManifests(manifests[0], manifests[1]), nil
+}
+
+// summary returns a one-line summary of the diff, such as
+// "manifest: 3 added, 1 removed, 4 changed, 2 release-level changes". It
+// returns "" if the manifests are the same.
+func (d *manifestDiff) summary() string {
+	if len(d.Added) == 0 && len(d.Removed) == 0 && len(d.Changed) == 0 {
+		return ""
+	}
+	return fmt.Sprintf("manifest: %d added, %d removed, %d changed, %d release-level changes",
+		len(d.Added), len(d.Removed), len(d.Changed), len(d.ChangedFields["release_level"]))
+}
+
+// releaseLevelChange is a record of the release level history file.
+type releaseLevelChange struct {
+	Date         string `json:"date"`
+	Distribution string `json:"distribution"`
+	Old          string `json:"old"`
+	New          string `json:"new"`
+}
+
+// releaseLevelChanges returns a record dated date for each entry whose
+// release level changed in d, in the order of their manifest keys.
+func releaseLevelChanges(d *manifestDiff, old, new map[string]ManifestEntry, date time.Time) []releaseLevelChange {
+	var changes []releaseLevelChange
+	for _, k := range d.ChangedFields["release_level"] {
+		changes = append(changes, releaseLevelChange{
+			Date:         date.Format(graduationDateLayout),
+			Distribution: new[k].DistributionName,
+			Old:          old[k].ReleaseLevel,
+			New:          new[k].ReleaseLevel,
+		})
+	}
+	return changes
+}
+
+// appendReleaseLevelHistory returns the contents of the history file at path
+// with a line appended for each of the changes. The file need not exist.
+func appendReleaseLevelHistory(path string, changes []releaseLevelChange) ([]byte, error) {
+	b, err := os.ReadFile(path)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+	if len(b) > 0 && b[len(b)-1] != '\n' {
+		b = append(b, '\n')
+	}
+	for _, c := range changes {
+		line, err := json.Marshal(c)
+		if err != nil {
+			return nil, err
+		}
+		b = append(append(b, line...), '\n')
+	}
+	return b, nil
+}
+
+// ChangedModules returns the sorted, distinct paths of the modules that own
+// the entries added, removed or changed in d, such as the modules to release
+// after a manifest change. Entries that are not generated and whose module
+// can not be resolved, such as removed libraries whose directory is gone,
+// are left out with a warning.
+func (p *postProcessor) ChangedModules(d *manifestDiff) ([]string, error) {
+	var names []string
+	names = append(names, d.Added...)
+	names = append(names, d.Removed...)
+	names = append(names, d.Changed...)
+	sort.Strings(names)
+	seen := map[string]bool{}
+	var mods []string
+	for _, name := range names {
+		_, _, generated := p.confForImportPath(name)
+		relPath, err := p.libraryDir(name)
+		var mod string
+		if err == nil {
+			mod, err = p.moduleForDir(filepath.Join(p.googleCloudDir, relPath))
+		}
+		if err != nil {
+			if generated {
+				return nil, fmt.Errorf("unable to resolve module of %s: %v", name, err)
+			}
+			p.warnf("unable to resolve module of %s, leaving it out of the changed modules: %v", name, err)
+			continue
+		}
+		if !seen[mod] {
+			seen[mod] = true
+			mods = append(mods, mod)
+		}
+	}
+	sort.Strings(mods)
+	return mods, nil
+}
+
+// fieldSummary returns the number of entries in which each field changed, in
+// the order of the fields of ManifestEntry, such as
+// "description: 3, docs_url: 1". It returns "" if no field changed.
+func (d *manifestDiff) fieldSummary() string {
+	var counts []string
+	for _, field := range manifestEntryFields() {
+		if n := len(d.ChangedFields[field]); field != "" && n > 0 {
+			counts = append(counts, fmt.Sprintf("%s: %d", field, n))
+		}
+	}
+	return strings.Join(counts, ", ")
+}
+
+// explicitLevelSources are the release level sources that only change when a
+// stability file, an import path or the config is edited.
+var explicitLevelSources = map[releaseLevelSource]bool{
+	stabilityFileSource: true,
+	pathSuffixSource:    true,
+	manualSource:        true,
+	overrideSource:      true,
+	pinSource:           true,
+}
+
+// suspiciousLevelFlips returns the sorted keys of the entries whose release
+// level differs between the old and new manifest entries even though the new
+// level was not determined by an explicit source. Such a flip is usually
+// caused by drift in the generated files, such as a reworded beta
+// disclaimer, rather than an intentional change. Entries without a recorded
+// release level source are not reported.
+func (p *postProcessor) suspiciousLevelFlips(old, new map[string]ManifestEntry) []string {
+	var flips []string
+	for key, ne := range new {
+		oe, ok := old[key]
+		if !ok || oe.ReleaseLevel == ne.ReleaseLevel {
+			continue
+		}
+		source, ok := p.ReleaseLevelSource(ne.DistributionName)
+		if !ok || explicitLevelSources[source] {
+			continue
+		}
+		flips = append(flips, key)
+	}
+	sort.Strings(flips)
+	return flips
+}
+
+// stabilityRank orders the release levels that a library can be promoted
+// between, least stable first.
+var stabilityRank = map[string]int{
+	"alpha": 0,
+	"beta":  1,
+	"ga":    2,
+}
+
+// promotion is a distribution that moved to a more stable release level.
+type promotion struct {
+	Distribution string
+	Old, New     string
+}
+
+// promotions returns the entries whose release level is more stable in the
+// new entries than in the old ones, sorted by distribution name. Entries are
+// matched by distribution name, so the two sets may be keyed differently.
+// Demotions and changes to or from a level without a stability rank, such as
+// deprecated, are not promotions.
+func promotions(old, new map[string]ManifestEntry) []promotion {
+	oldLevels := make(map[string]string, len(old))
+	for _, e := range old {
+		oldLevels[e.DistributionName] = e.ReleaseLevel
+	}
+	var ps []promotion
+	for _, e := range new {
+		oldLevel, ok := oldLevels[e.DistributionName]
+		if !ok {
+			continue
+		}
+		oldRank, oldOK := stabilityRank[oldLevel]
+		newRank, newOK := stabilityRank[e.ReleaseLevel]
+		if oldOK && newOK && newRank > oldRank {
+			ps = append(ps, promotion{e.DistributionName, oldLevel, e.ReleaseLevel})
+		}
+	}
+	sort.Slice(ps, func(i, j int) bool { return ps[i].Distribution < ps[j].Distribution })
+	return ps
+}
+
+// PromotionsSince computes the current manifest entries, without writing the
+// manifest, and returns the promotions since the manifest snapshot at
+// snapshotPath.
+func (p *postProcessor) PromotionsSince(ctx context.Context, snapshotPath string) ([]promotion, error) {
+	old, err := readManifestFile(snapshotPath)
+	if err != nil {
+		return nil, err
+	}
+	if old == nil {
+		return nil, fmt.Errorf("no manifest snapshot found at %s", snapshotPath)
+	}
+	entries, err := p.computeManifestEntries(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return promotions(old, entries), nil
+}
+
+// writePromotionsMarkdown writes the promotions to w as a GitHub-flavored
+// markdown table.
+func writePromotionsMarkdown(w io.Writer, ps []promotion) error {
+	if len(ps) == 0 {
+		_, err := fmt.Fprintln(w, "No promotions.")
+		return err
+	}
+	var b strings.Builder
+	b.WriteString("| distribution | old | new |\n")
+	b.WriteString("| --- | --- | --- |\n")
+	for _, pr := range ps {
+		fmt.Fprintf(&b, "| %s | %s | %s |\n", markdownCell(pr.Distribution), markdownCell(pr.Old), markdownCell(pr.New))
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// writeManifestDiffMarkdown writes the differences between the old and new
+// manifest entries to w as a GitHub-flavored markdown table, ordered by
+// distribution. A changed entry has a row for each field that changed, and an
+// added or removed entry has a single row.
+func writeManifestDiffMarkdown(w io.Writer, old, new map[string]ManifestEntry) error {
+	d := diffManifests(old, new)
+	if len(d.Added)+len(d.Removed)+len(d.Changed) == 0 {
+		_, err := fmt.Fprintln(w, "No manifest changes.")
+		return err
+	}
+	type row struct{ distribution, field, old, new string }
+	var rows []row
+	for _, name := range d.Added {
+		rows = append(rows, row{name, "_added_", "", ""})
+	}
+	for _, name := range d.Removed {
+		rows = append(rows, row{name, "_removed_", "", ""})
+	}
+	fields := manifestEntryFields()
+	for _, name := range d.Changed {
+		ov, nv := reflect.ValueOf(old[name]), reflect.ValueOf(new[name])
+		for i, field := range fields {
+			if field == "" {
+				continue
+			}
+			o, n := fmt.Sprint(ov.Field(i).Interface()), fmt.Sprint(nv.Field(i).Interface())
+			if o != n {
+				rows = append(rows, row{name, field, o, n})
+			}
+		}
+	}
+	// Rows of the same distribution keep the field order.
+	sort.SliceStable(rows, func(i, j int) bool { return rows[i].distribution < rows[j].distribution })
+
+	var b strings.Builder
+	b.WriteString("| distribution | field | old | new |\n")
+	b.WriteString("| --- | --- | --- | --- |\n")
+	for _, r := range rows {
+		fmt.Fprintf(&b, "| %s | %s | %s | %s |\n", markdownCell(r.distribution), r.field, markdownCell(r.old), markdownCell(r.new))
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// markdownCell escapes s for use in a markdown table cell.
+func markdownCell(s string) string {
+	s = strings.ReplaceAll(s, "|", `\|`)
+	return strings.Join(strings.Fields(s), " ")
+}
+
+// readManifestFile reads the manifest at path. It returns nil entries if the
+// file does not exist.
+func readManifestFile(path string) (map[string]ManifestEntry, error) {
+	b, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	return decodeManifest(b)
+}
@@ -0,0 +1,318 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestDiffManifests(t *testing.T) {
+	old := map[string]ManifestEntry{
+		"a": {DistributionName: "a", ReleaseLevel: "beta"},
+		"b": {DistributionName: "b", ReleaseLevel: "ga"},
+		"c": {DistributionName: "c", ReleaseLevel: "ga"},
+	}
+	new := map[string]ManifestEntry{
+		"a": {DistributionName: "a", ReleaseLevel: "ga"},
+		"b": {DistributionName: "b", ReleaseLevel: "ga"},
+		"d": {DistributionName: "d", ReleaseLevel: "ga"},
+	}
+	want := &manifestDiff{
+		Added:         []string{"d"},
+		Removed:       []string{"c"},
+		Changed:       []string{"a"},
+		ChangedFields: map[string][]string{"release_level": {"a"}},
+	}
+	d := diffManifests(old, new)
+	if diff := cmp.Diff(want, d); diff != "" {
+		t.Errorf("diffManifests() mismatch (-want +got):\n%s", diff)
+	}
+	if got, want := d.summary(), "manifest: 1 added, 1 removed, 1 changed, 1 release-level changes"; got != want {
+		t.Errorf("summary() = %q, want %q", got, want)
+	}
+}
+
+func TestDiffManifestFiles(t *testing.T) {
+	dir := t.TempDir()
+	writeTestFiles(t, dir, map[string]string{
+		"old.json": `{
+  "a": {"distribution_name": "a", "description": "A", "release_level": "beta"},
+  "b": {"distribution_name": "b", "description": "B", "release_level": "ga"},
+  "c": {"distribution_name": "c", "description": "C", "release_level": "ga"}
+}`,
+		"new.json": `{
+  "a": {"distributionName": "a", "description": "A API", "releaseLevel": "ga"},
+  "b": {"distributionName": "b", "description": "B", "releaseLevel": "ga"},
+  "d": {"distributionName": "d", "description": "D", "releaseLevel": "ga"}
+}`,
+	})
+	d, err := DiffManifests(filepath.Join(dir, "old.json"), filepath.Join(dir, "new.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := &manifestDiff{
+		Added:   []string{"d"},
+		Removed: []string{"c"},
+		Changed: []string{"a"},
+		ChangedFields: map[string][]string{
+			"description":   {"a"},
+			"release_level": {"a"},
+		},
+	}
+	if diff := cmp.Diff(want, d); diff != "" {
+		t.Errorf("DiffManifests() mismatch (-want +got):\n%s", diff)
+	}
+
+	if _, err := DiffManifests(filepath.Join(dir, "old.json"), filepath.Join(dir, "missing.json")); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("DiffManifests() = %v for a missing manifest, want fs.ErrNotExist", err)
+	}
+}
+
+func TestDiffManifestsChangedFields(t *testing.T) {
+	old := map[string]ManifestEntry{
+		"a": {DistributionName: "a", Description: "A", DocsURL: "https://example.com/a", LibraryType: gapicAutoLibraryType},
+		"b": {DistributionName: "b", Description: "B", DocsURL: "https://example.com/b", LibraryType: gapicAutoLibraryType},
+		"c": {DistributionName: "c", Description: "C", DocsURL: "https://example.com/c", LibraryType: gapicAutoLibraryType, Labels: []string{"x"}},
+		"d": {DistributionName: "d", Description: "D"},
+	}
+	new := map[string]ManifestEntry{
+		"a": {DistributionName: "a", Description: "A API", DocsURL: "https://example.com/a/latest", LibraryType: gapicAutoLibraryType},
+		"b": {DistributionName: "b", Description: "B API", DocsURL: "https://example.com/b", LibraryType: gapicManualLibraryType},
+		"c": {DistributionName: "c", Description: "C", DocsURL: "https://example.com/c/latest", LibraryType: gapicAutoLibraryType, Labels: []string{"x", "y"}},
+		"d": {DistributionName: "d", Description: "D"},
+	}
+	d := diffManifests(old, new)
+	want := map[string][]string{
+		"description":  {"a", "b"},
+		"docs_url":     {"a", "c"},
+		"library_type": {"b"},
+		"labels":       {"c"},
+	}
+	if diff := cmp.Diff(want, d.ChangedFields); diff != "" {
+		t.Errorf("ChangedFields mismatch (-want +got):\n%s", diff)
+	}
+	if got, want := d.fieldSummary(), "description: 2, docs_url: 2, library_type: 1, labels: 1"; got != want {
+		t.Errorf("fieldSummary() = %q, want %q", got, want)
+	}
+	if got := diffManifests(old, old).fieldSummary(); got != "" {
+		t.Errorf("fieldSummary() = %q for identical manifests, want empty", got)
+	}
+}
+
+func TestManifestDiffSummary(t *testing.T) {
+	p := newTestManifestProcessor(t)
+	if _, err := p.Manifest(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	delete(p.config.GoogleapisToImportPath, "google/cloud/qux/v1beta")
+	writeTestFiles(t, p.googleCloudDir, map[string]string{"bar/apiv1/doc.go": testDocGA})
+
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	defer log.SetOutput(os.Stderr)
+	if _, err := p.Manifest(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if want := "manifest: 0 added, 1 removed, 1 changed, 1 release-level changes"; !strings.Contains(buf.String(), want) {
+		t.Errorf("Manifest() logged:\n%s\nwant %q", buf.String(), want)
+	}
+
+	buf.Reset()
+	if _, err := p.Manifest(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(buf.String(), "manifest: ") {
+		t.Errorf("Manifest() logged a summary for an unchanged manifest:\n%s", buf.String())
+	}
+
+	buf.Reset()
+	p.quiet = true
+	delete(p.config.GoogleapisToImportPath, "google/cloud/foo/v1")
+	if _, err := p.Manifest(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(buf.String(), "manifest: ") {
+		t.Errorf("Manifest() logged a summary while quiet:\n%s", buf.String())
+	}
+}
+
+func TestChangedModules(t *testing.T) {
+	p := newTestManifestProcessor(t)
+	d := &manifestDiff{
+		Added:   []string{"cloud.google.com/go/foo/apiv1"},
+		Removed: []string{"cloud.google.com/go/gone"},
+		Changed: []string{"cloud.google.com/go/bar/apiv1", "cloud.google.com/go/baz"},
+	}
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	defer log.SetOutput(os.Stderr)
+	got, err := p.ChangedModules(d)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"cloud.google.com/go/bar", "cloud.google.com/go/baz", "cloud.google.com/go/foo"}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("ChangedModules() mismatch (-want +got):\n%s", diff)
+	}
+	if !strings.Contains(buf.String(), "unable to resolve module of cloud.google.com/go/gone") {
+		t.Errorf("ChangedModules() did not warn about the removed entry, got:\n%s", buf.String())
+	}
+
+	// A generated entry must resolve.
+	if err := os.RemoveAll(filepath.Join(p.googleCloudDir, "qux")); err != nil {
+		t.Fatal(err)
+	}
+	d.Changed = append(d.Changed, "cloud.google.com/go/qux/apiv1beta")
+	if _, err := p.ChangedModules(d); err == nil {
+		t.Errorf("ChangedModules() = nil error for an unresolvable generated entry, want error")
+	}
+}
+
+func TestManifestRemovedEntries(t *testing.T) {
+	p := newTestManifestProcessor(t)
+	if _, err := p.Manifest(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	delete(p.config.GoogleapisToImportPath, "google/cloud/qux/v1beta")
+
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	defer log.SetOutput(os.Stderr)
+	if _, err := p.Manifest(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(buf.String(), "entry cloud.google.com/go/qux/apiv1beta is no longer produced") {
+		t.Errorf("Manifest() did not report the removed entry, got:\n%s", buf.String())
+	}
+	if strings.Contains(buf.String(), "cloud.google.com/go/foo/apiv1 is no longer produced") {
+		t.Errorf("Manifest() reported a retained entry as removed, got:\n%s", buf.String())
+	}
+}
+
+func TestManifestSuspiciousLevelFlips(t *testing.T) {
+	p := newTestManifestProcessor(t)
+	if _, err := p.Manifest(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	writeTestFiles(t, p.googleCloudDir, map[string]string{
+		// The beta disclaimer disappears without any explicit change.
+		"bar/apiv1/doc.go": testDocGA,
+		// An explicit stability file demotes foo.
+		"foo/apiv1/" + defaultDetection.StabilityFile: "beta",
+	})
+
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	defer log.SetOutput(os.Stderr)
+	if _, err := p.Manifest(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if want := "suspicious auto-flip of cloud.google.com/go/bar/apiv1 from beta to ga: its level comes from inferred-ga"; !strings.Contains(buf.String(), want) {
+		t.Errorf("Manifest() logged:\n%s\nwant %q", buf.String(), want)
+	}
+	if strings.Contains(buf.String(), "suspicious auto-flip of cloud.google.com/go/foo/apiv1") {
+		t.Errorf("Manifest() reported the explicit change of foo as suspicious, got:\n%s", buf.String())
+	}
+}
+
+func TestPromotionsSince(t *testing.T) {
+	p := newTestManifestProcessor(t)
+	snapshotPath := filepath.Join(t.TempDir(), "old-manifest.json")
+	writeTestFiles(t, filepath.Dir(snapshotPath), map[string]string{
+		filepath.Base(snapshotPath): `{
+  "cloud.google.com/go/foo/apiv1": {"distribution_name": "cloud.google.com/go/foo/apiv1", "release_level": "alpha"},
+  "cloud.google.com/go/bar/apiv1": {"distribution_name": "cloud.google.com/go/bar/apiv1", "release_level": "alpha"},
+  "cloud.google.com/go/qux/apiv1beta": {"distribution_name": "cloud.google.com/go/qux/apiv1beta", "release_level": "ga"},
+  "cloud.google.com/go/baz": {"distribution_name": "cloud.google.com/go/baz", "release_level": "beta"},
+  "cloud.google.com/go/old/apiv1": {"distribution_name": "cloud.google.com/go/old/apiv1", "release_level": "alpha"}
+}`,
+	})
+	got, err := p.PromotionsSince(context.Background(), snapshotPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// qux was demoted from ga to beta and old was removed, so neither is a
+	// promotion.
+	want := []promotion{
+		{Distribution: "cloud.google.com/go/bar/apiv1", Old: "alpha", New: "beta"},
+		{Distribution: "cloud.google.com/go/baz", Old: "beta", New: "ga"},
+		{Distribution: "cloud.google.com/go/foo/apiv1", Old: "alpha", New: "ga"},
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("PromotionsSince() mismatch (-want +got):\n%s", diff)
+	}
+	if _, err := os.Stat(p.manifestPath()); !os.IsNotExist(err) {
+		t.Errorf("PromotionsSince() wrote the manifest, want it untouched")
+	}
+
+	var buf bytes.Buffer
+	if err := writePromotionsMarkdown(&buf, got); err != nil {
+		t.Fatal(err)
+	}
+	wantMarkdown := `| distribution | old | new |
+| --- | --- | --- |
+| cloud.google.com/go/bar/apiv1 | alpha | beta |
+| cloud.google.com/go/baz | beta | ga |
+| cloud.google.com/go/foo/apiv1 | alpha | ga |
+`
+	if diff := cmp.Diff(wantMarkdown, buf.String()); diff != "" {
+		t.Errorf("writePromotionsMarkdown() mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestWriteManifestDiffMarkdown(t *testing.T) {
+	old := map[string]ManifestEntry{
+		"a": {DistributionName: "a", Description: "A API", ReleaseLevel: "beta", DocsURL: "https://a/beta"},
+		"b": {DistributionName: "b", ReleaseLevel: "ga"},
+		"c": {DistributionName: "c", ReleaseLevel: "ga"},
+	}
+	new := map[string]ManifestEntry{
+		"a": {DistributionName: "a", Description: "A | B API", ReleaseLevel: "ga", DocsURL: "https://a/beta"},
+		"b": {DistributionName: "b", ReleaseLevel: "ga"},
+		"d": {DistributionName: "d", ReleaseLevel: "ga"},
+	}
+	var buf bytes.Buffer
+	if err := writeManifestDiffMarkdown(&buf, old, new); err != nil {
+		t.Fatal(err)
+	}
+	want := `| distribution | field | old | new |
+| --- | --- | --- | --- |
+| a | description | A API | A \| B API |
+| a | release_level | beta | ga |
+| c | _removed_ |  |  |
+| d | _added_ |  |  |
+`
+	if diff := cmp.Diff(want, buf.String()); diff != "" {
+		t.Errorf("writeManifestDiffMarkdown() mismatch (-want +got):\n%s", diff)
+	}
+
+	buf.Reset()
+	if err := writeManifestDiffMarkdown(&buf, old, old); err != nil {
+		t.Fatal(err)
+	}
+	if got := buf.String(); got != "No manifest changes.\n" {
+		t.Errorf("writeManifestDiffMarkdown() of identical manifests = %q", got)
+	}
+}
@@ -0,0 +1,261 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+	"unicode"
+
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	// snakeCaseNaming names the fields of manifest entries in snake_case, as
+	// in their struct tags.
+	snakeCaseNaming = "snake_case"
+	// camelCaseNaming names the fields of manifest entries in camelCase.
+	camelCaseNaming = "camelCase"
+)
+
+// manifestFormat is how a manifest file is encoded.
+type manifestFormat struct {
+	// Compact writes the manifest without indentation.
+	Compact bool
+	// Naming is the naming of the entry fields, snakeCaseNaming if empty.
+	Naming string
+	// NullUnset writes the unset fields of the entries as null rather than
+	// as an empty value or not at all.
+	NullUnset bool
+	// YAML writes the manifest as YAML with the same fields rather than as
+	// JSON. Compact does not apply to it.
+	YAML bool
+}
+
+// formatEntries returns the entries as the value to marshal in format.
+func formatEntries(entries map[string]ManifestEntry, format manifestFormat) interface{} {
+	if !format.NullUnset {
+		return entries
+	}
+	nullable := make(map[string]nullUnsetEntry, len(entries))
+	for key, e := range entries {
+		nullable[key] = nullUnsetEntry(e)
+	}
+	return nullable
+}
+
+// marshalEntry marshals the single entry e as compact JSON with its fields
+// named and its unset fields written as in format.
+func marshalEntry(e ManifestEntry, format manifestFormat) ([]byte, error) {
+	var v interface{} = e
+	if format.NullUnset {
+		v = nullUnsetEntry(e)
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	if format.Naming == camelCaseNaming {
+		return renameObjectFields(b, snakeToCamel)
+	}
+	return b, nil
+}
+
+// nullUnsetEntry is a manifest entry that marshals each of its unset fields,
+// other than its distribution name, as null, for consumers that tell an
+// absent value from an empty one.
+type nullUnsetEntry ManifestEntry
+
+// MarshalJSON marshals the fields of e in the order of ManifestEntry.
+func (e nullUnsetEntry) MarshalJSON() ([]byte, error) {
+	v := reflect.ValueOf(e)
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	for i, field := range manifestEntryFields() {
+		if field == "" {
+			continue
+		}
+		value := []byte("null")
+		if f := v.Field(i); !f.IsZero() || field == "distribution_name" {
+			b, err := json.Marshal(f.Interface())
+			if err != nil {
+				return nil, err
+			}
+			value = b
+		}
+		name, err := json.Marshal(field)
+		if err != nil {
+			return nil, err
+		}
+		if buf.Len() > 1 {
+			buf.WriteByte(',')
+		}
+		buf.Write(name)
+		buf.WriteByte(':')
+		buf.Write(value)
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
+
+// snakeToCamel converts a snake_case field name to camelCase.
+func snakeToCamel(s string) string {
+	parts := strings.Split(s, "_")
+	for i := 1; i < len(parts); i++ {
+		if parts[i] != "" {
+			parts[i] = strings.ToUpper(parts[i][:1]) + parts[i][1:]
+		}
+	}
+	return strings.Join(parts, "")
+}
+
+// camelToSnake converts a camelCase field name to snake_case. A snake_case
+// name is returned unchanged.
+func camelToSnake(s string) string {
+	var b strings.Builder
+	for i, r := range s {
+		if unicode.IsUpper(r) {
+			if i > 0 {
+				b.WriteByte('_')
+			}
+			r = unicode.ToLower(r)
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// renameEntryFields renames the fields of each entry of the JSON manifest b
+// with rename, keeping the order of the fields. The manifest is returned in
+// compact form.
+func renameEntryFields(b []byte, rename func(string) string) ([]byte, error) {
+	var raw map[string]json.RawMessage
+	if err := json.Unmarshal(b, &raw); err != nil {
+		return nil, err
+	}
+	for key, entry := range raw {
+		renamed, err := renameObjectFields(entry, rename)
+		if err != nil {
+			return nil, fmt.Errorf("entry %s: %v", key, err)
+		}
+		raw[key] = renamed
+	}
+	return json.Marshal(raw)
+}
+
+// renameObjectFields renames the fields of the JSON object b with rename,
+// keeping their order and values.
+func renameObjectFields(b []byte, rename func(string) string) ([]byte, error) {
+	dec := json.NewDecoder(bytes.NewReader(b))
+	if t, err := dec.Token(); err != nil {
+		return nil, err
+	} else if t != json.Delim('{') {
+		return nil, fmt.Errorf("not an object")
+	}
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	for dec.More() {
+		t, err := dec.Token()
+		if err != nil {
+			return nil, err
+		}
+		var value json.RawMessage
+		if err := dec.Decode(&value); err != nil {
+			return nil, err
+		}
+		name, err := json.Marshal(rename(t.(string)))
+		if err != nil {
+			return nil, err
+		}
+		if buf.Len() > 1 {
+			buf.WriteByte(',')
+		}
+		buf.Write(name)
+		buf.WriteByte(':')
+		buf.Write(value)
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
+
+// decodeManifest decodes the JSON or YAML manifest b, whose entry fields may
+// be named in snake_case or camelCase.
+func decodeManifest(b []byte) (map[string]ManifestEntry, error) {
+	b, err := manifestJSON(b)
+	if err != nil {
+		return nil, err
+	}
+	b, err = renameEntryFields(b, camelToSnake)
+	if err != nil {
+		return nil, err
+	}
+	var entries map[string]ManifestEntry
+	if err := json.Unmarshal(b, &entries); err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+// manifestJSON returns the JSON or YAML manifest b as JSON, keeping its field
+// names.
+func manifestJSON(b []byte) ([]byte, error) {
+	if t := bytes.TrimSpace(b); len(t) > 0 && t[0] != '{' {
+		return yamlToJSON(b)
+	}
+	return b, nil
+}
+
+// jsonToYAML converts the JSON manifest b to YAML, keeping its field names.
+func jsonToYAML(b []byte) ([]byte, error) {
+	var n yaml.Node
+	if err := yaml.Unmarshal(b, &n); err != nil {
+		return nil, err
+	}
+	// Decoded JSON is in flow style with quoted strings, which is left to the
+	// encoder instead.
+	var plain func(*yaml.Node)
+	plain = func(n *yaml.Node) {
+		n.Style = 0
+		for _, c := range n.Content {
+			plain(c)
+		}
+	}
+	plain(&n)
+	var buf bytes.Buffer
+	enc := yaml.NewEncoder(&buf)
+	enc.SetIndent(2)
+	if err := enc.Encode(&n); err != nil {
+		return nil, err
+	}
+	if err := enc.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// yamlToJSON converts the YAML manifest b to JSON, keeping its field names.
+func yamlToJSON(b []byte) ([]byte, error) {
+	var v map[string]interface{}
+	if err := yaml.Unmarshal(b, &v); err != nil {
+		return nil, err
+	}
+	if v == nil {
+		return nil, fmt.Errorf("not a manifest")
+	}
+	return json.Marshal(v)
+}
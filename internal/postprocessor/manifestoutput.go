@@ -0,0 +1,542 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"go/format"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// manualModuleBucket is the key under which ManifestByModule groups manual
+// entries whose module can not be resolved.
+const manualModuleBucket = "manual"
+
+// writeManifest writes the entries to w as JSON, or YAML, in the given format.
+func writeManifest(w io.Writer, entries map[string]ManifestEntry, format manifestFormat) error {
+	if format.Naming == camelCaseNaming || format.YAML {
+		b, err := json.Marshal(formatEntries(entries, format))
+		if err != nil {
+			return err
+		}
+		if format.Naming == camelCaseNaming {
+			if b, err = renameEntryFields(b, snakeToCamel); err != nil {
+				return err
+			}
+		}
+		if format.YAML {
+			if b, err = jsonToYAML(b); err != nil {
+				return err
+			}
+			_, err = w.Write(b)
+			return err
+		}
+		var buf bytes.Buffer
+		if format.Compact {
+			buf.Write(b)
+		} else if err := json.Indent(&buf, b, "", "  "); err != nil {
+			return err
+		}
+		buf.WriteByte('\n')
+		_, err = buf.WriteTo(w)
+		return err
+	}
+	enc := json.NewEncoder(w)
+	if !format.Compact {
+		enc.SetIndent("", "  ")
+	}
+	return enc.Encode(formatEntries(entries, format))
+}
+
+// writeManifestFile writes the entries as JSON to the file at path. It refuses
+// to overwrite a file that is not a manifest.
+func writeManifestFile(path string, entries map[string]ManifestEntry, format manifestFormat) error {
+	if err := checkManifestOverwrite(path); err != nil {
+		return err
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if err := writeManifest(f, entries, format); err != nil {
+		return err
+	}
+	return f.Close()
+}
+
+// writeLibrariesManifest writes the sorted entries to w as an object with an
+// array of entries rather than keyed by distribution name, with their fields
+// named and unset fields written as in format. The JSON is indented unless
+// format is compact.
+func writeLibrariesManifest(w io.Writer, sorted []ManifestEntry, format manifestFormat) error {
+	libs := make([]json.RawMessage, len(sorted))
+	for i, e := range sorted {
+		b, err := marshalEntry(e, format)
+		if err != nil {
+			return err
+		}
+		libs[i] = b
+	}
+	enc := json.NewEncoder(w)
+	if !format.Compact {
+		enc.SetIndent("", "  ")
+	}
+	return enc.Encode(struct {
+		Libraries []json.RawMessage `json:"libraries"`
+	}{libs})
+}
+
+// openAPIComponents is an OpenAPI components section with a schema for each
+// manifest entry.
+type openAPIComponents struct {
+	Components struct {
+		Schemas map[string]openAPISchema `json:"schemas"`
+	} `json:"components"`
+}
+
+// openAPISchema describes a library as an OpenAPI schema object. The release
+// level has no standard field, so it is an extension.
+type openAPISchema struct {
+	Title        string               `json:"title"`
+	Description  string               `json:"description,omitempty"`
+	ReleaseLevel string               `json:"x-release-level,omitempty"`
+	ExternalDocs *openAPIExternalDocs `json:"externalDocs,omitempty"`
+}
+
+// openAPIExternalDocs is an OpenAPI external documentation object.
+type openAPIExternalDocs struct {
+	URL string `json:"url"`
+}
+
+// openAPIComponentKey returns the key of the schema of the entry named name.
+// OpenAPI component keys may not contain slashes, so they are replaced with
+// dots.
+func openAPIComponentKey(name string) string {
+	return strings.ReplaceAll(name, "/", ".")
+}
+
+// newOpenAPIComponents returns the entries as an OpenAPI components section,
+// with the distribution name as the schema title. It returns an error if two
+// distribution names have the same component key.
+func newOpenAPIComponents(entries map[string]ManifestEntry) (openAPIComponents, error) {
+	var c openAPIComponents
+	c.Components.Schemas = make(map[string]openAPISchema, len(entries))
+	for _, e := range sortedManifestEntries(entries) {
+		key := openAPIComponentKey(e.DistributionName)
+		if other, ok := c.Components.Schemas[key]; ok {
+			return openAPIComponents{}, fmt.Errorf("entries %s and %s have the same OpenAPI component key %s", other.Title, e.DistributionName, key)
+		}
+		s := openAPISchema{
+			Title:        e.DistributionName,
+			Description:  e.Description,
+			ReleaseLevel: e.ReleaseLevel,
+		}
+		if e.DocsURL != "" {
+			s.ExternalDocs = &openAPIExternalDocs{URL: e.DocsURL}
+		}
+		c.Components.Schemas[key] = s
+	}
+	return c, nil
+}
+
+// writeOpenAPIComponents writes the entries to w as an OpenAPI components
+// section. The JSON is indented unless compact is set.
+func writeOpenAPIComponents(w io.Writer, entries map[string]ManifestEntry, compact bool) error {
+	c, err := newOpenAPIComponents(entries)
+	if err != nil {
+		return err
+	}
+	enc := json.NewEncoder(w)
+	if !compact {
+		enc.SetIndent("", "  ")
+	}
+	return enc.Encode(c)
+}
+
+// goSourceHeader starts every Go source file written by writeGoSource.
+const goSourceHeader = "// Code generated by the postprocessor. DO NOT EDIT.\n"
+
+// writeGoSource writes the entries to w as a gofmt'd Go source file of
+// package pkg that declares them as a map named Entries, along with a
+// ManifestEntry type with the fields of the manifest. Unset fields are left
+// out of each entry.
+func writeGoSource(w io.Writer, entries map[string]ManifestEntry, pkg string) error {
+	t := reflect.TypeOf(ManifestEntry{})
+	var fields []int
+	for i := 0; i < t.NumField(); i++ {
+		if t.Field(i).Tag.Get("json") != "-" {
+			fields = append(fields, i)
+		}
+	}
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "%s\npackage %s\n\n", goSourceHeader, pkg)
+	b.WriteString("// ManifestEntry is an entry of the manifest.\ntype ManifestEntry struct {\n")
+	for _, i := range fields {
+		typ := "string"
+		if t.Field(i).Type.Kind() == reflect.Slice {
+			typ = "[]string"
+		}
+		fmt.Fprintf(&b, "%s %s\n", t.Field(i).Name, typ)
+	}
+	b.WriteString("}\n\n// Entries are the entries of the manifest, keyed like the manifest.\nvar Entries = map[string]ManifestEntry{\n")
+	keys := make([]string, 0, len(entries))
+	for k := range entries {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		fmt.Fprintf(&b, "%s: {\n", strconv.Quote(k))
+		v := reflect.ValueOf(entries[k])
+		for _, i := range fields {
+			f := v.Field(i)
+			if f.IsZero() {
+				continue
+			}
+			if f.Kind() == reflect.Slice {
+				elems := make([]string, f.Len())
+				for j := range elems {
+					elems[j] = strconv.Quote(f.Index(j).String())
+				}
+				fmt.Fprintf(&b, "%s: []string{%s},\n", t.Field(i).Name, strings.Join(elems, ", "))
+				continue
+			}
+			fmt.Fprintf(&b, "%s: %s,\n", t.Field(i).Name, strconv.Quote(f.String()))
+		}
+		b.WriteString("},\n")
+	}
+	b.WriteString("}\n")
+	src, err := format.Source(b.Bytes())
+	if err != nil {
+		return fmt.Errorf("unable to format the Go source of the manifest: %v", err)
+	}
+	_, err = w.Write(src)
+	return err
+}
+
+// checkGoSourceOverwrite returns an error unless the file at path does not
+// exist or was written by writeGoSource, so that a misconfigured path does
+// not clobber a handwritten Go file.
+func checkGoSourceOverwrite(path string) error {
+	b, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("refusing to overwrite %s: %v", path, err)
+	}
+	if !bytes.HasPrefix(b, []byte(goSourceHeader)) {
+		return fmt.Errorf("refusing to overwrite %s: it was not generated from the manifest", path)
+	}
+	return nil
+}
+
+// checkManifestOverwrite returns an error unless the file at path does not
+// exist, is empty, or is a JSON object of manifest entries, so that a
+// misconfigured path does not clobber an unrelated file.
+func checkManifestOverwrite(path string) error {
+	b, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("refusing to overwrite %s: %v", path, err)
+	}
+	if len(bytes.TrimSpace(b)) == 0 {
+		return nil
+	}
+	if entries, err := decodeManifest(b); err != nil || entries == nil {
+		return fmt.Errorf("refusing to overwrite %s: it is not a manifest", path)
+	}
+	return nil
+}
+
+// checksumLine returns the SHA-256 checksum of b, the contents of the file at
+// path, in the format of sha256sum.
+func checksumLine(path string, b []byte) []byte {
+	return []byte(fmt.Sprintf("%x  %s\n", sha256.Sum256(b), filepath.Base(path)))
+}
+
+// writeDescriptionConfigs writes the service configs that the description of
+// each of the entries is from to w as indented JSON, keyed by distribution
+// name. Entries whose description is not from a service config are left out.
+func writeDescriptionConfigs(w io.Writer, configs map[string][]string, entries map[string]ManifestEntry) error {
+	written := map[string][]string{}
+	for name := range entries {
+		if paths, ok := configs[name]; ok {
+			written[name] = paths
+		}
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(written)
+}
+
+// writeModulePackages writes the packages of each module to w as indented
+// JSON.
+func writeModulePackages(w io.Writer, packages map[string][]modulePackage) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(packages)
+}
+
+// keyManifestEntries returns the entries, which are keyed by distribution
+// name, keyed by the configured manifest key. It is an error for two entries
+// to have the same key.
+func (p *postProcessor) keyManifestEntries(entries map[string]ManifestEntry) (map[string]ManifestEntry, error) {
+	keyed := make(map[string]ManifestEntry, len(entries))
+	names := map[string]string{} // Key is the manifest key, value the distribution name.
+	for name, entry := range entries {
+		key := name
+		if p.config.manifestKey() == importPathManifestKey {
+			if importPath, ok := p.importPaths[name]; ok {
+				key = importPath
+			}
+		}
+		if other, ok := names[key]; ok {
+			if other > name {
+				other, name = name, other
+			}
+			return nil, fmt.Errorf("entries %s and %s have the same manifest key %s", other, name, key)
+		}
+		names[key] = name
+		keyed[key] = entry
+	}
+	return keyed, nil
+}
+
+// splitManifestByReleaseLevel returns the entries grouped by release level,
+// with a group for every known release level even if it has no entries.
+func splitManifestByReleaseLevel(entries map[string]ManifestEntry) map[string]map[string]ManifestEntry {
+	splits := map[string]map[string]ManifestEntry{}
+	for level := range knownReleaseLevels {
+		splits[level] = map[string]ManifestEntry{}
+	}
+	for key, e := range entries {
+		if split, ok := splits[e.ReleaseLevel]; ok {
+			split[key] = e
+		}
+	}
+	return splits
+}
+
+// checkSplitOverlap returns an error listing the entries that are in more
+// than one of the splits, which are keyed by release level.
+func checkSplitOverlap(splits map[string]map[string]ManifestEntry) error {
+	levels := map[string][]string{} // Key is the manifest key.
+	for level, split := range splits {
+		for key := range split {
+			levels[key] = append(levels[key], level)
+		}
+	}
+	var errs []error
+	for key, ls := range levels {
+		if len(ls) > 1 {
+			sort.Strings(ls)
+			errs = append(errs, fmt.Errorf("%s is in the %s release level splits", key, strings.Join(ls, ", ")))
+		}
+	}
+	sort.Slice(errs, func(i, j int) bool { return errs[i].Error() < errs[j].Error() })
+	return errors.Join(errs...)
+}
+
+// reportLevelOrder is the order of the release levels in a release level
+// report. Other levels come last.
+var reportLevelOrder = map[string]int{
+	"alpha":      0,
+	"beta":       1,
+	"ga":         2,
+	"deprecated": 3,
+}
+
+// writeReleaseLevelReport writes the entries to w as a markdown table ordered
+// by release level, least stable first, and then by distribution name, so
+// that the entries that are not yet ga are listed first.
+func writeReleaseLevelReport(w io.Writer, entries map[string]ManifestEntry) error {
+	sorted := sortedManifestEntries(entries)
+	sortByReleaseLevel(sorted)
+	var b strings.Builder
+	b.WriteString("| release level | distribution | description |\n")
+	b.WriteString("| --- | --- | --- |\n")
+	for _, e := range sorted {
+		fmt.Fprintf(&b, "| %s | %s | %s |\n", markdownCell(e.ReleaseLevel), markdownCell(e.DistributionName), markdownCell(e.Description))
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// sortByReleaseLevel stably sorts the entries by release level in
+// reportLevelOrder, with other levels last in lexical order.
+func sortByReleaseLevel(s []ManifestEntry) {
+	rank := func(level string) int {
+		if r, ok := reportLevelOrder[level]; ok {
+			return r
+		}
+		return len(reportLevelOrder)
+	}
+	sort.SliceStable(s, func(i, j int) bool {
+		ri, rj := rank(s[i].ReleaseLevel), rank(s[j].ReleaseLevel)
+		if ri != rj {
+			return ri < rj
+		}
+		return s[i].ReleaseLevel < s[j].ReleaseLevel
+	})
+}
+
+// verifyManifestFile re-reads the manifest at path and checks that it decodes
+// to the entries that were written.
+func verifyManifestFile(path string, entries map[string]ManifestEntry) error {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	return verifyManifest(path, b, entries)
+}
+
+// verifyManifest checks that b, the manifest to be written to path, decodes to
+// the entries.
+func verifyManifest(path string, b []byte, entries map[string]ManifestEntry) error {
+	got, err := decodeManifest(b)
+	if err != nil {
+		return fmt.Errorf("verifying %s: %v", path, err)
+	}
+	if !reflect.DeepEqual(got, entries) {
+		return fmt.Errorf("verifying %s: decoded entries do not match the entries written", path)
+	}
+	return nil
+}
+
+// sortedManifestEntries returns the entries sorted by distribution name.
+func sortedManifestEntries(entries map[string]ManifestEntry) []ManifestEntry {
+	s := make([]ManifestEntry, 0, len(entries))
+	for _, e := range entries {
+		s = append(s, e)
+	}
+	sort.Slice(s, func(i, j int) bool {
+		return s[i].DistributionName < s[j].DistributionName
+	})
+	return s
+}
+
+// writeManifestJSONL writes the sorted entries to w as newline-delimited
+// JSON, one compact entry per line, with their fields named and unset fields
+// written as in format.
+func writeManifestJSONL(w io.Writer, sorted []ManifestEntry, format manifestFormat) error {
+	for _, e := range sorted {
+		b, err := marshalEntry(e, format)
+		if err != nil {
+			return err
+		}
+		if _, err := w.Write(append(b, '\n')); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// sortedOutputEntries returns the entries in the order of the configured
+// SortBy key, for the JSONL and libraries outputs. Entries with the same key
+// are sorted by distribution name.
+func (p *postProcessor) sortedOutputEntries(entries map[string]ManifestEntry) ([]ManifestEntry, error) {
+	sorted := sortedManifestEntries(entries)
+	switch p.config.sortBy() {
+	case releaseLevelSortKey:
+		sortByReleaseLevel(sorted)
+	case moduleSortKey:
+		groups, err := p.ManifestByModule(entries)
+		if err != nil {
+			return nil, err
+		}
+		mods := make([]string, 0, len(groups))
+		for mod := range groups {
+			mods = append(mods, mod)
+		}
+		sort.Strings(mods)
+		sorted = sorted[:0]
+		for _, mod := range mods {
+			sorted = append(sorted, groups[mod]...)
+		}
+	}
+	return sorted, nil
+}
+
+// ManifestByModule groups the entries by the path of the module that owns
+// them, with the entries of each module sorted by distribution name. Manual
+// entries whose module can not be resolved are grouped under
+// manualModuleBucket.
+func (p *postProcessor) ManifestByModule(entries map[string]ManifestEntry) (map[string][]ManifestEntry, error) {
+	groups := map[string][]ManifestEntry{}
+	for _, e := range sortedManifestEntries(entries) {
+		_, _, generated := p.confForImportPath(e.DistributionName)
+		relPath, err := p.libraryDir(e.DistributionName)
+		var mod string
+		if err == nil {
+			mod, err = p.moduleForDir(filepath.Join(p.googleCloudDir, relPath))
+		}
+		if err != nil {
+			if generated {
+				return nil, fmt.Errorf("unable to resolve module of %s: %v", e.DistributionName, err)
+			}
+			mod = manualModuleBucket
+		}
+		groups[mod] = append(groups[mod], e)
+	}
+	return groups, nil
+}
+
+// modulePackageCounts returns the number of packages in each module of
+// packages, as computed by manifestEntries, and the number of modules.
+func modulePackageCounts(packages map[string][]modulePackage) (map[string]int, int) {
+	counts := make(map[string]int, len(packages))
+	for mod, pkgs := range packages {
+		counts[mod] = len(pkgs)
+	}
+	return counts, len(counts)
+}
+
+// writeModuleCounts writes the package count of each module to w, sorted by
+// module path, followed by the number of modules.
+func writeModuleCounts(w io.Writer, counts map[string]int, total int) error {
+	mods := make([]string, 0, len(counts))
+	for mod := range counts {
+		mods = append(mods, mod)
+	}
+	sort.Strings(mods)
+	var b strings.Builder
+	for _, mod := range mods {
+		fmt.Fprintf(&b, "%s\t%d\n", mod, counts[mod])
+	}
+	fmt.Fprintf(&b, "total modules: %d\n", total)
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// moduleForDir returns the path of the module containing dir. Unlike
+// currentMod, it reports an error if dir does not exist.
+func (p *postProcessor) moduleForDir(dir string) (string, error) {
+	if _, err := os.Stat(dir); err != nil {
+		return "", err
+	}
+	return p.currentMod(dir)
+}
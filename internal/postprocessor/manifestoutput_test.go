@@ -0,0 +1,1034 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"go/ast"
+	"go/format"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestManifestJSONL(t *testing.T) {
+	p := newTestManifestProcessor(t)
+	p.config.WriteJSONL = true
+	entries, err := p.Manifest(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := os.ReadFile(filepath.Join(p.googleCloudDir, "internal", ".repo-metadata-full.jsonl"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := bytes.Split(bytes.TrimSuffix(b, []byte("\n")), []byte("\n"))
+	if len(lines) != len(entries) {
+		t.Fatalf("got %d lines, want %d", len(lines), len(entries))
+	}
+	var got []string
+	for _, line := range lines {
+		var e ManifestEntry
+		if err := json.Unmarshal(line, &e); err != nil {
+			t.Fatalf("json.Unmarshal(%q) = %v", line, err)
+		}
+		if diff := cmp.Diff(entries[e.DistributionName], e); diff != "" {
+			t.Errorf("entry mismatch (-want +got):\n%s", diff)
+		}
+		got = append(got, e.DistributionName)
+	}
+	want := []string{
+		"cloud.google.com/go/bar/apiv1",
+		"cloud.google.com/go/baz",
+		"cloud.google.com/go/foo/apiv1",
+		"cloud.google.com/go/qux/apiv1beta",
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("JSONL ordering mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestManifestSortBy(t *testing.T) {
+	tests := []struct {
+		sortBy string
+		want   []string
+	}{
+		{
+			sortBy: "",
+			want: []string{
+				"cloud.google.com/go/bar/apiv1",
+				"cloud.google.com/go/baz",
+				"cloud.google.com/go/foo/apiv1",
+				"cloud.google.com/go/qux/apiv1beta",
+				"cloud.google.com/go/zeta",
+			},
+		},
+		{
+			sortBy: distributionNameSortKey,
+			want: []string{
+				"cloud.google.com/go/bar/apiv1",
+				"cloud.google.com/go/baz",
+				"cloud.google.com/go/foo/apiv1",
+				"cloud.google.com/go/qux/apiv1beta",
+				"cloud.google.com/go/zeta",
+			},
+		},
+		{
+			sortBy: releaseLevelSortKey,
+			want: []string{
+				"cloud.google.com/go/zeta",
+				"cloud.google.com/go/bar/apiv1",
+				"cloud.google.com/go/qux/apiv1beta",
+				"cloud.google.com/go/baz",
+				"cloud.google.com/go/foo/apiv1",
+			},
+		},
+		{
+			// zeta has no go.mod of its own, so it is in the root module.
+			sortBy: moduleSortKey,
+			want: []string{
+				"cloud.google.com/go/zeta",
+				"cloud.google.com/go/bar/apiv1",
+				"cloud.google.com/go/baz",
+				"cloud.google.com/go/foo/apiv1",
+				"cloud.google.com/go/qux/apiv1beta",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.sortBy, func(t *testing.T) {
+			p := newTestManifestProcessor(t)
+			p.config.WriteJSONL = true
+			p.config.WriteLibrariesManifest = true
+			p.config.SortBy = tt.sortBy
+			p.config.ManualClientInfo = append(p.config.ManualClientInfo, &ManifestEntry{
+				DistributionName:  "cloud.google.com/go/zeta",
+				Description:       "Zeta",
+				Language:          "Go",
+				ClientLibraryType: "manual",
+				DocsURL:           "https://cloud.google.com/go/docs/reference/cloud.google.com/go/zeta/latest",
+				ReleaseLevel:      "alpha",
+				LibraryType:       gapicManualLibraryType,
+			})
+			writeTestFiles(t, p.googleCloudDir, map[string]string{"zeta/zeta.go": "package zeta\n"})
+			if _, err := p.Manifest(context.Background()); err != nil {
+				t.Fatal(err)
+			}
+			b, err := os.ReadFile(filepath.Join(p.googleCloudDir, "internal", ".repo-metadata-full.jsonl"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			for _, line := range bytes.Split(bytes.TrimSuffix(b, []byte("\n")), []byte("\n")) {
+				var e ManifestEntry
+				if err := json.Unmarshal(line, &e); err != nil {
+					t.Fatalf("json.Unmarshal(%q) = %v", line, err)
+				}
+				got = append(got, e.DistributionName)
+			}
+			if diff := cmp.Diff(tt.want, got); diff != "" {
+				t.Errorf("JSONL order mismatch (-want +got):\n%s", diff)
+			}
+			b, err = os.ReadFile(filepath.Join(p.googleCloudDir, "internal", ".repo-metadata-libraries.json"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			var libs struct {
+				Libraries []ManifestEntry `json:"libraries"`
+			}
+			if err := json.Unmarshal(b, &libs); err != nil {
+				t.Fatal(err)
+			}
+			got = nil
+			for _, e := range libs.Libraries {
+				got = append(got, e.DistributionName)
+			}
+			if diff := cmp.Diff(tt.want, got); diff != "" {
+				t.Errorf("libraries order mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+
+	c := &config{manifestConfig: manifestConfig{SortBy: "size"}}
+	if err := c.validate(); err == nil {
+		t.Errorf("validate() = nil error for an unknown sort-by, want error")
+	}
+}
+
+func TestManifestCamelCaseFields(t *testing.T) {
+	p := newTestManifestProcessor(t)
+	p.config.JSONFieldNaming = camelCaseNaming
+	p.config.WriteJSONL = true
+	p.config.WriteLibrariesManifest = true
+	entries, err := p.Manifest(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := os.ReadFile(p.manifestPath())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var raw map[string]map[string]interface{}
+	if err := json.Unmarshal(b, &raw); err != nil {
+		t.Fatal(err)
+	}
+	foo := raw["cloud.google.com/go/foo/apiv1"]
+	for _, field := range []string{"distributionName", "clientLibraryType", "docsUrl", "releaseLevel", "libraryType"} {
+		if _, ok := foo[field]; !ok {
+			t.Errorf("entry has no %s field, got %v", field, foo)
+		}
+	}
+	if _, ok := foo["distribution_name"]; ok {
+		t.Errorf("entry has a snake_case field, got %v", foo)
+	}
+	if !bytes.Contains(b, []byte(`{
+    "distributionName": "cloud.google.com/go/foo/apiv1",
+    "description"`)) {
+		t.Errorf("manifest does not keep the field order, got:\n%s", b)
+	}
+
+	got, err := readManifestFile(p.manifestPath())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(entries, got); diff != "" {
+		t.Errorf("camelCase manifest round trip mismatch (-want +got):\n%s", diff)
+	}
+
+	// The JSONL and libraries manifests use the same naming.
+	for _, name := range []string{".repo-metadata-full.jsonl", ".repo-metadata-libraries.json"} {
+		b, err := os.ReadFile(filepath.Join(p.googleCloudDir, "internal", name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Contains(b, []byte(`"distributionName":`)) || bytes.Contains(b, []byte(`"distribution_name":`)) {
+			t.Errorf("%s does not use camelCase fields, got:\n%s", name, b)
+		}
+	}
+}
+
+func TestWriteManifestNullUnset(t *testing.T) {
+	entries := map[string]ManifestEntry{
+		"cloud.google.com/go/foo/apiv1": {
+			DistributionName: "cloud.google.com/go/foo/apiv1",
+			Language:         "Go",
+			ReleaseLevel:     "beta",
+			Labels:           []string{"ai"},
+		},
+	}
+	for _, tt := range []struct {
+		format manifestFormat
+		want   string
+	}{
+		{
+			format: manifestFormat{Compact: true},
+			want:   `{"cloud.google.com/go/foo/apiv1":{"distribution_name":"cloud.google.com/go/foo/apiv1","description":"","language":"Go","client_library_type":"","docs_url":"","release_level":"beta","library_type":"","labels":["ai"]}}` + "\n",
+		},
+		{
+			format: manifestFormat{Compact: true, NullUnset: true},
+			want:   `{"cloud.google.com/go/foo/apiv1":{"distribution_name":"cloud.google.com/go/foo/apiv1","description":null,"language":"Go","client_library_type":null,"docs_url":null,"release_level":"beta","library_type":null,"graduation_date":null,"generator_version":null,"labels":["ai"]}}` + "\n",
+		},
+		{
+			format: manifestFormat{Compact: true, Naming: camelCaseNaming, NullUnset: true},
+			want:   `{"cloud.google.com/go/foo/apiv1":{"distributionName":"cloud.google.com/go/foo/apiv1","description":null,"language":"Go","clientLibraryType":null,"docsUrl":null,"releaseLevel":"beta","libraryType":null,"graduationDate":null,"generatorVersion":null,"labels":["ai"]}}` + "\n",
+		},
+	} {
+		var buf bytes.Buffer
+		if err := writeManifest(&buf, entries, tt.format); err != nil {
+			t.Fatal(err)
+		}
+		if diff := cmp.Diff(tt.want, buf.String()); diff != "" {
+			t.Errorf("writeManifest(%+v) mismatch (-want +got):\n%s", tt.format, diff)
+		}
+	}
+}
+
+func TestManifestNullUnsetFields(t *testing.T) {
+	p := newTestManifestProcessor(t)
+	p.config.NullUnsetFields = true
+	entries, err := p.Manifest(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := os.ReadFile(p.manifestPath())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Contains(b, []byte(`"graduation_date": null`)) {
+		t.Errorf("manifest has no null graduation date, got:\n%s", b)
+	}
+	got, err := readManifestFile(p.manifestPath())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(entries, got); diff != "" {
+		t.Errorf("null manifest round trip mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestFieldNaming(t *testing.T) {
+	for snake, camel := range map[string]string{
+		"distribution_name":   "distributionName",
+		"docs_url":            "docsUrl",
+		"client_library_type": "clientLibraryType",
+		"description":         "description",
+	} {
+		if got := snakeToCamel(snake); got != camel {
+			t.Errorf("snakeToCamel(%q) = %q, want %q", snake, got, camel)
+		}
+		if got := camelToSnake(camel); got != snake {
+			t.Errorf("camelToSnake(%q) = %q, want %q", camel, got, snake)
+		}
+		if got := camelToSnake(snake); got != snake {
+			t.Errorf("camelToSnake(%q) = %q, want it unchanged", snake, got)
+		}
+	}
+}
+
+func TestManifestChecksum(t *testing.T) {
+	p := newTestManifestProcessor(t)
+	p.config.WriteChecksum = true
+	if _, err := p.Manifest(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	manifest, err := os.ReadFile(p.manifestPath())
+	if err != nil {
+		t.Fatal(err)
+	}
+	checksum, err := os.ReadFile(p.manifestPath() + ".sha256")
+	if err != nil {
+		t.Fatal(err)
+	}
+	digest, name, ok := strings.Cut(strings.TrimSuffix(string(checksum), "\n"), "  ")
+	if !ok {
+		t.Fatalf("checksum file %q is not in sha256sum format", checksum)
+	}
+	if name != ".repo-metadata-full.json" {
+		t.Errorf("checksum file names %q, want .repo-metadata-full.json", name)
+	}
+	if want := fmt.Sprintf("%x", sha256.Sum256(manifest)); digest != want {
+		t.Errorf("checksum = %s, want %s", digest, want)
+	}
+}
+
+func TestWriteReleaseLevelReport(t *testing.T) {
+	entries := map[string]ManifestEntry{
+		"cloud.google.com/go/a/apiv1":      {DistributionName: "cloud.google.com/go/a/apiv1", Description: "A", ReleaseLevel: "ga"},
+		"cloud.google.com/go/b/apiv1beta1": {DistributionName: "cloud.google.com/go/b/apiv1beta1", Description: "B", ReleaseLevel: "beta"},
+		"cloud.google.com/go/c/apiv1":      {DistributionName: "cloud.google.com/go/c/apiv1", Description: "C", ReleaseLevel: "deprecated"},
+		"cloud.google.com/go/d/apiv1":      {DistributionName: "cloud.google.com/go/d/apiv1", Description: "D | old", ReleaseLevel: "alpha"},
+		"cloud.google.com/go/e/apiv1":      {DistributionName: "cloud.google.com/go/e/apiv1", Description: "E", ReleaseLevel: "beta"},
+		"cloud.google.com/go/f/apiv1":      {DistributionName: "cloud.google.com/go/f/apiv1", Description: "F", ReleaseLevel: "preview"},
+		"cloud.google.com/go/g/apiv1":      {DistributionName: "cloud.google.com/go/g/apiv1", Description: "G", ReleaseLevel: "alpha"},
+	}
+	var buf bytes.Buffer
+	if err := writeReleaseLevelReport(&buf, entries); err != nil {
+		t.Fatal(err)
+	}
+	want := `| release level | distribution | description |
+| --- | --- | --- |
+| alpha | cloud.google.com/go/d/apiv1 | D \| old |
+| alpha | cloud.google.com/go/g/apiv1 | G |
+| beta | cloud.google.com/go/b/apiv1beta1 | B |
+| beta | cloud.google.com/go/e/apiv1 | E |
+| ga | cloud.google.com/go/a/apiv1 | A |
+| deprecated | cloud.google.com/go/c/apiv1 | C |
+| preview | cloud.google.com/go/f/apiv1 | F |
+`
+	if diff := cmp.Diff(want, buf.String()); diff != "" {
+		t.Errorf("writeReleaseLevelReport() mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestManifestLibrariesWrapper(t *testing.T) {
+	p := newTestManifestProcessor(t)
+	p.config.WriteLibrariesManifest = true
+	entries, err := p.Manifest(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := os.ReadFile(filepath.Join(p.googleCloudDir, "internal", ".repo-metadata-libraries.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got map[string][]ManifestEntry
+	if err := json.Unmarshal(b, &got); err != nil {
+		t.Fatalf("json.Unmarshal() = %v", err)
+	}
+	want := map[string][]ManifestEntry{
+		"libraries": {
+			entries["cloud.google.com/go/bar/apiv1"],
+			entries["cloud.google.com/go/baz"],
+			entries["cloud.google.com/go/foo/apiv1"],
+			entries["cloud.google.com/go/qux/apiv1beta"],
+		},
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("libraries manifest mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestManifestOpenAPIComponents(t *testing.T) {
+	p := newTestManifestProcessor(t)
+	p.config.WriteOpenAPIComponents = true
+	if _, err := p.Manifest(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	b, err := os.ReadFile(filepath.Join(p.googleCloudDir, "internal", ".repo-metadata-openapi.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got map[string]map[string]map[string]map[string]interface{}
+	if err := json.Unmarshal(b, &got); err != nil {
+		t.Fatalf("json.Unmarshal() = %v", err)
+	}
+	schemas := got["components"]["schemas"]
+	if len(schemas) != 4 {
+		t.Errorf("got %d schemas, want 4", len(schemas))
+	}
+	want := map[string]interface{}{
+		"title":           "cloud.google.com/go/foo/apiv1",
+		"description":     "Foo API",
+		"x-release-level": "ga",
+		"externalDocs":    map[string]interface{}{"url": "https://cloud.google.com/go/docs/reference/cloud.google.com/go/foo/latest/apiv1"},
+	}
+	if diff := cmp.Diff(want, schemas["cloud.google.com.go.foo.apiv1"]); diff != "" {
+		t.Errorf("OpenAPI schema mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestManifestGoSource(t *testing.T) {
+	p := newTestManifestProcessor(t)
+	p.config.GoSourceFile = "internal/manifest/entries.go"
+	p.config.Labels = map[string][]string{"cloud.google.com/go/foo/apiv1": {"ai", "core"}}
+	if _, err := p.Manifest(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(p.googleCloudDir, p.config.GoSourceFile)
+	src, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	formatted, err := format.Source(src)
+	if err != nil {
+		t.Fatalf("format.Source() = %v, want the emitted source to parse", err)
+	}
+	if diff := cmp.Diff(string(src), string(formatted)); diff != "" {
+		t.Errorf("emitted source is not gofmt'd (-got +formatted):\n%s", diff)
+	}
+	fset := token.NewFileSet()
+	f, err := parser.ParseFile(fset, path, src, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f.Name.Name != "manifest" {
+		t.Errorf("package = %s, want the name of the directory", f.Name.Name)
+	}
+	if _, err := (&types.Config{}).Check("manifest", fset, []*ast.File{f}, nil); err != nil {
+		t.Errorf("emitted source does not type-check: %v", err)
+	}
+	for _, want := range []string{
+		`"cloud.google.com/go/foo/apiv1": {`,
+		`DocsURL:           "https://cloud.google.com/go/docs/reference/cloud.google.com/go/foo/latest/apiv1",`,
+		`Labels:            []string{"ai", "core"},`,
+	} {
+		if !strings.Contains(string(src), want) {
+			t.Errorf("emitted source:\n%s\nwant it to contain %q", src, want)
+		}
+	}
+
+	// A handwritten file at the path is not overwritten.
+	if err := os.WriteFile(path, []byte("package manifest\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := p.Manifest(context.Background()); err == nil {
+		t.Errorf("Manifest() = nil error, want error for overwriting a handwritten Go file")
+	}
+}
+
+func TestNewOpenAPIComponentsKeyCollision(t *testing.T) {
+	entries := map[string]ManifestEntry{
+		"cloud.google.com/go/foo/bar": {DistributionName: "cloud.google.com/go/foo/bar"},
+		"cloud.google.com/go/foo.bar": {DistributionName: "cloud.google.com/go/foo.bar"},
+	}
+	if _, err := newOpenAPIComponents(entries); err == nil {
+		t.Errorf("newOpenAPIComponents() = nil error, want error for colliding keys")
+	}
+}
+
+func TestManifestDescriptionSourcesSidecar(t *testing.T) {
+	p := newTestManifestProcessor(t)
+	p.config.WriteDescriptionSources = true
+	// The description of foo is from its override rather than its service
+	// config, so it is left out.
+	p.config.GoogleapisToImportPath["google/cloud/foo/v1"].DescriptionOverride = "Foo"
+	p.config.DescriptionSources = []descriptionSource{overrideDescriptionSource, titleDescriptionSource}
+	if _, err := p.Manifest(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	b, err := os.ReadFile(filepath.Join(p.googleCloudDir, "internal", ".repo-metadata-description-sources.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got map[string][]string
+	if err := json.Unmarshal(b, &got); err != nil {
+		t.Fatalf("json.Unmarshal() = %v", err)
+	}
+	want := map[string][]string{
+		"cloud.google.com/go/bar/apiv1":     {filepath.Join(p.googleapisDir, "google/cloud/bar/v1/bar_v1.yaml")},
+		"cloud.google.com/go/qux/apiv1beta": {filepath.Join(p.googleapisDir, "google/cloud/qux/v1beta/qux_v1beta.yaml")},
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("description sources mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestWriteManifest(t *testing.T) {
+	for _, compact := range []bool{false, true} {
+		t.Run(fmt.Sprintf("compact=%v", compact), func(t *testing.T) {
+			p := newTestManifestProcessor(t)
+			p.config.CompactJSON = compact
+			entries, err := p.Manifest(context.Background())
+			if err != nil {
+				t.Fatal(err)
+			}
+			var buf bytes.Buffer
+			if err := writeManifest(&buf, entries, manifestFormat{Compact: compact, Naming: snakeCaseNaming}); err != nil {
+				t.Fatal(err)
+			}
+			want, err := os.ReadFile(p.manifestPath())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if diff := cmp.Diff(string(want), buf.String()); diff != "" {
+				t.Errorf("writeManifest() mismatch with the manifest file (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestManifestCompactJSON(t *testing.T) {
+	p := newTestManifestProcessor(t)
+	p.config.CompactJSON = true
+	entries, err := p.Manifest(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := os.ReadFile(filepath.Join(p.googleCloudDir, "internal", ".repo-metadata-full.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := bytes.Count(b, []byte("\n")); n != 1 || !bytes.HasSuffix(b, []byte("\n")) {
+		t.Errorf("manifest has %d newlines, want a single trailing newline", n)
+	}
+	var got map[string]ManifestEntry
+	if err := json.Unmarshal(b, &got); err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(entries, got); diff != "" {
+		t.Errorf("compact manifest mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestVerifyManifestFile(t *testing.T) {
+	p := newTestManifestProcessor(t)
+	entries, err := p.Manifest(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(p.googleCloudDir, "internal", ".repo-metadata-full.json")
+	if err := verifyManifestFile(path, entries); err != nil {
+		t.Fatalf("verifyManifestFile() = %v, want nil", err)
+	}
+
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	corrupt := map[string][]byte{
+		"truncated": b[:len(b)/2],
+		"modified":  bytes.Replace(b, []byte(`"beta"`), []byte(`"ga"`), 1),
+	}
+	for name, content := range corrupt {
+		t.Run(name, func(t *testing.T) {
+			if err := os.WriteFile(path, content, 0644); err != nil {
+				t.Fatal(err)
+			}
+			if err := verifyManifestFile(path, entries); err == nil {
+				t.Errorf("verifyManifestFile() = nil, want error for corrupted manifest")
+			}
+		})
+	}
+}
+
+func TestModulePackageCounts(t *testing.T) {
+	p := newTestManifestProcessor(t)
+	p.config.GoogleapisToImportPath["google/cloud/foo/v2"] = &libraryInfo{
+		ImportPath:    "cloud.google.com/go/foo/apiv2",
+		ServiceConfig: serviceConfigList{"foo_v2.yaml"},
+		RelPath:       "/foo/apiv2",
+	}
+	writeTestFiles(t, p.googleCloudDir, map[string]string{"foo/apiv2/doc.go": testDocGA})
+	writeTestFiles(t, p.googleapisDir, map[string]string{
+		"google/cloud/foo/v2/foo_v2.yaml": "type: google.api.Service\ntitle: Foo API\n",
+	})
+	if _, err := p.computeManifestEntries(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	counts, total := modulePackageCounts(p.modulePackages)
+	want := map[string]int{
+		"cloud.google.com/go/bar": 1,
+		"cloud.google.com/go/foo": 2,
+		"cloud.google.com/go/qux": 1,
+	}
+	if diff := cmp.Diff(want, counts); diff != "" {
+		t.Errorf("modulePackageCounts() mismatch (-want +got):\n%s", diff)
+	}
+	if total != 3 {
+		t.Errorf("modulePackageCounts() total = %d, want 3", total)
+	}
+
+	var b strings.Builder
+	if err := writeModuleCounts(&b, counts, total); err != nil {
+		t.Fatal(err)
+	}
+	wantOut := "cloud.google.com/go/bar\t1\ncloud.google.com/go/foo\t2\ncloud.google.com/go/qux\t1\ntotal modules: 3\n"
+	if got := b.String(); got != wantOut {
+		t.Errorf("writeModuleCounts() = %q, want %q", got, wantOut)
+	}
+}
+
+func TestManifestByModule(t *testing.T) {
+	p := newTestManifestProcessor(t)
+	p.config.ManualClientInfo = append(p.config.ManualClientInfo, &ManifestEntry{
+		DistributionName: "cloud.google.com/go/legacy",
+		Description:      "Legacy",
+		Language:         "Go",
+		DocsURL:          "https://cloud.google.com/go/docs/reference/cloud.google.com/go/legacy/latest",
+		ReleaseLevel:     "ga",
+		LibraryType:      gapicManualLibraryType,
+	})
+	p.config.GoogleapisToImportPath["google/cloud/foo/v2"] = &libraryInfo{
+		ImportPath:    "cloud.google.com/go/foo/apiv2",
+		ServiceConfig: serviceConfigList{"foo_v2.yaml"},
+		RelPath:       "/foo/apiv2",
+	}
+	writeTestFiles(t, p.googleCloudDir, map[string]string{"foo/apiv2/doc.go": testDocGA})
+	writeTestFiles(t, p.googleapisDir, map[string]string{
+		"google/cloud/foo/v2/foo_v2.yaml": "type: google.api.Service\ntitle: Foo API\n",
+	})
+	entries, err := p.manifestEntries(context.Background(), p.config.ManualClientInfo, p.config.GoogleapisToImportPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	groups, err := p.ManifestByModule(entries)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := map[string][]string{}
+	for mod, es := range groups {
+		for _, e := range es {
+			got[mod] = append(got[mod], e.DistributionName)
+		}
+	}
+	want := map[string][]string{
+		"cloud.google.com/go/bar": {"cloud.google.com/go/bar/apiv1"},
+		"cloud.google.com/go/baz": {"cloud.google.com/go/baz"},
+		"cloud.google.com/go/foo": {"cloud.google.com/go/foo/apiv1", "cloud.google.com/go/foo/apiv2"},
+		"cloud.google.com/go/qux": {"cloud.google.com/go/qux/apiv1beta"},
+		manualModuleBucket:        {"cloud.google.com/go/legacy"},
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("ManifestByModule() mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestManifestModulePackages(t *testing.T) {
+	p := newTestManifestProcessor(t)
+	p.config.WriteModulePackages = true
+	p.config.GoogleapisToImportPath["google/cloud/foo/v2"] = &libraryInfo{
+		ImportPath:    "cloud.google.com/go/foo/apiv2",
+		ServiceConfig: serviceConfigList{"foo_v2.yaml"},
+		RelPath:       "/foo/apiv2",
+	}
+	writeTestFiles(t, p.googleCloudDir, map[string]string{"foo/apiv2/doc.go": testDocGA})
+	writeTestFiles(t, p.googleapisDir, map[string]string{
+		"google/cloud/foo/v2/foo_v2.yaml": "type: google.api.Service\ntitle: Foo API\n",
+	})
+	if _, err := p.Manifest(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	b, err := os.ReadFile(filepath.Join(p.googleCloudDir, "internal", ".repo-metadata-modules.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got map[string][]modulePackage
+	if err := json.Unmarshal(b, &got); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string][]modulePackage{
+		"cloud.google.com/go/bar": {
+			{ImportPath: "cloud.google.com/go/bar/apiv1", PkgPath: "apiv1"},
+		},
+		"cloud.google.com/go/foo": {
+			{ImportPath: "cloud.google.com/go/foo/apiv1", PkgPath: "apiv1"},
+			{ImportPath: "cloud.google.com/go/foo/apiv2", PkgPath: "apiv2"},
+		},
+		"cloud.google.com/go/qux": {
+			{ImportPath: "cloud.google.com/go/qux/apiv1beta", PkgPath: "apiv1beta"},
+		},
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("module packages mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestManifestKey(t *testing.T) {
+	for _, key := range []string{distributionNameManifestKey, importPathManifestKey} {
+		t.Run(key, func(t *testing.T) {
+			p := newTestManifestProcessor(t)
+			p.config.ManifestKey = key
+			entries, err := p.Manifest(context.Background())
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := readManifestFile(filepath.Join(p.googleCloudDir, "internal", ".repo-metadata-full.json"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := map[string]ManifestEntry{}
+			for name, entry := range entries {
+				if key == importPathManifestKey {
+					name = p.importPaths[name]
+				}
+				want[name] = entry
+			}
+			if diff := cmp.Diff(want, got); diff != "" {
+				t.Errorf("manifest keyed by %s mismatch (-want +got):\n%s", key, diff)
+			}
+		})
+	}
+}
+
+func TestKeyManifestEntries(t *testing.T) {
+	entries := map[string]ManifestEntry{
+		"cloud.google.com/go/foo":        {DistributionName: "cloud.google.com/go/foo"},
+		"cloud.google.com/go/foo/apiv1":  {DistributionName: "cloud.google.com/go/foo/apiv1"},
+		"cloud.google.com/go/bar/apiv1":  {DistributionName: "cloud.google.com/go/bar/apiv1"},
+		"cloud.google.com/go/bar/legacy": {DistributionName: "cloud.google.com/go/bar/legacy"},
+	}
+	p := &postProcessor{
+		config: &config{},
+		importPaths: map[string]string{
+			"cloud.google.com/go/foo":        "cloud.google.com/go/foo",
+			"cloud.google.com/go/foo/apiv1":  "cloud.google.com/go/foo/apiv1",
+			"cloud.google.com/go/bar/apiv1":  "cloud.google.com/go/bar/apiv1",
+			"cloud.google.com/go/bar/legacy": "cloud.google.com/go/bar/apiv1",
+		},
+	}
+	got, err := p.keyManifestEntries(entries)
+	if err != nil {
+		t.Fatalf("keyManifestEntries() keyed by distribution name: %v", err)
+	}
+	if diff := cmp.Diff(entries, got); diff != "" {
+		t.Errorf("keyManifestEntries() mismatch (-want +got):\n%s", diff)
+	}
+
+	p.config.ManifestKey = importPathManifestKey
+	_, err = p.keyManifestEntries(entries)
+	if err == nil || !strings.Contains(err.Error(), "cloud.google.com/go/bar/apiv1 and cloud.google.com/go/bar/legacy") {
+		t.Errorf("keyManifestEntries() keyed by import path = %v, want a duplicate key error", err)
+	}
+}
+
+func TestManifestReleaseLevelSplits(t *testing.T) {
+	p := newTestManifestProcessor(t)
+	p.config.WriteReleaseLevelSplits = true
+	entries, err := p.Manifest(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	all := map[string]ManifestEntry{}
+	for level := range knownReleaseLevels {
+		want := map[string]ManifestEntry{}
+		for name, e := range entries {
+			if e.ReleaseLevel == level {
+				want[name] = e
+			}
+		}
+		got, err := readManifestFile(filepath.Join(p.googleCloudDir, "internal", ".repo-metadata-"+level+".json"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if diff := cmp.Diff(want, got); diff != "" {
+			t.Errorf("%s split mismatch (-want +got):\n%s", level, diff)
+		}
+		for name, e := range got {
+			all[name] = e
+		}
+	}
+	if diff := cmp.Diff(entries, all); diff != "" {
+		t.Errorf("splits do not cover the manifest (-want +got):\n%s", diff)
+	}
+}
+
+func TestManifestGAManifest(t *testing.T) {
+	p := newTestManifestProcessor(t)
+	p.config.WriteGAManifest = true
+	entries, err := p.Manifest(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := readManifestFile(filepath.Join(p.googleCloudDir, "internal", ".repo-metadata-ga.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]ManifestEntry{
+		"cloud.google.com/go/baz":       entries["cloud.google.com/go/baz"],
+		"cloud.google.com/go/foo/apiv1": entries["cloud.google.com/go/foo/apiv1"],
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("ga manifest mismatch (-want +got):\n%s", diff)
+	}
+	if len(entries) != 4 {
+		t.Errorf("Manifest() = %d entries, want the full manifest to keep all 4", len(entries))
+	}
+	if _, err := os.Stat(filepath.Join(p.googleCloudDir, "internal", ".repo-metadata-beta.json")); !os.IsNotExist(err) {
+		t.Errorf("Stat() of the beta split = %v, want only the ga split to be written", err)
+	}
+}
+
+func TestManifestReleaseLevelSplitsOverlap(t *testing.T) {
+	p := newTestManifestProcessor(t)
+	p.config.WriteReleaseLevelSplits = true
+	// Simulate a splitting bug that also puts every beta entry in the ga
+	// split.
+	p.splitManifest = func(entries map[string]ManifestEntry) map[string]map[string]ManifestEntry {
+		splits := splitManifestByReleaseLevel(entries)
+		for key, e := range splits["beta"] {
+			splits["ga"][key] = e
+		}
+		return splits
+	}
+	_, err := p.Manifest(context.Background())
+	if err == nil {
+		t.Fatal("Manifest() = nil error, want error for overlapping splits")
+	}
+	for _, want := range []string{
+		"cloud.google.com/go/bar/apiv1 is in the beta, ga release level splits",
+		"cloud.google.com/go/qux/apiv1beta is in the beta, ga release level splits",
+	} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("Manifest() error = %v, want it to contain %q", err, want)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(p.googleCloudDir, "internal", ".repo-metadata-ga.json")); !os.IsNotExist(err) {
+		t.Errorf("ga split was written despite the overlap, stat error = %v", err)
+	}
+}
+
+func TestManifestScopedManifests(t *testing.T) {
+	p := newTestManifestProcessor(t)
+	p.config.Labels = map[string][]string{
+		"cloud.google.com/go/foo/apiv1": {"ai"},
+		"cloud.google.com/go/bar/apiv1": {"ml"},
+		"cloud.google.com/go/baz":       {"storage"},
+	}
+	p.config.ScopedManifests = []scopedManifest{
+		{File: ".repo-metadata-ai.json", Selector: "ai"},
+		{File: ".repo-metadata-ai-ml.json", Selector: "in (ai, ml)"},
+		{File: ".repo-metadata-none.json", Selector: "unused"},
+	}
+	entries, err := p.Manifest(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 4 {
+		t.Errorf("Manifest() returned %d entries, want all 4", len(entries))
+	}
+	for file, want := range map[string][]string{
+		".repo-metadata-ai.json":    {"cloud.google.com/go/foo/apiv1"},
+		".repo-metadata-ai-ml.json": {"cloud.google.com/go/bar/apiv1", "cloud.google.com/go/foo/apiv1"},
+		".repo-metadata-none.json":  nil,
+	} {
+		got, err := readManifestFile(filepath.Join(p.googleCloudDir, "internal", file))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var names []string
+		for name, e := range got {
+			if diff := cmp.Diff(entries[name], e); diff != "" {
+				t.Errorf("%s: entry %s mismatch (-want +got):\n%s", file, name, diff)
+			}
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		if diff := cmp.Diff(want, names); diff != "" {
+			t.Errorf("%s entries mismatch (-want +got):\n%s", file, diff)
+		}
+	}
+
+	// A scoped manifest must not replace the manifest or another output.
+	for _, tt := range []struct {
+		output string
+		file   string
+	}{
+		{file: ".repo-metadata-full.json"},
+		{output: "internal/meta.json", file: "meta.json"},
+		{output: "internal/meta.json", file: "meta.json.sha256"},
+		{file: ".repo-metadata-ga.json"},
+		{file: ".repo-metadata-beta.json"},
+		{file: ".repo-metadata-full.jsonl"},
+		{file: ".repo-metadata-libraries.json"},
+		{file: ".repo-metadata-openapi.json"},
+		{file: ".repo-metadata-description-sources.json"},
+		{file: ".repo-metadata-modules.json"},
+		{file: "internal/ai.json"},
+	} {
+		c := &config{manifestConfig: manifestConfig{
+			Output:          tt.output,
+			ScopedManifests: []scopedManifest{{File: tt.file, Selector: "ai"}},
+		}}
+		if err := c.validate(); err == nil {
+			t.Errorf("validate() = nil error for scoped manifest %q with output %q, want error", tt.file, tt.output)
+		}
+	}
+	c := &config{manifestConfig: manifestConfig{
+		Output:          "internal/meta.json",
+		ScopedManifests: []scopedManifest{{File: ".repo-metadata-full.json", Selector: "ai"}},
+	}}
+	if err := c.validate(); err != nil {
+		t.Errorf("validate() = %v for a scoped manifest with the default manifest name, want nil error", err)
+	}
+}
+
+func TestWriteManifestFileOverwrite(t *testing.T) {
+	entries := map[string]ManifestEntry{
+		"cloud.google.com/go/foo/apiv1": {DistributionName: "cloud.google.com/go/foo/apiv1", ReleaseLevel: "ga"},
+	}
+	tests := []struct {
+		name    string
+		exists  bool
+		content string
+		wantErr bool
+	}{
+		{name: "missing"},
+		{name: "empty", exists: true},
+		{name: "manifest", exists: true, content: `{"cloud.google.com/go/bar/apiv1": {"distribution_name": "cloud.google.com/go/bar/apiv1"}}`},
+		{name: "empty manifest", exists: true, content: "{}\n"},
+		{name: "source file", exists: true, content: "package main\n", wantErr: true},
+		{name: "json array", exists: true, content: `["cloud.google.com/go/foo/apiv1"]`, wantErr: true},
+		{name: "json null", exists: true, content: "null", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "manifest.json")
+			if tt.exists {
+				if err := os.WriteFile(path, []byte(tt.content), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			err := writeManifestFile(path, entries, manifestFormat{})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("writeManifestFile() = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr {
+				return
+			}
+			got, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != tt.content {
+				t.Errorf("writeManifestFile() modified the file to %q, want %q", got, tt.content)
+			}
+		})
+	}
+}
+
+func TestStreamManifest(t *testing.T) {
+	tests := []struct {
+		name  string
+		setup func(p *postProcessor)
+	}{
+		{name: "indented"},
+		{name: "compact", setup: func(p *postProcessor) { p.config.CompactJSON = true }},
+		{
+			name: "library metadata file",
+			setup: func(p *postProcessor) {
+				p.config.LibraryMetadataFile = "repo-metadata.json"
+				writeTestFiles(t, p.googleCloudDir, map[string]string{
+					"foo/apiv1/repo-metadata.json": `{
+  "description": "Foo, curated by its team",
+  "language": "Go",
+  "client_library_type": "generated",
+  "docs_url": "https://cloud.google.com/go/docs/reference/cloud.google.com/go/foo/latest/apiv1",
+  "release_level": "beta",
+  "library_type": "GAPIC_MANUAL"
+}`,
+				})
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := newTestManifestProcessor(t)
+			p.config.ExcludeFromManifest = []string{"cloud.google.com/go/bar/apiv1"}
+			if tt.setup != nil {
+				tt.setup(p)
+			}
+			if _, err := p.Manifest(context.Background()); err != nil {
+				t.Fatal(err)
+			}
+			want, err := os.ReadFile(p.manifestPath())
+			if err != nil {
+				t.Fatal(err)
+			}
+			var buf bytes.Buffer
+			if err := p.StreamManifest(context.Background(), &buf); err != nil {
+				t.Fatal(err)
+			}
+			if diff := cmp.Diff(string(want), buf.String()); diff != "" {
+				t.Errorf("StreamManifest() mismatch with Manifest() (-want +got):\n%s", diff)
+			}
+		})
+	}
+
+	p := newTestManifestProcessor(t)
+	p.config.GoogleapisToImportPath = nil
+	p.config.ManualClientInfo = nil
+	var buf bytes.Buffer
+	if err := p.StreamManifest(context.Background(), &buf); err != nil {
+		t.Fatal(err)
+	}
+	if got := buf.String(); got != "{}\n" {
+		t.Errorf("StreamManifest() of no entries = %q, want %q", got, "{}\n")
+	}
+	p.config.MinEntries = 1
+	if err := p.StreamManifest(context.Background(), &buf); err == nil {
+		t.Errorf("StreamManifest() = nil error for fewer entries than min-entries, want error")
+	}
+}
@@ -0,0 +1,135 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+)
+
+// schemaDiff describes how the fields of the entries of a manifest differ
+// from the fields of ManifestEntry.
+type schemaDiff struct {
+	// New are the fields of ManifestEntry that no old entry has.
+	New []string
+	// Removed are the old fields that ManifestEntry does not have.
+	Removed []string
+	// Renamed maps old fields to the fields of ManifestEntry whose names
+	// only differ from them in case and separators.
+	Renamed map[string]string
+}
+
+// readManifestSchema returns the sorted names of the fields that appear in
+// any entry of the JSON or YAML manifest at path, whatever its schema.
+func readManifestSchema(path string) ([]string, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if b, err = manifestJSON(b); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	var entries map[string]map[string]json.RawMessage
+	if err := json.Unmarshal(b, &entries); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	seen := map[string]bool{}
+	var fields []string
+	for _, e := range entries {
+		for field := range e {
+			if !seen[field] {
+				seen[field] = true
+				fields = append(fields, field)
+			}
+		}
+	}
+	sort.Strings(fields)
+	return fields, nil
+}
+
+// writeSchemaDiff writes the new, removed and renamed fields of d to w, one
+// per line.
+func writeSchemaDiff(w io.Writer, d *schemaDiff) error {
+	for _, f := range d.New {
+		if _, err := fmt.Fprintf(w, "new field: %s\n", f); err != nil {
+			return err
+		}
+	}
+	for _, f := range d.Removed {
+		if _, err := fmt.Fprintf(w, "removed field: %s\n", f); err != nil {
+			return err
+		}
+	}
+	var renamed []string
+	for from := range d.Renamed {
+		renamed = append(renamed, from)
+	}
+	sort.Strings(renamed)
+	for _, from := range renamed {
+		if _, err := fmt.Fprintf(w, "renamed field: %s -> %s\n", from, d.Renamed[from]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// diffSchema compares the old fields of a manifest with the fields of
+// ManifestEntry.
+func diffSchema(old []string) *schemaDiff {
+	current := manifestEntryFields()
+	inOld := map[string]bool{}
+	for _, f := range old {
+		inOld[f] = true
+	}
+	inCurrent := map[string]bool{}
+	for _, f := range current {
+		if f != "" {
+			inCurrent[f] = true
+		}
+	}
+	d := &schemaDiff{Renamed: map[string]string{}}
+	added := map[string]string{} // Key is the normalized field name.
+	for _, f := range current {
+		if f != "" && !inOld[f] {
+			added[normalizeFieldName(f)] = f
+		}
+	}
+	for _, f := range old {
+		if inCurrent[f] {
+			continue
+		}
+		if to, ok := added[normalizeFieldName(f)]; ok {
+			d.Renamed[f] = to
+			delete(added, normalizeFieldName(f))
+			continue
+		}
+		d.Removed = append(d.Removed, f)
+	}
+	for _, f := range added {
+		d.New = append(d.New, f)
+	}
+	sort.Strings(d.New)
+	return d
+}
+
+// normalizeFieldName lower cases name and drops its separators, so that for
+// example docsURL, docs-url and docs_url are the same.
+func normalizeFieldName(name string) string {
+	return strings.ToLower(strings.NewReplacer("_", "", "-", "").Replace(name))
+}
@@ -0,0 +1,91 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestDiffSchema(t *testing.T) {
+	old, err := readManifestSchema("testdata/manifest/old_schema.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := &schemaDiff{
+		New:     []string{"generator_version", "labels"},
+		Removed: []string{"requires_billing"},
+		Renamed: map[string]string{"docsURL": "docs_url"},
+	}
+	if diff := cmp.Diff(want, diffSchema(old)); diff != "" {
+		t.Errorf("diffSchema() mismatch (-want +got):\n%s", diff)
+	}
+
+	var fields []string
+	for _, f := range manifestEntryFields() {
+		if f != "" {
+			fields = append(fields, f)
+		}
+	}
+	current := diffSchema(fields)
+	if len(current.New)+len(current.Removed)+len(current.Renamed) != 0 {
+		t.Errorf("diffSchema() of the current fields = %+v, want no differences", current)
+	}
+
+	// A YAML manifest has the same schema.
+	b, err := os.ReadFile("testdata/manifest/old_schema.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b, err = jsonToYAML(b); err != nil {
+		t.Fatal(err)
+	}
+	yamlPath := filepath.Join(t.TempDir(), "old_schema.yaml")
+	if err := os.WriteFile(yamlPath, b, 0644); err != nil {
+		t.Fatal(err)
+	}
+	got, err := readManifestSchema(yamlPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(old, got); diff != "" {
+		t.Errorf("readManifestSchema() of the YAML manifest mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestWriteSchemaDiff(t *testing.T) {
+	d := &schemaDiff{
+		New:     []string{"generator_version", "labels"},
+		Removed: []string{"requires_billing"},
+		Renamed: map[string]string{"docsURL": "docs_url", "apiShortname": "api_shortname"},
+	}
+	var buf bytes.Buffer
+	if err := writeSchemaDiff(&buf, d); err != nil {
+		t.Fatal(err)
+	}
+	want := `new field: generator_version
+new field: labels
+removed field: requires_billing
+renamed field: apiShortname -> api_shortname
+renamed field: docsURL -> docs_url
+`
+	if diff := cmp.Diff(want, buf.String()); diff != "" {
+		t.Errorf("writeSchemaDiff() mismatch (-want +got):\n%s", diff)
+	}
+}
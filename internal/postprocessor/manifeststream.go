@@ -0,0 +1,214 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+)
+
+// StreamManifest computes the manifest entries one at a time in distribution
+// name order and writes each to w as soon as it is computed, so that only a
+// single entry and its service configs are held in memory. The output is the
+// same as that of Manifest except that the checks across entries, such as two
+// input directories generating the same entry or entries sharing a
+// description, are not made and handwritten clients are not discovered.
+// MinEntries is checked once every entry is written. Only manifests keyed by
+// distribution name can be streamed.
+func (p *postProcessor) StreamManifest(ctx context.Context, w io.Writer) error {
+	p.resetCaches()
+	if p.config.manifestKey() != distributionNameManifestKey {
+		return fmt.Errorf("streaming a manifest keyed by %s is not supported", p.config.manifestKey())
+	}
+	if p.config.format() != jsonManifestFormat {
+		return fmt.Errorf("streaming a manifest in %s format is not supported", p.config.format())
+	}
+	inputDirs := map[string]string{} // Key is the package name.
+	for inputDir, conf := range p.config.GoogleapisToImportPath {
+		if len(conf.ServiceConfig) > 0 {
+			inputDirs[normalizeImportPath(conf.ImportPath)] = inputDir
+		}
+	}
+	manual := map[string]*ManifestEntry{}
+	for _, m := range p.config.ManualClientInfo {
+		manual[m.DistributionName] = m
+	}
+	excluded := map[string]bool{}
+	for _, name := range p.config.ExcludeFromManifest {
+		excluded[name] = true
+	}
+	var names []string
+	for name := range inputDirs {
+		names = append(names, name)
+	}
+	for name := range manual {
+		if _, ok := inputDirs[name]; !ok {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	overrides, err := p.readManifestOverrides()
+	if err != nil {
+		return err
+	}
+	for name := range overrides {
+		if _, ok := manual[name]; !ok && inputDirs[name] == "" {
+			return fmt.Errorf("invalid overrides file %s: no entry for %q", p.overridesPath(), name)
+		}
+	}
+
+	bw := bufio.NewWriter(w)
+	sw := &manifestStreamWriter{w: bw, format: p.config.manifestFormat()}
+	for _, name := range names {
+		if name == "" || excluded[name] {
+			continue
+		}
+		entry, err := p.streamedManifestEntry(ctx, name, inputDirs[name], manual[name], overrides)
+		if err != nil {
+			return err
+		}
+		if err := sw.writeEntry(name, entry); err != nil {
+			return err
+		}
+		// The service configs of an entry are not needed for the next.
+		p.serviceConfigs.reset()
+	}
+	if err := sw.close(); err != nil {
+		return err
+	}
+	if err := bw.Flush(); err != nil {
+		return err
+	}
+	if sw.n < p.config.MinEntries {
+		return fmt.Errorf("computed %d manifest entries, want at least %d (min-entries)", sw.n, p.config.MinEntries)
+	}
+	return nil
+}
+
+// streamedManifestEntry computes the entry with the given distribution name
+// from the conf of inputDir, if any, and the manual entry m, if any, with its
+// agent marker, override, library metadata file and release level pin
+// applied, and checks it like Manifest does.
+func (p *postProcessor) streamedManifestEntry(ctx context.Context, name, inputDir string, m *ManifestEntry, overrides map[string]ManifestEntry) (ManifestEntry, error) {
+	var manual []*ManifestEntry
+	if m != nil {
+		manual = append(manual, m)
+	}
+	confs := map[string]*libraryInfo{}
+	if inputDir != "" {
+		confs[inputDir] = p.config.GoogleapisToImportPath[inputDir]
+	}
+	entries, err := p.manifestEntries(ctx, manual, confs)
+	if err != nil {
+		return ManifestEntry{}, err
+	}
+	p.config.applyLabels(entries)
+	if err := p.applyAgentMarkers(entries); err != nil {
+		return ManifestEntry{}, err
+	}
+	entry := entries[name]
+	if override, ok := overrides[name]; ok {
+		mergeManifestEntry(&entry, override)
+		entries[name] = entry
+	}
+	if err := p.applyLibraryMetadataFiles(entries); err != nil {
+		return ManifestEntry{}, err
+	}
+	if _, ok := entries[name]; ok {
+		p.pinReleaseLevel(entries, name)
+		entry = entries[name]
+	}
+	if err := p.validateLibraryTypes(entries); err != nil {
+		return ManifestEntry{}, err
+	}
+	if err := p.checkDescriptionLengths(entries); err != nil {
+		return ManifestEntry{}, err
+	}
+	p.checkPathSuffixLevels(entries)
+	if err := p.checkDocMarkerConflicts(entries); err != nil {
+		return ManifestEntry{}, err
+	}
+	if err := p.checkGeneratedDocsURLs(entries); err != nil {
+		return ManifestEntry{}, err
+	}
+	if err := p.checkBuilds(entries); err != nil {
+		return ManifestEntry{}, err
+	}
+	return entry, nil
+}
+
+// manifestStreamWriter writes manifest entries as the members of a JSON
+// object, formatted like writeManifest formats a map of entries.
+type manifestStreamWriter struct {
+	w      io.Writer
+	format manifestFormat
+	n      int
+}
+
+// writeEntry writes the entry as the next member of the object.
+func (sw *manifestStreamWriter) writeEntry(key string, entry ManifestEntry) error {
+	k, err := json.Marshal(key)
+	if err != nil {
+		return err
+	}
+	var value interface{} = entry
+	if sw.format.NullUnset {
+		value = nullUnsetEntry(entry)
+	}
+	v, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+	if sw.format.Naming == camelCaseNaming {
+		if v, err = renameObjectFields(v, snakeToCamel); err != nil {
+			return err
+		}
+	}
+	if !sw.format.Compact {
+		var buf bytes.Buffer
+		if err := json.Indent(&buf, v, "  ", "  "); err != nil {
+			return err
+		}
+		v = buf.Bytes()
+	}
+	sep, colon := ",", ":"
+	if sw.n == 0 {
+		sep = "{"
+	}
+	if !sw.format.Compact {
+		sep += "\n  "
+		colon += " "
+	}
+	sw.n++
+	_, err = fmt.Fprintf(sw.w, "%s%s%s%s", sep, k, colon, v)
+	return err
+}
+
+// close ends the object.
+func (sw *manifestStreamWriter) close() error {
+	end := "}\n"
+	if sw.n == 0 {
+		end = "{}\n"
+	} else if !sw.format.Compact {
+		end = "\n}\n"
+	}
+	_, err := io.WriteString(sw.w, end)
+	return err
+}
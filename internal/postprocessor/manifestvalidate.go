@@ -0,0 +1,548 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"go/parser"
+	"go/token"
+	"io/fs"
+	"net/url"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"cloud.google.com/go/internal/postprocessor/execv/gocmd"
+)
+
+// EntryError is a problem with a single field of a manifest entry.
+type EntryError struct {
+	Distribution string
+	// Field is the JSON name of the field with the problem.
+	Field  string
+	Reason string
+}
+
+func (e EntryError) Error() string {
+	return fmt.Sprintf("%s: %s: %s", e.Distribution, e.Field, e.Reason)
+}
+
+// ValidateEntries checks every field of every entry and returns all of the
+// problems found, sorted by distribution, field and reason.
+func (p *postProcessor) ValidateEntries(entries map[string]ManifestEntry) []EntryError {
+	allowed := map[libraryType]bool{}
+	for _, lt := range p.config.allowedLibraryTypes() {
+		allowed[lt] = true
+	}
+	var errs []EntryError
+	for name, e := range entries {
+		add := func(field, format string, v ...interface{}) {
+			errs = append(errs, EntryError{Distribution: name, Field: field, Reason: fmt.Sprintf(format, v...)})
+		}
+		if e.DistributionName != name {
+			add("distribution_name", "does not match the manifest key, got %q", e.DistributionName)
+		}
+		if e.Description == "" {
+			add("description", "is empty")
+		}
+		if !knownLanguages[e.Language] {
+			add("language", "unsupported language %q", e.Language)
+		}
+		if e.DocsURL == "" {
+			if !p.config.SkipDocsURL {
+				add("docs_url", "is empty")
+			}
+		} else {
+			if err := requireHTTPS(e.DocsURL); err != nil {
+				add("docs_url", "%v", err)
+			}
+			if !p.config.hasDocsURLOverride(name) {
+				for _, problem := range docsURLProblems(e.DocsURL, name, p.config.KeepModuleRootDocsURLSlash) {
+					add("docs_url", "%s", problem)
+				}
+			}
+		}
+		if !knownReleaseLevels[e.ReleaseLevel] && (e.ReleaseLevel != p.config.UnknownReleaseLevel || e.ReleaseLevel == "") {
+			add("release_level", "unknown release level %q", e.ReleaseLevel)
+		}
+		if !allowed[e.LibraryType] {
+			add("library_type", "disallowed library type %q", e.LibraryType)
+		}
+		if problem := libraryTypeInconsistency(e); problem != "" {
+			add("library_type", "is inconsistent: %s", problem)
+		}
+		if e.GraduationDate != "" {
+			if _, err := time.Parse(graduationDateLayout, e.GraduationDate); err != nil {
+				add("graduation_date", "%v", err)
+			}
+			if e.ReleaseLevel == "ga" {
+				add("graduation_date", "is set for a ga entry")
+			}
+		}
+		for i, l := range e.Labels {
+			if strings.TrimSpace(l) == "" {
+				add("labels", "has an empty label")
+			} else if i > 0 && l <= e.Labels[i-1] {
+				add("labels", "are not sorted and deduplicated")
+			}
+		}
+	}
+	sort.Slice(errs, func(i, j int) bool {
+		a, b := errs[i], errs[j]
+		if a.Distribution != b.Distribution {
+			return a.Distribution < b.Distribution
+		}
+		if a.Field != b.Field {
+			return a.Field < b.Field
+		}
+		return a.Reason < b.Reason
+	})
+	return errs
+}
+
+// DocParseErrors parses the whole doc.go of each configured library and
+// returns an error for each one that can not be read or is not a valid Go
+// file, sorted by import path. The release level detectors only scan the
+// text of doc.go, so a doc.go that does not parse usually means a generation
+// bug went unnoticed.
+func (p *postProcessor) DocParseErrors() ([]error, error) {
+	confs, err := p.withRelPaths(p.config.GoogleapisToImportPath)
+	if err != nil {
+		return nil, err
+	}
+	var sorted []*libraryInfo
+	for _, conf := range confs {
+		if len(conf.ServiceConfig) > 0 {
+			sorted = append(sorted, conf)
+		}
+	}
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].ImportPath < sorted[j].ImportPath })
+	var errs []error
+	fset := token.NewFileSet()
+	for _, conf := range sorted {
+		path := filepath.Join(p.googleCloudDir, conf.RelPath, "doc.go")
+		if _, err := parser.ParseFile(fset, path, nil, parser.ParseComments); err != nil {
+			errs = append(errs, fmt.Errorf("doc.go of %s: %v", conf.ImportPath, err))
+		}
+	}
+	return errs, nil
+}
+
+// validateLibraryTypes returns an error if any of the entries has a library
+// type that is not in the configured allowlist or that is inconsistent with
+// its client library type.
+func (p *postProcessor) validateLibraryTypes(entries map[string]ManifestEntry) error {
+	allowed := map[libraryType]bool{}
+	for _, lt := range p.config.allowedLibraryTypes() {
+		allowed[lt] = true
+	}
+	var bad, inconsistent []string
+	for _, e := range entries {
+		if !allowed[e.LibraryType] {
+			bad = append(bad, fmt.Sprintf("%s (%q)", e.DistributionName, e.LibraryType))
+		}
+		if problem := libraryTypeInconsistency(e); problem != "" {
+			inconsistent = append(inconsistent, fmt.Sprintf("%s (%s)", e.DistributionName, problem))
+		}
+	}
+	if len(bad) > 0 {
+		sort.Strings(bad)
+		return fmt.Errorf("entries with disallowed library types: %s", strings.Join(bad, ", "))
+	}
+	if len(inconsistent) > 0 {
+		sort.Strings(inconsistent)
+		return fmt.Errorf("entries with inconsistent library types: %s", strings.Join(inconsistent, ", "))
+	}
+	return nil
+}
+
+// libraryTypeInconsistency describes why the library type and client library
+// type of e do not make sense together, or returns "" if they do. A
+// GAPIC_AUTO library must be a generated client, but a generated client may
+// have another library type, such as AGENT.
+func libraryTypeInconsistency(e ManifestEntry) string {
+	if e.LibraryType == gapicAutoLibraryType && e.ClientLibraryType != "generated" {
+		return fmt.Sprintf("%s with client_library_type %q, want %q", e.LibraryType, e.ClientLibraryType, "generated")
+	}
+	return ""
+}
+
+// checkDescriptionLengths warns about each entry with a description longer
+// than the configured maximum, or returns an error naming them if
+// FailOnLongDescriptions is set.
+func (p *postProcessor) checkDescriptionLengths(entries map[string]ManifestEntry) error {
+	limit := p.config.maxDescriptionLength()
+	var long []string
+	for _, e := range entries {
+		if n := utf8.RuneCountInString(e.Description); n > limit {
+			long = append(long, fmt.Sprintf("%s (%d characters)", e.DistributionName, n))
+		}
+	}
+	if len(long) == 0 {
+		return nil
+	}
+	sort.Strings(long)
+	if p.config.FailOnLongDescriptions {
+		return fmt.Errorf("entries with descriptions longer than %d characters: %s", limit, strings.Join(long, ", "))
+	}
+	for _, l := range long {
+		p.warnf("description of %s is longer than %d characters", l, limit)
+	}
+	return nil
+}
+
+// sharedDescriptions returns the distribution names of the entries grouped by
+// description, for each description shared by more than limit entries.
+func sharedDescriptions(entries map[string]ManifestEntry, limit int) map[string][]string {
+	byDescription := map[string][]string{}
+	for name, e := range entries {
+		if e.Description != "" {
+			byDescription[e.Description] = append(byDescription[e.Description], name)
+		}
+	}
+	shared := map[string][]string{}
+	for description, names := range byDescription {
+		if len(names) > limit {
+			sort.Strings(names)
+			shared[description] = names
+		}
+	}
+	return shared
+}
+
+// checkSharedDescriptions warns about each description shared by more than
+// MaxEntriesPerDescription entries. It does nothing if MaxEntriesPerDescription is
+// not set.
+func (p *postProcessor) checkSharedDescriptions(entries map[string]ManifestEntry) {
+	if p.config.MaxEntriesPerDescription == 0 {
+		return
+	}
+	shared := sharedDescriptions(entries, p.config.MaxEntriesPerDescription)
+	var descriptions []string
+	for description := range shared {
+		descriptions = append(descriptions, description)
+	}
+	sort.Strings(descriptions)
+	for _, description := range descriptions {
+		names := shared[description]
+		p.warnf("description %q is shared by %d entries: %s", description, len(names), strings.Join(names, ", "))
+	}
+}
+
+// checkUnknownReleaseLevels warns about the entries that were given the
+// UnknownReleaseLevel placeholder because they have no stability signal.
+func (p *postProcessor) checkUnknownReleaseLevels(entries map[string]ManifestEntry) {
+	var unknown []string
+	for name := range entries {
+		if p.releaseLevelSources[name] == unknownSource {
+			unknown = append(unknown, name)
+		}
+	}
+	if len(unknown) == 0 {
+		return
+	}
+	sort.Strings(unknown)
+	p.warnf("%d entries have no stability signal and the placeholder release level %q: %s", len(unknown), p.config.UnknownReleaseLevel, strings.Join(unknown, ", "))
+}
+
+// checkGeneratedDocsURLs returns an error naming each generated entry with an
+// empty docs URL. A generated entry always has a docs URL unless resolving
+// its module failed, so this catches degraded module resolution. Manual
+// entries are not checked, and nothing is checked if docs URLs are skipped.
+func (p *postProcessor) checkGeneratedDocsURLs(entries map[string]ManifestEntry) error {
+	if p.config.SkipDocsURL {
+		return nil
+	}
+	var missing []string
+	for name, e := range entries {
+		if _, ok := p.generatedInputDirs[name]; ok && e.DocsURL == "" {
+			missing = append(missing, name)
+		}
+	}
+	if len(missing) > 0 {
+		sort.Strings(missing)
+		return fmt.Errorf("generated entries without a docs URL: %s", strings.Join(missing, ", "))
+	}
+	return nil
+}
+
+// checkSharedModuleDocsURLs warns about each manual entry that has the same
+// docs URL, ignoring case and a trailing slash, as a generated entry of the
+// same module, such as a handwritten wrapper and its generated client, or
+// returns an error naming them if FailOnDocsURLProblems is set. Only the
+// modules of the entries with a shared docs URL are resolved.
+func (p *postProcessor) checkSharedModuleDocsURLs(entries map[string]ManifestEntry) error {
+	byURL := map[string][]string{}
+	for name, e := range entries {
+		if e.DocsURL != "" {
+			key := strings.ToLower(strings.TrimSuffix(e.DocsURL, "/"))
+			byURL[key] = append(byURL[key], name)
+		}
+	}
+	var overlaps []string
+	for _, names := range byURL {
+		var manual, generated []string
+		for _, name := range names {
+			if _, ok := p.generatedInputDirs[name]; ok {
+				generated = append(generated, name)
+			} else {
+				manual = append(manual, name)
+			}
+		}
+		if len(manual) == 0 || len(generated) == 0 {
+			continue
+		}
+		sort.Strings(manual)
+		sort.Strings(generated)
+		for _, m := range manual {
+			relPath, err := p.libraryDir(m)
+			var mod string
+			if err == nil {
+				mod, err = p.moduleForDir(filepath.Join(p.googleCloudDir, relPath))
+			}
+			if err != nil {
+				p.warnf("unable to resolve module of %s, not checking its docs URL against generated entries: %v", m, err)
+				continue
+			}
+			for _, g := range generated {
+				genMod, err := p.moduleForDir(filepath.Join(p.googleCloudDir, p.relPaths[g]))
+				if err != nil {
+					return fmt.Errorf("unable to resolve module of %s: %v", g, err)
+				}
+				if genMod == mod {
+					overlaps = append(overlaps, fmt.Sprintf("manual entry %s and generated entry %s of module %s share the docs URL %s", m, g, mod, entries[g].DocsURL))
+				}
+			}
+		}
+	}
+	sort.Strings(overlaps)
+	if len(overlaps) > 0 && p.config.FailOnDocsURLProblems {
+		return fmt.Errorf("entries with overlapping docs URLs: %s", strings.Join(overlaps, "; "))
+	}
+	for _, o := range overlaps {
+		p.warnf("%s", o)
+	}
+	return nil
+}
+
+// checkPathSuffixLevels warns about each entry whose import path has an alpha
+// or beta version suffix that does not match its release level, such as an
+// apiv1beta1 package that is ga. This usually means a package was promoted
+// without moving it to a stable import path.
+func (p *postProcessor) checkPathSuffixLevels(entries map[string]ManifestEntry) {
+	var names []string
+	for name := range entries {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		importPath := name
+		if ip, ok := p.importPaths[name]; ok {
+			importPath = ip
+		}
+		suffixLevel, ok := pathSuffixLevel(importPath)
+		if !ok {
+			continue
+		}
+		if level := entries[name].ReleaseLevel; level != suffixLevel {
+			p.warnf("%s has release level %q but its import path %s has a %s version suffix", name, level, importPath, suffixLevel)
+		}
+	}
+}
+
+// checkDocMarkerConflicts reports each generated entry whose doc.go carries
+// an alpha or beta disclaimer that disagrees with the release level implied
+// by the version in its import path, such as an apiv1 package whose doc.go
+// still says it is beta. This usually means a promotion was half-completed.
+// Conflicts are warnings unless FailOnReleaseLevelConflicts is set.
+func (p *postProcessor) checkDocMarkerConflicts(entries map[string]ManifestEntry) error {
+	var names []string
+	for name := range entries {
+		if _, ok := p.relPaths[name]; ok {
+			names = append(names, name)
+		}
+	}
+	if len(names) == 0 {
+		return nil
+	}
+	sort.Strings(names)
+	alpha, err := p.alphaIndicator()
+	if err != nil {
+		return err
+	}
+	beta, err := p.betaIndicator()
+	if err != nil {
+		return err
+	}
+	var errs []error
+	for _, name := range names {
+		importPath := name
+		if ip, ok := p.importPaths[name]; ok {
+			importPath = ip
+		}
+		pathLevel, ok := importPathLevel(importPath)
+		if !ok {
+			continue
+		}
+		docPath := filepath.Join(p.googleCloudDir, p.relPaths[name], "doc.go")
+		start := time.Now()
+		docLevel, ok, err := docMarkerLevel(docPath, alpha, beta)
+		p.metrics.since(docScanPhase, start)
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if !ok || docLevel == pathLevel {
+			continue
+		}
+		if p.config.FailOnReleaseLevelConflicts {
+			errs = append(errs, fmt.Errorf("%s: doc.go says %s but its import path %s implies %s", name, docLevel, importPath, pathLevel))
+			continue
+		}
+		p.warnFilef(docPath, "%s: doc.go says %s but its import path %s implies %s", name, docLevel, importPath, pathLevel)
+	}
+	return errors.Join(errs...)
+}
+
+// checkBuilds returns an error naming each generated entry whose package
+// does not build, since its entry would be misleading. It does nothing
+// unless CheckBuilds is set.
+func (p *postProcessor) checkBuilds(entries map[string]ManifestEntry) error {
+	if !p.config.CheckBuilds {
+		return nil
+	}
+	build := p.goBuild
+	if build == nil {
+		build = gocmd.Build
+	}
+	var names []string
+	for name := range entries {
+		if _, ok := p.relPaths[name]; ok {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	var errs []error
+	for _, name := range names {
+		dir := filepath.Join(p.googleCloudDir, p.relPaths[name])
+		start := time.Now()
+		err := build(dir)
+		p.metrics.since(goCommandPhase, start)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("package of %s in %s does not build: %v", name, dir, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// uppercasePathProblems describes each segment of the package path of a
+// generated docs URL that is not lowercase, which the docs site does not
+// serve.
+func uppercasePathProblems(pkgPath string) []string {
+	var problems []string
+	for _, seg := range strings.Split(slashPath(pkgPath), "/") {
+		if seg != strings.ToLower(seg) {
+			problems = append(problems, fmt.Sprintf("package path segment %q is not lowercase", seg))
+		}
+	}
+	return problems
+}
+
+// validateManualDocsURLs returns an error for each manual entry with a
+// structurally malformed or non-https docs URL.
+func validateManualDocsURLs(manual []*ManifestEntry) error {
+	var errs []error
+	for _, m := range manual {
+		if m.DocsURLOverride != "" {
+			if err := requireHTTPS(m.DocsURLOverride); err != nil {
+				errs = append(errs, fmt.Errorf("manual entry %s: %v", m.DistributionName, err))
+			}
+			continue
+		}
+		if err := requireHTTPS(m.DocsURL); err != nil {
+			errs = append(errs, fmt.Errorf("manual entry %s: %v", m.DistributionName, err))
+		}
+		if problems := docsURLProblems(m.DocsURL, m.DistributionName, false); len(problems) > 0 {
+			errs = append(errs, fmt.Errorf("manual entry %s has malformed docs URL %s: %s", m.DistributionName, m.DocsURL, strings.Join(problems, "; ")))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// requireHTTPS returns an error if docsURL does not use the https scheme.
+func requireHTTPS(docsURL string) error {
+	u, err := url.Parse(docsURL)
+	if err != nil {
+		return err
+	}
+	if u.Scheme != "https" {
+		return fmt.Errorf("docs URL %s does not use https", docsURL)
+	}
+	return nil
+}
+
+// docsURLProblems reports structural problems with the docs URL of the
+// package with the given import path. It does not make any network requests,
+// so it only catches URLs that are obviously malformed. If keepRootSlash is
+// set, the URL of the root package of a module may end in /latest/.
+func docsURLProblems(docsURL, importPath string, keepRootSlash bool) []string {
+	u, err := url.Parse(docsURL)
+	if err != nil {
+		return []string{err.Error()}
+	}
+	var problems []string
+	if u.Host == "" {
+		problems = append(problems, "missing host")
+	}
+	if strings.Contains(u.Path, "//") {
+		problems = append(problems, "contains an empty path segment")
+	}
+	segs := strings.Split(strings.Trim(u.Path, "/"), "/")
+	ref, latest := -1, -1
+	for i, seg := range segs {
+		if seg == "reference" && ref < 0 {
+			ref = i
+		}
+		if seg == "latest" {
+			latest = i
+		}
+	}
+	if ref < 0 || latest < ref {
+		return append(problems, "missing reference/<module>/latest segments")
+	}
+	mod := strings.Join(segs[ref+1:latest], "/")
+	pkgPath := strings.Join(segs[latest+1:], "/")
+	if mod == "" {
+		problems = append(problems, "missing module segment")
+	} else if importPath != mod && !strings.HasPrefix(importPath, mod+"/") {
+		problems = append(problems, fmt.Sprintf("module segment %q is not a prefix of %s", mod, importPath))
+	}
+	if strings.HasSuffix(u.Path, "/latest/") && !(keepRootSlash && importPath == mod) {
+		problems = append(problems, "latest segment is followed by nothing")
+	}
+	if pkgPath == "" && mod != "" && importPath != mod {
+		problems = append(problems, "empty package path")
+	}
+	// The module was not trimmed from the import path of the package.
+	if mod != "" && (pkgPath == mod || strings.HasPrefix(pkgPath, mod+"/")) {
+		problems = append(problems, fmt.Sprintf("package path %q repeats the module path", pkgPath))
+	}
+	return problems
+}
@@ -0,0 +1,478 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestDocsURLProblems(t *testing.T) {
+	tests := []struct {
+		name       string
+		docsURL    string
+		importPath string
+		// keepRootSlash is passed to docsURLProblems.
+		keepRootSlash bool
+		want          []string
+	}{
+		{
+			name:       "valid",
+			docsURL:    "https://cloud.google.com/go/docs/reference/cloud.google.com/go/foo/latest/apiv1",
+			importPath: "cloud.google.com/go/foo/apiv1",
+		},
+		{
+			name:       "valid module root",
+			docsURL:    "https://cloud.google.com/go/docs/reference/cloud.google.com/go/foo/latest",
+			importPath: "cloud.google.com/go/foo",
+		},
+		{
+			name:       "empty package path",
+			docsURL:    "https://cloud.google.com/go/docs/reference/cloud.google.com/go/foo/latest",
+			importPath: "cloud.google.com/go/foo/apiv1",
+			want:       []string{"empty package path"},
+		},
+		{
+			name:       "double slash",
+			docsURL:    "https://cloud.google.com/go/docs/reference/cloud.google.com/go/foo/latest//apiv1",
+			importPath: "cloud.google.com/go/foo/apiv1",
+			want:       []string{"contains an empty path segment"},
+		},
+		{
+			name:       "missing module segment",
+			docsURL:    "https://cloud.google.com/go/docs/reference/latest/apiv1",
+			importPath: "cloud.google.com/go/foo/apiv1",
+			want:       []string{"missing module segment"},
+		},
+		{
+			name:       "wrong module segment",
+			docsURL:    "https://cloud.google.com/go/docs/reference/cloud.google.com/go/bar/latest/apiv1",
+			importPath: "cloud.google.com/go/foo/apiv1",
+			want:       []string{`module segment "cloud.google.com/go/bar" is not a prefix of cloud.google.com/go/foo/apiv1`},
+		},
+		{
+			name:       "latest followed by nothing",
+			docsURL:    "https://cloud.google.com/go/docs/reference/cloud.google.com/go/foo/latest/",
+			importPath: "cloud.google.com/go/foo",
+			want:       []string{"latest segment is followed by nothing"},
+		},
+		{
+			name:          "kept module root slash",
+			docsURL:       "https://cloud.google.com/go/docs/reference/cloud.google.com/go/foo/latest/",
+			importPath:    "cloud.google.com/go/foo",
+			keepRootSlash: true,
+		},
+		{
+			name:       "module path in package path",
+			docsURL:    "https://cloud.google.com/go/docs/reference/cloud.google.com/go/foo/latest/cloud.google.com/go/foo/apiv1",
+			importPath: "cloud.google.com/go/foo/apiv1",
+			want:       []string{`package path "cloud.google.com/go/foo/apiv1" repeats the module path`},
+		},
+		{
+			name:       "module root path as package path",
+			docsURL:    "https://cloud.google.com/go/docs/reference/cloud.google.com/go/foo/latest/cloud.google.com/go/foo",
+			importPath: "cloud.google.com/go/foo",
+			want:       []string{`package path "cloud.google.com/go/foo" repeats the module path`},
+		},
+		{
+			name:       "missing latest",
+			docsURL:    "https://cloud.google.com/go/docs/reference/cloud.google.com/go/foo/apiv1",
+			importPath: "cloud.google.com/go/foo/apiv1",
+			want:       []string{"missing reference/<module>/latest segments"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := docsURLProblems(tt.docsURL, tt.importPath, tt.keepRootSlash)
+			if diff := cmp.Diff(tt.want, got); diff != "" {
+				t.Errorf("docsURLProblems() mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestManifestAllowedLibraryTypes(t *testing.T) {
+	p := newTestManifestProcessor(t)
+	if _, err := p.Manifest(context.Background()); err != nil {
+		t.Fatalf("Manifest() = %v, want default allowlist to accept all known types", err)
+	}
+
+	p.config.ManualClientInfo[0].LibraryType = "GAPIC_MANAUL"
+	if _, err := p.Manifest(context.Background()); err == nil || !strings.Contains(err.Error(), "cloud.google.com/go/baz") {
+		t.Errorf("Manifest() = %v, want error naming the entry with a typo'd library type", err)
+	}
+
+	p.config.ManualClientInfo[0].LibraryType = gapicManualLibraryType
+	p.config.AllowedLibraryTypes = []libraryType{gapicAutoLibraryType}
+	if _, err := p.Manifest(context.Background()); err == nil {
+		t.Errorf("Manifest() = nil error for type outside of allowlist, want error")
+	}
+}
+
+func TestManifestInconsistentLibraryTypes(t *testing.T) {
+	p := newTestManifestProcessor(t)
+	p.config.ManualClientInfo[0].LibraryType = gapicAutoLibraryType
+	_, err := p.Manifest(context.Background())
+	if err == nil || !strings.Contains(err.Error(), "inconsistent library types") || !strings.Contains(err.Error(), "cloud.google.com/go/baz") {
+		t.Fatalf("Manifest() = %v, want error naming the GAPIC_AUTO manual client", err)
+	}
+
+	entries := map[string]ManifestEntry{
+		"cloud.google.com/go/baz": {
+			DistributionName:  "cloud.google.com/go/baz",
+			Description:       "Baz API",
+			Language:          "Go",
+			ClientLibraryType: "manual",
+			DocsURL:           "https://cloud.google.com/go/docs/reference/cloud.google.com/go/baz/latest",
+			LibraryType:       gapicAutoLibraryType,
+			ReleaseLevel:      "ga",
+		},
+	}
+	want := []EntryError{
+		{"cloud.google.com/go/baz", "library_type", `is inconsistent: GAPIC_AUTO with client_library_type "manual", want "generated"`},
+	}
+	if diff := cmp.Diff(want, p.ValidateEntries(entries)); diff != "" {
+		t.Errorf("ValidateEntries() mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestManifestSharedModuleDocsURL(t *testing.T) {
+	p := newTestManifestProcessor(t)
+	const fooURL = "https://cloud.google.com/go/docs/reference/cloud.google.com/go/foo/latest/apiv1"
+	// A handwritten wrapper in the module of the generated foo/apiv1.
+	p.config.ManualClientInfo = append(p.config.ManualClientInfo, &ManifestEntry{
+		DistributionName:  "cloud.google.com/go/foo",
+		Description:       "Foo",
+		Language:          "Go",
+		ClientLibraryType: "manual",
+		DocsURL:           fooURL + "/",
+		ReleaseLevel:      "ga",
+		LibraryType:       gapicManualLibraryType,
+	})
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	defer log.SetOutput(os.Stderr)
+	if _, err := p.Manifest(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	want := "manual entry cloud.google.com/go/foo and generated entry cloud.google.com/go/foo/apiv1 of module cloud.google.com/go/foo share the docs URL " + fooURL
+	if !strings.Contains(buf.String(), want) {
+		t.Errorf("Manifest() logged:\n%s\nwant %q", buf.String(), want)
+	}
+
+	p.config.FailOnDocsURLProblems = true
+	if _, err := p.Manifest(context.Background()); err == nil || !strings.Contains(err.Error(), want) {
+		t.Errorf("Manifest() = %v, want error containing %q", err, want)
+	}
+}
+
+func TestManifestManualDocsURL(t *testing.T) {
+	p := newTestManifestProcessor(t)
+	p.config.ManualClientInfo = append(p.config.ManualClientInfo, &ManifestEntry{
+		DistributionName: "cloud.google.com/go/legacy",
+		Description:      "Legacy",
+		DocsURL:          "https://cloud.google.com/go/docs/reference/cloud.google.com/go/legacy/lastest",
+		ReleaseLevel:     "ga",
+		LibraryType:      gapicManualLibraryType,
+	})
+	_, err := p.Manifest(context.Background())
+	if err == nil || !strings.Contains(err.Error(), "cloud.google.com/go/legacy") {
+		t.Fatalf("Manifest() = %v, want error naming the manual entry with a malformed docs URL", err)
+	}
+	if strings.Contains(err.Error(), "cloud.google.com/go/baz") {
+		t.Errorf("Manifest() = %v, want no error for the well-formed manual entry", err)
+	}
+}
+
+func TestManifestManualDocsURLHTTP(t *testing.T) {
+	p := newTestManifestProcessor(t)
+	p.config.ManualClientInfo[0].DocsURL = "http://cloud.google.com/go/docs/reference/cloud.google.com/go/baz/latest"
+	if _, err := p.Manifest(context.Background()); err == nil || !strings.Contains(err.Error(), "does not use https") {
+		t.Errorf("Manifest() = %v, want error for http docs URL", err)
+	}
+	if err := requireHTTPS("https://cloud.google.com/go/docs/reference/cloud.google.com/go/baz/latest"); err != nil {
+		t.Errorf("requireHTTPS() = %v, want nil for https docs URL", err)
+	}
+
+	entries := map[string]ManifestEntry{"cloud.google.com/go/baz": *p.config.ManualClientInfo[0]}
+	want := []EntryError{
+		{"cloud.google.com/go/baz", "docs_url", "docs URL http://cloud.google.com/go/docs/reference/cloud.google.com/go/baz/latest does not use https"},
+	}
+	if diff := cmp.Diff(want, p.ValidateEntries(entries)); diff != "" {
+		t.Errorf("ValidateEntries() mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestValidateEntries(t *testing.T) {
+	p := newTestManifestProcessor(t)
+	entries, err := p.manifestEntries(context.Background(), p.config.ManualClientInfo, p.config.GoogleapisToImportPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if errs := p.ValidateEntries(entries); len(errs) > 0 {
+		t.Fatalf("ValidateEntries() = %v, want no errors for computed entries", errs)
+	}
+
+	baz := entries["cloud.google.com/go/baz"]
+	baz.ReleaseLevel = "stable"
+	baz.DocsURL = "https://cloud.google.com/go/docs/reference/cloud.google.com/go/other/latest"
+	entries["cloud.google.com/go/baz"] = baz
+	foo := entries["cloud.google.com/go/foo/apiv1"]
+	foo.Description = ""
+	foo.Language = "Golang"
+	foo.LibraryType = "GAPIC_MANAUL"
+	entries["cloud.google.com/go/foo/apiv1"] = foo
+	entries["cloud.google.com/go/misnamed"] = entries["cloud.google.com/go/bar/apiv1"]
+
+	want := []EntryError{
+		{"cloud.google.com/go/baz", "docs_url", "empty package path"},
+		{"cloud.google.com/go/baz", "docs_url", `module segment "cloud.google.com/go/other" is not a prefix of cloud.google.com/go/baz`},
+		{"cloud.google.com/go/baz", "release_level", `unknown release level "stable"`},
+		{"cloud.google.com/go/foo/apiv1", "description", "is empty"},
+		{"cloud.google.com/go/foo/apiv1", "language", `unsupported language "Golang"`},
+		{"cloud.google.com/go/foo/apiv1", "library_type", `disallowed library type "GAPIC_MANAUL"`},
+		{"cloud.google.com/go/misnamed", "distribution_name", `does not match the manifest key, got "cloud.google.com/go/bar/apiv1"`},
+		{"cloud.google.com/go/misnamed", "docs_url", `module segment "cloud.google.com/go/bar" is not a prefix of cloud.google.com/go/misnamed`},
+	}
+	if diff := cmp.Diff(want, p.ValidateEntries(entries)); diff != "" {
+		t.Errorf("ValidateEntries() mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestManifestLongDescription(t *testing.T) {
+	p := newTestManifestProcessor(t)
+	title := strings.Repeat("Bar ", 60) + "API"
+	writeTestFiles(t, p.googleapisDir, map[string]string{
+		"google/cloud/bar/v1/bar_v1.yaml": "type: google.api.Service\ntitle: " + title + "\n",
+	})
+
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	defer log.SetOutput(os.Stderr)
+	if _, err := p.Manifest(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if got := buf.String(); !strings.Contains(got, "description of cloud.google.com/go/bar/apiv1 (243 characters) is longer than 200 characters") {
+		t.Errorf("Manifest() logged %q, want a warning naming cloud.google.com/go/bar/apiv1", got)
+	}
+	if strings.Contains(buf.String(), "cloud.google.com/go/foo/apiv1 (") {
+		t.Errorf("Manifest() warned about the short description of cloud.google.com/go/foo/apiv1")
+	}
+
+	p.config.MaxDescriptionLength = len(title) + 1
+	buf.Reset()
+	if _, err := p.Manifest(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(buf.String(), "longer than") {
+		t.Errorf("Manifest() logged %q, want no warning below the configured maximum", buf.String())
+	}
+
+	p.config.MaxDescriptionLength = 0
+	p.config.FailOnLongDescriptions = true
+	if _, err := p.Manifest(context.Background()); err == nil || !strings.Contains(err.Error(), "cloud.google.com/go/bar/apiv1") {
+		t.Errorf("Manifest() = %v, want an error naming cloud.google.com/go/bar/apiv1", err)
+	}
+}
+
+func TestManifestSharedDescriptions(t *testing.T) {
+	p := newTestManifestProcessor(t)
+	writeTestFiles(t, p.googleapisDir, map[string]string{
+		"google/cloud/foo/v1/foo_v1.yaml":         "type: google.api.Service\ntitle: Google API\n",
+		"google/cloud/bar/v1/bar_v1.yaml":         "type: google.api.Service\ntitle: Google API\n",
+		"google/cloud/qux/v1beta/qux_v1beta.yaml": "type: google.api.Service\ntitle: Google API\n",
+	})
+	p.config.MaxEntriesPerDescription = 2
+
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	defer log.SetOutput(os.Stderr)
+	entries, err := p.Manifest(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `description "Google API" is shared by 3 entries: cloud.google.com/go/bar/apiv1, cloud.google.com/go/foo/apiv1, cloud.google.com/go/qux/apiv1beta`
+	if !strings.Contains(buf.String(), want) {
+		t.Errorf("Manifest() logged:\n%s\nwant %q", buf.String(), want)
+	}
+	if strings.Contains(buf.String(), `description "Baz"`) {
+		t.Errorf("Manifest() reported a unique description, got:\n%s", buf.String())
+	}
+
+	if got := sharedDescriptions(entries, 3); len(got) != 0 {
+		t.Errorf("sharedDescriptions(3) = %v, want none", got)
+	}
+}
+
+func TestCheckPathSuffixLevels(t *testing.T) {
+	tests := []struct {
+		name       string
+		importPath string
+		level      string
+		wantWarn   bool
+	}{
+		{name: "beta suffix beta", importPath: "cloud.google.com/go/foo/apiv1beta1", level: "beta"},
+		{name: "alpha suffix alpha", importPath: "cloud.google.com/go/foo/apiv2alpha", level: "alpha"},
+		{name: "no suffix ga", importPath: "cloud.google.com/go/foo/apiv1", level: "ga"},
+		{name: "no suffix beta", importPath: "cloud.google.com/go/foo/apiv1", level: "beta"},
+		{name: "beta suffix ga", importPath: "cloud.google.com/go/foo/apiv1beta1", level: "ga", wantWarn: true},
+		{name: "alpha suffix beta", importPath: "cloud.google.com/go/foo/apiv1p1alpha1", level: "beta", wantWarn: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			log.SetOutput(&buf)
+			defer log.SetOutput(os.Stderr)
+			p := &postProcessor{config: &config{}}
+			p.checkPathSuffixLevels(map[string]ManifestEntry{
+				tt.importPath: {DistributionName: tt.importPath, ReleaseLevel: tt.level},
+			})
+			if got := strings.Contains(buf.String(), "version suffix"); got != tt.wantWarn {
+				t.Errorf("checkPathSuffixLevels() logged %q, want warning %v", buf.String(), tt.wantWarn)
+			}
+		})
+	}
+}
+
+func TestManifestDocMarkerConflict(t *testing.T) {
+	p := newTestManifestProcessor(t)
+	// bar/apiv1 is ga by its import path, but its doc.go says it is beta.
+	// The doc.go of qux/apiv1beta agrees with its import path.
+	writeTestFiles(t, p.googleCloudDir, map[string]string{"qux/apiv1beta/doc.go": testDocBeta})
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	defer log.SetOutput(os.Stderr)
+	if _, err := p.Manifest(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	want := "cloud.google.com/go/bar/apiv1: doc.go says beta but its import path cloud.google.com/go/bar/apiv1 implies ga"
+	if !strings.Contains(buf.String(), want) {
+		t.Errorf("Manifest() logged %q, want warning %q", buf.String(), want)
+	}
+	for _, name := range []string{"foo/apiv1", "qux/apiv1beta"} {
+		if strings.Contains(buf.String(), name+": doc.go says") {
+			t.Errorf("Manifest() logged %q, want no conflict for %s", buf.String(), name)
+		}
+	}
+
+	p.config.FailOnReleaseLevelConflicts = true
+	if _, err := p.Manifest(context.Background()); err == nil || !strings.Contains(err.Error(), want) {
+		t.Errorf("Manifest() = %v, want error containing %q", err, want)
+	}
+}
+
+func TestManifestCheckBuilds(t *testing.T) {
+	p := newTestManifestProcessor(t)
+	p.config.CheckBuilds = true
+	var built []string
+	p.goBuild = func(dir string) error {
+		rel, err := filepath.Rel(p.googleCloudDir, dir)
+		if err != nil {
+			return err
+		}
+		built = append(built, filepath.ToSlash(rel))
+		if rel == filepath.FromSlash("bar/apiv1") {
+			return errors.New("exit status 1")
+		}
+		return nil
+	}
+	_, err := p.Manifest(context.Background())
+	want := "package of cloud.google.com/go/bar/apiv1 in " + filepath.Join(p.googleCloudDir, "bar/apiv1") + " does not build: exit status 1"
+	if err == nil || err.Error() != want {
+		t.Errorf("Manifest() = %v, want error %q", err, want)
+	}
+	// Manual entries are not built.
+	if diff := cmp.Diff([]string{"bar/apiv1", "foo/apiv1", "qux/apiv1beta"}, built); diff != "" {
+		t.Errorf("built directories mismatch (-want +got):\n%s", diff)
+	}
+
+	p.config.CheckBuilds = false
+	built = nil
+	if _, err := p.Manifest(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if len(built) > 0 {
+		t.Errorf("Manifest() built %v, want no builds without check-builds", built)
+	}
+}
+
+func TestManifestPathSuffixLevelMismatch(t *testing.T) {
+	p := newTestManifestProcessor(t)
+	// A stability file takes precedence over the path suffix.
+	writeTestFiles(t, p.googleCloudDir, map[string]string{"qux/apiv1beta/" + defaultDetection.StabilityFile: "ga"})
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	defer log.SetOutput(os.Stderr)
+	if _, err := p.Manifest(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if want := `cloud.google.com/go/qux/apiv1beta has release level "ga" but its import path cloud.google.com/go/qux/apiv1beta has a beta version suffix`; !strings.Contains(buf.String(), want) {
+		t.Errorf("Manifest() logged %q, want %q", buf.String(), want)
+	}
+}
+
+func TestDocParseErrors(t *testing.T) {
+	p := newTestManifestProcessor(t)
+	errs, err := p.DocParseErrors()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(errs) != 0 {
+		t.Errorf("DocParseErrors() = %v, want none", errs)
+	}
+
+	writeTestFiles(t, p.googleCloudDir, map[string]string{
+		"foo/apiv1/doc.go": testDocGA + "\nfunc broken( {\n",
+	})
+	if errs, err = p.DocParseErrors(); err != nil {
+		t.Fatal(err)
+	}
+	if len(errs) != 1 || !strings.Contains(errs[0].Error(), "doc.go of cloud.google.com/go/foo/apiv1") {
+		t.Errorf("DocParseErrors() = %v, want an error for cloud.google.com/go/foo/apiv1", errs)
+	}
+}
+
+func TestCheckGeneratedDocsURLs(t *testing.T) {
+	p := newTestManifestProcessor(t)
+	entries, err := p.Manifest(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := p.checkGeneratedDocsURLs(entries); err != nil {
+		t.Fatalf("checkGeneratedDocsURLs() = %v, want nil", err)
+	}
+
+	generated := entries["cloud.google.com/go/foo/apiv1"]
+	generated.DocsURL = ""
+	entries["cloud.google.com/go/foo/apiv1"] = generated
+	manual := entries["cloud.google.com/go/baz"]
+	manual.DocsURL = ""
+	entries["cloud.google.com/go/baz"] = manual
+	err = p.checkGeneratedDocsURLs(entries)
+	if err == nil || !strings.Contains(err.Error(), "cloud.google.com/go/foo/apiv1") {
+		t.Fatalf("checkGeneratedDocsURLs() = %v, want error naming cloud.google.com/go/foo/apiv1", err)
+	}
+	if strings.Contains(err.Error(), "cloud.google.com/go/baz") {
+		t.Errorf("checkGeneratedDocsURLs() = %v, want no error for the manual entry", err)
+	}
+}
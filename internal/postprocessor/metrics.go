@@ -0,0 +1,82 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// manifestMetrics are the time spent in the phases of the most recent
+// manifest computation, to find out which of them are worth speeding up.
+// Phases that run concurrently add up the time of each call, so their sum can
+// exceed the total.
+type manifestMetrics struct {
+	mu sync.Mutex
+
+	// Total is the time of the whole manifest computation.
+	Total time.Duration
+	// ServiceConfigDecode is the time spent decoding service configs.
+	ServiceConfigDecode time.Duration
+	// GoCommand is the time spent in calls of the go command, resolving
+	// modules and building packages.
+	GoCommand time.Duration
+	// DocScan is the time spent scanning doc.go files for release level
+	// disclaimers.
+	DocScan time.Duration
+}
+
+// reset zeroes every phase of m.
+func (m *manifestMetrics) reset() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.Total, m.ServiceConfigDecode, m.GoCommand, m.DocScan = 0, 0, 0, 0
+}
+
+// metricsPhase is a phase of a manifest computation that is timed.
+type metricsPhase int
+
+const (
+	serviceConfigDecodePhase metricsPhase = iota
+	goCommandPhase
+	docScanPhase
+)
+
+// since adds the time since start to the given phase of m. It does nothing if
+// m is nil, so that callers need not check whether metrics are collected.
+func (m *manifestMetrics) since(phase metricsPhase, start time.Time) {
+	if m == nil {
+		return
+	}
+	d := time.Since(start)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	switch phase {
+	case serviceConfigDecodePhase:
+		m.ServiceConfigDecode += d
+	case goCommandPhase:
+		m.GoCommand += d
+	case docScanPhase:
+		m.DocScan += d
+	}
+}
+
+// String returns a one line summary of the phases of m.
+func (m *manifestMetrics) String() string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return fmt.Sprintf("total %v, service config decode %v, go command %v, doc.go scans %v", m.Total, m.ServiceConfigDecode, m.GoCommand, m.DocScan)
+}
@@ -0,0 +1,73 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"log"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestManifestMetrics(t *testing.T) {
+	p := newTestManifestProcessor(t)
+	p.metrics = &manifestMetrics{}
+	p.config.CheckBuilds = true
+	p.goBuild = func(dir string) error {
+		time.Sleep(time.Millisecond)
+		return nil
+	}
+	// Resolve modules without the go command, whose run time would make the
+	// go command time of the two computations below incomparable.
+	p.goCurrentMod = goModFileModule
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	defer log.SetOutput(os.Stderr)
+	if _, err := p.Manifest(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	m := p.metrics
+	for _, phase := range []struct {
+		name string
+		d    time.Duration
+	}{
+		{"ServiceConfigDecode", m.ServiceConfigDecode},
+		{"GoCommand", m.GoCommand},
+		{"DocScan", m.DocScan},
+	} {
+		if phase.d <= 0 {
+			t.Errorf("%s = %v, want positive", phase.name, phase.d)
+		}
+		if phase.d > m.Total {
+			t.Errorf("%s = %v, want at most the total %v", phase.name, phase.d, m.Total)
+		}
+	}
+	if want := "manifest metrics: total "; !strings.Contains(buf.String(), want) {
+		t.Errorf("log = %q, want summary containing %q", buf.String(), want)
+	}
+
+	// A second computation starts from zero.
+	goCommand := m.GoCommand
+	p.config.CheckBuilds = false
+	if _, err := p.Manifest(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if m.GoCommand >= goCommand {
+		t.Errorf("GoCommand = %v after a computation without builds, want less than the previous %v", m.GoCommand, goCommand)
+	}
+}
@@ -0,0 +1,119 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/internal/postprocessor/execv/gocmd"
+)
+
+// Module resolvers that may be configured as ModuleResolver.
+const (
+	// goModuleResolver asks the go command for the current module.
+	goModuleResolver = "go"
+	// goModFileResolver reads the nearest go.mod file directly, which does
+	// not require the Go toolchain.
+	goModFileResolver = "go-mod-file"
+	// fallbackModuleResolver uses goModuleResolver and falls back to
+	// goModFileResolver if the go command fails.
+	fallbackModuleResolver = "fallback"
+)
+
+// currentMod returns the path of the module containing dir using the
+// configured module resolver.
+func (p *postProcessor) currentMod(dir string) (string, error) {
+	currentMod := p.goCurrentMod
+	if currentMod == nil {
+		currentMod = gocmd.CurrentMod
+	}
+	goCurrentMod := func(dir string) (string, error) {
+		defer p.metrics.since(goCommandPhase, time.Now())
+		return currentMod(dir)
+	}
+	switch p.config.moduleResolver() {
+	case goModFileResolver:
+		return goModFileModule(dir)
+	case fallbackModuleResolver:
+		mod, err := goCurrentMod(dir)
+		if err == nil {
+			return mod, nil
+		}
+		mod, fileErr := goModFileModule(dir)
+		if fileErr != nil {
+			return "", fmt.Errorf("go command: %v; go.mod: %v", err, fileErr)
+		}
+		return mod, nil
+	default:
+		return goCurrentMod(dir)
+	}
+}
+
+// goModFileModule returns the module path declared by the nearest go.mod file
+// in dir or one of its parents.
+func goModFileModule(dir string) (string, error) {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return "", err
+	}
+	d := dir
+	for {
+		b, err := os.ReadFile(filepath.Join(d, "go.mod"))
+		if err == nil {
+			mod := modulePath(b)
+			if mod == "" {
+				return "", fmt.Errorf("%s: no module directive", filepath.Join(d, "go.mod"))
+			}
+			return mod, nil
+		} else if !errors.Is(err, fs.ErrNotExist) {
+			return "", err
+		}
+		parent := filepath.Dir(d)
+		if parent == d {
+			return "", fmt.Errorf("no go.mod found in %s or any parent directory", dir)
+		}
+		d = parent
+	}
+}
+
+// modulePath returns the path in the module directive of the go.mod file
+// contents b, or "" if there is none.
+func modulePath(b []byte) string {
+	s := bufio.NewScanner(bytes.NewReader(b))
+	for s.Scan() {
+		line := s.Text()
+		if i := strings.Index(line, "//"); i >= 0 {
+			line = line[:i]
+		}
+		fields := strings.Fields(line)
+		if len(fields) != 2 || fields[0] != "module" {
+			continue
+		}
+		if mod, err := strconv.Unquote(fields[1]); err == nil {
+			return mod
+		}
+		return fields[1]
+	}
+	return ""
+}
@@ -0,0 +1,109 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+)
+
+func TestGoModFileModule(t *testing.T) {
+	root := t.TempDir()
+	writeTestFiles(t, root, map[string]string{
+		"go.mod":          "module example.com/root\n\ngo 1.20\n",
+		"a/go.mod":        "// Package a.\nmodule \"example.com/root/a\" // a comment\n\ngo 1.20\n",
+		"a/b/c/doc.go":    "package c\n",
+		"d/doc.go":        "package d\n",
+		"nomodule/go.mod": "go 1.20\n",
+	})
+	tests := []struct {
+		dir     string
+		want    string
+		wantErr bool
+	}{
+		{dir: ".", want: "example.com/root"},
+		{dir: "a", want: "example.com/root/a"},
+		{dir: "a/b/c", want: "example.com/root/a"},
+		{dir: "d", want: "example.com/root"},
+		{dir: "nomodule", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.dir, func(t *testing.T) {
+			got, err := goModFileModule(filepath.Join(root, tt.dir))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("goModFileModule() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("goModFileModule() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestManifestGoModFileResolver(t *testing.T) {
+	for _, resolver := range []string{goModuleResolver, goModFileResolver, fallbackModuleResolver} {
+		t.Run(resolver, func(t *testing.T) {
+			p := newTestManifestProcessor(t)
+			p.config.ModuleResolver = resolver
+			got, err := p.ManifestForInput(context.Background(), "google/cloud/foo/v1")
+			if err != nil {
+				t.Fatal(err)
+			}
+			const wantURL = "https://cloud.google.com/go/docs/reference/cloud.google.com/go/foo/latest/apiv1"
+			if url := got["cloud.google.com/go/foo/apiv1"].DocsURL; url != wantURL {
+				t.Errorf("DocsURL = %q, want %q", url, wantURL)
+			}
+		})
+	}
+}
+
+func TestManifestNestedSubmodule(t *testing.T) {
+	for _, resolver := range []string{goModuleResolver, goModFileResolver} {
+		t.Run(resolver, func(t *testing.T) {
+			p := newTestManifestProcessor(t)
+			p.config.ModuleResolver = resolver
+			writeTestFiles(t, p.googleCloudDir, map[string]string{
+				"foo/admin/go.mod":       "module cloud.google.com/go/foo/admin\n\ngo 1.20\n",
+				"foo/admin/apiv1/doc.go": testDocGA,
+			})
+			writeTestFiles(t, p.googleapisDir, map[string]string{
+				"google/cloud/foo/admin/v1/admin_v1.yaml": "type: google.api.Service\ntitle: Foo Admin API\n",
+			})
+			p.config.GoogleapisToImportPath["google/cloud/foo/admin/v1"] = &libraryInfo{
+				ImportPath:    "cloud.google.com/go/foo/admin/apiv1",
+				ServiceConfig: serviceConfigList{"admin_v1.yaml"},
+				RelPath:       "/foo/admin/apiv1",
+			}
+			got, err := p.ManifestForInput(context.Background(), "google/cloud/foo/admin/v1")
+			if err != nil {
+				t.Fatal(err)
+			}
+			const wantURL = "https://cloud.google.com/go/docs/reference/cloud.google.com/go/foo/admin/latest/apiv1"
+			if url := got["cloud.google.com/go/foo/admin/apiv1"].DocsURL; url != wantURL {
+				t.Errorf("DocsURL = %q, want %q", url, wantURL)
+			}
+
+			// A submodule path that shares a prefix with the import path
+			// but is not an element of it does not contain the package.
+			writeTestFiles(t, p.googleCloudDir, map[string]string{
+				"foo/admin/go.mod": "module cloud.google.com/go/foo/adm\n\ngo 1.20\n",
+			})
+			if _, err := p.ManifestForInput(context.Background(), "google/cloud/foo/admin/v1"); err == nil {
+				t.Errorf("ManifestForInput() = nil error for a package outside of its module, want error")
+			}
+		})
+	}
+}
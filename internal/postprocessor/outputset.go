@@ -0,0 +1,157 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+// outputSet is a set of output files that are replaced together. The contents
+// of every file are staged in memory, and commit only replaces the files on
+// disk if all of them can be written, so a failure never leaves a mix of old
+// and new outputs.
+type outputSet struct {
+	paths    []string
+	contents map[string][]byte
+	// rename, if set, replaces os.Rename.
+	rename func(oldpath, newpath string) error
+}
+
+// add stages b as the contents of the file at path. It is an error to stage
+// a path more than once, since one output would silently replace another.
+func (s *outputSet) add(path string, b []byte) error {
+	path = filepath.Clean(path)
+	if _, ok := s.contents[path]; ok {
+		return fmt.Errorf("%s is written by more than one manifest output", path)
+	}
+	if s.contents == nil {
+		s.contents = map[string][]byte{}
+	}
+	s.paths = append(s.paths, path)
+	s.contents[path] = b
+	return nil
+}
+
+// addFunc stages the output of write as the contents of the file at path.
+func (s *outputSet) addFunc(path string, write func(w io.Writer) error) error {
+	var buf bytes.Buffer
+	if err := write(&buf); err != nil {
+		return fmt.Errorf("writing %s: %v", path, err)
+	}
+	return s.add(path, buf.Bytes())
+}
+
+// commit writes each staged file to a temporary file next to it, creating its
+// directory if needed, and then renames the temporary files into place, in
+// the order they were added. If writing or renaming any file fails, the files
+// that were already replaced are restored and the previous outputs are left as
+// they were.
+func (s *outputSet) commit() error {
+	rename := s.rename
+	if rename == nil {
+		rename = os.Rename
+	}
+	temps := make(map[string]string, len(s.paths))
+	defer func() {
+		for _, tmp := range temps {
+			os.Remove(tmp)
+		}
+	}()
+	for _, path := range s.paths {
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			return err
+		}
+		tmp, err := writeTempFile(path, s.contents[path])
+		if err != nil {
+			return err
+		}
+		temps[path] = tmp
+	}
+
+	// replaced records each file that was renamed into place, along with the
+	// backup of the file it replaced, which is empty if there was none.
+	type replacement struct{ path, backup string }
+	var replaced []replacement
+	rollback := func(err error) error {
+		errs := []error{err}
+		for i := len(replaced) - 1; i >= 0; i-- {
+			r := replaced[i]
+			var rerr error
+			if r.backup != "" {
+				rerr = rename(r.backup, r.path)
+			} else {
+				rerr = os.Remove(r.path)
+			}
+			if rerr != nil {
+				errs = append(errs, fmt.Errorf("restoring %s: %v", r.path, rerr))
+			}
+		}
+		return errors.Join(errs...)
+	}
+	for _, path := range s.paths {
+		var backup string
+		if _, err := os.Lstat(path); err == nil {
+			backup = temps[path] + ".old"
+			if err := rename(path, backup); err != nil {
+				return rollback(err)
+			}
+		} else if !errors.Is(err, fs.ErrNotExist) {
+			return rollback(err)
+		}
+		if err := rename(temps[path], path); err != nil {
+			if backup != "" {
+				if rerr := rename(backup, path); rerr != nil {
+					err = errors.Join(err, fmt.Errorf("restoring %s: %v", path, rerr))
+				}
+			}
+			return rollback(err)
+		}
+		delete(temps, path)
+		replaced = append(replaced, replacement{path, backup})
+	}
+	for _, r := range replaced {
+		if r.backup != "" {
+			os.Remove(r.backup)
+		}
+	}
+	return nil
+}
+
+// writeTempFile writes b to a new temporary file in the directory of path and
+// returns the name of the temporary file.
+func writeTempFile(path string, b []byte) (string, error) {
+	f, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".tmp-")
+	if err != nil {
+		return "", err
+	}
+	_, err = f.Write(b)
+	if err == nil {
+		err = f.Chmod(0644)
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return "", err
+	}
+	return f.Name(), nil
+}
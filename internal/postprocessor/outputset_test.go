@@ -0,0 +1,136 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+// readOutputDir returns the contents of each file in dir by name.
+func readOutputDir(t *testing.T, dir string) map[string]string {
+	t.Helper()
+	des, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	files := map[string]string{}
+	for _, de := range des {
+		b, err := os.ReadFile(filepath.Join(dir, de.Name()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		files[de.Name()] = string(b)
+	}
+	return files
+}
+
+func TestManifestOutputsRollBack(t *testing.T) {
+	tests := []struct {
+		name     string
+		previous bool
+	}{
+		{name: "replacing outputs", previous: true},
+		{name: "creating outputs"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := newTestManifestProcessor(t)
+			p.config.WriteChecksum = true
+			p.config.WriteJSONL = true
+			p.config.WriteLibrariesManifest = true
+			p.config.WriteReleaseLevelSplits = true
+			if tt.previous {
+				if _, err := p.Manifest(context.Background()); err != nil {
+					t.Fatal(err)
+				}
+			}
+			outputDir := filepath.Dir(p.manifestPath())
+			want := readOutputDir(t, outputDir)
+
+			// Fail on one of the later outputs, after the manifest, its
+			// checksum and the JSONL file have been moved into place.
+			p.config.DescriptionTemplate = "{{.Title}} for Go"
+			injected := errors.New("injected rename failure")
+			p.renameFile = func(oldpath, newpath string) error {
+				if filepath.Base(newpath) == ".repo-metadata-libraries.json" && !strings.HasSuffix(oldpath, ".old") {
+					return injected
+				}
+				return os.Rename(oldpath, newpath)
+			}
+			if _, err := p.Manifest(context.Background()); !errors.Is(err, injected) {
+				t.Fatalf("Manifest() = %v, want the injected error", err)
+			}
+			if diff := cmp.Diff(want, readOutputDir(t, outputDir)); diff != "" {
+				t.Errorf("outputs changed after a failed write (-want +got):\n%s", diff)
+			}
+
+			p.renameFile = nil
+			if _, err := p.Manifest(context.Background()); err != nil {
+				t.Fatal(err)
+			}
+			got := readOutputDir(t, outputDir)
+			for _, name := range []string{".repo-metadata-full.json", ".repo-metadata-full.json.sha256", ".repo-metadata-full.jsonl", ".repo-metadata-libraries.json", ".repo-metadata-ga.json"} {
+				if _, ok := got[name]; !ok {
+					t.Errorf("%s was not written", name)
+				}
+			}
+			if !strings.Contains(got[".repo-metadata-full.json"], "Foo API for Go") {
+				t.Errorf("manifest was not updated:\n%s", got[".repo-metadata-full.json"])
+			}
+			for name := range got {
+				if strings.Contains(name, ".tmp-") {
+					t.Errorf("temporary file %s was left behind", name)
+				}
+			}
+		})
+	}
+}
+
+func TestOutputSetDuplicatePath(t *testing.T) {
+	var out outputSet
+	path := filepath.Join(t.TempDir(), "manifest.json")
+	if err := out.add(path, []byte("first")); err != nil {
+		t.Fatal(err)
+	}
+	if err := out.add(path, []byte("second")); err == nil {
+		t.Errorf("add() = nil error for a staged path, want error")
+	}
+	if err := out.addFunc(filepath.Join(filepath.Dir(path), ".", "manifest.json"), func(w io.Writer) error { return nil }); err == nil {
+		t.Errorf("addFunc() = nil error for a staged path, want error")
+	}
+	if got := string(out.contents[path]); got != "first" {
+		t.Errorf("staged contents = %q, want the first %q", got, "first")
+	}
+
+	// Two manifest outputs with the same path fail rather than one
+	// replacing the other.
+	p := newTestManifestProcessor(t)
+	p.config.Output = "internal/meta.json"
+	p.config.ScopedManifests = []scopedManifest{{File: "meta.json", Selector: "ai"}}
+	if _, err := p.Manifest(context.Background()); err == nil {
+		t.Errorf("Manifest() = nil error for a scoped manifest named like the manifest, want error")
+	}
+	if _, err := os.Stat(p.manifestPath()); !os.IsNotExist(err) {
+		t.Errorf("manifest was written despite the collision: %v", err)
+	}
+}
@@ -0,0 +1,388 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"go/build/constraint"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// ReleaseLevelDetector determines the release level of a library from a
+// single stability signal.
+type ReleaseLevelDetector interface {
+	// Detect reports the release level of the library described by info,
+	// whose service configs are resolved to absolute paths. It reports false
+	// if the signal is absent.
+	Detect(ctx context.Context, info *libraryInfo) (level string, ok bool, err error)
+}
+
+// releaseLevelDetectorFunc adapts a function to a ReleaseLevelDetector.
+type releaseLevelDetectorFunc func(ctx context.Context, info *libraryInfo) (string, bool, error)
+
+func (f releaseLevelDetectorFunc) Detect(ctx context.Context, info *libraryInfo) (string, bool, error) {
+	return f(ctx, info)
+}
+
+// releaseLevelDetector is a detector in the chain along with the source it
+// reports release levels as.
+type releaseLevelDetector struct {
+	Source   releaseLevelSource
+	Detector ReleaseLevelDetector
+}
+
+// builtinDetectorSources are the sources of the built-in detectors, in their
+// default order.
+var builtinDetectorSources = []releaseLevelSource{
+	stabilityFileSource,
+	pathSuffixSource,
+	stableFlagSource,
+	launchStageSource,
+	snippetMetadataSource,
+	buildTagSource,
+	docMarkerSource,
+	releasePleaseSource,
+	changelogSource,
+}
+
+// isBuiltinDetectorSource reports whether source is the source of a built-in
+// detector.
+func isBuiltinDetectorSource(source releaseLevelSource) bool {
+	for _, s := range builtinDetectorSources {
+		if s == source {
+			return true
+		}
+	}
+	return false
+}
+
+// builtinDetector returns the built-in detector for source.
+func (p *postProcessor) builtinDetector(source releaseLevelSource) (ReleaseLevelDetector, bool) {
+	var f releaseLevelDetectorFunc
+	switch source {
+	case stabilityFileSource:
+		f = func(_ context.Context, info *libraryInfo) (string, bool, error) {
+			return stabilityFileLevel(filepath.Join(p.googleCloudDir, info.RelPath), p.config.detection().StabilityFile)
+		}
+	case pathSuffixSource:
+		f = func(_ context.Context, info *libraryInfo) (string, bool, error) {
+			level, ok := pathSuffixLevel(info.ImportPath)
+			return level, ok, nil
+		}
+	case stableFlagSource:
+		f = p.stableFlagDetectorLevel
+	case launchStageSource:
+		f = p.launchStageDetectorLevel
+	case snippetMetadataSource:
+		f = func(_ context.Context, info *libraryInfo) (string, bool, error) {
+			return p.snippetMetadataLevel(info.RelPath)
+		}
+	case buildTagSource:
+		f = func(_ context.Context, info *libraryInfo) (string, bool, error) {
+			return buildTagLevel(filepath.Join(p.googleCloudDir, info.RelPath), p.config.detection().PreviewBuildTag)
+		}
+	case docMarkerSource:
+		f = func(_ context.Context, info *libraryInfo) (string, bool, error) {
+			alpha, err := p.alphaIndicator()
+			if err != nil {
+				return "", false, err
+			}
+			beta, err := p.betaIndicator()
+			if err != nil {
+				return "", false, err
+			}
+			defer p.metrics.since(docScanPhase, time.Now())
+			return docMarkerLevel(filepath.Join(p.googleCloudDir, info.RelPath, "doc.go"), alpha, beta)
+		}
+	case releasePleaseSource:
+		f = func(_ context.Context, info *libraryInfo) (string, bool, error) {
+			return p.releasePleaseLevel(info.RelPath)
+		}
+	case changelogSource:
+		f = func(_ context.Context, info *libraryInfo) (string, bool, error) {
+			return p.changelogLevel(info.RelPath)
+		}
+	default:
+		return nil, false
+	}
+	return f, true
+}
+
+// releaseLevelDetectors returns the chain of detectors used to determine
+// release levels. Detectors set on the postProcessor take precedence over
+// the configured chain.
+func (p *postProcessor) releaseLevelDetectors() ([]releaseLevelDetector, error) {
+	if p.detectors != nil {
+		return p.detectors, nil
+	}
+	return p.builtinDetectorChain(p.config.releaseLevelDetectors())
+}
+
+// builtinDetectorChain returns the chain of the built-in detectors of
+// sources, in order.
+func (p *postProcessor) builtinDetectorChain(sources []releaseLevelSource) ([]releaseLevelDetector, error) {
+	var chain []releaseLevelDetector
+	for _, source := range sources {
+		d, ok := p.builtinDetector(source)
+		if !ok {
+			return nil, fmt.Errorf("unknown release level detector %q", source)
+		}
+		chain = append(chain, releaseLevelDetector{source, d})
+	}
+	return chain, nil
+}
+
+// detectorOrderChange is an entry whose winning detector or release level
+// differs between two orders of the detector chain.
+type detectorOrderChange struct {
+	Distribution string
+	OldDetector  releaseLevelSource
+	OldLevel     string
+	NewDetector  releaseLevelSource
+	NewLevel     string
+}
+
+// DetectorOrderChanges computes the current manifest entries, without
+// writing the manifest, with the current chain of detectors and with the
+// built-in detectors of order instead. It returns the entries whose winning
+// detector or release level differs between the two, sorted by distribution
+// name, to review a change of the detector order.
+func (p *postProcessor) DetectorOrderChanges(ctx context.Context, order []releaseLevelSource) ([]detectorOrderChange, error) {
+	chain, err := p.builtinDetectorChain(order)
+	if err != nil {
+		return nil, err
+	}
+	old, err := p.ReleaseLevelReasons(ctx)
+	if err != nil {
+		return nil, err
+	}
+	saved := p.detectors
+	p.detectors = chain
+	defer func() { p.detectors = saved }()
+	new, err := p.ReleaseLevelReasons(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var changes []detectorOrderChange
+	for name, o := range old {
+		if n, ok := new[name]; ok && n != o {
+			changes = append(changes, detectorOrderChange{name, o.Detector, o.Level, n.Detector, n.Level})
+		}
+	}
+	sort.Slice(changes, func(i, j int) bool { return changes[i].Distribution < changes[j].Distribution })
+	return changes, nil
+}
+
+// writeDetectorOrderChangesMarkdown writes the changes to w as a markdown
+// table of the old and new winning detector and release level.
+func writeDetectorOrderChangesMarkdown(w io.Writer, changes []detectorOrderChange) error {
+	if len(changes) == 0 {
+		_, err := fmt.Fprintln(w, "No detector order changes.")
+		return err
+	}
+	var b strings.Builder
+	b.WriteString("| distribution | old detector | old level | new detector | new level |\n")
+	b.WriteString("| --- | --- | --- | --- | --- |\n")
+	for _, c := range changes {
+		fmt.Fprintf(&b, "| %s | %s | %s | %s | %s |\n", markdownCell(c.Distribution), markdownCell(string(c.OldDetector)), markdownCell(c.OldLevel), markdownCell(string(c.NewDetector)), markdownCell(c.NewLevel))
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// stableFlagDetectorLevel reports ga if the primary service config of info
+// marks the API as stable. It reports nothing for an API that is not marked
+// stable, leaving its level to the later detectors.
+func (p *postProcessor) stableFlagDetectorLevel(_ context.Context, info *libraryInfo) (string, bool, error) {
+	if len(info.ServiceConfig) == 0 {
+		return "", false, nil
+	}
+	sc, err := p.readServiceConfig(info.ServiceConfig[0])
+	if err != nil {
+		return "", false, err
+	}
+	if sc.isStable() {
+		return "ga", true, nil
+	}
+	return "", false, nil
+}
+
+// launchStageDetectorLevel reports the release level mapped from the launch
+// stage in the primary service config of info.
+func (p *postProcessor) launchStageDetectorLevel(_ context.Context, info *libraryInfo) (string, bool, error) {
+	if len(info.ServiceConfig) == 0 {
+		return "", false, nil
+	}
+	sc, err := p.readServiceConfig(info.ServiceConfig[0])
+	if err != nil {
+		return "", false, err
+	}
+	return p.config.launchStageLevel(sc.launchStage())
+}
+
+// templatesManifest is the part of the templates manifest of the generator
+// that is used to detect the release level of a package.
+type templatesManifest struct {
+	// Version is the version of the generator templates.
+	Version string `yaml:"version"`
+	// BetaDisclaimer is a phrase of the beta disclaimer the generator writes
+	// to doc.go.
+	BetaDisclaimer string `yaml:"beta-disclaimer"`
+	// AlphaDisclaimer is a phrase of the alpha disclaimer the generator
+	// writes to doc.go.
+	AlphaDisclaimer string `yaml:"alpha-disclaimer"`
+}
+
+// readTemplatesManifest reads the configured templates manifest, which is
+// memoized until the caches are reset. It returns a zero templatesManifest if
+// none is configured.
+func (p *postProcessor) readTemplatesManifest() (templatesManifest, error) {
+	var tm templatesManifest
+	if p.config.TemplatesManifest == "" {
+		return tm, nil
+	}
+	p.templatesMu.Lock()
+	defer p.templatesMu.Unlock()
+	if p.templates != nil {
+		return *p.templates, nil
+	}
+	path := filepath.Join(p.googleCloudDir, p.config.TemplatesManifest)
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return tm, err
+	}
+	if err := yaml.Unmarshal(b, &tm); err != nil {
+		return tm, fmt.Errorf("invalid templates manifest %s: %v", path, err)
+	}
+	p.templates = &tm
+	return tm, nil
+}
+
+// betaIndicator returns the phrase of the beta disclaimer in doc.go, read
+// from the configured templates manifest if there is one. It falls back to the
+// beta indicator of the detection defaults if no templates manifest is
+// configured or it does not declare a disclaimer.
+func (p *postProcessor) betaIndicator() (string, error) {
+	tm, err := p.readTemplatesManifest()
+	if err != nil {
+		return "", err
+	}
+	if tm.BetaDisclaimer == "" {
+		return p.config.detection().BetaIndicator, nil
+	}
+	return tm.BetaDisclaimer, nil
+}
+
+// alphaIndicator is like betaIndicator for the alpha disclaimer.
+func (p *postProcessor) alphaIndicator() (string, error) {
+	tm, err := p.readTemplatesManifest()
+	if err != nil {
+		return "", err
+	}
+	if tm.AlphaDisclaimer == "" {
+		return p.config.detection().AlphaIndicator, nil
+	}
+	return tm.AlphaDisclaimer, nil
+}
+
+// docMarkerLevel reports alpha if the doc.go at path contains the alpha
+// disclaimer phrase alpha, and otherwise beta if it contains the beta
+// disclaimer phrase beta.
+func docMarkerLevel(path, alpha, beta string) (string, bool, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", false, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	var lineCnt int
+	var isBeta bool
+	for scanner.Scan() && lineCnt < 50 {
+		line := scanner.Text()
+		if strings.Contains(line, alpha) {
+			return "alpha", true, nil
+		}
+		if strings.Contains(line, beta) {
+			isBeta = true
+		}
+	}
+	if isBeta {
+		return "beta", true, nil
+	}
+	return "", false, nil
+}
+
+// buildTagLevel reports the package in dir as beta if any of its Go files has
+// a build constraint that requires the preview build tag.
+func buildTagLevel(dir, tag string) (string, bool, error) {
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		return "", false, err
+	}
+	for _, file := range files {
+		if file.IsDir() || !strings.HasSuffix(file.Name(), ".go") {
+			continue
+		}
+		preview, err := requiresBuildTag(filepath.Join(dir, file.Name()), tag)
+		if err != nil {
+			return "", false, err
+		}
+		if preview {
+			return "beta", true, nil
+		}
+	}
+	return "", false, nil
+}
+
+// requiresBuildTag reports whether the build constraints in the header of the
+// Go file at path require tag to be set.
+func requiresBuildTag(path, tag string) (bool, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if strings.HasPrefix(line, "package ") {
+			break
+		}
+		if !constraint.IsGoBuild(line) {
+			continue
+		}
+		expr, err := constraint.Parse(line)
+		if err != nil {
+			return false, fmt.Errorf("%s: %v", path, err)
+		}
+		// The tag is required if the constraint is never satisfied without
+		// it, whatever the other tags.
+		if expr.Eval(func(string) bool { return true }) && !expr.Eval(func(t string) bool { return t != tag }) {
+			return true, nil
+		}
+	}
+	return false, scanner.Err()
+}
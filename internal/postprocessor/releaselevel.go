@@ -0,0 +1,500 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"go/parser"
+	"go/token"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// releaseLevelSource describes how the release level of an entry was
+// determined.
+type releaseLevelSource string
+
+const (
+	stabilityFileSource   releaseLevelSource = "stability-file"
+	pathSuffixSource      releaseLevelSource = "path-suffix"
+	docMarkerSource       releaseLevelSource = "doc-marker"
+	inferredGASource      releaseLevelSource = "inferred-ga"
+	launchStageSource     releaseLevelSource = "launch-stage"
+	snippetMetadataSource releaseLevelSource = "snippet-metadata"
+	changelogSource       releaseLevelSource = "changelog"
+	releasePleaseSource   releaseLevelSource = "release-please"
+	buildTagSource        releaseLevelSource = "build-tag"
+	stableFlagSource      releaseLevelSource = "stable-flag"
+	underlyingSource      releaseLevelSource = "underlying-package"
+	manualSource          releaseLevelSource = "manual"
+	overrideSource        releaseLevelSource = "override"
+	pinSource             releaseLevelSource = "pin"
+	unknownSource         releaseLevelSource = "unknown"
+)
+
+// versionSuffixRe matches a versioned package name with a pre-release suffix,
+// such as apiv1beta1, apiv1p1beta1 or v2alpha.
+var versionSuffixRe = regexp.MustCompile(`^(?:api)?v\d+(?:p\d+)?(alpha|beta)\d*$`)
+
+// stableVersionRe matches a versioned package name without a pre-release
+// suffix, such as apiv1, apiv1p1 or v2.
+var stableVersionRe = regexp.MustCompile(`^(?:api)?v\d+(?:p\d+)?$`)
+
+// versionElemRe matches an import path element that is a version rather than
+// a package name, such as apiv1, apiv1beta1 or v2.
+var versionElemRe = regexp.MustCompile(`^(?:api)?v\d+`)
+
+// releaseLevelResult is a computed release level along with its source.
+type releaseLevelResult struct {
+	Level  string
+	Source releaseLevelSource
+}
+
+// knownReleaseLevels are the canonical release levels of a manifest entry.
+var knownReleaseLevels = map[string]bool{
+	"alpha": true,
+	"beta":  true,
+	"ga":    true,
+}
+
+// releaseLevels computes the release level of each of the confs with
+// resolved service config paths, keyed by input directory. The doc.go scans
+// are independent so they are done concurrently by the given number of
+// workers. All errors are returned, ordered by input directory.
+func (p *postProcessor) releaseLevels(ctx context.Context, confs map[string]*libraryInfo, yamlPaths map[string][]string, workers int) (map[string]releaseLevelResult, error) {
+	var inputDirs []string
+	for inputDir := range yamlPaths {
+		inputDirs = append(inputDirs, inputDir)
+	}
+	sort.Strings(inputDirs)
+	if workers < 1 {
+		workers = 1
+	}
+
+	levels := make([]releaseLevelResult, len(inputDirs))
+	errs := make([]error, len(inputDirs))
+	work := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range work {
+				info := *confs[inputDirs[i]]
+				info.ServiceConfig = yamlPaths[inputDirs[i]]
+				level, err := p.releaseLevel(ctx, &info)
+				if err != nil {
+					err = fmt.Errorf("unable to calculate release level for %v: %v", inputDirs[i], err)
+				}
+				levels[i], errs[i] = level, err
+			}
+		}()
+	}
+	for i := range inputDirs {
+		work <- i
+	}
+	close(work)
+	wg.Wait()
+
+	if err := errors.Join(errs...); err != nil {
+		return nil, err
+	}
+	m := make(map[string]releaseLevelResult, len(inputDirs))
+	for i, inputDir := range inputDirs {
+		m[inputDir] = levels[i]
+	}
+	return m, nil
+}
+
+// LibrariesByReleaseLevel returns the distribution names of all configured
+// libraries grouped by release level, with each group sorted. It runs only
+// the release level detectors, so it is much cheaper than computing the
+// manifest. Manual clients are included with their configured or, if
+// DetectManualReleaseLevels is set, detected release level unless a
+// generated library has the same name. Configured release level pins are
+// applied as in the manifest.
+func (p *postProcessor) LibrariesByReleaseLevel(ctx context.Context) (map[string][]string, error) {
+	p.resetCaches()
+	levels := map[string]string{}
+	for _, m := range p.config.ManualClientInfo {
+		if m.ReleaseLevel != "" {
+			levels[m.DistributionName] = m.ReleaseLevel
+		} else if level, ok, err := p.manualReleaseLevel(ctx, m); err != nil {
+			return nil, err
+		} else if ok {
+			levels[m.DistributionName] = level.Level
+		}
+	}
+	confs, err := p.withRelPaths(p.config.GoogleapisToImportPath)
+	if err != nil {
+		return nil, err
+	}
+	yamlPaths, err := p.serviceConfigPaths(confs)
+	if err != nil {
+		return nil, err
+	}
+	workers, err := p.config.maxWorkers()
+	if err != nil {
+		return nil, err
+	}
+	results, err := p.releaseLevels(ctx, confs, yamlPaths, workers)
+	if err != nil {
+		return nil, err
+	}
+	for inputDir, result := range results {
+		levels[normalizeImportPath(confs[inputDir].ImportPath)] = result.Level
+	}
+	delete(levels, "")
+	for name, level := range p.config.ReleaseLevelPins {
+		if _, ok := levels[name]; ok {
+			levels[name] = level
+		}
+	}
+	byLevel := map[string][]string{}
+	for name, level := range levels {
+		byLevel[level] = append(byLevel[level], name)
+	}
+	for _, names := range byLevel {
+		sort.Strings(names)
+	}
+	return byLevel, nil
+}
+
+// manualReleaseLevel determines the release level of the manual entry m from
+// the package of its distribution name, like that of a generated library. It
+// reports false if m sets a release level or DetectManualReleaseLevels is not
+// set.
+func (p *postProcessor) manualReleaseLevel(ctx context.Context, m *ManifestEntry) (releaseLevelResult, bool, error) {
+	if m.ReleaseLevel != "" || !p.config.DetectManualReleaseLevels {
+		return releaseLevelResult{}, false, nil
+	}
+	dir, err := p.libraryDir(m.DistributionName)
+	if err != nil {
+		return releaseLevelResult{}, false, fmt.Errorf("unable to detect the release level of manual entry %s: %v", m.DistributionName, err)
+	}
+	level, err := p.releaseLevel(ctx, &libraryInfo{ImportPath: m.DistributionName, RelPath: dir})
+	if err != nil {
+		return releaseLevelResult{}, false, fmt.Errorf("unable to detect the release level of manual entry %s: %v", m.DistributionName, err)
+	}
+	return level, true, nil
+}
+
+// releaseLevel determines the release level of the library described by
+// info, whose service configs are resolved to absolute paths. The release
+// level is taken from the first detector in the chain that reports one. By
+// default the chain is: a stability file, an alpha or beta import path
+// suffix, the launch stage in the primary service config, the API versions in
+// the snippet metadata if enabled, the beta disclaimer in doc.go, and the
+// highest version in the changelog if enabled. If no detector reports a
+// level the package is considered ga, unless explicit stability is required.
+func (p *postProcessor) releaseLevel(ctx context.Context, info *libraryInfo) (releaseLevelResult, error) {
+	if p.config.CheckDocPackage {
+		if err := checkDocPackage(filepath.Join(p.googleCloudDir, info.RelPath, "doc.go"), info.ImportPath); err != nil {
+			return releaseLevelResult{}, err
+		}
+	}
+	level, ok, err := p.detectReleaseLevel(ctx, info)
+	if err != nil || ok {
+		return level, err
+	}
+	if info.UnderlyingPackage != "" {
+		level, ok, err = p.underlyingReleaseLevel(ctx, info)
+		if err != nil || ok {
+			return level, err
+		}
+	}
+	if p.config.RequireExplicitStability {
+		if l := p.config.UnknownReleaseLevel; l != "" {
+			return releaseLevelResult{l, unknownSource}, nil
+		}
+		return releaseLevelResult{}, fmt.Errorf("no stability signal found for %s", info.ImportPath)
+	}
+	return releaseLevelResult{"ga", inferredGASource}, nil
+}
+
+// detectReleaseLevel returns the release level reported by the first detector
+// in the chain that reports one for info. It reports false if none do.
+func (p *postProcessor) detectReleaseLevel(ctx context.Context, info *libraryInfo) (releaseLevelResult, bool, error) {
+	detectors, err := p.releaseLevelDetectors()
+	if err != nil {
+		return releaseLevelResult{}, false, err
+	}
+	for _, d := range detectors {
+		level, ok, err := d.Detector.Detect(ctx, info)
+		if err != nil {
+			return releaseLevelResult{}, false, err
+		}
+		if ok {
+			return releaseLevelResult{level, d.Source}, true, nil
+		}
+	}
+	return releaseLevelResult{}, false, nil
+}
+
+// underlyingReleaseLevel returns the detected release level of the library
+// that info wraps, following the chain of underlying packages until a
+// detector reports a level. It reports false if the end of the chain is
+// reached without a level.
+func (p *postProcessor) underlyingReleaseLevel(ctx context.Context, info *libraryInfo) (releaseLevelResult, bool, error) {
+	seen := map[string]bool{info.ImportPath: true}
+	for under := info.UnderlyingPackage; under != ""; {
+		if seen[under] {
+			return releaseLevelResult{}, false, fmt.Errorf("underlying package %s of %s wraps itself", under, info.ImportPath)
+		}
+		seen[under] = true
+		inputDir, conf, ok := p.confForImportPath(under)
+		if !ok {
+			return releaseLevelResult{}, false, fmt.Errorf("underlying package %s of %s is not configured", under, info.ImportPath)
+		}
+		confs, err := p.withRelPaths(map[string]*libraryInfo{inputDir: conf})
+		if err != nil {
+			return releaseLevelResult{}, false, err
+		}
+		paths, err := p.serviceConfigPaths(confs)
+		if err != nil {
+			return releaseLevelResult{}, false, err
+		}
+		u := *confs[inputDir]
+		u.ServiceConfig = paths[inputDir]
+		level, ok, err := p.detectReleaseLevel(ctx, &u)
+		if err != nil {
+			return releaseLevelResult{}, false, fmt.Errorf("underlying package %s of %s: %v", under, info.ImportPath, err)
+		}
+		if ok {
+			return releaseLevelResult{level.Level, underlyingSource}, true, nil
+		}
+		under = u.UnderlyingPackage
+	}
+	return releaseLevelResult{}, false, nil
+}
+
+// ReleaseLevelSource reports how the release level of the distribution was
+// determined by the most recent manifest computation.
+func (p *postProcessor) ReleaseLevelSource(distribution string) (releaseLevelSource, bool) {
+	source, ok := p.releaseLevelSources[distribution]
+	return source, ok
+}
+
+// releaseLevelReason explains how the release level of an entry was
+// determined.
+type releaseLevelReason struct {
+	// Detector is the detector that reported the level or, for a level that
+	// was not detected, its other source, such as manual or inferred-ga.
+	Detector releaseLevelSource `json:"detector"`
+	Level    string             `json:"level"`
+}
+
+// ReleaseLevelReasons computes the current manifest entries, without writing
+// the manifest, and returns how the release level of each was determined,
+// keyed by distribution name.
+func (p *postProcessor) ReleaseLevelReasons(ctx context.Context) (map[string]releaseLevelReason, error) {
+	entries, err := p.computeManifestEntries(ctx)
+	if err != nil {
+		return nil, err
+	}
+	reasons := make(map[string]releaseLevelReason, len(entries))
+	for name, e := range entries {
+		reasons[name] = releaseLevelReason{Detector: p.releaseLevelSources[name], Level: e.ReleaseLevel}
+	}
+	return reasons, nil
+}
+
+// pathSuffixLevel reports the pre-release level in the version suffix of the
+// last element of importPath. Package names that merely contain "alpha" or
+// "beta" are not treated as pre-release versions.
+func pathSuffixLevel(importPath string) (string, bool) {
+	i := strings.LastIndex(importPath, "/")
+	m := versionSuffixRe.FindStringSubmatch(importPath[i+1:])
+	if m == nil {
+		return "", false
+	}
+	return m[1], true
+}
+
+// importPathLevel reports the release level implied by the version in the
+// last element of importPath: the pre-release level of its version suffix,
+// or ga for a version without a suffix. It reports false if the last element
+// is not a version.
+func importPathLevel(importPath string) (string, bool) {
+	if level, ok := pathSuffixLevel(importPath); ok {
+		return level, true
+	}
+	i := strings.LastIndex(importPath, "/")
+	if stableVersionRe.MatchString(importPath[i+1:]) {
+		return "ga", true
+	}
+	return "", false
+}
+
+// snippetMetadata is the part of a snippet metadata file that is used to
+// detect the release level of a package.
+type snippetMetadata struct {
+	ClientLibrary struct {
+		APIs []struct {
+			Version string `json:"version"`
+		} `json:"apis"`
+	} `json:"clientLibrary"`
+}
+
+// snippetMetadataLevel reports the pre-release level of the API versions in
+// the snippet metadata file of the package at relPath, preferring alpha if
+// the versions are mixed. It reports false if there is no snippet metadata
+// file or none of its API versions are pre-release.
+func (p *postProcessor) snippetMetadataLevel(relPath string) (string, bool, error) {
+	glob := filepath.Join(p.googleCloudDir, "internal", "generated", "snippets", relPath, "snippet_metadata.*.json")
+	files, err := filepath.Glob(glob)
+	if err != nil || len(files) == 0 {
+		return "", false, err
+	}
+	b, err := os.ReadFile(files[0])
+	if err != nil {
+		return "", false, err
+	}
+	var md snippetMetadata
+	if err := json.Unmarshal(b, &md); err != nil {
+		return "", false, fmt.Errorf("%s: %v", files[0], err)
+	}
+	var level string
+	for _, api := range md.ClientLibrary.APIs {
+		if m := versionSuffixRe.FindStringSubmatch(api.Version); m != nil && level != "alpha" {
+			level = m[1]
+		}
+	}
+	return level, level != "", nil
+}
+
+// checkDocPackage returns an error if the package clause of the doc.go at path
+// does not match the package name expected for importPath. The expected name
+// is the last element of the import path, skipping version elements such as
+// apiv1 or v2 and the internal and autogen directories that generated clients
+// are sometimes placed in.
+func checkDocPackage(path, importPath string) error {
+	f, err := parser.ParseFile(token.NewFileSet(), path, nil, parser.PackageClauseOnly)
+	if err != nil {
+		return err
+	}
+	var want string
+	for _, elem := range strings.Split(importPath, "/") {
+		if elem == "internal" || elem == "autogen" || versionElemRe.MatchString(elem) {
+			continue
+		}
+		want = elem
+	}
+	if got := f.Name.Name; got != want {
+		return fmt.Errorf("%s declares package %s, want %s", path, got, want)
+	}
+	return nil
+}
+
+// changelogNames are the names of the changelog files of a module.
+var changelogNames = []string{"CHANGES.md", "CHANGELOG.md"}
+
+// changelogVersionRe matches a changelog heading for a release, such as
+// "## [1.10.1](https://...) (2023-05-08)" or "## v0.2.0", capturing the major
+// version.
+var changelogVersionRe = regexp.MustCompile(`^##\s+\[?v?(\d+)\.\d+\.\d+`)
+
+// maxChangelogLines is how many lines at the top of a changelog are searched
+// for release headings.
+const maxChangelogLines = 200
+
+// changelogLevel infers the release level of the package at relPath from the
+// highest release heading in the changelog of its module: ga from v1.0.0 on,
+// beta before. The changelog is looked for in the package directory and then
+// in each parent up to the module root. It reports false if no changelog or
+// release heading is found.
+func (p *postProcessor) changelogLevel(relPath string) (string, bool, error) {
+	root := filepath.Clean(p.googleCloudDir)
+	dir := filepath.Join(root, relPath)
+	for {
+		for _, name := range changelogNames {
+			major, ok, err := changelogMajorVersion(filepath.Join(dir, name))
+			if err != nil {
+				return "", false, err
+			}
+			if !ok {
+				continue
+			}
+			if major >= 1 {
+				return "ga", true, nil
+			}
+			return "beta", true, nil
+		}
+		if _, err := os.Stat(filepath.Join(dir, "go.mod")); err == nil || dir == root {
+			return "", false, nil
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return "", false, nil
+		}
+		dir = parent
+	}
+}
+
+// changelogMajorVersion returns the highest major version among the release
+// headings at the top of the changelog at path. It reports false if the file
+// does not exist or has no release headings.
+func changelogMajorVersion(path string) (int, bool, error) {
+	f, err := os.Open(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return 0, false, nil
+	} else if err != nil {
+		return 0, false, err
+	}
+	defer f.Close()
+	major, found := 0, false
+	scanner := bufio.NewScanner(f)
+	for n := 0; scanner.Scan() && n < maxChangelogLines; n++ {
+		m := changelogVersionRe.FindStringSubmatch(scanner.Text())
+		if m == nil {
+			continue
+		}
+		v, err := strconv.Atoi(m[1])
+		if err != nil {
+			return 0, false, fmt.Errorf("%s: %v", path, err)
+		}
+		if !found || v > major {
+			major, found = v, true
+		}
+	}
+	return major, found, scanner.Err()
+}
+
+// stabilityFileLevel reads the release level declared by the stability file
+// with the given name in dir. It reports false if there is no stability file.
+func stabilityFileLevel(dir, name string) (string, bool, error) {
+	path := filepath.Join(dir, name)
+	b, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return "", false, nil
+	} else if err != nil {
+		return "", false, err
+	}
+	level := strings.TrimSpace(string(b))
+	if !knownReleaseLevels[level] {
+		return "", false, fmt.Errorf("%s: invalid release level %q", path, level)
+	}
+	return level, true, nil
+}
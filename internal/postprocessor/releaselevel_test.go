@@ -0,0 +1,999 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestReleaseLevel(t *testing.T) {
+	tests := []struct {
+		name       string
+		importPath string
+		files      map[string]string
+		explicit   bool
+		want       string
+		wantErr    bool
+	}{
+		{
+			name:       "ga",
+			importPath: "cloud.google.com/go/foo/apiv1",
+			files:      map[string]string{"doc.go": testDocGA},
+			want:       "ga",
+		},
+		{
+			name:       "beta doc",
+			importPath: "cloud.google.com/go/foo/apiv1",
+			files:      map[string]string{"doc.go": testDocBeta},
+			want:       "beta",
+		},
+		{
+			name:       "alpha doc",
+			importPath: "cloud.google.com/go/foo/apiv1",
+			files:      map[string]string{"doc.go": testDocAlpha},
+			want:       "alpha",
+		},
+		{
+			name:       "alpha doc after beta doc",
+			importPath: "cloud.google.com/go/foo/apiv1",
+			files:      map[string]string{"doc.go": testDocBeta + "\n// This package is in alpha.\n"},
+			want:       "alpha",
+		},
+		{
+			name:       "beta path",
+			importPath: "cloud.google.com/go/foo/apiv1beta1",
+			files:      map[string]string{"doc.go": testDocGA},
+			want:       "beta",
+		},
+		{
+			name:       "alpha path",
+			importPath: "cloud.google.com/go/foo/apiv1alpha",
+			files:      map[string]string{"doc.go": testDocGA},
+			want:       "alpha",
+		},
+		{
+			name:       "beta in package name",
+			importPath: "cloud.google.com/go/albeta",
+			files:      map[string]string{"doc.go": testDocGA},
+			want:       "ga",
+		},
+		{
+			name:       "alpha in package name",
+			importPath: "cloud.google.com/go/foo/alpha_utils",
+			files:      map[string]string{"doc.go": testDocGA},
+			want:       "ga",
+		},
+		{
+			name:       "stability file",
+			importPath: "cloud.google.com/go/foo/apiv1",
+			files:      map[string]string{"doc.go": testDocBeta, defaultDetection.StabilityFile: "ga\n"},
+			want:       "ga",
+		},
+		{
+			name:       "invalid stability file",
+			importPath: "cloud.google.com/go/foo/apiv1",
+			files:      map[string]string{"doc.go": testDocGA, defaultDetection.StabilityFile: "stable\n"},
+			wantErr:    true,
+		},
+		{
+			name:       "no signal with explicit stability",
+			importPath: "cloud.google.com/go/foo/apiv1",
+			files:      map[string]string{"doc.go": testDocGA},
+			explicit:   true,
+			wantErr:    true,
+		},
+		{
+			name:       "beta doc with explicit stability",
+			importPath: "cloud.google.com/go/foo/apiv1",
+			files:      map[string]string{"doc.go": testDocBeta},
+			explicit:   true,
+			want:       "beta",
+		},
+		{
+			name:       "stability file with explicit stability",
+			importPath: "cloud.google.com/go/foo/apiv1",
+			files:      map[string]string{"doc.go": testDocGA, defaultDetection.StabilityFile: "ga"},
+			explicit:   true,
+			want:       "ga",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			writeTestFiles(t, dir, tt.files)
+			p := &postProcessor{
+				googleCloudDir: dir,
+				config: &config{
+					manifestConfig: manifestConfig{RequireExplicitStability: tt.explicit},
+				},
+			}
+			got, err := p.releaseLevel(context.Background(), &libraryInfo{ImportPath: tt.importPath})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("releaseLevel() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got.Level != tt.want {
+				t.Errorf("releaseLevel() = %q, want %q", got.Level, tt.want)
+			}
+		})
+	}
+}
+
+func TestPathSuffixLevel(t *testing.T) {
+	tests := []struct {
+		importPath string
+		want       string
+		wantOK     bool
+	}{
+		{importPath: "cloud.google.com/go/foo/apiv1beta1", want: "beta", wantOK: true},
+		{importPath: "cloud.google.com/go/foo/apiv1beta", want: "beta", wantOK: true},
+		{importPath: "cloud.google.com/go/foo/apiv1p1beta1", want: "beta", wantOK: true},
+		{importPath: "cloud.google.com/go/foo/apiv2alpha", want: "alpha", wantOK: true},
+		{importPath: "cloud.google.com/go/foo/v1alpha1", want: "alpha", wantOK: true},
+		{importPath: "cloud.google.com/go/foo/apiv1"},
+		{importPath: "cloud.google.com/go/foo"},
+		{importPath: "cloud.google.com/go/albeta"},
+		{importPath: "cloud.google.com/go/foo/alpha_utils"},
+		{importPath: "cloud.google.com/go/foo/betaclient"},
+		{importPath: "cloud.google.com/go/foo/alpha"},
+		{importPath: "cloud.google.com/go/foo/apiv1betafoo"},
+		{importPath: "cloud.google.com/go/foobeta/apiv1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.importPath, func(t *testing.T) {
+			got, ok := pathSuffixLevel(tt.importPath)
+			if got != tt.want || ok != tt.wantOK {
+				t.Errorf("pathSuffixLevel() = %q, %v, want %q, %v", got, ok, tt.want, tt.wantOK)
+			}
+		})
+	}
+}
+
+func TestReleaseLevels(t *testing.T) {
+	p := newTestManifestProcessor(t)
+	p.config.GoogleapisToImportPath["google/cloud/broken/v1"] = &libraryInfo{
+		ImportPath:    "cloud.google.com/go/broken/apiv1",
+		ServiceConfig: serviceConfigList{"broken_v1.yaml"},
+		RelPath:       "/broken/apiv1",
+	}
+	writeTestFiles(t, p.googleapisDir, map[string]string{
+		"google/cloud/broken/v1/broken_v1.yaml": "title: Broken API\n",
+	})
+	p.config.GoogleapisToImportPath["google/cloud/noconf/v1"] = &libraryInfo{
+		ImportPath: "cloud.google.com/go/noconf/apiv1",
+		RelPath:    "/noconf/apiv1",
+	}
+	yamlPaths, err := p.serviceConfigPaths(p.config.GoogleapisToImportPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := p.releaseLevels(context.Background(), p.config.GoogleapisToImportPath, yamlPaths, 4); err == nil || !strings.Contains(err.Error(), "google/cloud/broken/v1") {
+		t.Errorf("releaseLevels() = %v, want error for package without doc.go", err)
+	}
+
+	delete(yamlPaths, "google/cloud/broken/v1")
+	got, err := p.releaseLevels(context.Background(), p.config.GoogleapisToImportPath, yamlPaths, 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]releaseLevelResult{
+		"google/cloud/foo/v1":     {"ga", inferredGASource},
+		"google/cloud/bar/v1":     {"beta", docMarkerSource},
+		"google/cloud/qux/v1beta": {"beta", pathSuffixSource},
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("releaseLevels() mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func BenchmarkReleaseLevels(b *testing.B) {
+	p := newBenchManifestProcessor(b, *benchLibraries)
+	yamlPaths, err := p.serviceConfigPaths(p.config.GoogleapisToImportPath)
+	if err != nil {
+		b.Fatal(err)
+	}
+	for _, workers := range []int{1, 4, 16} {
+		b.Run(fmt.Sprintf("workers=%d", workers), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				if _, err := p.releaseLevels(context.Background(), p.config.GoogleapisToImportPath, yamlPaths, workers); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func TestReleaseLevelSource(t *testing.T) {
+	p := newTestManifestProcessor(t)
+	p.config.GoogleapisToImportPath["google/cloud/foo/v2"] = &libraryInfo{
+		ImportPath:    "cloud.google.com/go/foo/apiv2",
+		ServiceConfig: serviceConfigList{"../v1/foo_v1.yaml"},
+		RelPath:       "/foo/apiv2",
+	}
+	writeTestFiles(t, p.googleCloudDir, map[string]string{
+		"foo/apiv2/doc.go": testDocBeta,
+		"foo/apiv2/" + defaultDetection.StabilityFile: "ga\n",
+	})
+	if _, err := p.Manifest(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]releaseLevelSource{
+		"cloud.google.com/go/foo/apiv1":     inferredGASource,
+		"cloud.google.com/go/foo/apiv2":     stabilityFileSource,
+		"cloud.google.com/go/bar/apiv1":     docMarkerSource,
+		"cloud.google.com/go/qux/apiv1beta": pathSuffixSource,
+		"cloud.google.com/go/baz":           manualSource,
+	}
+	for dist, wantSource := range want {
+		if got, ok := p.ReleaseLevelSource(dist); !ok || got != wantSource {
+			t.Errorf("ReleaseLevelSource(%q) = %q, %v, want %q", dist, got, ok, wantSource)
+		}
+	}
+	if _, ok := p.ReleaseLevelSource("cloud.google.com/go/unknown"); ok {
+		t.Errorf("ReleaseLevelSource() reported a source for an unknown distribution")
+	}
+}
+
+func TestLaunchStageLevel(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  manifestConfig
+		stage   string
+		want    string
+		wantOK  bool
+		wantErr bool
+	}{
+		{name: "default ga", stage: "GA", want: "ga", wantOK: true},
+		{name: "default beta", stage: "BETA", want: "beta", wantOK: true},
+		{name: "default prelaunch", stage: "PRELAUNCH", want: "alpha", wantOK: true},
+		{name: "unknown", stage: "SOMEDAY", wantErr: true},
+		{name: "unspecified", stage: "LAUNCH_STAGE_UNSPECIFIED"},
+		{name: "deprecated", stage: "DEPRECATED"},
+		{name: "empty", stage: ""},
+		{
+			name:   "empty with default",
+			config: manifestConfig{DefaultReleaseLevelForUnknownStage: "beta"},
+			stage:  "",
+			want:   "beta",
+			wantOK: true,
+		},
+		{
+			name:   "custom override",
+			config: manifestConfig{LaunchStageLevels: map[string]string{"EARLY_ACCESS": "beta"}},
+			stage:  "EARLY_ACCESS",
+			want:   "beta",
+			wantOK: true,
+		},
+		{
+			name:   "no signal",
+			config: manifestConfig{LaunchStageLevels: map[string]string{"BETA": ""}},
+			stage:  "BETA",
+		},
+		{
+			name:   "unknown with default",
+			config: manifestConfig{DefaultReleaseLevelForUnknownStage: "beta"},
+			stage:  "SOMEDAY",
+			want:   "beta",
+			wantOK: true,
+		},
+		{
+			name:   "unspecified with default",
+			config: manifestConfig{DefaultReleaseLevelForUnknownStage: "beta"},
+			stage:  "LAUNCH_STAGE_UNSPECIFIED",
+			want:   "beta",
+			wantOK: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &config{manifestConfig: tt.config}
+			got, ok, err := c.launchStageLevel(tt.stage)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("launchStageLevel() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want || ok != tt.wantOK {
+				t.Errorf("launchStageLevel() = %q, %t, want %q, %t", got, ok, tt.want, tt.wantOK)
+			}
+		})
+	}
+
+	c := &config{manifestConfig: manifestConfig{LaunchStageLevels: map[string]string{"GA": "stable"}}}
+	if err := c.validate(); err == nil {
+		t.Errorf("validate() = nil, want error for unknown release level in launch-stage-levels")
+	}
+}
+
+func TestReleaseLevelLaunchStage(t *testing.T) {
+	p := newTestManifestProcessor(t)
+	writeTestFiles(t, p.googleapisDir, map[string]string{
+		"google/cloud/foo/v1/foo_v1.yaml": "title: Foo API\npublishing:\n  library_settings:\n  - version: google.cloud.foo.v1\n    launch_stage: PRELAUNCH\n",
+	})
+	// The launch stage is only used once opted in.
+	got, err := p.ManifestForInput(context.Background(), "google/cloud/foo/v1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if level := got["cloud.google.com/go/foo/apiv1"].ReleaseLevel; level != "ga" {
+		t.Errorf("ReleaseLevel without use-launch-stage = %q, want %q", level, "ga")
+	}
+
+	p.config.UseLaunchStage = true
+	got, err = p.ManifestForInput(context.Background(), "google/cloud/foo/v1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if level := got["cloud.google.com/go/foo/apiv1"].ReleaseLevel; level != "alpha" {
+		t.Errorf("ReleaseLevel = %q, want %q", level, "alpha")
+	}
+	if source, _ := p.ReleaseLevelSource("cloud.google.com/go/foo/apiv1"); source != launchStageSource {
+		t.Errorf("ReleaseLevelSource() = %q, want %q", source, launchStageSource)
+	}
+}
+
+func TestReleaseLevelStableFlag(t *testing.T) {
+	tests := []struct {
+		name       string
+		fixture    string
+		want       string
+		wantSource releaseLevelSource
+	}{
+		{name: "stable", fixture: "bar_v1_stable.yaml", want: "ga", wantSource: stableFlagSource},
+		{name: "maturity stable", fixture: "bar_v1_maturity_stable.yaml", want: "ga", wantSource: stableFlagSource},
+		{name: "not stable", fixture: "bar_v1_not_stable.yaml", want: "beta", wantSource: docMarkerSource},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sc, err := os.ReadFile(filepath.Join("testdata/manifest", tt.fixture))
+			if err != nil {
+				t.Fatal(err)
+			}
+			// The doc.go of bar/apiv1 has the beta disclaimer, which the
+			// annotation takes precedence over.
+			p := newTestManifestProcessor(t)
+			writeTestFiles(t, p.googleapisDir, map[string]string{"google/cloud/bar/v1/bar_v1.yaml": string(sc)})
+			got, err := p.ManifestForInput(context.Background(), "google/cloud/bar/v1")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if level := got["cloud.google.com/go/bar/apiv1"].ReleaseLevel; level != tt.want {
+				t.Errorf("ReleaseLevel = %q, want %q", level, tt.want)
+			}
+			if source, _ := p.ReleaseLevelSource("cloud.google.com/go/bar/apiv1"); source != tt.wantSource {
+				t.Errorf("ReleaseLevelSource() = %q, want %q", source, tt.wantSource)
+			}
+		})
+	}
+}
+
+func TestReleaseLevelUnderlyingPackage(t *testing.T) {
+	p := newTestManifestProcessor(t)
+	p.config.GoogleapisToImportPath["google/cloud/bar"] = &libraryInfo{
+		ImportPath:        "cloud.google.com/go/bar",
+		ServiceConfig:     serviceConfigList{"bar.yaml"},
+		RelPath:           "/bar",
+		UnderlyingPackage: "cloud.google.com/go/bar/apiv1",
+	}
+	writeTestFiles(t, p.googleCloudDir, map[string]string{"bar/doc.go": "// Package bar wraps the Bar API.\npackage bar\n"})
+	writeTestFiles(t, p.googleapisDir, map[string]string{"google/cloud/bar/bar.yaml": "type: google.api.Service\ntitle: Bar API\n"})
+	entries, err := p.Manifest(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := entries["cloud.google.com/go/bar"].ReleaseLevel; got != "beta" {
+		t.Errorf("wrapper release level = %q, want beta from its underlying package", got)
+	}
+	if got, _ := p.ReleaseLevelSource("cloud.google.com/go/bar"); got != underlyingSource {
+		t.Errorf("ReleaseLevelSource() = %q, want %q", got, underlyingSource)
+	}
+
+	// A signal of the wrapper itself takes precedence.
+	writeTestFiles(t, p.googleCloudDir, map[string]string{"bar/" + defaultDetection.StabilityFile: "ga\n"})
+	if entries, err = p.Manifest(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if got := entries["cloud.google.com/go/bar"].ReleaseLevel; got != "ga" {
+		t.Errorf("wrapper release level with a stability file = %q, want ga", got)
+	}
+
+	if err := os.Remove(filepath.Join(p.googleCloudDir, "bar", defaultDetection.StabilityFile)); err != nil {
+		t.Fatal(err)
+	}
+	for _, under := range []string{"cloud.google.com/go/bar", "cloud.google.com/go/missing"} {
+		info := &libraryInfo{ImportPath: "cloud.google.com/go/bar", RelPath: "/bar", UnderlyingPackage: under}
+		if _, err := p.releaseLevel(context.Background(), info); err == nil {
+			t.Errorf("releaseLevel() with underlying package %s = nil error, want error", under)
+		}
+	}
+}
+
+func TestReleaseLevelReasons(t *testing.T) {
+	p := newTestManifestProcessor(t)
+	writeTestFiles(t, p.googleCloudDir, map[string]string{"qux/apiv1beta/" + defaultDetection.StabilityFile: "ga\n"})
+	got, err := p.ReleaseLevelReasons(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]releaseLevelReason{
+		"cloud.google.com/go/foo/apiv1":     {Detector: inferredGASource, Level: "ga"},
+		"cloud.google.com/go/bar/apiv1":     {Detector: docMarkerSource, Level: "beta"},
+		"cloud.google.com/go/qux/apiv1beta": {Detector: stabilityFileSource, Level: "ga"},
+		"cloud.google.com/go/baz":           {Detector: manualSource, Level: "ga"},
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("ReleaseLevelReasons() mismatch (-want +got):\n%s", diff)
+	}
+	b, err := json.Marshal(got["cloud.google.com/go/bar/apiv1"])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := `{"detector":"doc-marker","level":"beta"}`; string(b) != want {
+		t.Errorf("reason JSON = %s, want %s", b, want)
+	}
+}
+
+func TestReleaseLevelSnippetMetadata(t *testing.T) {
+	md, err := os.ReadFile("testdata/manifest/snippet_metadata.google.cloud.foo.v1beta1.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name       string
+		enabled    bool
+		metadata   bool
+		want       string
+		wantSource releaseLevelSource
+	}{
+		{name: "enabled", enabled: true, metadata: true, want: "beta", wantSource: snippetMetadataSource},
+		{name: "disabled", metadata: true, want: "ga", wantSource: inferredGASource},
+		{name: "absent", enabled: true, want: "ga", wantSource: inferredGASource},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			files := map[string]string{"foo/apiv1/doc.go": testDocGA}
+			if tt.metadata {
+				files["internal/generated/snippets/foo/apiv1/snippet_metadata.google.cloud.foo.v1beta1.json"] = string(md)
+			}
+			writeTestFiles(t, dir, files)
+			p := &postProcessor{
+				googleCloudDir: dir,
+				config: &config{
+					manifestConfig: manifestConfig{UseSnippetMetadata: tt.enabled},
+				},
+			}
+			got, err := p.releaseLevel(context.Background(), &libraryInfo{ImportPath: "cloud.google.com/go/foo/apiv1", RelPath: "/foo/apiv1"})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if want := (releaseLevelResult{tt.want, tt.wantSource}); got != want {
+				t.Errorf("releaseLevel() = %+v, want %+v", got, want)
+			}
+		})
+	}
+}
+
+func TestCheckDocPackage(t *testing.T) {
+	tests := []struct {
+		importPath string
+		pkg        string
+		wantErr    bool
+	}{
+		{importPath: "cloud.google.com/go/foo/apiv1", pkg: "foo"},
+		{importPath: "cloud.google.com/go/foo/apiv1beta1", pkg: "foo"},
+		{importPath: "cloud.google.com/go/foo/apiv1/v2", pkg: "foo"},
+		{importPath: "cloud.google.com/go/foo/bar/apiv1", pkg: "bar"},
+		{importPath: "cloud.google.com/go/foo/internal/apiv2", pkg: "foo"},
+		{importPath: "cloud.google.com/go/foo", pkg: "foo"},
+		{importPath: "cloud.google.com/go/foo/apiv1", pkg: "bar", wantErr: true},
+		{importPath: "cloud.google.com/go/foo/apiv1", pkg: "apiv1", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.importPath+"/"+tt.pkg, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "doc.go")
+			if err := os.WriteFile(path, []byte("// Package doc.\npackage "+tt.pkg+"\n"), 0644); err != nil {
+				t.Fatal(err)
+			}
+			if err := checkDocPackage(path, tt.importPath); (err != nil) != tt.wantErr {
+				t.Errorf("checkDocPackage() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestReleaseLevelCheckDocPackage(t *testing.T) {
+	dir := t.TempDir()
+	writeTestFiles(t, dir, map[string]string{"foo/apiv1/doc.go": "package bar\n"})
+	p := &postProcessor{googleCloudDir: dir, config: &config{}}
+	if _, err := p.releaseLevel(context.Background(), &libraryInfo{ImportPath: "cloud.google.com/go/foo/apiv1", RelPath: "/foo/apiv1"}); err != nil {
+		t.Fatalf("releaseLevel() = %v, want no error without CheckDocPackage", err)
+	}
+	p.config.CheckDocPackage = true
+	if _, err := p.releaseLevel(context.Background(), &libraryInfo{ImportPath: "cloud.google.com/go/foo/apiv1", RelPath: "/foo/apiv1"}); err == nil || !strings.Contains(err.Error(), "declares package bar") {
+		t.Errorf("releaseLevel() = %v, want error for mismatched package clause", err)
+	}
+}
+
+func TestReleaseLevelChangelog(t *testing.T) {
+	const v0 = "# Changes\n\n## [0.3.0](https://github.com/googleapis/google-cloud-go/compare/foo/v0.2.0...foo/v0.3.0) (2023-05-08)\n\n### Features\n\n## [0.2.0](https://github.com/googleapis/google-cloud-go/compare/foo/v0.1.0...foo/v0.2.0) (2023-01-04)\n"
+	const v1 = "# Changes\n\n## [1.0.1](https://github.com/googleapis/google-cloud-go/compare/foo/v1.0.0...foo/v1.0.1) (2023-05-08)\n\n## [1.0.0](https://github.com/googleapis/google-cloud-go/compare/foo/v0.9.0...foo/v1.0.0) (2023-01-04)\n\n## 0.9.0\n"
+	tests := []struct {
+		name       string
+		files      map[string]string
+		enabled    bool
+		want       string
+		wantSource releaseLevelSource
+	}{
+		{
+			name:       "v0",
+			files:      map[string]string{"foo/CHANGES.md": v0},
+			enabled:    true,
+			want:       "beta",
+			wantSource: changelogSource,
+		},
+		{
+			name:       "v1",
+			files:      map[string]string{"foo/CHANGES.md": v1},
+			enabled:    true,
+			want:       "ga",
+			wantSource: changelogSource,
+		},
+		{
+			name:       "changelog in package directory",
+			files:      map[string]string{"foo/CHANGES.md": v1, "foo/apiv1/CHANGELOG.md": v0},
+			enabled:    true,
+			want:       "beta",
+			wantSource: changelogSource,
+		},
+		{
+			name:       "disabled",
+			files:      map[string]string{"foo/CHANGES.md": v0},
+			want:       "ga",
+			wantSource: inferredGASource,
+		},
+		{
+			name:       "no changelog",
+			enabled:    true,
+			want:       "ga",
+			wantSource: inferredGASource,
+		},
+		{
+			name:       "doc marker wins",
+			files:      map[string]string{"foo/CHANGES.md": v1, "foo/apiv1/doc.go": testDocBeta},
+			enabled:    true,
+			want:       "beta",
+			wantSource: docMarkerSource,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			writeTestFiles(t, dir, map[string]string{
+				"foo/go.mod":       "module cloud.google.com/go/foo\n",
+				"foo/apiv1/doc.go": testDocGA,
+			})
+			writeTestFiles(t, dir, tt.files)
+			p := &postProcessor{
+				googleCloudDir: dir,
+				config: &config{
+					manifestConfig: manifestConfig{UseChangelog: tt.enabled},
+				},
+			}
+			got, err := p.releaseLevel(context.Background(), &libraryInfo{ImportPath: "cloud.google.com/go/foo/apiv1", RelPath: "/foo/apiv1"})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if want := (releaseLevelResult{tt.want, tt.wantSource}); got != want {
+				t.Errorf("releaseLevel() = %+v, want %+v", got, want)
+			}
+		})
+	}
+}
+
+func TestReleaseLevelReleasePlease(t *testing.T) {
+	conf, err := os.ReadFile("testdata/manifest/release-please-config.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name       string
+		relPath    string
+		files      map[string]string
+		enabled    bool
+		noConfig   bool
+		want       string
+		wantSource releaseLevelSource
+	}{
+		{name: "v0 release-as", relPath: "/foo/apiv1", enabled: true, want: "beta", wantSource: releasePleaseSource},
+		{name: "longest package wins", relPath: "/foo/apiv2", enabled: true, want: "ga", wantSource: releasePleaseSource},
+		{name: "prerelease type", relPath: "/alpha/apiv1", enabled: true, want: "alpha", wantSource: releasePleaseSource},
+		{name: "prerelease", relPath: "/preview/apiv1", enabled: true, want: "beta", wantSource: releasePleaseSource},
+		{name: "no hint", relPath: "/other/apiv1", enabled: true, want: "ga", wantSource: inferredGASource},
+		{name: "disabled", relPath: "/foo/apiv1", want: "ga", wantSource: inferredGASource},
+		{name: "absent", relPath: "/foo/apiv1", enabled: true, noConfig: true, want: "ga", wantSource: inferredGASource},
+		{
+			name:       "doc marker wins",
+			relPath:    "/foo/apiv2",
+			files:      map[string]string{"foo/apiv2/doc.go": testDocBeta},
+			enabled:    true,
+			want:       "beta",
+			wantSource: docMarkerSource,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			files := map[string]string{tt.relPath[1:] + "/doc.go": testDocGA}
+			if !tt.noConfig {
+				files["release-please-config.json"] = string(conf)
+			}
+			writeTestFiles(t, dir, files)
+			writeTestFiles(t, dir, tt.files)
+			p := &postProcessor{
+				googleCloudDir: dir,
+				config: &config{
+					manifestConfig: manifestConfig{UseReleasePlease: tt.enabled},
+				},
+			}
+			got, err := p.releaseLevel(context.Background(), &libraryInfo{ImportPath: "cloud.google.com/go" + tt.relPath, RelPath: tt.relPath})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if want := (releaseLevelResult{tt.want, tt.wantSource}); got != want {
+				t.Errorf("releaseLevel() = %+v, want %+v", got, want)
+			}
+		})
+	}
+
+	// The release-please config is read once per manifest computation.
+	dir := t.TempDir()
+	writeTestFiles(t, dir, map[string]string{"release-please-config.json": string(conf)})
+	p := &postProcessor{googleCloudDir: dir, config: &config{}}
+	if got, _, err := p.releasePleaseLevel("/foo/apiv1"); err != nil || got != "beta" {
+		t.Fatalf("releasePleaseLevel() = %q, %v, want %q", got, err, "beta")
+	}
+	if err := os.Remove(filepath.Join(dir, "release-please-config.json")); err != nil {
+		t.Fatal(err)
+	}
+	if got, _, err := p.releasePleaseLevel("/foo/apiv1"); err != nil || got != "beta" {
+		t.Errorf("releasePleaseLevel() = %q, %v, want the memoized %q", got, err, "beta")
+	}
+	p.resetCaches()
+	if _, ok, err := p.releasePleaseLevel("/foo/apiv1"); err != nil || ok {
+		t.Errorf("releasePleaseLevel() after resetCaches() = %v, %v, want no hint", ok, err)
+	}
+}
+
+func TestReleaseLevelBuildTag(t *testing.T) {
+	const previewFile = `// Copyright 2023 Google LLC
+
+//go:build preview
+
+package foo
+`
+	tests := []struct {
+		name       string
+		files      map[string]string
+		enabled    bool
+		want       string
+		wantSource releaseLevelSource
+	}{
+		{
+			name:       "preview tag in another file",
+			files:      map[string]string{"foo/apiv1/preview.go": previewFile},
+			enabled:    true,
+			want:       "beta",
+			wantSource: buildTagSource,
+		},
+		{
+			name:       "disabled",
+			files:      map[string]string{"foo/apiv1/preview.go": previewFile},
+			want:       "ga",
+			wantSource: inferredGASource,
+		},
+		{
+			name:       "preview tag not required",
+			files:      map[string]string{"foo/apiv1/other.go": "//go:build preview || !preview\n\npackage foo\n"},
+			enabled:    true,
+			want:       "ga",
+			wantSource: inferredGASource,
+		},
+		{
+			name:       "negated preview tag",
+			files:      map[string]string{"foo/apiv1/stable.go": "//go:build !preview\n\npackage foo\n"},
+			enabled:    true,
+			want:       "ga",
+			wantSource: inferredGASource,
+		},
+		{
+			name:       "constraint after package clause",
+			files:      map[string]string{"foo/apiv1/late.go": "package foo\n\n//go:build preview\n"},
+			enabled:    true,
+			want:       "ga",
+			wantSource: inferredGASource,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			writeTestFiles(t, dir, map[string]string{"foo/apiv1/doc.go": testDocGA})
+			writeTestFiles(t, dir, tt.files)
+			p := &postProcessor{
+				googleCloudDir: dir,
+				config: &config{
+					manifestConfig: manifestConfig{UseBuildTags: tt.enabled},
+				},
+			}
+			got, err := p.releaseLevel(context.Background(), &libraryInfo{ImportPath: "cloud.google.com/go/foo/apiv1", RelPath: "/foo/apiv1"})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if want := (releaseLevelResult{tt.want, tt.wantSource}); got != want {
+				t.Errorf("releaseLevel() = %+v, want %+v", got, want)
+			}
+		})
+	}
+}
+
+func TestLibrariesByReleaseLevel(t *testing.T) {
+	p := newTestManifestProcessor(t)
+	writeTestFiles(t, p.googleCloudDir, map[string]string{"foo/apiv2/doc.go": testDocGA, "foo/apiv2/" + defaultDetection.StabilityFile: "alpha"})
+	writeTestFiles(t, p.googleapisDir, map[string]string{"google/cloud/foo/v2/foo_v2.yaml": "type: google.api.Service\ntitle: Foo API\n"})
+	p.config.GoogleapisToImportPath["google/cloud/foo/v2"] = &libraryInfo{
+		ImportPath:    "cloud.google.com/go/foo/apiv2",
+		ServiceConfig: serviceConfigList{"foo_v2.yaml"},
+		RelPath:       "/foo/apiv2",
+	}
+	got, err := p.LibrariesByReleaseLevel(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string][]string{
+		"alpha": {"cloud.google.com/go/foo/apiv2"},
+		"beta":  {"cloud.google.com/go/bar/apiv1", "cloud.google.com/go/qux/apiv1beta"},
+		"ga":    {"cloud.google.com/go/baz", "cloud.google.com/go/foo/apiv1"},
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("LibrariesByReleaseLevel() mismatch (-want +got):\n%s", diff)
+	}
+
+	// Pins apply to generated and manual libraries, like in the manifest.
+	p.config.ReleaseLevelPins = map[string]string{
+		"cloud.google.com/go/foo/apiv2": "beta",
+		"cloud.google.com/go/baz":       "beta",
+	}
+	got, err = p.LibrariesByReleaseLevel(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	want = map[string][]string{
+		"beta": {"cloud.google.com/go/bar/apiv1", "cloud.google.com/go/baz", "cloud.google.com/go/foo/apiv2", "cloud.google.com/go/qux/apiv1beta"},
+		"ga":   {"cloud.google.com/go/foo/apiv1"},
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("LibrariesByReleaseLevel() with pins mismatch (-want +got):\n%s", diff)
+	}
+}
+
+// fakeDetector is a ReleaseLevelDetector that reports a fixed result and
+// records whether it was called.
+type fakeDetector struct {
+	level  string
+	ok     bool
+	err    error
+	called bool
+}
+
+func (d *fakeDetector) Detect(ctx context.Context, info *libraryInfo) (string, bool, error) {
+	d.called = true
+	return d.level, d.ok, d.err
+}
+
+func TestReleaseLevelDetectorChain(t *testing.T) {
+	absent := &fakeDetector{}
+	first := &fakeDetector{level: "alpha", ok: true}
+	second := &fakeDetector{level: "beta", ok: true}
+	p := &postProcessor{
+		config: &config{},
+		detectors: []releaseLevelDetector{
+			{"absent", absent},
+			{"first", first},
+			{"second", second},
+		},
+	}
+	got, err := p.releaseLevel(context.Background(), &libraryInfo{ImportPath: "cloud.google.com/go/foo/apiv1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := (releaseLevelResult{"alpha", "first"}); got != want {
+		t.Errorf("releaseLevel() = %+v, want %+v", got, want)
+	}
+	if !absent.called || !first.called || second.called {
+		t.Errorf("detectors called = %v, %v, %v; want true, true, false", absent.called, first.called, second.called)
+	}
+
+	p.detectors = []releaseLevelDetector{{"absent", absent}}
+	got, err = p.releaseLevel(context.Background(), &libraryInfo{ImportPath: "cloud.google.com/go/foo/apiv1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := (releaseLevelResult{"ga", inferredGASource}); got != want {
+		t.Errorf("releaseLevel() with no level detected = %+v, want %+v", got, want)
+	}
+
+	p.detectors = []releaseLevelDetector{{"broken", &fakeDetector{err: errors.New("broken")}}, {"first", first}}
+	if _, err := p.releaseLevel(context.Background(), &libraryInfo{}); err == nil || err.Error() != "broken" {
+		t.Errorf("releaseLevel() = %v, want the detector error", err)
+	}
+}
+
+func TestReleaseLevelConfiguredDetectors(t *testing.T) {
+	dir := t.TempDir()
+	writeTestFiles(t, dir, map[string]string{"foo/apiv1beta1/doc.go": testDocGA})
+	p := &postProcessor{
+		googleCloudDir: dir,
+		config: &config{
+			manifestConfig: manifestConfig{
+				ReleaseLevelDetectors: []releaseLevelSource{docMarkerSource, stabilityFileSource},
+			},
+		},
+	}
+	info := &libraryInfo{ImportPath: "cloud.google.com/go/foo/apiv1beta1", RelPath: "/foo/apiv1beta1"}
+	got, err := p.releaseLevel(context.Background(), info)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The path suffix detector is not in the chain.
+	if want := (releaseLevelResult{"ga", inferredGASource}); got != want {
+		t.Errorf("releaseLevel() = %+v, want %+v", got, want)
+	}
+
+	p.config.ReleaseLevelDetectors = []releaseLevelSource{"git-tag"}
+	if err := p.config.validate(); err == nil {
+		t.Errorf("validate() = nil, want error for unknown detector")
+	}
+}
+
+func TestDetectorOrderChanges(t *testing.T) {
+	p := newTestManifestProcessor(t)
+	// qux is beta by its path suffix, which wins by default, and alpha by its
+	// doc.go.
+	writeTestFiles(t, p.googleCloudDir, map[string]string{"qux/apiv1beta/doc.go": testDocAlpha})
+	order := []releaseLevelSource{stabilityFileSource, docMarkerSource, pathSuffixSource, stableFlagSource, launchStageSource}
+	got, err := p.DetectorOrderChanges(context.Background(), order)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []detectorOrderChange{{
+		Distribution: "cloud.google.com/go/qux/apiv1beta",
+		OldDetector:  pathSuffixSource,
+		OldLevel:     "beta",
+		NewDetector:  docMarkerSource,
+		NewLevel:     "alpha",
+	}}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("DetectorOrderChanges() mismatch (-want +got):\n%s", diff)
+	}
+	if p.detectors != nil {
+		t.Errorf("DetectorOrderChanges() left the detectors set to %v, want them restored", p.detectors)
+	}
+
+	var b strings.Builder
+	if err := writeDetectorOrderChangesMarkdown(&b, got); err != nil {
+		t.Fatal(err)
+	}
+	if want := "| cloud.google.com/go/qux/apiv1beta | path-suffix | beta | doc-marker | alpha |\n"; !strings.Contains(b.String(), want) {
+		t.Errorf("writeDetectorOrderChangesMarkdown() = %q, want it to contain %q", b.String(), want)
+	}
+
+	if got, err := p.DetectorOrderChanges(context.Background(), p.config.releaseLevelDetectors()); err != nil || len(got) != 0 {
+		t.Errorf("DetectorOrderChanges() of the same order = %v, %v, want no changes", got, err)
+	}
+	if _, err := p.DetectorOrderChanges(context.Background(), []releaseLevelSource{"git-tag"}); err == nil {
+		t.Errorf("DetectorOrderChanges() = nil error for an unknown detector, want error")
+	}
+}
+
+func TestReleaseLevelTemplatesManifest(t *testing.T) {
+	const customDoc = `// Package foo is an auto-generated package for the
+// Foo API.
+//
+//	NOTE: This package is a preview. Its surface may change without notice.
+package foo
+`
+	tests := []struct {
+		name      string
+		templates string
+		doc       string
+		want      releaseLevelResult
+	}{
+		{
+			name:      "custom phrase",
+			templates: "version: v0.40.0\nbeta-disclaimer: Its surface may change\n",
+			doc:       customDoc,
+			want:      releaseLevelResult{"beta", docMarkerSource},
+		},
+		{
+			name:      "custom phrase replaces default",
+			templates: "version: v0.40.0\nbeta-disclaimer: Its surface may change\n",
+			doc:       testDocBeta,
+			want:      releaseLevelResult{"ga", inferredGASource},
+		},
+		{
+			name:      "custom alpha phrase",
+			templates: "version: v0.40.0\nalpha-disclaimer: Its surface may change\n",
+			doc:       strings.Replace(testDocBeta, "It is not stable", "Its surface may change. It is not stable", 1),
+			want:      releaseLevelResult{"alpha", docMarkerSource},
+		},
+		{
+			name:      "no phrase falls back to default",
+			templates: "version: v0.40.0\n",
+			doc:       testDocBeta,
+			want:      releaseLevelResult{"beta", docMarkerSource},
+		},
+		{
+			name: "no templates manifest",
+			doc:  testDocBeta,
+			want: releaseLevelResult{"beta", docMarkerSource},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			files := map[string]string{"foo/apiv1/doc.go": tt.doc}
+			p := &postProcessor{googleCloudDir: dir, config: &config{}}
+			if tt.templates != "" {
+				files["internal/templates.yaml"] = tt.templates
+				p.config.TemplatesManifest = "internal/templates.yaml"
+			}
+			writeTestFiles(t, dir, files)
+			got, err := p.releaseLevel(context.Background(), &libraryInfo{ImportPath: "cloud.google.com/go/foo/apiv1", RelPath: "/foo/apiv1"})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("releaseLevel() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+
+	p := &postProcessor{googleCloudDir: t.TempDir(), config: &config{}}
+	p.config.TemplatesManifest = "internal/missing.yaml"
+	if _, err := p.betaIndicator(); err == nil {
+		t.Errorf("betaIndicator() = nil error, want error for missing templates manifest")
+	}
+
+	// The templates manifest is read once per manifest computation.
+	p.config.TemplatesManifest = "internal/templates.yaml"
+	writeTestFiles(t, p.googleCloudDir, map[string]string{p.config.TemplatesManifest: "beta-disclaimer: first\n"})
+	if got, err := p.betaIndicator(); err != nil || got != "first" {
+		t.Fatalf("betaIndicator() = %q, %v, want %q", got, err, "first")
+	}
+	writeTestFiles(t, p.googleCloudDir, map[string]string{p.config.TemplatesManifest: "beta-disclaimer: second\n"})
+	if got, err := p.betaIndicator(); err != nil || got != "first" {
+		t.Errorf("betaIndicator() = %q, %v, want the memoized %q", got, err, "first")
+	}
+	p.resetCaches()
+	if got, err := p.betaIndicator(); err != nil || got != "second" {
+		t.Errorf("betaIndicator() after resetCaches() = %q, %v, want %q", got, err, "second")
+	}
+}
@@ -16,6 +16,8 @@ package main
 
 import (
 	"encoding/json"
+	"errors"
+	"fmt"
 	"io"
 	"io/fs"
 	"log"
@@ -23,6 +25,8 @@ import (
 	"path/filepath"
 	"sort"
 	"strings"
+
+	"golang.org/x/mod/semver"
 )
 
 var individuallyReleasedModules map[string]bool = map[string]bool{
@@ -58,6 +62,95 @@ type releasePleaseConfig struct {
 
 type releasePleasePackage struct {
 	Component string `json:"component"`
+	// Prerelease, PrereleaseType and ReleaseAs are only read to detect
+	// release levels.
+	Prerelease     bool   `json:"prerelease,omitempty"`
+	PrereleaseType string `json:"prerelease-type,omitempty"`
+	ReleaseAs      string `json:"release-as,omitempty"`
+}
+
+// defaultReleasePleaseConfigFile is the release-please config read by the
+// release-please detector unless another is configured.
+const defaultReleasePleaseConfigFile = "release-please-config.json"
+
+// releasePleaseLevel reports the release level hinted at by the package of
+// the release-please config that contains the library in relPath, the one
+// with the longest path. It reports false if the config does not exist, no
+// package contains the library or its package has no hint.
+func (p *postProcessor) releasePleaseLevel(relPath string) (string, bool, error) {
+	path := filepath.Join(p.googleCloudDir, p.config.releasePleaseConfigFile())
+	conf, err := p.readReleasePleaseConfig(path)
+	if err != nil {
+		return "", false, err
+	}
+	rel := strings.TrimPrefix(filepath.ToSlash(relPath), "/")
+	var key string
+	var pkg *releasePleasePackage
+	for k, v := range conf.Packages {
+		if k != "." && rel != k && !strings.HasPrefix(rel, k+"/") {
+			continue
+		}
+		// The root package "." contains every library but is the shortest.
+		if pkg == nil || key == "." || k != "." && len(k) > len(key) {
+			key, pkg = k, v
+		}
+	}
+	if pkg == nil {
+		return "", false, nil
+	}
+	level, ok, err := releasePleasePackageLevel(pkg)
+	if err != nil {
+		return "", false, fmt.Errorf("%s: package %q: %v", path, key, err)
+	}
+	return level, ok, nil
+}
+
+// readReleasePleaseConfig reads the release-please config at path, which is
+// memoized until the caches are reset. A missing config has no packages.
+func (p *postProcessor) readReleasePleaseConfig(path string) (*releasePleaseConfig, error) {
+	p.releasePleaseMu.Lock()
+	defer p.releasePleaseMu.Unlock()
+	if p.releasePlease != nil {
+		return p.releasePlease, nil
+	}
+	conf := &releasePleaseConfig{}
+	b, err := os.ReadFile(path)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	} else if err == nil {
+		if err := json.Unmarshal(b, conf); err != nil {
+			return nil, fmt.Errorf("invalid release-please config %s: %v", path, err)
+		}
+	}
+	p.releasePlease = conf
+	return conf, nil
+}
+
+// releasePleasePackageLevel reports the release level hinted at by pkg. A
+// release-as version is ga unless it is a pre-release or below 1.0.0, which
+// are alpha if the pre-release says so and beta otherwise. Without one, a
+// prerelease package is alpha or beta by its prerelease type.
+func releasePleasePackageLevel(pkg *releasePleasePackage) (string, bool, error) {
+	if pkg.ReleaseAs != "" {
+		v := "v" + strings.TrimPrefix(pkg.ReleaseAs, "v")
+		if !semver.IsValid(v) {
+			return "", false, fmt.Errorf("invalid release-as %q", pkg.ReleaseAs)
+		}
+		switch pre := semver.Prerelease(v); {
+		case strings.Contains(pre, "alpha"):
+			return "alpha", true, nil
+		case pre != "", semver.Major(v) == "v0":
+			return "beta", true, nil
+		}
+		return "ga", true, nil
+	}
+	if pkg.Prerelease || pkg.PrereleaseType != "" {
+		if strings.Contains(pkg.PrereleaseType, "alpha") {
+			return "alpha", true, nil
+		}
+		return "beta", true, nil
+	}
+	return "", false, nil
 }
 
 // updateReleaseFiles reconciles release-please configure based of the state of
@@ -0,0 +1,97 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// defaultMaxBetaDays is how long a library may stay beta if a release level
+// policy does not say.
+const defaultMaxBetaDays = 365
+
+// releaseLevelPolicy is a governance policy on how long libraries may stay
+// beta.
+type releaseLevelPolicy struct {
+	// MaxBetaDays is the number of days a library may stay beta after its
+	// first beta release. Defaults to defaultMaxBetaDays.
+	MaxBetaDays int `yaml:"max-beta-days"`
+	// FirstBetaDates are the dates, in RFC 3339 full-date format, of the
+	// first beta release of libraries, keyed by distribution name.
+	FirstBetaDates map[string]string `yaml:"first-beta-dates"`
+}
+
+// readReleaseLevelPolicy reads the YAML release level policy at path.
+func readReleaseLevelPolicy(path string) (releaseLevelPolicy, error) {
+	var policy releaseLevelPolicy
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return policy, err
+	}
+	if err := yaml.Unmarshal(b, &policy); err != nil {
+		return policy, fmt.Errorf("invalid release level policy %s: %v", path, err)
+	}
+	if policy.MaxBetaDays < 0 {
+		return policy, fmt.Errorf("invalid release level policy %s: negative max-beta-days %d", path, policy.MaxBetaDays)
+	}
+	if policy.MaxBetaDays == 0 {
+		policy.MaxBetaDays = defaultMaxBetaDays
+	}
+	for name, date := range policy.FirstBetaDates {
+		if _, err := time.Parse(graduationDateLayout, date); err != nil {
+			return policy, fmt.Errorf("invalid release level policy %s: first-beta-dates: %s: %v", path, name, err)
+		}
+	}
+	return policy, nil
+}
+
+// betaPolicyViolation is a library that has been beta for longer than a
+// release level policy allows.
+type betaPolicyViolation struct {
+	Distribution  string
+	FirstBetaDate string
+	DaysOver      int
+}
+
+// betaPolicyViolations returns the beta entries that have been beta for more
+// than the policy's MaxBetaDays at now, sorted by distribution name. Entries
+// without a first beta date in the policy are not checked.
+func betaPolicyViolations(entries map[string]ManifestEntry, policy releaseLevelPolicy, now time.Time) []betaPolicyViolation {
+	var vs []betaPolicyViolation
+	for name, e := range entries {
+		if e.ReleaseLevel != "beta" {
+			continue
+		}
+		date, ok := policy.FirstBetaDates[name]
+		if !ok {
+			continue
+		}
+		first, err := time.Parse(graduationDateLayout, date)
+		if err != nil {
+			continue
+		}
+		days := int(now.Sub(first).Hours() / 24)
+		if over := days - policy.MaxBetaDays; over > 0 {
+			vs = append(vs, betaPolicyViolation{name, date, over})
+		}
+	}
+	sort.Slice(vs, func(i, j int) bool { return vs[i].Distribution < vs[j].Distribution })
+	return vs
+}
@@ -0,0 +1,73 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestBetaPolicyViolations(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "policy.yaml")
+	policy := `max-beta-days: 365
+first-beta-dates:
+  cloud.google.com/go/foo/apiv1beta: "2024-01-01"
+  cloud.google.com/go/bar/apiv1beta: "2025-06-01"
+  cloud.google.com/go/baz/apiv1: "2023-01-01"
+`
+	if err := os.WriteFile(path, []byte(policy), 0644); err != nil {
+		t.Fatal(err)
+	}
+	p, err := readReleaseLevelPolicy(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	entries := map[string]ManifestEntry{
+		// 2024-01-01 was 471 days before 2025-04-16, 106 days over the limit.
+		"cloud.google.com/go/foo/apiv1beta": {ReleaseLevel: "beta"},
+		// Within its window.
+		"cloud.google.com/go/bar/apiv1beta": {ReleaseLevel: "beta"},
+		// No longer beta.
+		"cloud.google.com/go/baz/apiv1": {ReleaseLevel: "ga"},
+		// Not in the policy.
+		"cloud.google.com/go/qux/apiv1beta": {ReleaseLevel: "beta"},
+	}
+	now := time.Date(2025, 4, 16, 12, 0, 0, 0, time.UTC)
+	want := []betaPolicyViolation{{"cloud.google.com/go/foo/apiv1beta", "2024-01-01", 106}}
+	if diff := cmp.Diff(want, betaPolicyViolations(entries, p, now)); diff != "" {
+		t.Errorf("betaPolicyViolations() mismatch (-want +got):\n%s", diff)
+	}
+
+	// The limit defaults to a year.
+	if err := os.WriteFile(path, []byte("first-beta-dates: {}\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if p, err := readReleaseLevelPolicy(path); err != nil || p.MaxBetaDays != defaultMaxBetaDays {
+		t.Errorf("readReleaseLevelPolicy() = %+v, %v, want MaxBetaDays %d", p, err, defaultMaxBetaDays)
+	}
+
+	for _, bad := range []string{"max-beta-days: -1\n", "first-beta-dates:\n  cloud.google.com/go/foo/apiv1beta: January 2024\n"} {
+		if err := os.WriteFile(path, []byte(bad), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := readReleaseLevelPolicy(path); err == nil {
+			t.Errorf("readReleaseLevelPolicy() = nil error for %q, want error", bad)
+		}
+	}
+}
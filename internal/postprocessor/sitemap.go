@@ -0,0 +1,87 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+)
+
+// sitemapURL is a page listed in a sitemap.
+type sitemapURL struct {
+	Loc string `xml:"loc"`
+}
+
+// readSitemapFile reads the URLs listed in the docs sitemap at path. A sitemap
+// starting with "<" is read as a sitemaps.org XML urlset, any other sitemap as
+// a list of URLs, one per line. Empty lines and lines starting with "#" are
+// skipped.
+func readSitemapFile(path string) (map[string]bool, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	urls := map[string]bool{}
+	if bytes.HasPrefix(bytes.TrimSpace(b), []byte("<")) {
+		var set struct {
+			URLs []sitemapURL `xml:"url"`
+		}
+		if err := xml.Unmarshal(b, &set); err != nil {
+			return nil, fmt.Errorf("invalid sitemap %s: %v", path, err)
+		}
+		for _, u := range set.URLs {
+			if loc := strings.TrimSpace(u.Loc); loc != "" {
+				urls[normalizeSitemapURL(loc)] = true
+			}
+		}
+		return urls, nil
+	}
+	for _, line := range strings.Split(string(b), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		urls[normalizeSitemapURL(line)] = true
+	}
+	return urls, nil
+}
+
+// normalizeSitemapURL removes the trailing slash of u, which sitemaps are not
+// consistent about.
+func normalizeSitemapURL(u string) string {
+	return strings.TrimSuffix(u, "/")
+}
+
+// missingFromSitemap returns the sorted keys of the generated entries whose
+// docs URL is not in the sitemap, whatever their library type. The generated
+// entries are those of the most recent manifest computation. Entries without
+// a docs URL are not checked.
+func (p *postProcessor) missingFromSitemap(sitemap map[string]bool, entries map[string]ManifestEntry) []string {
+	var missing []string
+	for name, e := range entries {
+		if _, ok := p.generatedInputDirs[e.DistributionName]; !ok || e.DocsURL == "" {
+			continue
+		}
+		if !sitemap[normalizeSitemapURL(e.DocsURL)] {
+			missing = append(missing, name)
+		}
+	}
+	sort.Strings(missing)
+	return missing
+}
@@ -0,0 +1,94 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestMissingFromSitemap(t *testing.T) {
+	entries := map[string]ManifestEntry{
+		"cloud.google.com/go/foo/apiv1": {
+			DistributionName: "cloud.google.com/go/foo/apiv1",
+			DocsURL:          "https://cloud.google.com/go/docs/reference/cloud.google.com/go/foo/latest/apiv1",
+			LibraryType:      gapicAutoLibraryType,
+		},
+		"cloud.google.com/go/bar/apiv1": {
+			DistributionName: "cloud.google.com/go/bar/apiv1",
+			DocsURL:          "https://cloud.google.com/go/docs/reference/cloud.google.com/go/bar/latest/apiv1",
+			LibraryType:      gapicAutoLibraryType,
+		},
+		// A generated entry retyped by an agent marker is still checked.
+		"cloud.google.com/go/agent/apiv1": {
+			DistributionName: "cloud.google.com/go/agent/apiv1",
+			DocsURL:          "https://cloud.google.com/go/docs/reference/cloud.google.com/go/agent/latest/apiv1",
+			LibraryType:      agentLibraryType,
+		},
+		"cloud.google.com/go/baz": {
+			DistributionName: "cloud.google.com/go/baz",
+			DocsURL:          "https://example.com/baz",
+			LibraryType:      gapicManualLibraryType,
+		},
+	}
+	p := &postProcessor{generatedInputDirs: map[string]string{
+		"cloud.google.com/go/foo/apiv1":   "google/cloud/foo/v1",
+		"cloud.google.com/go/bar/apiv1":   "google/cloud/bar/v1",
+		"cloud.google.com/go/agent/apiv1": "google/cloud/agent/v1",
+	}}
+	tests := map[string]string{
+		"sitemap.xml": `<?xml version="1.0" encoding="UTF-8"?>
+<urlset xmlns="http://www.sitemaps.org/schemas/sitemap/0.9">
+  <url>
+    <loc>https://cloud.google.com/go/docs/reference/cloud.google.com/go/foo/latest/apiv1/</loc>
+  </url>
+  <url>
+    <loc>https://cloud.google.com/go/docs/reference/cloud.google.com/go/qux/latest/apiv1</loc>
+  </url>
+</urlset>
+`,
+		"sitemap.txt": "# Go reference pages\nhttps://cloud.google.com/go/docs/reference/cloud.google.com/go/foo/latest/apiv1\n\nhttps://cloud.google.com/go/docs/reference/cloud.google.com/go/qux/latest/apiv1\n",
+	}
+	want := []string{"cloud.google.com/go/agent/apiv1", "cloud.google.com/go/bar/apiv1"}
+	for name, content := range tests {
+		t.Run(name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), name)
+			if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+				t.Fatal(err)
+			}
+			sitemap, err := readSitemapFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := p.missingFromSitemap(sitemap, entries)
+			if diff := cmp.Diff(want, got); diff != "" {
+				t.Errorf("missingFromSitemap() mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestReadSitemapFileInvalid(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "sitemap.xml")
+	if err := os.WriteFile(path, []byte("<urlset><url>"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := readSitemapFile(path); err == nil {
+		t.Error("readSitemapFile() got no error for a truncated sitemap")
+	}
+}
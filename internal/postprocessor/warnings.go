@@ -0,0 +1,90 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"log"
+	"path/filepath"
+	"strings"
+)
+
+// logf logs an informational message, unless quiet mode is on.
+func (p *postProcessor) logf(format string, v ...interface{}) {
+	if !p.quiet {
+		log.Printf(format, v...)
+	}
+}
+
+// logln logs an informational message formatted like log.Println, unless
+// quiet mode is on.
+func (p *postProcessor) logln(v ...interface{}) {
+	if !p.quiet {
+		log.Println(v...)
+	}
+}
+
+// debugf logs a debug message, if verbose mode is on and quiet mode is not.
+func (p *postProcessor) debugf(format string, v ...interface{}) {
+	if p.verbose && !p.quiet {
+		log.Printf(format, v...)
+	}
+}
+
+// warnf logs a warning encountered while generating the manifest.
+func (p *postProcessor) warnf(format string, v ...interface{}) {
+	p.warnFilef("", format, v...)
+}
+
+// warnFilef logs a warning about the file at path, which may be empty. If
+// GitHub Actions annotations are enabled, the warning is written as a
+// workflow command instead so that it is shown inline on the file. Logged
+// warnings are suppressed in quiet mode, annotations are not.
+func (p *postProcessor) warnFilef(path, format string, v ...interface{}) {
+	msg := fmt.Sprintf(format, v...)
+	if p.annotations == nil {
+		p.logf("warning: %s", msg)
+		return
+	}
+	if path != "" {
+		if rel, err := filepath.Rel(p.googleCloudDir, path); err == nil {
+			path = filepath.ToSlash(rel)
+		}
+	}
+	fmt.Fprintln(p.annotations, githubAnnotation("warning", path, msg))
+}
+
+// githubAnnotation formats a GitHub Actions workflow command that annotates
+// the file at path, which may be empty, with msg.
+func githubAnnotation(level, path, msg string) string {
+	var props string
+	if path != "" {
+		props = " file=" + escapeAnnotationProperty(path)
+	}
+	return fmt.Sprintf("::%s%s::%s", level, props, escapeAnnotationData(msg))
+}
+
+var (
+	annotationDataEscaper     = strings.NewReplacer("%", "%25", "\r", "%0D", "\n", "%0A")
+	annotationPropertyEscaper = strings.NewReplacer("%", "%25", "\r", "%0D", "\n", "%0A", ":", "%3A", ",", "%2C")
+)
+
+func escapeAnnotationData(s string) string {
+	return annotationDataEscaper.Replace(s)
+}
+
+func escapeAnnotationProperty(s string) string {
+	return annotationPropertyEscaper.Replace(s)
+}
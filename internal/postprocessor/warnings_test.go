@@ -0,0 +1,65 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"path/filepath"
+	"testing"
+)
+
+func TestGithubAnnotation(t *testing.T) {
+	tests := []struct {
+		name string
+		path string
+		msg  string
+		want string
+	}{
+		{
+			name: "file",
+			path: "internal/.repo-metadata-full.json",
+			msg:  "entry cloud.google.com/go/foo is no longer produced",
+			want: "::warning file=internal/.repo-metadata-full.json::entry cloud.google.com/go/foo is no longer produced",
+		},
+		{
+			name: "no file",
+			msg:  "something happened",
+			want: "::warning::something happened",
+		},
+		{
+			name: "escaped",
+			path: "a:b,c.go",
+			msg:  "100% broken\nsecond line",
+			want: "::warning file=a%3Ab%2Cc.go::100%25 broken%0Asecond line",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := githubAnnotation("warning", tt.path, tt.msg); got != tt.want {
+				t.Errorf("githubAnnotation() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWarnFilefAnnotations(t *testing.T) {
+	var buf bytes.Buffer
+	p := &postProcessor{googleCloudDir: t.TempDir(), annotations: &buf}
+	p.warnFilef(filepath.Join(p.googleCloudDir, "foo", "apiv1", "doc.go"), "malformed docs URL for %s", "cloud.google.com/go/foo/apiv1")
+	want := "::warning file=foo/apiv1/doc.go::malformed docs URL for cloud.google.com/go/foo/apiv1\n"
+	if got := buf.String(); got != want {
+		t.Errorf("warnFilef() wrote %q, want %q", got, want)
+	}
+}